@@ -0,0 +1,553 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchImageExts are the extensions picked up when a batch input is a
+// directory rather than a glob.
+var batchImageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// BatchOptions configures a batch run.
+type BatchOptions struct {
+	// Inputs are files, directories or glob patterns; "**" matches any
+	// number of directories.
+	Inputs []string
+	// Out is the output path template, e.g. "out/{name}.jpg".
+	Out             string
+	Steps           []Step
+	Jobs            int
+	SkipUpToDate    bool
+	ContinueOnError bool
+	// Deps are extra files (recipes) whose modification makes every output
+	// stale for SkipUpToDate.
+	Deps []string
+}
+
+// BatchResult is the outcome for one input file.
+type BatchResult struct {
+	Index    int           `json:"index"`
+	Input    string        `json:"input"`
+	Output   string        `json:"output"`
+	Status   string        `json:"status"` // "ok", "failed", "skipped" or "not run"
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"-"`
+	Millis   int64         `json:"duration_ms"`
+}
+
+// BatchSummary aggregates the results of a batch run.
+type BatchSummary struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	NotRun    int           `json:"not_run"`
+	Elapsed   time.Duration `json:"-"`
+	Millis    int64         `json:"elapsed_ms"`
+	Files     []BatchResult `json:"files"`
+}
+
+// batchUsage prints the usage for the `batch` subcommand.
+func batchUsage() {
+	fmt.Fprintln(os.Stderr, "usage: timp batch <glob|dir>... [<command> [args...]...] --out <template> [options]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "options:")
+	fmt.Fprintln(os.Stderr, "  --out <template>       output path; placeholders {name} {ext} {dir} {reldir} {index} {date}")
+	fmt.Fprintln(os.Stderr, "  --recipe <file>        run the steps from a recipe file (repeatable)")
+	fmt.Fprintln(os.Stderr, "  --var name=value       set a recipe variable (repeatable)")
+	fmt.Fprintln(os.Stderr, "  --jobs <n>             number of files processed in parallel (default: CPU count)")
+	fmt.Fprintln(os.Stderr, "  --skip-up-to-date      skip files whose output is newer than the input and recipes")
+	fmt.Fprintln(os.Stderr, "  --continue-on-error    keep going after a file fails")
+	fmt.Fprintln(os.Stderr, "  --report <file>        write a summary report (.csv or .json)")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "example: timp batch 'photos/**/*.jpg' --recipe web.timp --out out/{reldir}/{name}.jpg")
+}
+
+// RunBatch implements `timp batch`. It returns an error when the arguments
+// are invalid or any file failed.
+func RunBatch(args []string) error {
+	opts := BatchOptions{Jobs: runtime.NumCPU()}
+	var recipes []string
+	var report string
+	vars := map[string]string{}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--out", "-o", "--recipe", "--var", "--jobs", "-j", "--report":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			v := args[i+1]
+			i++
+			switch args[i-1] {
+			case "--out", "-o":
+				opts.Out = v
+			case "--recipe":
+				recipes = append(recipes, v)
+			case "--var":
+				if err := ParseVarFlag(v, vars); err != nil {
+					return err
+				}
+			case "--jobs", "-j":
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					return fmt.Errorf("--jobs: expected a positive integer, got %q", v)
+				}
+				opts.Jobs = n
+			case "--report":
+				report = v
+			}
+		case "--skip-up-to-date":
+			opts.SkipUpToDate = true
+		case "--continue-on-error", "-k":
+			opts.ContinueOnError = true
+		case "-h", "--help":
+			batchUsage()
+			return nil
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+
+	store := newCommandStore()
+	// Leading tokens that do not name a command are inputs.
+	n := 0
+	for n < len(rest) {
+		if _, ok := store.byName[rest[n]]; ok {
+			break
+		}
+		n++
+	}
+	opts.Inputs = rest[:n]
+	if len(opts.Inputs) == 0 {
+		batchUsage()
+		return fmt.Errorf("missing input files")
+	}
+	if opts.Out == "" {
+		return fmt.Errorf("missing output template (--out)")
+	}
+	if report != "" {
+		if ext := strings.ToLower(filepath.Ext(report)); ext != ".csv" && ext != ".json" {
+			return fmt.Errorf("--report: unsupported format %q (use .csv or .json)", ext)
+		}
+	}
+	steps, err := buildSteps(store, recipes, vars, rest[n:])
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return fmt.Errorf("no commands given (pass a command chain or --recipe)")
+	}
+	opts.Steps = steps
+	opts.Deps = recipes
+
+	sum, err := Batch(store, opts, os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("batch: %d succeeded, %d failed, %d skipped", sum.Succeeded, sum.Failed, sum.Skipped)
+	if sum.NotRun > 0 {
+		fmt.Printf(", %d not run", sum.NotRun)
+	}
+	fmt.Printf(" in %s\n", sum.Elapsed.Round(time.Millisecond))
+	if report != "" {
+		if err := WriteBatchReport(report, sum); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Printf("Report written to %s\n", report)
+	}
+	if sum.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", sum.Failed, len(sum.Files))
+	}
+	return nil
+}
+
+// batchJob is one input file with its resolved output path.
+type batchJob struct {
+	index  int
+	input  string
+	output string
+}
+
+// Batch expands opts.Inputs and processes the files with at most opts.Jobs
+// workers, writing one progress line per file to w. Argument errors (no
+// matching files, bad template, colliding outputs) are returned before any
+// file is processed; per-file failures are reported in the summary.
+func Batch(store *StdMetaStore, opts BatchOptions, w io.Writer) (*BatchSummary, error) {
+	start := time.Now()
+	jobs, err := planBatch(opts)
+	if err != nil {
+		return nil, err
+	}
+	var depTime time.Time
+	for _, d := range opts.Deps {
+		if fi, err := os.Stat(d); err == nil && fi.ModTime().After(depTime) {
+			depTime = fi.ModTime()
+		}
+	}
+
+	results := make([]BatchResult, len(jobs))
+	for i, j := range jobs {
+		results[i] = BatchResult{Index: j.index, Input: j.input, Output: j.output, Status: "not run"}
+	}
+
+	workers := opts.Jobs
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		mu      sync.Mutex
+		stopped bool
+		done    int
+		wg      sync.WaitGroup
+	)
+	queue := make(chan int)
+	for k := 0; k < workers; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				r := runBatchJob(store, opts, jobs[i], depTime)
+				mu.Lock()
+				results[i] = r
+				done++
+				switch r.Status {
+				case "failed":
+					fmt.Fprintf(w, "[%d/%d] failed %s: %s\n", done, len(jobs), r.Input, r.Error)
+					if !opts.ContinueOnError {
+						stopped = true
+					}
+				case "skipped":
+					fmt.Fprintf(w, "[%d/%d] up to date %s\n", done, len(jobs), r.Output)
+				default:
+					fmt.Fprintf(w, "[%d/%d] %s -> %s (%s)\n", done, len(jobs), r.Input, r.Output, r.Duration.Round(time.Millisecond))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range jobs {
+		mu.Lock()
+		stop := stopped
+		mu.Unlock()
+		if stop {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	sum := &BatchSummary{Files: results, Elapsed: time.Since(start)}
+	sum.Millis = sum.Elapsed.Milliseconds()
+	for _, r := range results {
+		switch r.Status {
+		case "ok":
+			sum.Succeeded++
+		case "failed":
+			sum.Failed++
+		case "skipped":
+			sum.Skipped++
+		default:
+			sum.NotRun++
+		}
+	}
+	return sum, nil
+}
+
+// runBatchJob processes a single file.
+func runBatchJob(store *StdMetaStore, opts BatchOptions, j batchJob, depTime time.Time) (r BatchResult) {
+	r = BatchResult{Index: j.index, Input: j.input, Output: j.output}
+	start := time.Now()
+	defer func() {
+		r.Duration = time.Since(start)
+		r.Millis = r.Duration.Milliseconds()
+	}()
+	fail := func(err error) BatchResult {
+		r.Status = "failed"
+		r.Error = err.Error()
+		return r
+	}
+
+	if opts.SkipUpToDate && upToDate(j.input, j.output, depTime) {
+		r.Status = "skipped"
+		return r
+	}
+	img, format, segs, autoOriented, err := LoadImage(j.input)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read image: %w", err))
+	}
+	st := imageState{img: img, path: j.input, format: format, appSegments: segs, autoOriented: autoOriented}
+	st, err = applySteps(context.Background(), store, st, opts.Steps, nil)
+	if err != nil {
+		return fail(err)
+	}
+	if dir := filepath.Dir(j.output); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fail(err)
+		}
+	}
+	if err := SaveImage(j.output, st.img, st.appSegments, st.autoOriented); err != nil {
+		return fail(fmt.Errorf("failed to write image: %w", err))
+	}
+	r.Status = "ok"
+	return r
+}
+
+// upToDate reports whether output exists and is newer than both input and
+// depTime.
+func upToDate(input, output string, depTime time.Time) bool {
+	out, err := os.Stat(output)
+	if err != nil {
+		return false
+	}
+	in, err := os.Stat(input)
+	if err != nil {
+		return false
+	}
+	return !out.ModTime().Before(in.ModTime()) && !out.ModTime().Before(depTime)
+}
+
+// planBatch expands the inputs, renders every output path and rejects runs
+// where two inputs would write the same file.
+func planBatch(opts BatchOptions) ([]batchJob, error) {
+	if err := checkBatchTemplate(opts.Out); err != nil {
+		return nil, err
+	}
+	type input struct{ path, base string }
+	var inputs []input
+	seen := map[string]bool{}
+	for _, in := range opts.Inputs {
+		files, base, err := expandBatchInput(in)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files match %s", in)
+		}
+		for _, f := range files {
+			if !seen[f] {
+				seen[f] = true
+				inputs = append(inputs, input{f, base})
+			}
+		}
+	}
+
+	jobs := make([]batchJob, len(inputs))
+	outputs := map[string]string{}
+	for i, in := range inputs {
+		out, err := renderBatchOutput(opts.Out, in.path, in.base, i+1)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", in.path, err)
+		}
+		key := filepath.Clean(out)
+		if prev, dup := outputs[key]; dup {
+			return nil, fmt.Errorf("output %s would be written by both %s and %s; add {reldir} or {index} to --out", out, prev, in.path)
+		}
+		if filepath.Clean(in.path) == key {
+			return nil, fmt.Errorf("output %s would overwrite its input", out)
+		}
+		outputs[key] = in.path
+		jobs[i] = batchJob{index: i + 1, input: in.path, output: out}
+	}
+	return jobs, nil
+}
+
+// expandBatchInput returns the files matched by a batch input together with
+// the directory that {reldir} is relative to. A directory yields the image
+// files directly inside it; a pattern containing "**" is matched recursively.
+func expandBatchInput(in string) ([]string, string, error) {
+	if fi, err := os.Stat(in); err == nil {
+		if !fi.IsDir() {
+			return []string{in}, filepath.Dir(in), nil
+		}
+		entries, err := os.ReadDir(in)
+		if err != nil {
+			return nil, "", err
+		}
+		var files []string
+		for _, e := range entries {
+			if !e.IsDir() && batchImageExts[strings.ToLower(filepath.Ext(e.Name()))] {
+				files = append(files, filepath.Join(in, e.Name()))
+			}
+		}
+		return files, in, nil
+	}
+
+	pattern := filepath.ToSlash(in)
+	segs := strings.Split(pattern, "/")
+	// The base is the longest leading run of segments without wildcards.
+	n := 0
+	for n < len(segs)-1 && !strings.ContainsAny(segs[n], "*?[") {
+		n++
+	}
+	base := filepath.FromSlash(strings.Join(segs[:n], "/"))
+	if base == "" {
+		if strings.HasPrefix(pattern, "/") {
+			base = "/"
+		} else {
+			base = "."
+		}
+	}
+	if !strings.Contains(pattern, "**") {
+		files, err := filepath.Glob(in)
+		if err != nil {
+			return nil, "", err
+		}
+		sort.Strings(files)
+		return files, base, nil
+	}
+
+	rest := segs[n:]
+	var files []string
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		if matchGlobSegments(rest, strings.Split(filepath.ToSlash(rel), "/")) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(files)
+	return files, base, nil
+}
+
+// matchGlobSegments matches path segments against pattern segments, where a
+// "**" segment matches zero or more path segments.
+func matchGlobSegments(pat, path []string) bool {
+	if len(pat) == 0 {
+		return len(path) == 0
+	}
+	if pat[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchGlobSegments(pat[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	ok, err := filepath.Match(pat[0], path[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchGlobSegments(pat[1:], path[1:])
+}
+
+// batchPlaceholders are the placeholders accepted in --out templates.
+var batchPlaceholders = map[string]bool{"name": true, "ext": true, "dir": true, "reldir": true, "index": true, "date": true}
+
+// checkBatchTemplate rejects unknown or unterminated placeholders.
+func checkBatchTemplate(tmpl string) error {
+	for rest := tmpl; ; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			return nil
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			return fmt.Errorf("--out: unterminated placeholder in %q", tmpl)
+		}
+		if name := rest[i+1 : i+j]; !batchPlaceholders[name] {
+			return fmt.Errorf("--out: unknown placeholder {%s}", name)
+		}
+		rest = rest[i+j+1:]
+	}
+}
+
+// renderBatchOutput fills the output template for input, the index-th file
+// of the batch. base is the directory {reldir} is relative to.
+func renderBatchOutput(tmpl, input, base string, index int) (string, error) {
+	ext := filepath.Ext(input)
+	name := strings.TrimSuffix(filepath.Base(input), ext)
+	reldir, err := filepath.Rel(base, filepath.Dir(input))
+	if err != nil {
+		reldir = "."
+	}
+	date := ""
+	if strings.Contains(tmpl, "{date}") {
+		date, err = batchFileDate(input)
+		if err != nil {
+			return "", err
+		}
+	}
+	r := strings.NewReplacer(
+		"{name}", name,
+		"{ext}", strings.TrimPrefix(ext, "."),
+		"{dir}", filepath.Dir(input),
+		"{reldir}", reldir,
+		"{index}", strconv.Itoa(index),
+		"{date}", date,
+	)
+	return filepath.Clean(r.Replace(tmpl)), nil
+}
+
+// batchFileDate returns the capture date (YYYY-MM-DD) from EXIF
+// DateTimeOriginal, falling back to the file's modification time.
+func batchFileDate(path string) (string, error) {
+	if ex, err := ExtractEXIFStruct(path); err == nil {
+		for _, v := range []string{ex.DateTimeOriginal, ex.DateTime} {
+			if t, perr := time.Parse("2006:01:02 15:04:05", strings.TrimSpace(v)); perr == nil {
+				return t.Format("2006-01-02"), nil
+			}
+		}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fi.ModTime().Format("2006-01-02"), nil
+}
+
+// WriteBatchReport writes sum to path as JSON or CSV depending on the
+// extension.
+func WriteBatchReport(path string, sum *BatchSummary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sum); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	cw := csv.NewWriter(f)
+	_ = cw.Write([]string{"index", "input", "output", "status", "duration_ms", "error"})
+	for _, r := range sum.Files {
+		_ = cw.Write([]string{strconv.Itoa(r.Index), r.Input, r.Output, r.Status, strconv.FormatInt(r.Millis, 10), r.Error})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatchGlobSegments(t *testing.T) {
+	cases := []struct {
+		pat, path string
+		want      bool
+	}{
+		{"**/*.jpg", "a.jpg", true},
+		{"**/*.jpg", "x/y/a.jpg", true},
+		{"**/*.jpg", "x/y/a.png", false},
+		{"x/**/a.jpg", "x/a.jpg", true},
+		{"x/**/a.jpg", "y/a.jpg", false},
+		{"*/a.jpg", "x/y/a.jpg", false},
+	}
+	for _, c := range cases {
+		got := matchGlobSegments(strings.Split(c.pat, "/"), strings.Split(c.path, "/"))
+		if got != c.want {
+			t.Errorf("match(%q, %q) = %v, want %v", c.pat, c.path, got, c.want)
+		}
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPNG(t, filepath.Join(dir, "in", "a.png"), 8, 8)
+	writeTestPNG(t, filepath.Join(dir, "in", "sub", "b.png"), 8, 8)
+	// Not an image: fails to load.
+	if err := os.WriteFile(filepath.Join(dir, "in", "sub", "c.png"), []byte("nope"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	opts := BatchOptions{
+		Inputs:          []string{filepath.Join(dir, "in", "**", "*.png")},
+		Out:             filepath.Join(dir, "out", "{reldir}", "{name}-{index}.{ext}"),
+		Steps:           []Step{{Name: "resize", Args: []string{"4", "2"}}},
+		Jobs:            2,
+		ContinueOnError: true,
+		SkipUpToDate:    true,
+	}
+	sum, err := Batch(store, opts, io.Discard)
+	if err != nil {
+		t.Fatalf("Batch: %v", err)
+	}
+	if sum.Succeeded != 2 || sum.Failed != 1 {
+		t.Fatalf("summary = %+v", sum)
+	}
+	for _, rel := range []string{"a-1.png", filepath.Join("sub", "b-2.png")} {
+		img, _, _, _, err := LoadImage(filepath.Join(dir, "out", rel))
+		if err != nil {
+			t.Fatalf("output %s: %v", rel, err)
+		}
+		if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+			t.Fatalf("output %s size = %v", rel, b)
+		}
+	}
+
+	// Second run: the successful outputs are up to date.
+	sum, err = Batch(store, opts, io.Discard)
+	if err != nil {
+		t.Fatalf("Batch rerun: %v", err)
+	}
+	if sum.Skipped != 2 || sum.Failed != 1 {
+		t.Fatalf("rerun summary = %+v", sum)
+	}
+
+	report := filepath.Join(dir, "report.json")
+	if err := WriteBatchReport(report, sum); err != nil {
+		t.Fatalf("WriteBatchReport: %v", err)
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded BatchSummary
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded.Files) != 3 {
+		t.Fatalf("report = %s (err %v)", data, err)
+	}
+}
+
+func TestBatchRejectsCollidingOutputs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestPNG(t, filepath.Join(dir, "x", "a.png"), 2, 2)
+	writeTestPNG(t, filepath.Join(dir, "y", "a.png"), 2, 2)
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	opts := BatchOptions{
+		Inputs: []string{filepath.Join(dir, "**", "*.png")},
+		Out:    filepath.Join(dir, "out", "{name}.png"),
+		Steps:  []Step{{Name: "negate"}},
+		Jobs:   1,
+	}
+	if _, err := Batch(store, opts, io.Discard); err == nil || !strings.Contains(err.Error(), "would be written by both") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+	opts.Out = filepath.Join(dir, "out", "{bogus}.png")
+	if _, err := Batch(store, opts, io.Discard); err == nil || !strings.Contains(err.Error(), "unknown placeholder") {
+		t.Fatalf("expected placeholder error, got %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// Buffer is one open image in the interactive editor. Each buffer keeps its
+// own metadata, undo history, recorded macro, the region that commands are
+// limited to and the current selection mask. A buffer with layers also
+// holds its document (see layers.go).
+type Buffer struct {
+	Name      string
+	st        imageState
+	hist      *History
+	macro     []Step
+	region    Region
+	selection *image.Gray
+	doc       *stdimg.Document
+	layerHist map[*stdimg.Layer]*History
+}
+
+// Session holds the open buffers and tracks which one commands apply to.
+type Session struct {
+	buffers []*Buffer
+	active  int
+	budget  int64
+}
+
+// NewSession returns an empty session whose buffers each get a history with
+// the given memory budget.
+func NewSession(budget int64) *Session {
+	return &Session{active: -1, budget: budget}
+}
+
+// Active returns the buffer commands apply to, or nil if none is open.
+func (s *Session) Active() *Buffer {
+	if s.active < 0 || s.active >= len(s.buffers) {
+		return nil
+	}
+	return s.buffers[s.active]
+}
+
+// Open adds st as a new buffer, named after its file, and makes it active.
+func (s *Session) Open(st imageState) *Buffer {
+	b := &Buffer{Name: s.uniqueName(bufferBaseName(st.path)), st: st, hist: NewHistory(s.budget)}
+	s.buffers = append(s.buffers, b)
+	s.active = len(s.buffers) - 1
+	return b
+}
+
+// Find looks a buffer up by name (with or without a leading '@') or by its
+// 1-based position in the listing.
+func (s *Session) Find(ref string) (*Buffer, bool) {
+	ref = strings.TrimPrefix(strings.TrimSpace(ref), "@")
+	for _, b := range s.buffers {
+		if b.Name == ref {
+			return b, true
+		}
+	}
+	if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(s.buffers) {
+		return s.buffers[n-1], true
+	}
+	return nil, false
+}
+
+// Switch makes the buffer named by ref active.
+func (s *Session) Switch(ref string) (*Buffer, error) {
+	b, ok := s.Find(ref)
+	if !ok {
+		return nil, fmt.Errorf("no buffer named %s", ref)
+	}
+	for i, x := range s.buffers {
+		if x == b {
+			s.active = i
+		}
+	}
+	return b, nil
+}
+
+// Close removes b, releasing its history. The next buffer in the listing
+// (or the previous one, if b was last) becomes active.
+func (s *Session) Close(b *Buffer) {
+	for i, x := range s.buffers {
+		if x != b {
+			continue
+		}
+		b.close()
+		s.buffers = append(s.buffers[:i], s.buffers[i+1:]...)
+		if s.active >= len(s.buffers) {
+			s.active = len(s.buffers) - 1
+		}
+		return
+	}
+}
+
+// CloseAll releases every buffer's history.
+func (s *Session) CloseAll() {
+	for _, b := range s.buffers {
+		b.close()
+	}
+	s.buffers = nil
+	s.active = -1
+}
+
+// Print lists the buffers on w, marking the active one.
+func (s *Session) Print(w io.Writer) {
+	if len(s.buffers) == 0 {
+		fmt.Fprintln(w, "no open buffers")
+		return
+	}
+	for i, b := range s.buffers {
+		mark := " "
+		if i == s.active {
+			mark = "*"
+		}
+		bounds := b.st.img.Bounds()
+		fmt.Fprintf(w, "%s %d) @%s  %s  %dx%d\n", mark, i+1, b.Name, b.st.path, bounds.Dx(), bounds.Dy())
+	}
+}
+
+// Resolve maps an "@name" reference to the current image of that buffer,
+// flattened if it has layers, and "@selection" to the active buffer's selection mask. It is installed as
+// stdimg.SourceResolver so commands such as composite can take an open
+// buffer as their source.
+func (s *Session) Resolve(ref string) (image.Image, bool) {
+	if !strings.HasPrefix(ref, "@") {
+		return nil, false
+	}
+	if b := s.Active(); ref == selectionRef && b != nil && b.selection != nil {
+		return b.selection, true
+	}
+	b, ok := s.Find(ref)
+	if !ok {
+		return nil, false
+	}
+	return b.view(), true
+}
+
+// record makes img, the result of applying step, the buffer's image, as an
+// undoable action that the macro also records. identify leaves the image
+// alone and is neither, so an undo right after it takes back the step
+// before it in both.
+func (b *Buffer) record(step Step, img image.Image) {
+	if step.Name == "identify" {
+		return
+	}
+	b.hist.Push(b.st, historyAction{Label: stepLabel(step), Steps: []Step{step}})
+	if img != nil {
+		b.st.img = img
+	}
+	b.macro = append(b.macro, step)
+}
+
+// stepHistory undoes (or redoes) the latest action, keeping the recorded
+// macro in step with the image. ok is false when there is nothing to undo
+// or redo.
+func (b *Buffer) stepHistory(undo bool) (action historyAction, ok bool, err error) {
+	var next imageState
+	if undo {
+		next, action, ok, err = b.hist.Undo(b.st)
+	} else {
+		next, action, ok, err = b.hist.Redo(b.st)
+	}
+	if err != nil || !ok {
+		return action, ok, err
+	}
+	b.st = next
+	if undo {
+		// The log may have been cleared with 'm' since this action.
+		b.macro = b.macro[:max(0, len(b.macro)-len(action.Steps))]
+	} else {
+		b.macro = append(b.macro, action.Steps...)
+	}
+	return action, true, nil
+}
+
+// bufferBaseName derives a buffer name from a file path: the base name
+// without extension, restricted to characters that are safe after '@'.
+func bufferBaseName(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" || name == "." {
+		return "image"
+	}
+	return name
+}
+
+// uniqueName returns base, or base-2, base-3, ... if base is taken.
+func (s *Session) uniqueName(base string) string {
+	taken := func(name string) bool {
+		for _, b := range s.buffers {
+			if b.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+	name := base
+	for n := 2; taken(name); n++ {
+		name = fmt.Sprintf("%s-%d", base, n)
+	}
+	return name
+}
+
+// Names returns the buffer names in listing order.
+func (s *Session) Names() []string {
+	names := make([]string, len(s.buffers))
+	for i, b := range s.buffers {
+		names[i] = b.Name
+	}
+	return names
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func solidNRGBA(w, h int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestSessionBuffers(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+
+	a := sess.Open(imageState{img: solidNRGBA(4, 4, color.NRGBA{255, 0, 0, 255}), path: "photos/base.jpg"})
+	b := sess.Open(imageState{img: solidNRGBA(2, 2, color.NRGBA{0, 0, 255, 255}), path: "other/base.png"})
+	c := sess.Open(imageState{img: solidNRGBA(1, 1, color.NRGBA{}), path: "my logo.png"})
+	if a.Name != "base" || b.Name != "base-2" || c.Name != "my_logo" {
+		t.Fatalf("names = %q %q %q", a.Name, b.Name, c.Name)
+	}
+	if sess.Active() != c {
+		t.Fatalf("newly opened buffer is not active")
+	}
+	if got, err := sess.Switch("@base"); err != nil || got != a || sess.Active() != a {
+		t.Fatalf("Switch(@base) = %v, %v", got, err)
+	}
+	if got, err := sess.Switch("2"); err != nil || got != b {
+		t.Fatalf("Switch(2) = %v, %v", got, err)
+	}
+	if _, err := sess.Switch("nope"); err == nil {
+		t.Fatalf("expected error switching to unknown buffer")
+	}
+
+	// Buffers have independent histories.
+	b.hist.Push(b.st, historyAction{Label: "negate"})
+	if len(a.hist.undo) != 0 || len(b.hist.undo) != 1 {
+		t.Fatalf("histories are shared")
+	}
+
+	sess.Close(b)
+	if sess.Active() != c {
+		t.Fatalf("closing the active buffer should activate the next one, got %v", sess.Active())
+	}
+	sess.Close(c)
+	if sess.Active() != a {
+		t.Fatalf("closing the last buffer should activate the previous one")
+	}
+	sess.Close(a)
+	if sess.Active() != nil {
+		t.Fatalf("expected no active buffer")
+	}
+}
+
+func TestCompositeFromBuffer(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+	base := sess.Open(imageState{img: solidNRGBA(4, 4, color.NRGBA{255, 0, 0, 255}), path: "base.png"})
+	sess.Open(imageState{img: solidNRGBA(2, 2, color.NRGBA{0, 0, 255, 255}), path: "logo.png"})
+
+	stdimg.SourceResolver = sess.Resolve
+	defer func() { stdimg.SourceResolver = nil }()
+
+	out, err := stdimg.ApplyCommandStdlib(base.st.img, "composite", []string{"@logo", "OVER", "1", "1"})
+	if err != nil {
+		t.Fatalf("composite from buffer: %v", err)
+	}
+	n := out.(*image.NRGBA)
+	if got := n.NRGBAAt(1, 1); got != (color.NRGBA{0, 0, 255, 255}) {
+		t.Fatalf("pixel inside overlay = %v", got)
+	}
+	if got := n.NRGBAAt(0, 0); got != (color.NRGBA{255, 0, 0, 255}) {
+		t.Fatalf("pixel outside overlay = %v", got)
+	}
+	if _, err := stdimg.ApplyCommandStdlib(base.st.img, "composite", []string{"@missing", "OVER", "0", "0"}); err == nil {
+		t.Fatalf("expected error for unknown buffer")
+	}
+}
+
+func TestResolveFlattensLayers(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+	red := color.NRGBA{255, 0, 0, 255}
+	doc := sess.Open(imageState{img: solidNRGBA(4, 4, red), path: "doc.png"})
+	top := stdimg.NewLayer("top", solidNRGBA(2, 2, color.NRGBA{0, 0, 255, 255}))
+	top.Offset = image.Pt(2, 2)
+	doc.addLayer(top, sess.budget)
+
+	img, ok := sess.Resolve("@doc")
+	if !ok {
+		t.Fatal("@doc did not resolve")
+	}
+	flat := stdimg.ToNRGBA(img)
+	if flat.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Fatalf("@doc bounds = %v, want the whole document", flat.Bounds())
+	}
+	if got := flat.NRGBAAt(0, 0); got != red {
+		t.Errorf("background pixel = %v", got)
+	}
+	if got := flat.NRGBAAt(3, 3); got != (color.NRGBA{0, 0, 255, 255}) {
+		t.Errorf("top layer pixel = %v", got)
+	}
+}
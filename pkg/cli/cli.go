@@ -1,9 +1,10 @@
 package cli
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"image"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -14,45 +15,84 @@ import (
 func usage() {
 	fmt.Println("Commands available:")
 	fmt.Println("  /  - select and apply command")
-	fmt.Println("  o  - open another image at runtime")
+	fmt.Println("  o  - open another image in a new buffer")
+	fmt.Println("  b  - list open buffers and switch between them")
+	fmt.Println("  c  - close the current buffer")
 	fmt.Println("  s  - save current image")
+	fmt.Println("  r  - run a recipe file")
+	fmt.Println("  R  - limit commands to a rectangle or mask (region)")
+	fmt.Println("  S  - select by color (magic wand) and edit the selection")
+	fmt.Println("  L  - add, arrange and blend layers")
+	fmt.Println("  m  - export the recorded session as a recipe or command line")
+	fmt.Println("  z  - undo the last change")
+	fmt.Println("  y  - redo the last undone change")
+	fmt.Println("  H  - show undo/redo history")
 	fmt.Println("  u  - check for updates")
 	fmt.Println("  h  - show this help message")
 	fmt.Println("  q  - quit")
 }
 
 func RunCLI() {
+	args, overrides, err := splitConfigFlags(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timp: %v\n", err)
+		os.Exit(1)
+	}
+	cfg, err := LoadConfig(overrides)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "timp: %v\n", err)
+		os.Exit(1)
+	}
+	applyConfig(cfg)
+	if cfg.Bool("plugins") {
+		LoadPlugins(pluginDirs(), os.Stderr)
+	}
+	if err := cfg.CheckCommandDefaults(); err != nil {
+		fmt.Fprintf(os.Stderr, "timp: %v\n", err)
+		os.Exit(1)
+	}
+
+	if handled, err := runSubcommand(args); handled {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "timp %s: %v\n", args[0], err)
+			var status *exitStatus
+			if errors.As(err, &status) {
+				os.Exit(status.code)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
 	var inputImagePath string
-	if len(os.Args) >= 2 {
-		inputImagePath = os.Args[1]
-	} else {
-		inputImagePath = ""
+	if len(args) >= 1 {
+		inputImagePath = args[0]
 	}
 
 	// Use stdimg command metadata as the canonical source
-	storeStd := NewMetaStoreFromStdimg(stdimg.Commands)
-
-	var cur image.Image
-	// Track the path of the currently loaded image so we can show EXIF for identify
-	var currentImagePath string
-	var currentFormat string
-	var currentAppSegments []AppSegment
-	var currentAutoOriented bool
+	storeStd := newCommandStore()
+
+	// Each open image lives in its own buffer with its path (used to show
+	// EXIF for identify), format, the JPEG metadata to write back on save,
+	// undo history and recorded macro. Commands apply to the active buffer.
+	sess := NewSession(historyMemory)
+	defer sess.CloseAll()
+	stdimg.SourceResolver = sess.Resolve
+	defer func() { stdimg.SourceResolver = nil }()
+	if inputImagePath == "-" {
+		fmt.Fprintln(os.Stderr, "reading an image from stdin is only supported by `timp run`; the interactive editor reads commands from stdin")
+		os.Exit(1)
+	}
 	if inputImagePath != "" {
-		img, format, meta, autoOriented, err := LoadImage(inputImagePath)
+		b, err := openBuffer(sess, inputImagePath)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to read image %s: %v\n", inputImagePath, err)
 			os.Exit(1)
 		}
-		cur = img
-		currentImagePath = inputImagePath
-		currentFormat = format
-		currentAppSegments = meta
-		currentAutoOriented = autoOriented
 		// Try to show an initial preview in compatible terminals.
 		// Ignore errors here so preview remains optional.
-		_ = PreviewImage(cur, currentFormat)
-		if info, ierr := GetImageInfoImage(cur); ierr == nil {
+		_ = PreviewImage(b.view(), b.st.format)
+		if info, ierr := GetImageInfoImage(b.st.img); ierr == nil {
 			fmt.Println(info)
 		}
 	}
@@ -60,18 +100,24 @@ func RunCLI() {
 	fmt.Println("Terminal Image Editor")
 	usage()
 
-	reader := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Print("> ")
-		r, _, err := reader.ReadRune()
+		r, _, err := stdinReader.ReadRune()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "read input error: %v\n", err)
 			continue
 		}
+		// Keys are read in cooked mode; drop the rest of the line so the
+		// next prompt starts on fresh input.
+		if r != '\n' {
+			_, _ = stdinReader.ReadString('\n')
+		}
 
+		// buf is the active buffer; nil until an image is opened.
+		buf := sess.Active()
 		switch r {
 		case '/':
-			if cur == nil {
+			if buf == nil {
 				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
 				continue
 			}
@@ -83,7 +129,7 @@ func RunCLI() {
 				for i, c := range stdimg.Commands {
 					fmt.Printf("  %d) %s - %s\n", i+1, c.Name, c.Description)
 				}
-				selection, _ := PromptLine("Enter number or command name (leave empty to cancel): ")
+				selection, _ := PromptLineKind("Enter number or command name (leave empty to cancel): ", "command", commandCompleter())
 				if selection == "" {
 					fmt.Println("selection cancelled")
 					continue
@@ -150,11 +196,9 @@ func RunCLI() {
 				}
 				prompt := fmt.Sprintf("%s (%s): ", p.Name, typeLabel)
 
-				lowerName := strings.ToLower(p.Name)
-				lowerHint := strings.ToLower(p.Description)
 				var val string
 				var perr error
-				if strings.Contains(lowerName, "path") || strings.Contains(lowerName, "file") || strings.Contains(lowerHint, "path") || strings.Contains(lowerHint, "file") {
+				if isPathArg(p) {
 					prompt = fmt.Sprintf("%s (%s) [enter image path, url, or enter '/' to use fzf]: ", p.Name, typeLabel)
 					val, perr = PromptLineWithFzf(prompt)
 					if perr != nil {
@@ -162,7 +206,7 @@ func RunCLI() {
 						val = ""
 					}
 				} else {
-					val, perr = PromptLine(prompt)
+					val, perr = PromptLineKind(prompt, "arg:"+p.Name, argCompleter(p))
 					if perr != nil {
 						fmt.Fprintf(os.Stderr, "input error: %v\n", perr)
 						val = ""
@@ -178,132 +222,207 @@ func RunCLI() {
 				continue
 			}
 
-			// Apply command using pure-Go stdlib engine
-			newImg, err := stdimg.ApplyCommandStdlib(cur, commandName, normArgs)
+			mask, err := buf.region.mask(buf.st.img.Bounds())
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "apply command error: %v\n", err)
+				fmt.Fprintf(os.Stderr, "region error: %v\n", err)
+				continue
+			}
+
+			// Apply command using pure-Go stdlib engine; Ctrl-C cancels it
+			ctx, stop := interruptContext()
+			progress := newProgressPrinter("Applying " + commandName)
+			prev := buf.st.img
+			newImg, err := stdimg.ApplyCommandMasked(ctx, buf.st.img, commandName, normArgs, mask, progress.update)
+			progress.finish()
+			stop()
+			if errors.Is(err, context.Canceled) {
+				fmt.Printf("%s cancelled; image unchanged\n", commandName)
 				continue
 			}
-			if newImg != nil {
-				cur = newImg
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "apply command error: %v\n", err)
+				continue
 			}
+			buf.record(Step{Name: commandName, Args: normArgs, Region: buf.region}, newImg)
 			fmt.Printf("Applied %s\n", commandName)
-			_ = PreviewImage(cur, currentFormat)
+			_ = PreviewImage(buf.view(), buf.st.format)
 			if commandName == "strip" {
 				// clear stored metadata on strip
-				currentAppSegments = nil
-				currentAutoOriented = false
+				buf.st.appSegments = nil
+				buf.st.autoOriented = false
 				fmt.Println("metadata cleared")
 			}
 			if commandName == "identify" {
-				if currentImagePath != "" {
-					if ex, err := ExtractEXIFStruct(currentImagePath); err == nil {
-						// Print a concise EXIF summary
-						if ex.Make != "" || ex.Model != "" {
-							fmt.Printf("Make: %s\nModel: %s\n", ex.Make, ex.Model)
-						}
-						if ex.Software != "" {
-							fmt.Printf("Software: %s\n", ex.Software)
-						}
-						if ex.Orientation != 0 {
-							fmt.Printf("Orientation: %d\n", ex.Orientation)
-						}
-						if ex.DateTimeOriginal != "" {
-							fmt.Printf("DateTimeOriginal: %s\n", ex.DateTimeOriginal)
-						}
-						if ex.ExposureTime != "" {
-							fmt.Printf("ExposureTime: %s sec\n", ex.ExposureTime)
-						}
-						if ex.Exposure != 0 {
-							fmt.Printf("Exposure: %.4f sec\n", ex.Exposure)
-						}
-						if ex.ShutterSpeed != "" {
-							fmt.Printf("ShutterSpeed: %s\n", ex.ShutterSpeed)
-						}
-						if ex.ApertureValue != 0 {
-							fmt.Printf("ApertureValue: f/%.1f\n", ex.ApertureValue)
-						}
-						if ex.FNumber != 0 {
-							fmt.Printf("FNumber: f/%.1f\n", ex.FNumber)
-						}
-						if ex.MeteringMode != 0 {
-							fmt.Printf("MeteringMode: %d\n", ex.MeteringMode)
-						}
-						if ex.Flash != 0 {
-							fmt.Printf("Flash: %d\n", ex.Flash)
-						}
-						if ex.ISOSpeed != 0 {
-							fmt.Printf("ISO Speed: %d\n", ex.ISOSpeed)
-						}
-						if ex.FocalLength != 0 {
-							fmt.Printf("FocalLength: %.1f mm\n", ex.FocalLength)
-						}
-						if ex.LensModel != "" {
-							fmt.Printf("LenseModel: %s\n", ex.LensModel)
-						}
-						if ex.GPS != nil {
-							// Print a nicely formatted GPS summary
-							lat := ex.GPS.Latitude
-							lon := ex.GPS.Longitude
-							latRef := ex.GPS.LatRef
-							lonRef := ex.GPS.LonRef
-							alt := ex.GPS.Altitude
-							altRef := ex.GPS.AltitudeRef
-
-							fmt.Println("GPS:")
-							fmt.Printf("  Latitude:  %.8f %s\n", lat, latRef)
-							fmt.Printf("  Longitude: %.8f %s\n", lon, lonRef)
-
-							if alt != 0 {
-								refStr := "above sea level"
-								if altRef == 1 {
-									refStr = "below sea level"
-								}
-								fmt.Printf("  Altitude:  %.2f m (%s)\n", alt, refStr)
-							}
-
-							if ex.GPS.GPSDateStamp != "" || ex.GPS.GPSTimeStamp != "" {
-								if ex.GPS.GPSDateStamp != "" {
-									fmt.Printf("  GPS Date:  %s\n", ex.GPS.GPSDateStamp)
-								}
-								if ex.GPS.GPSTimeStamp != "" {
-									fmt.Printf("  GPS Time:  %s\n", ex.GPS.GPSTimeStamp)
-								}
-							}
-
-							if !ex.GPS.Timestamp.IsZero() {
-								fmt.Printf("  Timestamp: %s\n", ex.GPS.Timestamp.String())
-							}
-						}
-					} else {
-						fmt.Fprintf(os.Stderr, "failed to extract EXIF: %v\n", err)
+				if normArgs[0] == "true" {
+					if err := writeIdentifyJSON(os.Stdout, buf.st, true); err != nil {
+						fmt.Fprintf(os.Stderr, "identify error: %v\n", err)
 					}
 				} else {
-					fmt.Println("identify: no image path available to extract EXIF")
+					printIdentify(os.Stdout, buf.st.path)
 				}
 			}
-			if info, ierr := GetImageInfoImage(cur); ierr == nil {
+			if commandName == "compare" {
+				reportComparison(os.Stdout, prev, normArgs[0])
+			}
+			if info, ierr := GetImageInfoImage(buf.st.img); ierr == nil {
 				fmt.Println(info)
 			}
 			continue
 
 		case 's':
-			out, _ := PromptLine("Enter output filename: ")
+			if buf == nil {
+				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
+				continue
+			}
+			out, _ := PromptLineKind("Enter output filename: ", "path", completePath)
 			if out == "" {
 				fmt.Println("no filename provided")
 				continue
 			}
-			if err := SaveImage(out, cur, currentAppSegments, currentAutoOriented); err != nil {
+			if err := buf.save(out); err != nil {
 				fmt.Fprintf(os.Stderr, "failed to write image: %v\n", err)
 				continue
 			}
 			fmt.Printf("Saved to %s\n", out)
 
+		case 'r':
+			if buf == nil {
+				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
+				continue
+			}
+			recipePath, _ := PromptLineWithFzf("Enter recipe path [enter '/' to use fzf] (leave empty to cancel): ")
+			if recipePath == "" {
+				fmt.Println("recipe cancelled")
+				continue
+			}
+			varLine, _ := PromptLineKind("Variables as name=value (leave empty for recipe defaults): ", "vars", nil)
+			vars := map[string]string{}
+			var varErr error
+			for _, kv := range strings.Fields(varLine) {
+				if varErr = ParseVarFlag(kv, vars); varErr != nil {
+					break
+				}
+			}
+			if varErr != nil {
+				fmt.Fprintf(os.Stderr, "input validation error: %v\n", varErr)
+				continue
+			}
+			rec, err := LoadRecipe(recipePath, storeStd, vars)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "recipe error: %v\n", err)
+				continue
+			}
+			var applied []Step
+			ctx, stop := interruptContext()
+			st, err := applySteps(ctx, storeStd, buf.st, rec.Steps, func(_ int, s Step, _ imageState) {
+				fmt.Printf("Applied %s (line %d)\n", s.Name, s.Line)
+				applied = append(applied, Step{Name: s.Name, Args: s.Args, Region: s.Region})
+			})
+			stop()
+			if errors.Is(err, context.Canceled) {
+				fmt.Println("recipe cancelled; image unchanged")
+				continue
+			}
+			if err != nil {
+				// Leave the image untouched so a half-applied recipe never sticks.
+				fmt.Fprintf(os.Stderr, "recipe aborted at %v; image unchanged\n", err)
+				continue
+			}
+			buf.hist.Push(buf.st, historyAction{Label: "recipe " + recipePath, Steps: applied})
+			buf.st = st
+			buf.macro = append(buf.macro, applied...)
+			fmt.Printf("Applied recipe %s (%d steps)\n", recipePath, len(rec.Steps))
+			_ = PreviewImage(buf.view(), buf.st.format)
+			if info, ierr := GetImageInfoImage(buf.st.img); ierr == nil {
+				fmt.Println(info)
+			}
+			continue
+
+		case 'R':
+			if buf == nil {
+				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
+				continue
+			}
+			fmt.Printf("Current region: %s\n", buf.region)
+			region, err := promptRegion()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "input validation error: %v\n", err)
+				continue
+			}
+			if _, err := region.mask(buf.st.img.Bounds()); err != nil {
+				fmt.Fprintf(os.Stderr, "region error: %v\n", err)
+				continue
+			}
+			buf.region = region
+			fmt.Printf("Commands now apply to: %s\n", buf.region)
+			continue
+
+		case 'S':
+			if buf == nil {
+				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
+				continue
+			}
+			if err := editSelection(buf); err != nil {
+				fmt.Fprintf(os.Stderr, "selection error: %v\n", err)
+			}
+			continue
+
+		case 'L':
+			if buf == nil {
+				fmt.Println("No image loaded. Press 'o' to open an image first, or provide an image path as the first argument.")
+				continue
+			}
+			if err := editLayers(sess, buf); err != nil {
+				fmt.Fprintf(os.Stderr, "layer error: %v\n", err)
+				continue
+			}
+			_ = PreviewImage(buf.view(), buf.st.format)
+			continue
+
+		case 'm':
+			if buf == nil || len(buf.macro) == 0 {
+				fmt.Println("nothing recorded yet; apply a command with '/' first")
+				continue
+			}
+			fmt.Printf("Recorded %d steps:\n", len(buf.macro))
+			usesSelection := false
+			for i, st := range buf.macro {
+				fmt.Printf("  %d) %s\n", i+1, stepLabel(st))
+				usesSelection = usesSelection || st.Region.Mask == selectionRef
+			}
+			if usesSelection {
+				fmt.Printf("note: %s only exists in this session; save it with 'S' then 'w' and use the file as --mask\n", selectionRef)
+			}
+			choice, _ := PromptLine("Export as (r)ecipe file, (c)ommand line, or (x) clear the log (leave empty to cancel): ")
+			switch strings.ToLower(choice) {
+			case "r", "recipe":
+				out, _ := PromptLineKind("Enter recipe filename: ", "path", completePath)
+				if out == "" {
+					fmt.Println("no filename provided")
+					continue
+				}
+				if err := os.WriteFile(out, []byte(FormatRecipe(buf.macro)), 0o644); err != nil {
+					fmt.Fprintf(os.Stderr, "failed to write recipe: %v\n", err)
+					continue
+				}
+				fmt.Printf("Saved recipe to %s\n", out)
+			case "c", "command":
+				fmt.Println(FormatShellCommand(buf.st.path, editedOutputPath(buf.st.path), buf.macro))
+			case "x", "clear":
+				buf.macro = nil
+				fmt.Println("recorded steps cleared")
+			case "":
+				fmt.Println("export cancelled")
+			default:
+				fmt.Printf("unknown choice: %s\n", choice)
+			}
+			continue
+
 		case 'o':
 			selected, selErr := SelectFileWithFzf(".")
 			var newPath string
 			if selErr != nil || selected == "" {
-				newPath, _ = PromptLine("Enter path to image to open (leave empty to cancel): ")
+				newPath, _ = PromptLineKind("Enter path to image to open (leave empty to cancel): ", "path", completePath)
 				if newPath == "" {
 					fmt.Println("open cancelled")
 					continue
@@ -312,23 +431,92 @@ func RunCLI() {
 				newPath = selected
 			}
 
-			img, format, meta, autoOriented, err := LoadImage(newPath)
+			nb, err := openBuffer(sess, newPath)
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "failed to read image %s: %v\n", newPath, err)
 				continue
 			}
-			cur = img
-			currentImagePath = newPath
-			currentFormat = format
-			currentAppSegments = meta
-			currentAutoOriented = autoOriented
-			fmt.Printf("Opened %s\n", newPath)
-			_ = PreviewImage(cur, currentFormat)
-			if info, ierr := GetImageInfoImage(cur); ierr == nil {
+			buf = nb
+			fmt.Printf("Opened %s as @%s\n", newPath, buf.Name)
+			_ = PreviewImage(buf.view(), buf.st.format)
+			if info, ierr := GetImageInfoImage(buf.st.img); ierr == nil {
+				fmt.Println(info)
+			}
+			continue
+
+		case 'z', 'y':
+			if buf == nil {
+				fmt.Println("No image loaded.")
+				continue
+			}
+			undo := r == 'z'
+			action, ok, err := buf.stepHistory(undo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "history error: %v\n", err)
+				continue
+			}
+			if !ok {
+				if undo {
+					fmt.Println("nothing to undo")
+				} else {
+					fmt.Println("nothing to redo")
+				}
+				continue
+			}
+			if undo {
+				fmt.Printf("Undid %s\n", action.Label)
+			} else {
+				fmt.Printf("Redid %s\n", action.Label)
+			}
+			_ = PreviewImage(buf.view(), buf.st.format)
+			if info, ierr := GetImageInfoImage(buf.st.img); ierr == nil {
+				fmt.Println(info)
+			}
+			continue
+
+		case 'H':
+			if buf == nil {
+				fmt.Println("No image loaded.")
+				continue
+			}
+			fmt.Printf("History of @%s:\n", buf.Name)
+			buf.hist.Print(os.Stdout)
+			continue
+
+		case 'b':
+			sess.Print(os.Stdout)
+			if buf == nil {
+				continue
+			}
+			ref, _ := PromptLineKind("Switch to buffer (name or number, leave empty to stay): ", "buffer", wordCompleter(sess.Names()))
+			if ref == "" {
+				continue
+			}
+			nb, err := sess.Switch(ref)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			fmt.Printf("Switched to @%s (%s)\n", nb.Name, nb.st.path)
+			_ = PreviewImage(nb.view(), nb.st.format)
+			if info, ierr := GetImageInfoImage(nb.st.img); ierr == nil {
 				fmt.Println(info)
 			}
 			continue
 
+		case 'c':
+			if buf == nil {
+				fmt.Println("No image loaded.")
+				continue
+			}
+			sess.Close(buf)
+			fmt.Printf("Closed @%s\n", buf.Name)
+			if nb := sess.Active(); nb != nil {
+				fmt.Printf("Active buffer: @%s (%s)\n", nb.Name, nb.st.path)
+				_ = PreviewImage(nb.view(), nb.st.format)
+			}
+			continue
+
 		case 'u':
 			err := CheckForUpdates()
 			if err != nil {
@@ -349,3 +537,98 @@ func RunCLI() {
 		}
 	}
 }
+
+// printIdentify writes a concise EXIF summary for the image at path to w.
+// Errors are reported on stderr so they never mix with the summary itself.
+func printIdentify(w io.Writer, path string) {
+	if path == "" {
+		fmt.Fprintln(w, "identify: no image path available to extract EXIF")
+		return
+	}
+	ex, err := ExtractEXIFStruct(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to extract EXIF: %v\n", err)
+		return
+	}
+	printEXIFSummary(w, ex)
+}
+
+// printEXIFSummary writes a concise, human-readable summary of ex to w.
+func printEXIFSummary(w io.Writer, ex EXIF) {
+	if ex.Make != "" || ex.Model != "" {
+		fmt.Fprintf(w, "Make: %s\nModel: %s\n", ex.Make, ex.Model)
+	}
+	if ex.Software != "" {
+		fmt.Fprintf(w, "Software: %s\n", ex.Software)
+	}
+	if ex.Orientation != 0 {
+		fmt.Fprintf(w, "Orientation: %d\n", ex.Orientation)
+	}
+	if ex.DateTimeOriginal != "" {
+		fmt.Fprintf(w, "DateTimeOriginal: %s\n", ex.DateTimeOriginal)
+	}
+	if ex.ExposureTime != "" {
+		fmt.Fprintf(w, "ExposureTime: %s sec\n", ex.ExposureTime)
+	}
+	if ex.Exposure != 0 {
+		fmt.Fprintf(w, "Exposure: %.4f sec\n", ex.Exposure)
+	}
+	if ex.ShutterSpeed != "" {
+		fmt.Fprintf(w, "ShutterSpeed: %s\n", ex.ShutterSpeed)
+	}
+	if ex.ApertureValue != 0 {
+		fmt.Fprintf(w, "ApertureValue: f/%.1f\n", ex.ApertureValue)
+	}
+	if ex.FNumber != 0 {
+		fmt.Fprintf(w, "FNumber: f/%.1f\n", ex.FNumber)
+	}
+	if ex.MeteringMode != 0 {
+		fmt.Fprintf(w, "MeteringMode: %d\n", ex.MeteringMode)
+	}
+	if ex.Flash != 0 {
+		fmt.Fprintf(w, "Flash: %d\n", ex.Flash)
+	}
+	if ex.ISOSpeed != 0 {
+		fmt.Fprintf(w, "ISO Speed: %d\n", ex.ISOSpeed)
+	}
+	if ex.FocalLength != 0 {
+		fmt.Fprintf(w, "FocalLength: %.1f mm\n", ex.FocalLength)
+	}
+	if ex.LensModel != "" {
+		fmt.Fprintf(w, "LenseModel: %s\n", ex.LensModel)
+	}
+	if ex.GPS != nil {
+		// Print a nicely formatted GPS summary
+		lat := ex.GPS.Latitude
+		lon := ex.GPS.Longitude
+		latRef := ex.GPS.LatRef
+		lonRef := ex.GPS.LonRef
+		alt := ex.GPS.Altitude
+		altRef := ex.GPS.AltitudeRef
+
+		fmt.Fprintln(w, "GPS:")
+		fmt.Fprintf(w, "  Latitude:  %.8f %s\n", lat, latRef)
+		fmt.Fprintf(w, "  Longitude: %.8f %s\n", lon, lonRef)
+
+		if alt != 0 {
+			refStr := "above sea level"
+			if altRef == 1 {
+				refStr = "below sea level"
+			}
+			fmt.Fprintf(w, "  Altitude:  %.2f m (%s)\n", alt, refStr)
+		}
+
+		if ex.GPS.GPSDateStamp != "" || ex.GPS.GPSTimeStamp != "" {
+			if ex.GPS.GPSDateStamp != "" {
+				fmt.Fprintf(w, "  GPS Date:  %s\n", ex.GPS.GPSDateStamp)
+			}
+			if ex.GPS.GPSTimeStamp != "" {
+				fmt.Fprintf(w, "  GPS Time:  %s\n", ex.GPS.GPSTimeStamp)
+			}
+		}
+
+		if !ex.GPS.Timestamp.IsZero() {
+			fmt.Fprintf(w, "  Timestamp: %s\n", ex.GPS.Timestamp.String())
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// Exit codes of `timp compare`, as with cmp(1): the images are within the
+// threshold, they differ beyond it, or they could not be compared.
+const (
+	compareSame    = 0
+	compareDiffer  = 1
+	compareTrouble = 2
+)
+
+// exitStatus is an error that makes RunCLI exit with code rather than 1.
+type exitStatus struct {
+	code int
+	err  error
+}
+
+func (e *exitStatus) Error() string { return e.err.Error() }
+func (e *exitStatus) Unwrap() error { return e.err }
+
+// compareMetrics are the names accepted by --metric, with whether a higher
+// value means more similar images.
+var compareMetrics = map[string]bool{"mae": false, "mse": false, "psnr": true, "ssim": true, "deltae": false}
+
+// metric returns the named measure of c.
+func metric(c stdimg.Comparison, name string) float64 {
+	switch name {
+	case "mae":
+		return c.MAE
+	case "mse":
+		return c.MSE
+	case "psnr":
+		return c.PSNR
+	case "ssim":
+		return c.SSIM
+	}
+	return c.DeltaE
+}
+
+// withinThreshold reports whether c passes: metric name no worse than
+// threshold, or identical images when no threshold is given.
+func withinThreshold(c stdimg.Comparison, name string, threshold *float64) bool {
+	if threshold == nil {
+		return c.Identical()
+	}
+	v := metric(c, name)
+	if compareMetrics[name] {
+		return v >= *threshold
+	}
+	return v <= *threshold
+}
+
+// printComparison writes the measures of c, one per line.
+func printComparison(w io.Writer, c stdimg.Comparison) {
+	psnr := fmt.Sprintf("%.2f dB", c.PSNR)
+	if math.IsInf(c.PSNR, 1) {
+		psnr = "inf (identical)"
+	}
+	fmt.Fprintf(w, "MAE     %.4f\nMSE     %.4f\nPSNR    %s\nSSIM    %.5f\ndeltaE  %.4f\n", c.MAE, c.MSE, psnr, c.SSIM, c.DeltaE)
+}
+
+// writeComparisonJSON writes c as one JSON object. PSNR is null for
+// identical images, since JSON has no infinity.
+func writeComparisonJSON(w io.Writer, c stdimg.Comparison, pass bool) error {
+	var psnr *float64
+	if !math.IsInf(c.PSNR, 1) {
+		psnr = &c.PSNR
+	}
+	return json.NewEncoder(w).Encode(struct {
+		MAE       float64  `json:"mae"`
+		MSE       float64  `json:"mse"`
+		PSNR      *float64 `json:"psnr"`
+		SSIM      float64  `json:"ssim"`
+		DeltaE    float64  `json:"deltae"`
+		Identical bool     `json:"identical"`
+		Pass      bool     `json:"pass"`
+	}{c.MAE, c.MSE, psnr, c.SSIM, c.DeltaE, c.Identical(), pass})
+}
+
+// reportComparison prints how much img differs from the image named by
+// ref, for the compare command in the editor and in `timp run`.
+func reportComparison(w io.Writer, img image.Image, ref string) {
+	c, err := stdimg.CompareToSource(img, ref)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "compare error: %v\n", err)
+		return
+	}
+	printComparison(w, c)
+}
+
+// compareUsage prints the usage for the `compare` subcommand.
+func compareUsage() {
+	fmt.Fprintln(os.Stderr, "usage: timp compare [--metric mae|mse|psnr|ssim|deltae] [--threshold N] [--diff out.png] [--scale N] [--json] <image> <reference>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Prints MAE, MSE, PSNR, SSIM and mean Lab delta-E between two images of the")
+	fmt.Fprintln(os.Stderr, "same size. Colors are compared premultiplied by alpha and alpha counts as a")
+	fmt.Fprintln(os.Stderr, "fourth channel. Exits 0 if every alpha value and every visible color match,")
+	fmt.Fprintln(os.Stderr, "or with --threshold if the metric (default psnr) is no worse than N; 1 if they")
+	fmt.Fprintln(os.Stderr, "differ more; 2 on errors.")
+	fmt.Fprintln(os.Stderr, "--diff writes a heat map of the differences, white at a delta-E of --scale (10).")
+}
+
+// RunCompare implements `timp compare`. Errors carry an exitStatus: 1 when
+// the images differ beyond the threshold, 2 when they cannot be compared.
+func RunCompare(args []string) error {
+	trouble := func(err error) error { return &exitStatus{compareTrouble, err} }
+	name := "psnr"
+	var threshold *float64
+	var diffPath string
+	scale := 10.0
+	asJSON := false
+	var paths []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--metric", "--threshold", "--diff", "--scale":
+			if i+1 >= len(args) {
+				return trouble(fmt.Errorf("%s requires a value", args[i]))
+			}
+			v := args[i+1]
+			i++
+			switch args[i-1] {
+			case "--metric":
+				name = strings.ToLower(v)
+				if _, ok := compareMetrics[name]; !ok {
+					return trouble(fmt.Errorf("unknown metric %q", v))
+				}
+			case "--diff":
+				diffPath = v
+			default:
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return trouble(fmt.Errorf("invalid %s %q", args[i-1], v))
+				}
+				if args[i-1] == "--scale" {
+					scale = f
+				} else {
+					threshold = &f
+				}
+			}
+		case "--json", "-j":
+			asJSON = true
+		case "-h", "--help":
+			compareUsage()
+			return nil
+		default:
+			paths = append(paths, args[i])
+		}
+	}
+	if len(paths) != 2 {
+		compareUsage()
+		return trouble(fmt.Errorf("need two images to compare"))
+	}
+	var imgs [2]*image.NRGBA
+	for i, p := range paths {
+		img, _, _, _, err := LoadImage(p)
+		if err != nil {
+			return trouble(fmt.Errorf("failed to read image %s: %w", p, err))
+		}
+		imgs[i] = stdimg.ToNRGBA(img)
+	}
+	c, err := stdimg.CompareImages(imgs[0], imgs[1])
+	if err != nil {
+		return trouble(err)
+	}
+	if diffPath != "" {
+		heat, err := stdimg.DifferenceMap(imgs[0], imgs[1], scale)
+		if err == nil {
+			err = SaveImage(diffPath, heat, nil, false)
+		}
+		if err != nil {
+			return trouble(fmt.Errorf("failed to write %s: %w", diffPath, err))
+		}
+	}
+	pass := withinThreshold(c, name, threshold)
+	if asJSON {
+		if err := writeComparisonJSON(os.Stdout, c, pass); err != nil {
+			return trouble(err)
+		}
+	} else {
+		printComparison(os.Stdout, c)
+	}
+	if pass {
+		return nil
+	}
+	if threshold == nil {
+		return &exitStatus{compareDiffer, fmt.Errorf("images differ")}
+	}
+	return &exitStatus{compareDiffer, fmt.Errorf("images differ: %s %.4g is beyond the threshold %g", name, metric(c, name), *threshold)}
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeShadedPNG(t *testing.T, path string, shade, alpha uint8) {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(x * 16), uint8(y * 16), shade, alpha})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func compareExitCode(err error) int {
+	var status *exitStatus
+	if errors.As(err, &status) {
+		return status.code
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+func TestRunCompareExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.png")
+	same := filepath.Join(dir, "same.png")
+	near := filepath.Join(dir, "near.png")
+	transparent := filepath.Join(dir, "clear.png")
+	transparentOther := filepath.Join(dir, "clear-other.png")
+	writeShadedPNG(t, a, 100, 255)
+	writeShadedPNG(t, same, 100, 255)
+	writeShadedPNG(t, near, 104, 255)
+	writeShadedPNG(t, transparent, 100, 0)
+	writeShadedPNG(t, transparentOther, 104, 0)
+	writeTestPNG(t, filepath.Join(dir, "small.png"), 8, 8)
+
+	diff := filepath.Join(dir, "diff.png")
+	for _, tc := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{a, same}, 0},
+		{[]string{a, near}, 1},
+		// MSE over R, G, B and A is 16/4, a PSNR of about 42.1 dB
+		{[]string{"--threshold", "42", a, near}, 0},
+		{[]string{"--threshold", "43", a, near}, 1},
+		{[]string{"--metric", "mae", "--threshold", "1", a, near}, 0},
+		{[]string{"--metric", "mae", "--threshold", "0.5", a, near}, 1},
+		// same colors, but one is fully transparent
+		{[]string{a, transparent}, 1},
+		{[]string{"--json", a, transparent}, 1},
+		// the colors under fully transparent pixels are not compared
+		{[]string{transparent, transparentOther}, 0},
+		{[]string{"--json", "--diff", diff, a, near}, 1},
+		{[]string{a, filepath.Join(dir, "small.png")}, 2},
+		{[]string{a, filepath.Join(dir, "missing.png")}, 2},
+		{[]string{"--metric", "psnrr", a, same}, 2},
+		{[]string{a}, 2},
+	} {
+		if got := compareExitCode(RunCompare(tc.args)); got != tc.want {
+			t.Errorf("compare %q: exit %d, want %d", tc.args, got, tc.want)
+		}
+	}
+	if _, err := os.Stat(diff); err != nil {
+		t.Errorf("--diff wrote no heat map: %v", err)
+	}
+}
@@ -0,0 +1,545 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// projectConfigName is the per-directory config file, read from the current
+// working directory.
+const projectConfigName = ".timp.json"
+
+// configKey describes one setting: its JSON key, the environment variable
+// that overrides it (if any), its default and how to validate a value.
+type configKey struct {
+	Name     string
+	Env      string
+	Default  string
+	Doc      string
+	validate func(string) error
+}
+
+// configKeys is the config schema. Settings are resolved in order of
+// precedence: -c flags, environment, project config, user config, default.
+var configKeys = []configKey{
+	{"jpeg_quality", "TIMP_JPEG_QUALITY", "92", "JPEG quality (1-100) used when saving and previewing", intBetween(1, 100)},
+	{"preview_backend", "PREVIEW_BACKEND", "", "preferred preview backend: kitty, inline, sixel or chafa (empty = detect)", oneOf("", "kitty", "inline", "iterm", "wezterm", "sixel", "chafa")},
+	{"preview_debug", "PREVIEW_DEBUG", "false", "log preview backend decisions to stderr", isBool},
+	{"preview_min_cols", "TIMP_PREVIEW_MIN_COLS", "6", "smallest preview width in terminal columns", intBetween(1, 10000)},
+	{"preview_min_rows", "TIMP_PREVIEW_MIN_ROWS", "3", "smallest preview height in terminal rows", intBetween(1, 10000)},
+	{"preview_max_cols", "TIMP_PREVIEW_MAX_COLS", "80", "largest preview width in terminal columns", intBetween(1, 10000)},
+	{"preview_max_rows", "TIMP_PREVIEW_MAX_ROWS", "40", "largest preview height in terminal rows", intBetween(1, 10000)},
+	{"histogram_smooth_window", "TIMP_HISTOGRAM_SMOOTH_WINDOW", "20", "smoothing window (pixels) for the vertical scale of the histogram render", intBetween(1, 255)},
+	{"workers", "TIMP_WORKERS", "0", "goroutines used by filters; 0 uses every CPU, 1 runs serially for reproducible profiling", intBetween(0, 1024)},
+	{"linear_light", "TIMP_LINEAR_LIGHT", "false", "resize, rotate, blur and composite in linear light unless a command's linear argument says otherwise", isBool},
+	{"history_memory", "TIMP_HISTORY_MEM", "512MB", "memory budget for undo history per buffer (e.g. 256MB, 2G)", isByteSize},
+	{"tile_memory", "TIMP_TILE_MEMORY", "0", "working-memory budget of blur, median, edge, sharpen and adaptiveThreshold; larger images are filtered in tiles (e.g. 256MB; 0 filters whole images)", isByteSize},
+	{"dotenv", "TIMP_DOTENV", "true", "load a .env file from the current directory", isBool},
+	{"plugins", "TIMP_PLUGINS", "true", "load timp-plugin-* commands from PATH and the plugins config directory", isBool},
+	{"plugin_timeout", "TIMP_PLUGIN_TIMEOUT", "30s", "how long a plugin may run on one image before it is killed", isDuration},
+}
+
+// configValue is a resolved setting and a description of where it came from.
+type configValue struct {
+	Value  string
+	Source string
+}
+
+// Config holds the effective settings. CommandDefaults maps a command name
+// to argument defaults used when an optional argument is left empty.
+type Config struct {
+	values          map[string]configValue
+	CommandDefaults map[string]map[string]configValue
+	// Files lists the config files that were consulted, in load order.
+	Files []string
+}
+
+// activeConfig is the configuration in effect; RunCLI replaces it with the
+// loaded one. Until then every setting has its default.
+var activeConfig = defaultConfig()
+
+func defaultConfig() *Config {
+	c := &Config{values: map[string]configValue{}, CommandDefaults: map[string]map[string]configValue{}}
+	for _, k := range configKeys {
+		c.values[k.Name] = configValue{Value: k.Default, Source: "default"}
+	}
+	return c
+}
+
+// Get returns the effective value of key.
+func (c *Config) Get(key string) string {
+	return c.values[key].Value
+}
+
+// Int returns key as an integer. Values are validated on load, so this only
+// fails for keys that are not integers at all.
+func (c *Config) Int(key string) int {
+	n, _ := strconv.Atoi(c.Get(key))
+	return n
+}
+
+// Bool returns key as a boolean.
+func (c *Config) Bool(key string) bool {
+	b, _ := parseBoolLikeToString(c.Get(key))
+	return b == "true"
+}
+
+// userConfigPath returns the user config file location:
+// $XDG_CONFIG_HOME/timp/config.json on Linux, the platform equivalent
+// elsewhere.
+func userConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "timp", "config.json")
+}
+
+// LoadConfig resolves the configuration from the user and project config
+// files, the environment and overrides given as "key=value" (from -c flags).
+// A .env file in the current directory is loaded first unless dotenv is
+// turned off. Command defaults are not checked here; see
+// CheckCommandDefaults.
+func LoadConfig(overrides []string) (*Config, error) {
+	return loadConfig(userConfigPath(), projectConfigName, overrides, os.Getenv, func() {
+		_ = LoadDotEnv(".env") // optional
+	})
+}
+
+// loadConfig is LoadConfig with its inputs injected. loadDotEnv is called,
+// if dotenv is enabled, before environment variables are consulted.
+func loadConfig(userPath, projectPath string, overrides []string, getenv func(string) string, loadDotEnv func()) (*Config, error) {
+	c := defaultConfig()
+	layers := []struct {
+		path, label string
+	}{{userPath, "user"}, {projectPath, "project"}}
+	for _, l := range layers {
+		if l.path == "" {
+			continue
+		}
+		if err := c.mergeFile(l.path, l.label); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := map[string]string{}
+	for _, o := range overrides {
+		k, v, ok := strings.Cut(o, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("-c: expected key=value, got %q", o)
+		}
+		flags[k] = strings.TrimSpace(v)
+	}
+
+	// dotenv decides whether .env feeds the environment, so it is resolved
+	// before the other keys look at the environment.
+	if err := c.mergeEnvAndFlag(keyByName("dotenv"), getenv, flags, nil); err != nil {
+		return nil, err
+	}
+	var before map[string]string
+	if c.Bool("dotenv") && loadDotEnv != nil {
+		before = map[string]string{}
+		for _, k := range configKeys {
+			if k.Env != "" {
+				before[k.Env] = getenv(k.Env)
+			}
+		}
+		loadDotEnv()
+	}
+	for _, k := range configKeys {
+		if k.Name == "dotenv" {
+			continue
+		}
+		if err := c.mergeEnvAndFlag(k, getenv, flags, before); err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range flags {
+		rest, ok := strings.CutPrefix(k, "command_defaults.")
+		if !ok {
+			if _, known := c.values[k]; !known {
+				return nil, fmt.Errorf("-c: unknown config key %q", k)
+			}
+			continue
+		}
+		cmd, arg, ok := strings.Cut(rest, ".")
+		if !ok {
+			return nil, fmt.Errorf("-c: expected command_defaults.<command>.<arg>, got %q", k)
+		}
+		c.setCommandDefault(cmd, arg, v, "flag -c")
+	}
+	return c, nil
+}
+
+func keyByName(name string) configKey {
+	for _, k := range configKeys {
+		if k.Name == name {
+			return k
+		}
+	}
+	panic("unknown config key " + name)
+}
+
+// mergeEnvAndFlag applies k's environment variable, then its -c flag.
+// Invalid environment values are reported and ignored, as before the config
+// file existed; invalid flags are errors. before holds environment values
+// from prior to loading .env so that values it set can be labelled.
+func (c *Config) mergeEnvAndFlag(k configKey, getenv func(string) string, flags map[string]string, before map[string]string) error {
+	if k.Env != "" {
+		if v := getenv(k.Env); v != "" {
+			if err := k.validate(v); err != nil {
+				fmt.Fprintf(os.Stderr, "ignoring %s: %v\n", k.Env, err)
+			} else {
+				src := "env " + k.Env
+				if before != nil && before[k.Env] != v {
+					src = ".env " + k.Env
+				}
+				c.values[k.Name] = configValue{Value: v, Source: src}
+			}
+		}
+	}
+	if v, ok := flags[k.Name]; ok {
+		if err := k.validate(v); err != nil {
+			return fmt.Errorf("-c %s: %w", k.Name, err)
+		}
+		c.values[k.Name] = configValue{Value: v, Source: "flag -c"}
+	}
+	return nil
+}
+
+// mergeFile applies the settings in the JSON config file at path. A missing
+// file is not an error; unknown keys are reported and skipped.
+func (c *Config) mergeFile(path, label string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read config %s: %w", path, err)
+	}
+	if abs, aerr := filepath.Abs(path); aerr == nil {
+		path = abs
+	}
+	c.Files = append(c.Files, path)
+	src := label + " " + path
+
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, name := range keys {
+		msg := raw[name]
+		if name == "command_defaults" {
+			var cmds map[string]map[string]any
+			if err := decodeJSONNumber(msg, &cmds); err != nil {
+				return fmt.Errorf("config %s: command_defaults: %w", path, err)
+			}
+			for cmd, args := range cmds {
+				for arg, v := range args {
+					s, err := configScalar(v)
+					if err != nil {
+						return fmt.Errorf("config %s: command_defaults.%s.%s: %w", path, cmd, arg, err)
+					}
+					c.setCommandDefault(cmd, arg, s, src)
+				}
+			}
+			continue
+		}
+		if _, known := c.values[name]; !known {
+			fmt.Fprintf(os.Stderr, "config %s: ignoring unknown key %q\n", path, name)
+			continue
+		}
+		var v any
+		if err := decodeJSONNumber(msg, &v); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, name, err)
+		}
+		s, err := configScalar(v)
+		if err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, name, err)
+		}
+		if err := keyByName(name).validate(s); err != nil {
+			return fmt.Errorf("config %s: %s: %w", path, name, err)
+		}
+		c.values[name] = configValue{Value: s, Source: src}
+	}
+	return nil
+}
+
+func decodeJSONNumber(msg json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(msg))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// configScalar renders a JSON string, number or boolean as a setting value.
+func configScalar(v any) (string, error) {
+	switch x := v.(type) {
+	case string:
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	}
+	return "", fmt.Errorf("expected a string, number or boolean")
+}
+
+// setCommandDefault records a default for cmd's argument arg. Whether both
+// exist is checked by CheckCommandDefaults, once plugin commands are
+// registered; the value itself is validated when the command runs.
+func (c *Config) setCommandDefault(cmd, arg, value, source string) {
+	if c.CommandDefaults[cmd] == nil {
+		c.CommandDefaults[cmd] = map[string]configValue{}
+	}
+	c.CommandDefaults[cmd][arg] = configValue{Value: value, Source: source}
+}
+
+// CheckCommandDefaults reports a command default whose command or argument
+// does not exist. Call it after LoadPlugins so that defaults for plugin
+// commands are accepted.
+func (c *Config) CheckCommandDefaults() error {
+	cmds := make([]string, 0, len(c.CommandDefaults))
+	for cmd := range c.CommandDefaults {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		args := make([]string, 0, len(c.CommandDefaults[cmd]))
+		for a := range c.CommandDefaults[cmd] {
+			args = append(args, a)
+		}
+		sort.Strings(args)
+		spec, _, ok := stdimg.Lookup(cmd)
+		if !ok {
+			return fmt.Errorf("%s: command_defaults: unknown command %q", c.CommandDefaults[cmd][args[0]].Source, cmd)
+		}
+		for _, arg := range args {
+			found := false
+			for _, a := range spec.Args {
+				if a.Name == arg {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s: command_defaults: %s has no argument %q", c.CommandDefaults[cmd][arg].Source, cmd, arg)
+			}
+		}
+	}
+	return nil
+}
+
+// argDefaults flattens CommandDefaults for StdMetaStore.
+func (c *Config) argDefaults() map[string]map[string]string {
+	out := make(map[string]map[string]string, len(c.CommandDefaults))
+	for cmd, args := range c.CommandDefaults {
+		out[cmd] = make(map[string]string, len(args))
+		for a, v := range args {
+			out[cmd][a] = v.Value
+		}
+	}
+	return out
+}
+
+// Print writes every setting with its effective value and source to w.
+func (c *Config) Print(w io.Writer) {
+	fmt.Fprintf(w, "user config:    %s\n", orNone(userConfigPath()))
+	fmt.Fprintf(w, "project config: %s\n", projectConfigName)
+	if len(c.Files) == 0 {
+		fmt.Fprintln(w, "loaded:         (none)")
+	}
+	for _, f := range c.Files {
+		fmt.Fprintf(w, "loaded:         %s\n", f)
+	}
+	fmt.Fprintln(w)
+	for _, k := range configKeys {
+		v := c.values[k.Name]
+		fmt.Fprintf(w, "%-24s = %-10s (%s)\n", k.Name, quoteEmpty(v.Value), v.Source)
+	}
+	cmds := make([]string, 0, len(c.CommandDefaults))
+	for cmd := range c.CommandDefaults {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	for _, cmd := range cmds {
+		args := make([]string, 0, len(c.CommandDefaults[cmd]))
+		for a := range c.CommandDefaults[cmd] {
+			args = append(args, a)
+		}
+		sort.Strings(args)
+		for _, a := range args {
+			v := c.CommandDefaults[cmd][a]
+			fmt.Fprintf(w, "%-24s = %-10s (%s)\n", "command_defaults."+cmd+"."+a, quoteEmpty(v.Value), v.Source)
+		}
+	}
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
+
+func quoteEmpty(s string) string {
+	if s == "" {
+		return `""`
+	}
+	return s
+}
+
+// applyConfig pushes c's settings into the places that use them.
+func applyConfig(c *Config) {
+	activeConfig = c
+	jpegQuality = c.Int("jpeg_quality")
+	previewBackend = strings.ToLower(c.Get("preview_backend"))
+	previewDebug = c.Bool("preview_debug")
+	previewClamp = previewLimits{
+		MinCols: c.Int("preview_min_cols"),
+		MinRows: c.Int("preview_min_rows"),
+		MaxCols: c.Int("preview_max_cols"),
+		MaxRows: c.Int("preview_max_rows"),
+	}
+	stdimg.HistogramSmoothWindow = c.Int("histogram_smooth_window")
+	stdimg.SetWorkers(c.Int("workers"))
+	stdimg.SetLinearLight(c.Bool("linear_light"))
+	historyMemory, _ = parseByteSize(c.Get("history_memory"))
+	tileMemory, _ := parseByteSize(c.Get("tile_memory"))
+	stdimg.SetTileMemory(tileMemory)
+	pluginTimeout, _ = time.ParseDuration(c.Get("plugin_timeout"))
+}
+
+// newCommandStore returns the command registry with the configured
+// per-command argument defaults applied.
+func newCommandStore() *StdMetaStore {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	store.argDefaults = activeConfig.argDefaults()
+	return store
+}
+
+// splitConfigFlags removes leading "-c key=value" options from args and
+// returns the remaining arguments and the collected overrides.
+func splitConfigFlags(args []string) (rest, overrides []string, err error) {
+	i := 0
+	for i < len(args) {
+		a := args[i]
+		if a != "-c" && a != "--set" {
+			if v, ok := strings.CutPrefix(a, "--set="); ok {
+				overrides = append(overrides, v)
+				i++
+				continue
+			}
+			break
+		}
+		if i+1 >= len(args) {
+			return nil, nil, fmt.Errorf("%s requires key=value", a)
+		}
+		overrides = append(overrides, args[i+1])
+		i += 2
+	}
+	return args[i:], overrides, nil
+}
+
+// RunConfig implements `timp config`.
+func RunConfig(args []string) error {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		configUsage()
+		if len(args) == 0 {
+			return fmt.Errorf("missing config subcommand")
+		}
+		return nil
+	}
+	switch args[0] {
+	case "show":
+		activeConfig.Print(os.Stdout)
+		return nil
+	case "keys":
+		for _, k := range configKeys {
+			env := ""
+			if k.Env != "" {
+				env = " [" + k.Env + "]"
+			}
+			fmt.Printf("%-24s %s (default %s)%s\n", k.Name, k.Doc, quoteEmpty(k.Default), env)
+		}
+		fmt.Printf("%-24s %s\n", "command_defaults", "per-command argument defaults, e.g. {\"blur\": {\"sigma\": 2}}")
+		return nil
+	}
+	configUsage()
+	return fmt.Errorf("unknown config subcommand %q", args[0])
+}
+
+func configUsage() {
+	fmt.Fprintln(os.Stderr, "usage: timp [-c key=value]... config show|keys")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "show prints each setting's effective value and where it came from;")
+	fmt.Fprintln(os.Stderr, "keys lists the settings with their defaults and environment variables.")
+}
+
+func intBetween(lo, hi int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", s)
+		}
+		if n < lo || n > hi {
+			return fmt.Errorf("%d is outside %d..%d", n, lo, hi)
+		}
+		return nil
+	}
+}
+
+func oneOf(choices ...string) func(string) error {
+	return func(s string) error {
+		for _, c := range choices {
+			if strings.EqualFold(s, c) {
+				return nil
+			}
+		}
+		var named []string
+		for _, c := range choices {
+			if c != "" {
+				named = append(named, c)
+			}
+		}
+		return fmt.Errorf("expected one of %s, got %q", strings.Join(named, ", "), s)
+	}
+}
+
+func isBool(s string) error {
+	_, err := parseBoolLikeToString(s)
+	return err
+}
+
+func isDuration(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("expected a duration such as 30s or 2m, got %q", s)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive, got %q", s)
+	}
+	return nil
+}
+
+func isByteSize(s string) error {
+	_, err := parseByteSize(s)
+	return err
+}
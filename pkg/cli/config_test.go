@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	user := writeConfigFile(t, dir, "user.json", `{"jpeg_quality": 70, "preview_max_cols": 100, "history_memory": "1G", "preview_debug": true}`)
+	project := writeConfigFile(t, dir, "project.json", `{"jpeg_quality": 75, "preview_max_cols": 120}`)
+	env := map[string]string{"TIMP_PREVIEW_MAX_COLS": "140", "PREVIEW_BACKEND": "sixel"}
+
+	c, err := loadConfig(user, project, []string{"preview_backend=chafa"}, func(k string) string { return env[k] }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct{ key, value, source string }{
+		{"jpeg_quality", "75", "project " + project},
+		{"preview_max_cols", "140", "env TIMP_PREVIEW_MAX_COLS"},
+		{"preview_backend", "chafa", "flag -c"},
+		{"history_memory", "1G", "user " + user},
+		{"preview_debug", "true", "user " + user},
+		{"preview_max_rows", "40", "default"},
+	}
+	for _, tc := range cases {
+		if got := c.values[tc.key]; got.Value != tc.value || got.Source != tc.source {
+			t.Errorf("%s = %+v, want %q from %q", tc.key, got, tc.value, tc.source)
+		}
+	}
+	if c.Int("jpeg_quality") != 75 || !c.Bool("preview_debug") {
+		t.Errorf("typed getters: quality %d debug %v", c.Int("jpeg_quality"), c.Bool("preview_debug"))
+	}
+}
+
+func TestLoadConfigDotEnv(t *testing.T) {
+	env := map[string]string{}
+	getenv := func(k string) string { return env[k] }
+	load := func() { env["TIMP_JPEG_QUALITY"] = "60" }
+
+	c, err := loadConfig("", "", nil, getenv, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.values["jpeg_quality"]; got.Value != "60" || got.Source != ".env TIMP_JPEG_QUALITY" {
+		t.Errorf("jpeg_quality = %+v", got)
+	}
+
+	env = map[string]string{}
+	c, err = loadConfig("", "", []string{"dotenv=false"}, getenv, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Get("jpeg_quality") != "92" {
+		t.Errorf("dotenv=false should skip .env, jpeg_quality = %s", c.Get("jpeg_quality"))
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	none := func(string) string { return "" }
+	bad := []struct {
+		name, file string
+		flags      []string
+	}{
+		{"syntax", `{"jpeg_quality": }`, nil},
+		{"range", `{"jpeg_quality": 101}`, nil},
+		{"backend", `{"preview_backend": "vt100"}`, nil},
+		{"unknown command", `{"command_defaults": {"nope": {"x": 1}}}`, nil},
+		{"unknown arg", `{"command_defaults": {"blur": {"nope": 1}}}`, nil},
+		{"unknown flag key", `{}`, []string{"bogus=1"}},
+		{"flag without value", `{}`, []string{"jpeg_quality"}},
+	}
+	for _, tc := range bad {
+		p := writeConfigFile(t, dir, "c.json", tc.file)
+		c, err := loadConfig(p, "", tc.flags, none, nil)
+		if err == nil {
+			err = c.CheckCommandDefaults()
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+	// Invalid environment values are ignored rather than fatal.
+	c, err := loadConfig("", "", nil, func(k string) string {
+		if k == "TIMP_HISTORY_MEM" {
+			return "lots"
+		}
+		return ""
+	}, nil)
+	if err != nil || c.Get("history_memory") != "512MB" {
+		t.Errorf("invalid env value: err %v, history_memory %s", err, c.Get("history_memory"))
+	}
+}
+
+func TestCommandDefaultsFillEmptyArgs(t *testing.T) {
+	dir := t.TempDir()
+	p := writeConfigFile(t, dir, "c.json", `{"command_defaults": {"addNoise": {"amount": 5}}}`)
+	c, err := loadConfig(p, "", []string{"command_defaults.addNoise.type=UNIFORM"}, func(string) string { return "" }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := activeConfig
+	activeConfig = c
+	defer func() { activeConfig = prev }()
+
+	store := newCommandStore()
+	got, err := NormalizeArgsFromStd(store, "addNoise", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"UNIFORM", "5", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("addNoise args = %q, want %q", got, want)
+	}
+	// Explicit values still win.
+	got, _ = NormalizeArgsFromStd(store, "addNoise", []string{"GAUSSIAN", "1", "7"})
+	if want := []string{"GAUSSIAN", "1", "7"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("explicit addNoise args = %q, want %q", got, want)
+	}
+
+	var sb strings.Builder
+	c.Print(&sb)
+	if !strings.Contains(sb.String(), "command_defaults.addNoise.type") || !strings.Contains(sb.String(), "(flag -c)") {
+		t.Errorf("Print output missing command default:\n%s", sb.String())
+	}
+}
+
+func TestSplitConfigFlags(t *testing.T) {
+	rest, over, err := splitConfigFlags([]string{"-c", "jpeg_quality=80", "--set=dotenv=false", "run", "-c", "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rest, []string{"run", "-c", "x"}) || !reflect.DeepEqual(over, []string{"jpeg_quality=80", "dotenv=false"}) {
+		t.Errorf("rest %q overrides %q", rest, over)
+	}
+	if _, _, err := splitConfigFlags([]string{"-c"}); err == nil {
+		t.Error("expected an error for -c without a value")
+	}
+}
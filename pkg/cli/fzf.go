@@ -3,7 +3,6 @@ package cli
 import (
 	"bytes"
 	"fmt"
-	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -117,5 +116,5 @@ func SelectFileWithFzf(startDir string) (string, error) {
 func clearKittyImages() {
 	// ESC _ G a=d ESC \
 	// We write to stdout so the control sequence targets the foreground terminal.
-	fmt.Fprint(os.Stdout, "\x1b_Ga=d\x1b\\")
+	fmt.Fprint(previewOut(), "\x1b_Ga=d\x1b\\")
 }
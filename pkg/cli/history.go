@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// defaultHistoryMemory is the snapshot budget used when history_memory is
+// not configured: enough for a handful of uncompressed 40MP frames.
+const defaultHistoryMemory = 512 << 20
+
+// historyAction describes one undoable action: a label for the history
+// listing and the recorded macro steps it added.
+type historyAction struct {
+	Label string
+	Steps []Step
+}
+
+// snapshot is a saved imageState. Its pixels live in one of three places:
+// resident (img), flate-compressed in memory (packed), or compressed in a
+// temp file (spill). Metadata is always kept in memory.
+type snapshot struct {
+	st     imageState
+	action historyAction
+
+	rect   image.Rectangle
+	deep   bool // packed pixels are NRGBA64
+	packed []byte
+	spill  string
+}
+
+// bytes returns the memory the snapshot's pixels currently occupy.
+func (s *snapshot) bytes() int64 {
+	switch {
+	case s.st.img != nil:
+		return imageBytes(s.st.img)
+	case s.spill == "":
+		return int64(len(s.packed))
+	}
+	return 0
+}
+
+// imageBytes estimates the in-memory size of img's pixel buffer.
+func imageBytes(img image.Image) int64 {
+	switch n := img.(type) {
+	case *image.NRGBA:
+		return int64(len(n.Pix))
+	case *image.NRGBA64:
+		return int64(len(n.Pix))
+	}
+	b := img.Bounds()
+	if stdimg.Is16Bit(img) {
+		return int64(b.Dx()) * int64(b.Dy()) * 8
+	}
+	return int64(b.Dx()) * int64(b.Dy()) * 4
+}
+
+// History is an undo/redo stack of image states bounded by a memory budget.
+// Once the snapshots exceed the budget, the oldest are compressed and, if
+// that is not enough, spilled to a temporary directory.
+type History struct {
+	undo   []*snapshot
+	redo   []*snapshot
+	budget int64
+	dir    string
+}
+
+// NewHistory returns an empty history with the given memory budget in bytes.
+func NewHistory(budget int64) *History {
+	return &History{budget: budget}
+}
+
+// historyMemory is the per-buffer snapshot budget, set from the
+// history_memory config key (TIMP_HISTORY_MEM in the environment).
+var historyMemory int64 = defaultHistoryMemory
+
+// parseByteSize parses sizes such as "512", "64K", "256MB" or "2GiB".
+// Suffixes are binary (K = 1024).
+func parseByteSize(s string) (int64, error) {
+	u := strings.ToUpper(strings.TrimSpace(s))
+	u = strings.TrimSuffix(strings.TrimSuffix(u, "B"), "I")
+	mult := int64(1)
+	if u != "" {
+		switch u[len(u)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			u = u[:len(u)-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(u), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// Push records prev, the state before action was applied, and discards any
+// redoable states.
+func (h *History) Push(prev imageState, action historyAction) {
+	for _, s := range h.redo {
+		h.discard(s)
+	}
+	h.redo = nil
+	h.undo = append(h.undo, &snapshot{st: prev, action: action})
+	h.enforceBudget()
+}
+
+// Undo returns the state before the most recent action together with that
+// action; cur becomes available to Redo. ok is false when there is nothing
+// to undo.
+func (h *History) Undo(cur imageState) (st imageState, action historyAction, ok bool, err error) {
+	if len(h.undo) == 0 {
+		return cur, historyAction{}, false, nil
+	}
+	s := h.undo[len(h.undo)-1]
+	st, err = h.restore(s)
+	if err != nil {
+		return cur, historyAction{}, false, err
+	}
+	h.undo = h.undo[:len(h.undo)-1]
+	h.redo = append(h.redo, &snapshot{st: cur, action: s.action})
+	h.enforceBudget()
+	return st, s.action, true, nil
+}
+
+// Redo reapplies the most recently undone action, returning its resulting
+// state; cur becomes available to Undo again.
+func (h *History) Redo(cur imageState) (st imageState, action historyAction, ok bool, err error) {
+	if len(h.redo) == 0 {
+		return cur, historyAction{}, false, nil
+	}
+	s := h.redo[len(h.redo)-1]
+	st, err = h.restore(s)
+	if err != nil {
+		return cur, historyAction{}, false, err
+	}
+	h.redo = h.redo[:len(h.redo)-1]
+	h.undo = append(h.undo, &snapshot{st: cur, action: s.action})
+	h.enforceBudget()
+	return st, s.action, true, nil
+}
+
+// Print writes the history listing to w: applied actions oldest first, a
+// marker for the current state, then undone (redoable) actions.
+func (h *History) Print(w io.Writer) {
+	if len(h.undo) == 0 && len(h.redo) == 0 {
+		fmt.Fprintln(w, "history is empty")
+		return
+	}
+	n := 0
+	for _, s := range h.undo {
+		n++
+		fmt.Fprintf(w, "  %d) %s%s\n", n, s.action.Label, s.storageNote())
+	}
+	fmt.Fprintln(w, "  -> current")
+	for i := len(h.redo) - 1; i >= 0; i-- {
+		n++
+		fmt.Fprintf(w, "  %d) %s (undone)%s\n", n, h.redo[i].action.Label, h.redo[i].storageNote())
+	}
+}
+
+func (s *snapshot) storageNote() string {
+	switch {
+	case s.spill != "":
+		return " [on disk]"
+	case s.packed != nil:
+		return " [compressed]"
+	}
+	return ""
+}
+
+// Close releases spilled snapshots.
+func (h *History) Close() {
+	if h.dir != "" {
+		_ = os.RemoveAll(h.dir)
+		h.dir = ""
+	}
+}
+
+// enforceBudget compresses, then spills, the oldest snapshots until the
+// resident total fits the budget. Snapshots at the bottom of each stack,
+// farthest from the current state, go first.
+func (h *History) enforceBudget() {
+	order := make([]*snapshot, 0, len(h.undo)+len(h.redo))
+	order = append(order, h.undo...)
+	order = append(order, h.redo...)
+	total := int64(0)
+	for _, s := range order {
+		total += s.bytes()
+	}
+	for _, s := range order {
+		if total <= h.budget {
+			return
+		}
+		if s.st.img != nil {
+			before := s.bytes()
+			if err := s.pack(); err != nil {
+				fmt.Fprintf(os.Stderr, "history: compress snapshot: %v\n", err)
+				continue
+			}
+			total += s.bytes() - before
+		}
+	}
+	for _, s := range order {
+		if total <= h.budget {
+			return
+		}
+		if s.packed != nil && s.spill == "" {
+			before := s.bytes()
+			if err := h.spillSnapshot(s); err != nil {
+				fmt.Fprintf(os.Stderr, "history: spill snapshot: %v\n", err)
+				return
+			}
+			total -= before
+		}
+	}
+}
+
+// pack replaces the resident pixels with a flate-compressed copy. 16-bit
+// images are packed as NRGBA64 so undo does not lose precision.
+func (s *snapshot) pack() error {
+	var rect image.Rectangle
+	var pix []byte
+	var stride, bpp int
+	if stdimg.Is16Bit(s.st.img) {
+		n, ok := s.st.img.(*image.NRGBA64)
+		if !ok {
+			n = stdimg.ToNRGBA64(s.st.img)
+		}
+		rect, pix, stride, bpp = n.Rect, n.Pix, n.Stride, 8
+	} else {
+		n, ok := s.st.img.(*image.NRGBA)
+		if !ok {
+			n = stdimg.ToNRGBA(s.st.img)
+		}
+		rect, pix, stride, bpp = n.Rect, n.Pix, n.Stride, 4
+	}
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+	// Write row by row so sub-images with a wider stride pack correctly.
+	w := rect.Dx() * bpp
+	for y := 0; y < rect.Dy(); y++ {
+		off := y * stride
+		if _, err := zw.Write(pix[off : off+w]); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	s.rect = rect
+	s.deep = bpp == 8
+	s.packed = buf.Bytes()
+	s.st.img = nil
+	return nil
+}
+
+func (h *History) spillSnapshot(s *snapshot) error {
+	if h.dir == "" {
+		dir, err := os.MkdirTemp("", "timp-history-*")
+		if err != nil {
+			return err
+		}
+		h.dir = dir
+	}
+	f, err := os.CreateTemp(h.dir, "snap-*.flate")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(s.packed); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.spill = f.Name()
+	s.packed = nil
+	return nil
+}
+
+// restore returns the full imageState held by s, decompressing or reading
+// back its pixels as needed. Reading back a spilled snapshot deletes its
+// file, so such an s can be restored only once; Undo and Redo drop s from
+// the history once it is restored.
+func (h *History) restore(s *snapshot) (imageState, error) {
+	st := s.st
+	if st.img != nil {
+		return st, nil
+	}
+	packed := s.packed
+	if s.spill != "" {
+		data, err := os.ReadFile(s.spill)
+		if err != nil {
+			return st, fmt.Errorf("read spilled snapshot: %w", err)
+		}
+		packed = data
+	}
+	var out image.Image
+	var pix []byte
+	if s.deep {
+		n := image.NewNRGBA64(s.rect)
+		out, pix = n, n.Pix
+	} else {
+		n := image.NewNRGBA(s.rect)
+		out, pix = n, n.Pix
+	}
+	zr := flate.NewReader(bytes.NewReader(packed))
+	defer zr.Close()
+	if _, err := io.ReadFull(zr, pix); err != nil {
+		return st, fmt.Errorf("decompress snapshot: %w", err)
+	}
+	if s.spill != "" {
+		h.discard(s)
+	}
+	st.img = out
+	return st, nil
+}
+
+// discard removes any temp file backing s.
+func (h *History) discard(s *snapshot) {
+	if s.spill != "" {
+		_ = os.Remove(s.spill)
+		s.spill = ""
+	}
+}
@@ -0,0 +1,169 @@
+package cli
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+)
+
+func solidState(v uint8, segs []AppSegment) imageState {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = v
+	}
+	img.SetNRGBA(3, 4, color.NRGBA{v + 1, v + 2, v + 3, 255})
+	return imageState{img: img, path: "in.jpg", format: "jpeg", appSegments: segs, autoOriented: segs != nil}
+}
+
+func samePixels(t *testing.T, a, b image.Image) {
+	t.Helper()
+	an, bn := a.(*image.NRGBA), b.(*image.NRGBA)
+	if an.Rect != bn.Rect || !bytes.Equal(an.Pix, bn.Pix) {
+		t.Fatalf("pixels differ after restore")
+	}
+}
+
+func TestHistoryUndoRedoRestoresMetadata(t *testing.T) {
+	h := NewHistory(defaultHistoryMemory)
+	defer h.Close()
+
+	segs := []AppSegment{{Marker: 0xE1, Payload: []byte("Exif\x00\x00")}}
+	s0 := solidState(10, segs)
+	// strip: same pixels, metadata cleared
+	s1 := imageState{img: s0.img, path: s0.path, format: s0.format}
+	h.Push(s0, historyAction{Label: "strip", Steps: []Step{{Name: "strip"}}})
+	s2 := solidState(20, nil)
+	h.Push(s1, historyAction{Label: "negate"})
+
+	got, a, ok, err := h.Undo(s2)
+	if err != nil || !ok || a.Label != "negate" {
+		t.Fatalf("undo 1: ok=%v err=%v action=%+v", ok, err, a)
+	}
+	got, a, ok, err = h.Undo(got)
+	if err != nil || !ok || a.Label != "strip" || len(a.Steps) != 1 {
+		t.Fatalf("undo 2: ok=%v err=%v action=%+v", ok, err, a)
+	}
+	if len(got.appSegments) != 1 || !got.autoOriented {
+		t.Fatalf("undoing strip did not restore metadata: %+v", got)
+	}
+	if _, _, ok, _ := h.Undo(got); ok {
+		t.Fatalf("expected nothing left to undo")
+	}
+
+	got, _, ok, err = h.Redo(got)
+	if err != nil || !ok || got.appSegments != nil {
+		t.Fatalf("redo strip: ok=%v err=%v segs=%v", ok, err, got.appSegments)
+	}
+	got, _, _, _ = h.Redo(got)
+	samePixels(t, got.img, s2.img)
+
+	// A new action discards the redo stack.
+	h.Undo(got)
+	h.Push(s1, historyAction{Label: "blur 2"})
+	if _, _, ok, _ := h.Redo(s2); ok {
+		t.Fatalf("redo should be empty after a new action")
+	}
+}
+
+func TestUndoAfterIdentifyKeepsMacro(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+	s0 := solidState(10, nil)
+	buf := sess.Open(s0)
+
+	buf.record(Step{Name: "blur", Args: []string{"2"}}, solidState(20, nil).img)
+	buf.record(Step{Name: "identify", Args: []string{"false"}}, nil)
+	if len(buf.macro) != 1 || buf.macro[0].Name != "blur" {
+		t.Fatalf("macro = %+v, want just blur", buf.macro)
+	}
+	a, ok, err := buf.stepHistory(true)
+	if err != nil || !ok || a.Label != "blur 2" {
+		t.Fatalf("undo: ok=%v err=%v action=%+v", ok, err, a)
+	}
+	samePixels(t, buf.st.img, s0.img)
+	if len(buf.macro) != 0 {
+		t.Fatalf("macro after undoing blur = %+v, want empty", buf.macro)
+	}
+	if _, ok, _ := buf.stepHistory(false); !ok || len(buf.macro) != 1 {
+		t.Fatalf("redo: ok=%v macro=%+v", ok, buf.macro)
+	}
+}
+
+func TestHistoryCompressesAndSpills(t *testing.T) {
+	// Budget smaller than a single 32x32 frame forces every snapshot out
+	// of memory.
+	h := NewHistory(100)
+	defer h.Close()
+
+	states := []imageState{solidState(1, nil), solidState(2, nil), solidState(3, nil)}
+	for i, st := range states {
+		h.Push(st, historyAction{Label: string(rune('a' + i))})
+	}
+	for _, s := range h.undo {
+		if s.st.img != nil {
+			t.Fatalf("snapshot still resident over budget")
+		}
+	}
+	if h.undo[0].spill == "" {
+		t.Fatalf("oldest snapshot was not spilled to disk")
+	}
+	spilled := h.undo[0].spill
+
+	var listing bytes.Buffer
+	h.Print(&listing)
+	if !strings.Contains(listing.String(), "[on disk]") {
+		t.Fatalf("listing does not show storage: %q", listing.String())
+	}
+
+	cur := solidState(4, nil)
+	for i := len(states) - 1; i >= 0; i-- {
+		var err error
+		var ok bool
+		cur, _, ok, err = h.Undo(cur)
+		if err != nil || !ok {
+			t.Fatalf("undo %d: ok=%v err=%v", i, ok, err)
+		}
+		samePixels(t, cur.img, states[i].img)
+	}
+	if _, err := os.Stat(spilled); !os.IsNotExist(err) {
+		t.Fatalf("restored spill file not removed")
+	}
+}
+
+func TestHistoryPacksDeepImages(t *testing.T) {
+	h := NewHistory(100)
+	defer h.Close()
+
+	img := image.NewNRGBA64(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	h.Push(imageState{img: img, path: "in.png", format: "png"}, historyAction{Label: "gamma 2"})
+	if h.undo[0].st.img != nil || !h.undo[0].deep {
+		t.Fatalf("16-bit snapshot was not packed as 16-bit")
+	}
+	got, _, ok, err := h.Undo(solidState(1, nil))
+	if err != nil || !ok {
+		t.Fatalf("undo: ok=%v err=%v", ok, err)
+	}
+	n, isDeep := got.img.(*image.NRGBA64)
+	if !isDeep || n.Rect != img.Rect || !bytes.Equal(n.Pix, img.Pix) {
+		t.Fatalf("16-bit pixels not restored exactly (%T)", got.img)
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	cases := map[string]int64{"512": 512, "64K": 64 << 10, "256MB": 256 << 20, "2GiB": 2 << 30, "1.5g": 3 << 29}
+	for in, want := range cases {
+		got, err := parseByteSize(in)
+		if err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Errorf("expected error for invalid size")
+	}
+}
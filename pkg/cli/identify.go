@@ -0,0 +1,213 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// ImageReport is the machine-readable identify output for one image.
+type ImageReport struct {
+	Path         string           `json:"path"`
+	Format       string           `json:"format"`
+	Width        int              `json:"width"`
+	Height       int              `json:"height"`
+	ColorModel   string           `json:"color_model"`
+	BitDepth     int              `json:"bit_depth"`
+	AlphaChannel bool             `json:"alpha_channel"`
+	HasAlpha     bool             `json:"has_alpha"`
+	AutoOriented bool             `json:"auto_oriented"`
+	EXIF         *EXIF            `json:"exif,omitempty"`
+	AppSegments  []AppSegmentInfo `json:"app_segments"`
+}
+
+// AppSegmentInfo describes a JPEG APPn segment without its payload.
+type AppSegmentInfo struct {
+	Marker string `json:"marker"` // e.g. "APP1"
+	ID     string `json:"id,omitempty"`
+	Size   int    `json:"size"`
+}
+
+// NewImageReport builds the identify report for st. EXIF comes from the
+// state's APP1 segment, so a stripped image reports no EXIF.
+func NewImageReport(st imageState) ImageReport {
+	b := st.img.Bounds()
+	model, depth, alpha := describeColorModel(st.img)
+	r := ImageReport{
+		Path:         st.path,
+		Format:       st.format,
+		Width:        b.Dx(),
+		Height:       b.Dy(),
+		ColorModel:   model,
+		BitDepth:     depth,
+		AlphaChannel: alpha,
+		HasAlpha:     alpha && !isOpaque(st.img),
+		AutoOriented: st.autoOriented,
+		AppSegments:  []AppSegmentInfo{},
+	}
+	if ex, ok := exifFromAppSegments(st.appSegments); ok {
+		r.EXIF = &ex
+	}
+	for _, s := range st.appSegments {
+		r.AppSegments = append(r.AppSegments, AppSegmentInfo{
+			Marker: fmt.Sprintf("APP%d", s.Marker-0xE0),
+			ID:     appSegmentID(s.Payload),
+			Size:   len(s.Payload),
+		})
+	}
+	return r
+}
+
+// describeColorModel names img's pixel layout and reports its bits per
+// channel and whether it carries an alpha channel.
+func describeColorModel(img image.Image) (name string, depth int, alpha bool) {
+	switch m := img.(type) {
+	case *image.YCbCr:
+		return "YCbCr", 8, false
+	case *image.NYCbCrA:
+		return "NYCbCrA", 8, true
+	case *image.Gray:
+		return "Gray", 8, false
+	case *image.Gray16:
+		return "Gray16", 16, false
+	case *image.CMYK:
+		return "CMYK", 8, false
+	case *image.RGBA:
+		return "RGBA", 8, true
+	case *image.NRGBA:
+		return "NRGBA", 8, true
+	case *image.RGBA64:
+		return "RGBA64", 16, true
+	case *image.NRGBA64:
+		return "NRGBA64", 16, true
+	case *image.Alpha:
+		return "Alpha", 8, true
+	case *image.Alpha16:
+		return "Alpha16", 16, true
+	case *image.Paletted:
+		// A palette entry with alpha < 255 makes the palette translucent.
+		for _, c := range m.Palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return fmt.Sprintf("Paletted(%d)", len(m.Palette)), 8, true
+			}
+		}
+		return fmt.Sprintf("Paletted(%d)", len(m.Palette)), 8, false
+	default:
+		return fmt.Sprintf("%T", img), 8, true
+	}
+}
+
+// isOpaque reports whether every pixel of img is fully opaque.
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// appSegmentID returns the NUL-terminated identifier that conventionally
+// starts an APPn payload ("Exif", "JFIF", "ICC_PROFILE",
+// "http://ns.adobe.com/xap/1.0/", ...), or "" if there is none.
+func appSegmentID(p []byte) string {
+	for i := 0; i < len(p) && i < 64; i++ {
+		if p[i] == 0 {
+			return string(p[:i])
+		}
+		if p[i] < 0x20 || p[i] > 0x7e {
+			return ""
+		}
+	}
+	return ""
+}
+
+// exifFromAppSegments parses the first EXIF APP1 segment in segs.
+func exifFromAppSegments(segs []AppSegment) (EXIF, bool) {
+	for _, s := range segs {
+		if s.Marker != 0xE1 || len(s.Payload) < 6 || string(s.Payload[:6]) != "Exif\x00\x00" {
+			continue
+		}
+		tags, err := readEXIFTags(s.Payload, 6)
+		if err != nil {
+			continue
+		}
+		return convertTagsToEXIF(tags), true
+	}
+	return EXIF{}, false
+}
+
+// writeIdentifyJSON writes the report for st to w as a single JSON document.
+// indent selects human-friendly output; otherwise the document is one line.
+func writeIdentifyJSON(w io.Writer, st imageState, indent bool) error {
+	enc := json.NewEncoder(w)
+	if indent {
+		enc.SetIndent("", "  ")
+	}
+	return enc.Encode(NewImageReport(st))
+}
+
+// identifyUsage prints the usage for the `identify` subcommand.
+func identifyUsage() {
+	fmt.Fprintln(os.Stderr, "usage: timp identify [--json] <image>...")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Prints image information and EXIF metadata. With --json, writes one JSON")
+	fmt.Fprintln(os.Stderr, "document per image, one per line. Use - to read an image from stdin.")
+}
+
+// RunIdentify implements `timp identify`. Files that cannot be read are
+// reported on stderr and the remaining files are still processed.
+func RunIdentify(args []string) error {
+	asJSON := false
+	var paths []string
+	for _, a := range args {
+		switch a {
+		case "--json", "-j":
+			asJSON = true
+		case "-h", "--help":
+			identifyUsage()
+			return nil
+		default:
+			paths = append(paths, a)
+		}
+	}
+	if len(paths) == 0 {
+		identifyUsage()
+		return fmt.Errorf("missing input image")
+	}
+	failed := 0
+	for _, p := range paths {
+		img, format, segs, autoOriented, err := LoadImage(p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read image %s: %v\n", p, err)
+			failed++
+			continue
+		}
+		st := imageState{img: img, path: p, format: format, appSegments: segs, autoOriented: autoOriented}
+		if asJSON {
+			if err := writeIdentifyJSON(os.Stdout, st, false); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Printf("%s:\n", p)
+		if info, ierr := GetImageInfoImage(img); ierr == nil {
+			fmt.Println(info)
+		}
+		if ex, ok := exifFromAppSegments(segs); ok {
+			printEXIFSummary(os.Stdout, ex)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be read", failed, len(paths))
+	}
+	return nil
+}
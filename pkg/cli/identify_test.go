@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestIdentifyJSON(t *testing.T) {
+	data, _ := makeTestJPEGWithSegments(t, 6)
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	img, format, segs, autoOriented, err := LoadImage(path)
+	if err != nil {
+		t.Fatalf("LoadImage: %v", err)
+	}
+	st := imageState{img: img, path: path, format: format, appSegments: segs, autoOriented: autoOriented}
+
+	var buf bytes.Buffer
+	if err := writeIdentifyJSON(&buf, st, false); err != nil {
+		t.Fatalf("writeIdentifyJSON: %v", err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 1 {
+		t.Fatalf("expected a single-line document, got %d lines", n)
+	}
+	var r ImageReport
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, buf.String())
+	}
+	if r.Format != "jpeg" || r.Width != 16 || r.Height != 16 || !r.AutoOriented {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+	if r.HasAlpha {
+		t.Fatalf("opaque JPEG reported as having alpha")
+	}
+	if r.EXIF == nil || r.EXIF.Orientation != 6 || len(r.EXIF.Raw) == 0 {
+		t.Fatalf("EXIF missing from report: %+v", r.EXIF)
+	}
+	want := []AppSegmentInfo{{"APP0", "JFIF", 10}, {"APP1", "Exif", len(segs[1].Payload)}, {"APP2", "", 7}}
+	if len(r.AppSegments) != len(want) {
+		t.Fatalf("app segments = %+v, want %+v", r.AppSegments, want)
+	}
+	for i := range want {
+		if r.AppSegments[i] != want[i] {
+			t.Fatalf("app segment %d = %+v, want %+v", i, r.AppSegments[i], want[i])
+		}
+	}
+
+	// After strip the report carries no EXIF.
+	st.appSegments = nil
+	if r := NewImageReport(st); r.EXIF != nil || len(r.AppSegments) != 0 {
+		t.Fatalf("stripped report still has metadata: %+v", r)
+	}
+}
+
+func TestIdentifyReportsAlpha(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	r := NewImageReport(imageState{img: img, format: "png"})
+	if r.ColorModel != "NRGBA" || !r.AlphaChannel || !r.HasAlpha || r.BitDepth != 8 {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+}
+
+func TestParseStepsBoolFlag(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	steps, err := ParseSteps(store, []string{"identify", "--json", "negate"})
+	if err != nil {
+		t.Fatalf("ParseSteps: %v", err)
+	}
+	if len(steps) != 2 || len(steps[0].Args) != 1 || steps[0].Args[0] != "true" {
+		t.Fatalf("steps = %+v", steps)
+	}
+}
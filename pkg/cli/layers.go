@@ -0,0 +1,331 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// A buffer opened from a layered project file (or given a second layer with
+// 'L') holds a stdimg.Document. Its imageState then holds the active layer:
+// commands, undo and regions work on that layer, and the document is only
+// flattened to preview or save it. Each layer keeps its own undo history.
+
+// documentExt is the extension of layered project files.
+const documentExt = ".timpdoc"
+
+// isDocumentPath reports whether path names a layered project file.
+func isDocumentPath(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), documentExt)
+}
+
+// loadDocument reads the layered project file at path.
+func loadDocument(path string) (*stdimg.Document, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return stdimg.ReadDocument(bytes.NewReader(b), int64(len(b)))
+}
+
+// saveDocument writes d to path as a layered project file.
+func saveDocument(path string, d *stdimg.Document) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := stdimg.WriteDocument(f, d); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openBuffer loads path into a new buffer of s, keeping the layers of a
+// project file.
+func openBuffer(s *Session, path string) (*Buffer, error) {
+	if !isDocumentPath(path) {
+		img, format, meta, autoOriented, err := LoadImage(path)
+		if err != nil {
+			return nil, err
+		}
+		return s.Open(imageState{img: img, path: path, format: format, appSegments: meta, autoOriented: autoOriented}), nil
+	}
+	doc, err := loadDocument(path)
+	if err != nil {
+		return nil, err
+	}
+	b := s.Open(imageState{img: doc.ActiveLayer().Image, path: path, format: "png"})
+	b.doc = doc
+	return b, nil
+}
+
+// view returns the image to preview or save: the flattened document, or
+// the buffer's image if it has no layers.
+func (b *Buffer) view() image.Image {
+	if b.doc == nil {
+		return b.st.img
+	}
+	b.doc.ActiveLayer().Image = b.st.img
+	return b.doc.Flatten()
+}
+
+// save writes the buffer to path: the whole document for a project file,
+// the flattened image otherwise.
+func (b *Buffer) save(path string) error {
+	if !isDocumentPath(path) {
+		return SaveImage(path, b.view(), b.st.appSegments, b.st.autoOriented)
+	}
+	if b.doc == nil {
+		return saveDocument(path, stdimg.NewDocument("background", b.st.img))
+	}
+	b.doc.ActiveLayer().Image = b.st.img
+	return saveDocument(path, b.doc)
+}
+
+// close releases the histories of the buffer and of its inactive layers.
+func (b *Buffer) close() {
+	b.hist.Close()
+	for _, h := range b.layerHist {
+		h.Close()
+	}
+	b.layerHist = nil
+}
+
+// leaveLayer stores the active layer's image and history in the document
+// before another layer becomes active.
+func (b *Buffer) leaveLayer() {
+	cur := b.doc.ActiveLayer()
+	cur.Image = b.st.img
+	if b.layerHist == nil {
+		b.layerHist = map[*stdimg.Layer]*History{}
+	}
+	b.layerHist[cur] = b.hist
+}
+
+// enterLayer swaps in the image and history of the document's active layer.
+func (b *Buffer) enterLayer(budget int64) {
+	l := b.doc.ActiveLayer()
+	b.st.img = l.Image
+	b.hist = b.layerHist[l]
+	if b.hist == nil {
+		b.hist = NewHistory(budget)
+	}
+	delete(b.layerHist, l)
+}
+
+// switchLayer makes layer i of the document active.
+func (b *Buffer) switchLayer(i int, budget int64) {
+	b.leaveLayer()
+	b.doc.Active = i
+	b.enterLayer(budget)
+}
+
+// addLayer puts l above the active layer and makes it active, turning a
+// plain buffer into a document first.
+func (b *Buffer) addLayer(l *stdimg.Layer, budget int64) {
+	if b.doc == nil {
+		b.doc = stdimg.NewDocument("background", b.st.img)
+	}
+	b.leaveLayer()
+	b.doc.Add(l)
+	b.enterLayer(budget)
+}
+
+// removeLayer deletes layer i along with its history.
+func (b *Buffer) removeLayer(i int, budget int64) error {
+	victim := b.doc.Layers[i]
+	wasActive := i == b.doc.Active
+	if err := b.doc.Remove(i); err != nil {
+		return err
+	}
+	if !wasActive {
+		if h, ok := b.layerHist[victim]; ok {
+			h.Close()
+			delete(b.layerHist, victim)
+		}
+		return nil
+	}
+	b.hist.Close()
+	b.enterLayer(budget)
+	return nil
+}
+
+// flatten merges the document into a single image. The layer histories
+// are dropped since they no longer apply.
+func (b *Buffer) flatten(budget int64) {
+	b.st.img = b.view()
+	b.close()
+	b.doc = nil
+	b.hist = NewHistory(budget)
+}
+
+// printLayers lists the layers of b on w, top first, marking the active one.
+func (b *Buffer) printLayers(w io.Writer) {
+	if b.doc == nil {
+		fmt.Fprintf(w, "@%s has a single layer\n", b.Name)
+		return
+	}
+	d := b.doc
+	fmt.Fprintf(w, "Layers of @%s (%dx%d canvas, top first):\n", b.Name, d.Width, d.Height)
+	for i := len(d.Layers) - 1; i >= 0; i-- {
+		l := d.Layers[i]
+		mark := " "
+		if i == d.Active {
+			mark = "*"
+		}
+		var notes []string
+		if l.Hidden {
+			notes = append(notes, "hidden")
+		}
+		if l.Mask != nil {
+			notes = append(notes, "masked")
+		}
+		img := l.Image
+		if i == d.Active {
+			img = b.st.img
+		}
+		size := img.Bounds().Size()
+		fmt.Fprintf(w, "%s %d) %s  %dx%d at %d,%d  %s %.0f%%  %s\n", mark, i+1, l.Name, size.X, size.Y, l.Offset.X, l.Offset.Y, layerBlend(l), l.Opacity*100, strings.Join(notes, " "))
+	}
+}
+
+func layerBlend(l *stdimg.Layer) string {
+	if l.Blend == "" {
+		return "OVER"
+	}
+	return l.Blend
+}
+
+// blendModes lists the operators a layer can blend with.
+func blendModes() []string {
+	var modes []string
+	for k := range composeOpNameToValue {
+		if k != "UNDEFINED" {
+			modes = append(modes, k)
+		}
+	}
+	slices.Sort(modes)
+	return modes
+}
+
+// parsePoint parses "x,y".
+func parsePoint(s string) (image.Point, error) {
+	xs, ys, ok := strings.Cut(s, ",")
+	x, errX := strconv.Atoi(strings.TrimSpace(xs))
+	y, errY := strconv.Atoi(strings.TrimSpace(ys))
+	if !ok || errX != nil || errY != nil {
+		return image.Point{}, fmt.Errorf("invalid position %q (want x,y)", s)
+	}
+	return image.Pt(x, y), nil
+}
+
+// editLayers runs the interactive layer menu for buf.
+func editLayers(s *Session, buf *Buffer) error {
+	buf.printLayers(os.Stdout)
+	choice, _ := PromptLine("(a)dd a layer, (s)elect, (o)pacity, (v)isibility, (b)lend mode, (m)ask, (p)osition, move (u)p/(d)own, (r)emove, (f)latten (leave empty to cancel): ")
+	choice = strings.ToLower(strings.TrimSpace(choice))
+	if choice == "" {
+		return nil
+	}
+	if choice != "a" && buf.doc == nil {
+		return fmt.Errorf("@%s has no layers yet; add one with (a)", buf.Name)
+	}
+	var l *stdimg.Layer
+	if buf.doc != nil {
+		l = buf.doc.ActiveLayer()
+	}
+	switch choice {
+	case "a":
+		ref, _ := PromptLineWithFzf("Layer image path or @buffer [enter '/' to use fzf]: ")
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return fmt.Errorf("no image given")
+		}
+		img, ok := s.Resolve(ref)
+		name := strings.TrimPrefix(ref, "@")
+		if !ok {
+			var err error
+			if img, _, _, _, err = LoadImage(ref); err != nil {
+				return err
+			}
+			name = bufferBaseName(ref)
+		}
+		nl := stdimg.NewLayer(name, img)
+		if at, _ := PromptLineKind("Position as x,y (empty for 0,0): ", "point", nil); strings.TrimSpace(at) != "" {
+			p, err := parsePoint(at)
+			if err != nil {
+				return err
+			}
+			nl.Offset = p
+		}
+		buf.addLayer(nl, s.budget)
+	case "s":
+		ref, _ := PromptLineKind("Layer number: ", "layer", nil)
+		n, err := strconv.Atoi(strings.TrimSpace(ref))
+		if err != nil || n < 1 || n > len(buf.doc.Layers) {
+			return fmt.Errorf("no layer %q", ref)
+		}
+		buf.switchLayer(n-1, s.budget)
+	case "o":
+		v, _ := PromptLineKind("Opacity in percent (0-100): ", "opacity", nil)
+		f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(v), "%"), 64)
+		if err != nil || f < 0 || f > 100 {
+			return fmt.Errorf("invalid opacity %q", v)
+		}
+		l.Opacity = f / 100
+	case "v":
+		l.Hidden = !l.Hidden
+	case "b":
+		modes := blendModes()
+		v, _ := PromptLineKind(fmt.Sprintf("Blend mode (%s): ", strings.Join(modes, ", ")), "arg:operator", wordCompleter(modes))
+		mode := strings.ToUpper(strings.TrimSpace(v))
+		if !slices.Contains(modes, mode) {
+			return fmt.Errorf("unknown blend mode %q", v)
+		}
+		l.Blend = mode
+	case "m":
+		ref, _ := PromptLineWithFzf("Mask image, @buffer or @selection [enter '/' to use fzf] (empty to remove): ")
+		if ref = strings.TrimSpace(ref); ref == "" {
+			l.Mask = nil
+			break
+		}
+		m, err := stdimg.LoadMask(ref, buf.st.img.Bounds())
+		if err != nil {
+			return err
+		}
+		l.Mask = m
+	case "p":
+		at, _ := PromptLineKind("Position as x,y: ", "point", nil)
+		p, err := parsePoint(at)
+		if err != nil {
+			return err
+		}
+		l.Offset = p
+	case "u", "d":
+		delta := 1
+		if choice == "d" {
+			delta = -1
+		}
+		buf.doc.Move(buf.doc.Active, delta)
+	case "r":
+		if err := buf.removeLayer(buf.doc.Active, s.budget); err != nil {
+			return err
+		}
+	case "f":
+		buf.flatten(s.budget)
+		fmt.Println("flattened; layer histories cleared")
+	default:
+		return fmt.Errorf("unknown choice: %s", choice)
+	}
+	buf.printLayers(os.Stdout)
+	return nil
+}
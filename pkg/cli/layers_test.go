@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestBufferLayers(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+	red := color.NRGBA{255, 0, 0, 255}
+	buf := sess.Open(imageState{img: solidNRGBA(4, 4, red), path: "base.png"})
+
+	// Edit the background, then add a half-transparent layer on top.
+	buf.hist.Push(buf.st, historyAction{Label: "edit background"})
+	top := stdimg.NewLayer("top", solidNRGBA(2, 2, color.NRGBA{0, 0, 255, 255}))
+	top.Offset = image.Pt(2, 2)
+	top.Opacity = 0.5
+	buf.addLayer(top, sess.budget)
+	if buf.doc == nil || buf.doc.ActiveLayer() != top || buf.st.img != top.Image {
+		t.Fatalf("the new layer is not active")
+	}
+	if _, _, ok, _ := buf.hist.Undo(buf.st); ok {
+		t.Fatal("the new layer inherited the background's history")
+	}
+	flat := stdimg.ToNRGBA(buf.view())
+	if got := flat.NRGBAAt(0, 0); got != red {
+		t.Errorf("background pixel = %v", got)
+	}
+	if got := flat.NRGBAAt(3, 3); got.R < 126 || got.R > 129 || got.B < 126 || got.B > 129 {
+		t.Errorf("blended pixel = %v, want half red, half blue", got)
+	}
+
+	// Back on the background, its history is there again.
+	buf.switchLayer(0, sess.budget)
+	if _, action, ok, _ := buf.hist.Undo(buf.st); !ok || action.Label != "edit background" {
+		t.Errorf("background history lost: %v %q", ok, action.Label)
+	}
+
+	path := filepath.Join(t.TempDir(), "project.timpdoc")
+	if err := buf.save(path); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openBuffer(sess, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.doc == nil || len(reopened.doc.Layers) != 2 || reopened.doc.Active != 0 {
+		t.Fatalf("reopened document = %+v", reopened.doc)
+	}
+	samePixels(t, reopened.view(), flat)
+	// Non-interactive loading flattens.
+	img, format, _, _, err := LoadImage(path)
+	if err != nil || format != "png" {
+		t.Fatalf("LoadImage(%s) = %v, %v", path, format, err)
+	}
+	samePixels(t, img, flat)
+
+	if err := buf.removeLayer(1, sess.budget); err != nil {
+		t.Fatal(err)
+	}
+	buf.flatten(sess.budget)
+	if buf.doc != nil {
+		t.Fatal("the buffer still has layers after flattening")
+	}
+	samePixels(t, buf.st.img, solidNRGBA(4, 4, red))
+}
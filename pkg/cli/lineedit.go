@@ -0,0 +1,402 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// errPromptInterrupted is returned by the line editor when the user presses
+// Ctrl-C; callers treat it like an empty (cancelled) answer.
+var errPromptInterrupted = errors.New("interrupted")
+
+// Completer returns completion candidates for the text before the cursor.
+// Each candidate replaces the whole of that text.
+type Completer func(prefix string) []string
+
+// maxLineHistory bounds each prompt kind's history list.
+const maxLineHistory = 200
+
+// lineEditor is a small readline-style editor for raw-mode terminals. It
+// supports cursor movement (arrows, Home/End, Ctrl-A/E/B/F), deletion
+// (Backspace, Delete, Ctrl-U/K/W), history per prompt kind (Up/Down) and tab
+// completion. It works on any io.Reader/io.Writer pair so it can be tested
+// without a terminal; raw mode itself is handled by the caller.
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	history map[string][]string
+}
+
+func newLineEditor(in *bufio.Reader, out io.Writer) *lineEditor {
+	return &lineEditor{in: in, out: out, history: map[string][]string{}}
+}
+
+// readLine prints prompt and edits a line until Enter. kind selects the
+// history list; complete may be nil. io.EOF is returned for Ctrl-D on an
+// empty line or at end of input.
+func (e *lineEditor) readLine(prompt, kind string, complete Completer) (string, error) {
+	var buf []rune
+	pos := 0
+	hist := e.history[kind]
+	histIdx := len(hist)
+	var saved []rune // the line being typed before browsing history
+	lastWasTab := false
+
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(r []rune) {
+		buf = append(buf[:0:0], r...)
+		pos = len(buf)
+	}
+	redraw()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(buf) > 0 {
+				break
+			}
+			fmt.Fprint(e.out, "\r\n")
+			return "", err
+		}
+		tab := false
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			line := string(buf)
+			e.addHistory(kind, line)
+			return line, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errPromptInterrupted
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 0x7f, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 0x15: // Ctrl-U
+			buf = append(buf[:0:0], buf[pos:]...)
+			pos = 0
+		case 0x0b: // Ctrl-K
+			buf = buf[:pos]
+		case 0x17: // Ctrl-W
+			start := pos
+			for start > 0 && buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && buf[start-1] != ' ' && buf[start-1] != '/' {
+				start--
+			}
+			buf = append(buf[:start], buf[pos:]...)
+			pos = start
+		case '\t':
+			tab = true
+			if complete == nil {
+				break
+			}
+			prefix := string(buf[:pos])
+			cands := complete(prefix)
+			switch {
+			case len(cands) == 0:
+				fmt.Fprint(e.out, "\a")
+			case len(cands) == 1:
+				buf = append([]rune(cands[0]), buf[pos:]...)
+				pos = utf8.RuneCountInString(cands[0])
+			default:
+				if common := commonPrefix(cands); len(common) > len(prefix) {
+					buf = append([]rune(common), buf[pos:]...)
+					pos = utf8.RuneCountInString(common)
+				} else if lastWasTab {
+					fmt.Fprint(e.out, "\r\n"+strings.Join(completionLabels(cands), "  ")+"\r\n")
+				} else {
+					fmt.Fprint(e.out, "\a")
+				}
+			}
+		case 0x1b: // escape sequence
+			switch e.readEscape() {
+			case "up":
+				if histIdx > 0 {
+					if histIdx == len(hist) {
+						saved = append(saved[:0], buf...)
+					}
+					histIdx--
+					setLine([]rune(hist[histIdx]))
+				}
+			case "down":
+				if histIdx < len(hist) {
+					histIdx++
+					if histIdx == len(hist) {
+						setLine(saved)
+					} else {
+						setLine([]rune(hist[histIdx]))
+					}
+				}
+			case "left":
+				if pos > 0 {
+					pos--
+				}
+			case "right":
+				if pos < len(buf) {
+					pos++
+				}
+			case "home":
+				pos = 0
+			case "end":
+				pos = len(buf)
+			case "delete":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+				pos++
+			}
+		}
+		lastWasTab = tab
+		redraw()
+	}
+	fmt.Fprint(e.out, "\r\n")
+	return string(buf), nil
+}
+
+// readEscape decodes the remainder of an ANSI/VT escape sequence after ESC
+// and names the key, or returns "" for sequences the editor ignores. A key
+// sequence arrives in one read, so when nothing follows ESC it was a bare
+// Escape press; a byte other than '[' or 'O' (as after Alt) is left to be
+// read as the next key.
+func (e *lineEditor) readEscape() string {
+	if e.in.Buffered() == 0 {
+		return ""
+	}
+	if next, err := e.in.Peek(1); err != nil || (next[0] != '[' && next[0] != 'O') {
+		return ""
+	}
+	e.in.ReadByte()
+	var params []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		if c >= 0x40 && c <= 0x7e {
+			switch c {
+			case 'A':
+				return "up"
+			case 'B':
+				return "down"
+			case 'C':
+				return "right"
+			case 'D':
+				return "left"
+			case 'H':
+				return "home"
+			case 'F':
+				return "end"
+			case '~':
+				switch string(params) {
+				case "1", "7":
+					return "home"
+				case "4", "8":
+					return "end"
+				case "3":
+					return "delete"
+				}
+			}
+			return ""
+		}
+		params = append(params, c)
+	}
+}
+
+// addHistory appends line to kind's history, skipping blanks and immediate
+// repeats.
+func (e *lineEditor) addHistory(kind, line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	h := e.history[kind]
+	if len(h) > 0 && h[len(h)-1] == line {
+		return
+	}
+	h = append(h, line)
+	if len(h) > maxLineHistory {
+		h = h[len(h)-maxLineHistory:]
+	}
+	e.history[kind] = h
+}
+
+// commonPrefix returns the longest prefix shared by all of ss.
+func commonPrefix(ss []string) string {
+	p := ss[0]
+	for _, s := range ss[1:] {
+		for !strings.HasPrefix(s, p) {
+			_, size := utf8.DecodeLastRuneInString(p)
+			p = p[:len(p)-size]
+		}
+	}
+	return p
+}
+
+// completionLabels shortens path candidates to their last element so the
+// listing stays readable.
+func completionLabels(cands []string) []string {
+	out := make([]string, len(cands))
+	for i, c := range cands {
+		trimmed := strings.TrimSuffix(c, "/")
+		if j := strings.LastIndexByte(trimmed, '/'); j >= 0 {
+			out[i] = c[j+1:]
+		} else {
+			out[i] = c
+		}
+	}
+	return out
+}
+
+// wordCompleter completes prefix case-insensitively against words.
+func wordCompleter(words []string) Completer {
+	return func(prefix string) []string {
+		lp := strings.ToLower(prefix)
+		var out []string
+		for _, w := range words {
+			if strings.HasPrefix(strings.ToLower(w), lp) {
+				out = append(out, w)
+			}
+		}
+		return out
+	}
+}
+
+// commandCompleter completes command names from stdimg.Commands.
+func commandCompleter() Completer {
+	names := make([]string, len(stdimg.Commands))
+	for i, c := range stdimg.Commands {
+		names[i] = c.Name
+	}
+	return wordCompleter(names)
+}
+
+// argCompleter picks the completer for a command argument: enum values,
+// true/false for booleans, and file names for path-like arguments.
+func argCompleter(p stdimg.ArgSpec) Completer {
+	if isPathArg(p) {
+		return completePath
+	}
+	if strings.ToLower(p.Type) == "bool" {
+		return wordCompleter([]string{"true", "false"})
+	}
+	if cands := enumCandidates(p); len(cands) > 0 {
+		return wordCompleter(cands)
+	}
+	return nil
+}
+
+// isPathArg reports whether p names a file, judging by its name and
+// description.
+func isPathArg(p stdimg.ArgSpec) bool {
+	lowerName := strings.ToLower(p.Name)
+	lowerHint := strings.ToLower(p.Description)
+	return strings.Contains(lowerName, "path") || strings.Contains(lowerName, "file") || strings.Contains(lowerHint, "path") || strings.Contains(lowerHint, "file")
+}
+
+// completePath lists files and directories starting with prefix.
+// Directories get a trailing slash so completion can continue into them.
+func completePath(prefix string) []string {
+	dir, base := filepath.Split(prefix)
+	expanded := dir
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			expanded = filepath.Join(home, dir[2:]) + string(filepath.Separator)
+		}
+	}
+	readDir := expanded
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, ent := range entries {
+		name := ent.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		c := dir + name
+		if ent.IsDir() {
+			c += "/"
+		}
+		out = append(out, c)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// stdinReader is shared by the interactive loop and every prompt so that
+// input buffered by one reader is never lost to another.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// stdinEditor is created on first use of an interactive prompt.
+var stdinEditor *lineEditor
+
+// PromptLineKind displays prompt and reads a line. On a terminal it uses the
+// line editor with the history list for kind and the given completer (which
+// may be nil); otherwise it reads a plain line from stdin. The result is
+// trimmed of surrounding whitespace. Ctrl-C yields an empty answer.
+func PromptLineKind(prompt, kind string, complete Completer) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if isTerminal(fd) {
+		if restore, err := makeRaw(fd); err == nil {
+			if stdinEditor == nil {
+				stdinEditor = newLineEditor(stdinReader, os.Stdout)
+			}
+			line, err := stdinEditor.readLine(prompt, kind, complete)
+			restore()
+			if err == errPromptInterrupted {
+				return "", nil
+			}
+			return strings.TrimSpace(line), err
+		}
+	}
+	fmt.Print(prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
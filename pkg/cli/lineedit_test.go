@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func editLine(t *testing.T, e *lineEditor, input, kind string, complete Completer) string {
+	t.Helper()
+	e.in = bufio.NewReader(strings.NewReader(input))
+	line, err := e.readLine("> ", kind, complete)
+	if err != nil {
+		t.Fatalf("readLine(%q): %v", input, err)
+	}
+	return line
+}
+
+func TestLineEditorEditing(t *testing.T) {
+	e := newLineEditor(nil, io.Discard)
+	cases := []struct {
+		in, want string
+	}{
+		{"hello\r", "hello"},
+		{"helo\x1b[Dl\r", "hello"},              // left arrow, insert
+		{"abc\x7f\x7fd\r", "ad"},                // backspace
+		{"world\x01hello \r", "hello world"},    // Ctrl-A
+		{"abc\x1b[H\x1b[3~\r", "bc"},            // Home, Delete
+		{"one two\x17three\r", "one three"},     // Ctrl-W
+		{"keep cut\x02\x02\x02\x0b\r", "keep "}, // Ctrl-B, Ctrl-K
+		{"x\x15y\r", "y"},                       // Ctrl-U
+		{"ab\x1bcd\r", "abcd"},                  // Escape or Alt-c keeps the c
+		{"ab\x1b\x1b[Dc\r", "acb"},              // Escape, then left arrow
+	}
+	for _, c := range cases {
+		if got := editLine(t, e, c.in, "t", nil); got != c.want {
+			t.Errorf("input %q: got %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestLineEditorHistoryPerKind(t *testing.T) {
+	e := newLineEditor(nil, io.Discard)
+	editLine(t, e, "first\r", "a", nil)
+	editLine(t, e, "second\r", "a", nil)
+	editLine(t, e, "other\r", "b", nil)
+
+	if got := editLine(t, e, "\x1b[A\r", "a", nil); got != "second" {
+		t.Errorf("up: got %q, want second", got)
+	}
+	if got := editLine(t, e, "\x1b[A\x1b[A\x1b[A\r", "a", nil); got != "first" {
+		t.Errorf("up x3: got %q, want first", got)
+	}
+	if got := editLine(t, e, "draft\x1b[A\x1b[B\r", "a", nil); got != "draft" {
+		t.Errorf("up then down: got %q, want draft", got)
+	}
+	if got := editLine(t, e, "\x1b[A\r", "b", nil); got != "other" {
+		t.Errorf("kind b: got %q, want other", got)
+	}
+	if got := e.history["a"]; !reflect.DeepEqual(got, []string{"first", "second", "first", "draft"}) {
+		t.Errorf("history a = %q", got)
+	}
+}
+
+func TestLineEditorCtrlCAndEOF(t *testing.T) {
+	e := newLineEditor(bufio.NewReader(strings.NewReader("abc\x03")), io.Discard)
+	if _, err := e.readLine("> ", "t", nil); err != errPromptInterrupted {
+		t.Errorf("Ctrl-C: err = %v, want errPromptInterrupted", err)
+	}
+	e.in = bufio.NewReader(strings.NewReader("\x04"))
+	if _, err := e.readLine("> ", "t", nil); err != io.EOF {
+		t.Errorf("Ctrl-D: err = %v, want io.EOF", err)
+	}
+}
+
+func TestLineEditorTabCompletion(t *testing.T) {
+	var out strings.Builder
+	e := newLineEditor(nil, &out)
+	complete := wordCompleter([]string{"OVER", "OVERLAY", "MULTIPLY"})
+
+	if got := editLine(t, e, "m\t\r", "t", complete); got != "MULTIPLY" {
+		t.Errorf("unique: got %q, want MULTIPLY", got)
+	}
+	if got := editLine(t, e, "ov\t\r", "t", complete); got != "OVER" {
+		t.Errorf("common prefix: got %q, want OVER", got)
+	}
+	out.Reset()
+	editLine(t, e, "OVER\t\t\r", "t", complete)
+	if !strings.Contains(out.String(), "OVER  OVERLAY") {
+		t.Errorf("second tab should list candidates, output %q", out.String())
+	}
+}
+
+func TestCommandAndArgCompleters(t *testing.T) {
+	got := commandCompleter()("adaptiveB")
+	if !reflect.DeepEqual(got, []string{"adaptiveBlur"}) {
+		t.Errorf("commandCompleter = %q", got)
+	}
+	var noise, composite stdimg.CommandSpec
+	for _, c := range stdimg.Commands {
+		switch c.Name {
+		case "addNoise":
+			noise = c
+		case "composite":
+			composite = c
+		}
+	}
+	if got := argCompleter(noise.Args[0])("p"); !reflect.DeepEqual(got, []string{"POISSON"}) {
+		t.Errorf("addNoise type completion = %q", got)
+	}
+	if got := argCompleter(composite.Args[1])("s"); !reflect.DeepEqual(got, []string{"SCREEN"}) {
+		t.Errorf("composite operator completion = %q", got)
+	}
+	if argCompleter(noise.Args[1]) != nil {
+		t.Error("float args should have no completer")
+	}
+}
+
+func TestCompletePath(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "images"), 0o755)
+	os.WriteFile(filepath.Join(dir, "image.png"), nil, 0o644)
+	os.WriteFile(filepath.Join(dir, ".hidden"), nil, 0o644)
+
+	got := completePath(dir + "/im")
+	want := []string{dir + "/image.png", dir + "/images/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("completePath = %q, want %q", got, want)
+	}
+	if got := completePath(dir + "/"); len(got) != 2 {
+		t.Errorf("hidden files should be skipped without a leading dot: %q", got)
+	}
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -127,6 +128,9 @@ func GenerateValidationRulesFromStdSpec(c stdimg.CommandSpec) map[string]Validat
 type StdMetaStore struct {
 	Commands []stdimg.CommandSpec
 	byName   map[string]stdimg.CommandSpec
+	// argDefaults holds configured values for optional arguments left
+	// empty, keyed by command then argument name.
+	argDefaults map[string]map[string]string
 }
 
 // NewMetaStoreFromStdimg creates a StdMetaStore from stdimg.CommandSpec list.
@@ -186,6 +190,9 @@ func NormalizeArgsFromStd(store *StdMetaStore, cmdName string, args []string) ([
 		} else {
 			raw = ""
 		}
+		if raw == "" {
+			raw = store.argDefaults[cmdName][a.Name]
+		}
 		if raw == "" {
 			if a.Required {
 				return nil, fmt.Errorf("missing required parameter: %s", a.Name)
@@ -332,3 +339,36 @@ func mapEnumToNumeric(paramName string, val string) (string, bool) {
 	}
 	return "", false
 }
+
+// enumCandidates lists the textual values accepted by arg, for completion in
+// the interactive prompts. Choices spelled out in the description as
+// "(A|B|C)" take precedence; otherwise the enum maps above are consulted by
+// parameter name. UNDEFINED is never offered.
+func enumCandidates(arg stdimg.ArgSpec) []string {
+	if i := strings.LastIndexByte(arg.Description, '('); i >= 0 {
+		if j := strings.IndexByte(arg.Description[i:], ')'); j > 0 {
+			if inner := arg.Description[i+1 : i+j]; strings.Contains(inner, "|") {
+				return strings.Split(inner, "|")
+			}
+		}
+	}
+	var m map[string]string
+	switch strings.ToLower(arg.Name) {
+	case "noisetype", "noise_type", "noise":
+		m = noiseTypeNameToValue
+	case "composeoperator", "compose_operator", "compose", "operator":
+		m = composeOpNameToValue
+	case "compression", "compressiontype", "compress":
+		m = compressionNameToValue
+	default:
+		return nil
+	}
+	out := make([]string, 0, len(m))
+	for k := range m {
+		if k != "UNDEFINED" {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,321 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// Plugin protocol, version 1.
+//
+// A plugin is an executable named timp-plugin-<anything> found on PATH or in
+// the plugins directory next to the user config file. timp runs it in two
+// ways:
+//
+//	timp-plugin-x --timp-describe
+//	    Print a JSON pluginDescription on stdout and exit 0.
+//
+//	timp-plugin-x --timp-apply <command> [args...]
+//	    Read the image from stdin and write the result to stdout, both in the
+//	    encoding chosen by the description's "io" field, then exit 0. Args
+//	    are the normalized argument strings, one per declared argument (empty
+//	    for an omitted optional argument).
+//
+// On failure a plugin exits non-zero; the last part of what it wrote to
+// stderr becomes the error message. Anything written to stderr on success is
+// passed through. Every invocation is bounded by a timeout (plugin_timeout
+// for apply, pluginDescribeTimeout for describe) after which the process is
+// killed.
+const (
+	pluginPrefix          = "timp-plugin-"
+	pluginProtocol        = 1
+	pluginDescribeTimeout = 5 * time.Second
+	// pluginStderrLimit bounds how much stderr is kept for error messages.
+	pluginStderrLimit = 4096
+)
+
+// pluginDescription is what a plugin prints for --timp-describe.
+type pluginDescription struct {
+	Protocol int                  `json:"protocol"`
+	IO       string               `json:"io"` // "png" (default) or "nrgba"
+	Commands []stdimg.CommandSpec `json:"commands"`
+}
+
+// Plugin is a discovered plugin executable and the commands it provides.
+type Plugin struct {
+	Path     string
+	IO       string
+	Commands []stdimg.CommandSpec
+}
+
+// pluginCommands maps a command name to the plugin that implements it.
+var pluginCommands = map[string]*Plugin{}
+
+// pluginTimeout bounds a single --timp-apply run (config key
+// plugin_timeout).
+var pluginTimeout = 30 * time.Second
+
+// pluginDirs returns the directories searched for plugins: the plugins
+// directory under the user config directory, then PATH.
+func pluginDirs() []string {
+	var dirs []string
+	if p := userConfigPath(); p != "" {
+		dirs = append(dirs, filepath.Join(filepath.Dir(p), "plugins"))
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+// findPlugins lists plugin executables in dirs. When the same file name
+// appears in several directories, the first one wins, as with PATH lookup.
+func findPlugins(dirs []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, ent := range entries {
+			name := ent.Name()
+			if !strings.HasPrefix(name, pluginPrefix) || ent.IsDir() || seen[name] {
+				continue
+			}
+			p := filepath.Join(dir, name)
+			if !isExecutable(p) {
+				continue
+			}
+			seen[name] = true
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(filepath.Ext(path), ".exe")
+	}
+	return fi.Mode()&0o111 != 0
+}
+
+// LoadPlugins discovers plugins in dirs, asks each for its commands and
+// registers them with the engine so they appear in the command picker, in
+// completion and in recipes like built-in commands. A plugin that fails to
+// describe itself, or a command whose name is already taken, is reported on
+// w and skipped.
+func LoadPlugins(dirs []string, w io.Writer) []*Plugin {
+	var loaded []*Plugin
+	for _, path := range findPlugins(dirs) {
+		p, err := describePlugin(path)
+		if err != nil {
+			fmt.Fprintf(w, "plugin %s: %v\n", filepath.Base(path), err)
+			continue
+		}
+		var kept []stdimg.CommandSpec
+		for _, c := range p.Commands {
+			if _, _, taken := stdimg.Lookup(c.Name); taken {
+				fmt.Fprintf(w, "plugin %s: command %q is already defined; skipped\n", filepath.Base(path), c.Name)
+				continue
+			}
+			name := c.Name
+			stdimg.RegisterContext(c, func(ctx context.Context, src *image.NRGBA, args []string, _ stdimg.ProgressFunc) (image.Image, error) {
+				return p.ApplyContext(ctx, src, name, args)
+			})
+			kept = append(kept, c)
+			pluginCommands[c.Name] = p
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		p.Commands = kept
+		loaded = append(loaded, p)
+	}
+	return loaded
+}
+
+// describePlugin runs path --timp-describe and validates the answer.
+func describePlugin(path string) (*Plugin, error) {
+	out, err := runPlugin(context.Background(), path, pluginDescribeTimeout, nil, "--timp-describe")
+	if err != nil {
+		return nil, err
+	}
+	var d pluginDescription
+	if err := json.Unmarshal(out, &d); err != nil {
+		return nil, fmt.Errorf("invalid description: %w", err)
+	}
+	if d.Protocol != pluginProtocol {
+		return nil, fmt.Errorf("unsupported protocol version %d (want %d)", d.Protocol, pluginProtocol)
+	}
+	switch d.IO {
+	case "":
+		d.IO = "png"
+	case "png", "nrgba":
+	default:
+		return nil, fmt.Errorf("unsupported io %q (want png or nrgba)", d.IO)
+	}
+	for i, c := range d.Commands {
+		if c.Name == "" || strings.ContainsAny(c.Name, " \t\n") {
+			return nil, fmt.Errorf("command %d has an invalid name %q", i+1, c.Name)
+		}
+		if d.Commands[i].Usage == "" {
+			d.Commands[i].Usage = c.Name
+		}
+	}
+	return &Plugin{Path: path, IO: d.IO, Commands: d.Commands}, nil
+}
+
+// Apply runs the plugin's command on img.
+func (p *Plugin) Apply(img image.Image, name string, args []string) (image.Image, error) {
+	return p.ApplyContext(context.Background(), img, name, args)
+}
+
+// ApplyContext is Apply with cancellation: the plugin process is killed when
+// ctx is done.
+func (p *Plugin) ApplyContext(ctx context.Context, img image.Image, name string, args []string) (image.Image, error) {
+	var in bytes.Buffer
+	var err error
+	if p.IO == "nrgba" {
+		err = writeRawNRGBA(&in, stdimg.ToNRGBA(img))
+	} else {
+		err = png.Encode(&in, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode input: %w", err)
+	}
+	out, err := runPlugin(ctx, p.Path, pluginTimeout, &in, append([]string{"--timp-apply", name}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	var res image.Image
+	if p.IO == "nrgba" {
+		res, err = readRawNRGBA(bytes.NewReader(out))
+	} else {
+		res, err = png.Decode(bytes.NewReader(out))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s returned an invalid image: %w", filepath.Base(p.Path), err)
+	}
+	return res, nil
+}
+
+// runPlugin runs path with args, feeding it stdin, and returns its stdout.
+// The process is killed after timeout or when parent is cancelled. Errors
+// carry the tail of stderr.
+func runPlugin(parent context.Context, path string, timeout time.Duration, stdin io.Reader, args ...string) ([]byte, error) {
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("TIMP_PLUGIN_PROTOCOL=%d", pluginProtocol))
+	cmd.Stdin = stdin
+	// Do not hang on grandchildren that inherited the pipes.
+	cmd.WaitDelay = time.Second
+	var stdout bytes.Buffer
+	stderr := &tailBuffer{limit: pluginStderrLimit}
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	name := filepath.Base(path)
+	if err := parent.Err(); err != nil {
+		return nil, err
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("plugin %s timed out after %v", name, timeout)
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && msg != "" {
+			return nil, fmt.Errorf("plugin %s failed (exit %d): %s", name, exitErr.ExitCode(), msg)
+		}
+		return nil, fmt.Errorf("plugin %s: %w", name, err)
+	}
+	if msg != "" {
+		fmt.Fprintf(os.Stderr, "plugin %s: %s\n", name, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = t.buf[len(t.buf)-t.limit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string { return string(t.buf) }
+
+// rawNRGBAMagic starts the raw image encoding used by "nrgba" plugins:
+// the magic, big-endian uint32 width and height, then width*height*4 bytes
+// of non-premultiplied RGBA, row by row.
+const rawNRGBAMagic = "NRGB"
+
+func writeRawNRGBA(w io.Writer, img *image.NRGBA) error {
+	b := img.Bounds()
+	hdr := make([]byte, 12)
+	copy(hdr, rawNRGBAMagic)
+	binary.BigEndian.PutUint32(hdr[4:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(hdr[8:], uint32(b.Dy()))
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	for y := 0; y < b.Dy(); y++ {
+		off := y * img.Stride
+		if _, err := w.Write(img.Pix[off : off+b.Dx()*4]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRawNRGBA(r io.Reader) (*image.NRGBA, error) {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if string(hdr[:4]) != rawNRGBAMagic {
+		return nil, fmt.Errorf("missing %s header", rawNRGBAMagic)
+	}
+	w := binary.BigEndian.Uint32(hdr[4:])
+	h := binary.BigEndian.Uint32(hdr[8:])
+	if w == 0 || h == 0 || uint64(w)*uint64(h) > 1<<28 {
+		return nil, fmt.Errorf("invalid size %dx%d", w, h)
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, int(w), int(h)))
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return nil, fmt.Errorf("short pixel data: %w", err)
+	}
+	return img, nil
+}
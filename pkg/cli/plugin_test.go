@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// writePlugin writes a shell-script plugin to dir.
+func writePlugin(t *testing.T, dir, name, script string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell-script plugins need a POSIX shell")
+	}
+	if err := os.WriteFile(filepath.Join(dir, pluginPrefix+name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withPluginState unregisters plugin commands after a test.
+func withPluginState(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		for name := range pluginCommands {
+			stdimg.Unregister(name)
+		}
+		pluginCommands = map[string]*Plugin{}
+	})
+}
+
+const echoPlugin = `case "$1" in
+--timp-describe)
+  echo '{"protocol": 1, "io": "%s", "commands": [{"name": "echoImage", "args": [{"name": "level", "type": "int"}]}, {"name": "blur"}]}' ;;
+--timp-apply)
+  echo "applying $2 $3" >&2
+  exec cat ;;
+esac
+`
+
+func TestPluginDiscoveryAndApply(t *testing.T) {
+	for _, io := range []string{"png", "nrgba"} {
+		t.Run(io, func(t *testing.T) {
+			withPluginState(t)
+			dir := t.TempDir()
+			writePlugin(t, dir, "echo", strings.Replace(echoPlugin, "%s", io, 1))
+			os.WriteFile(filepath.Join(dir, pluginPrefix+"noexec"), []byte("x"), 0o644)
+
+			var warn bytes.Buffer
+			loaded := LoadPlugins([]string{dir}, &warn)
+			if len(loaded) != 1 || len(loaded[0].Commands) != 1 {
+				t.Fatalf("loaded %+v", loaded)
+			}
+			if !strings.Contains(warn.String(), `"blur" is already defined`) {
+				t.Errorf("expected a warning for the shadowed built-in, got %q", warn.String())
+			}
+			store := NewMetaStoreFromStdimg(stdimg.Commands)
+			if _, ok := store.byName["echoImage"]; !ok {
+				t.Fatal("plugin command missing from the command list")
+			}
+
+			src := solidNRGBA(5, 3, color.NRGBA{10, 20, 30, 255})
+			out, _, err := applyStep(context.Background(), store, src, Step{Name: "echoImage", Args: []string{"4"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := stdimg.ToNRGBA(out)
+			if got.Bounds() != src.Bounds() || !bytes.Equal(got.Pix, src.Pix) {
+				t.Errorf("round trip changed the image")
+			}
+		})
+	}
+}
+
+func TestCommandDefaultsForPluginCommands(t *testing.T) {
+	withPluginState(t)
+	dir := t.TempDir()
+	writePlugin(t, dir, "echo", strings.Replace(echoPlugin, "%s", "png", 1))
+	c, err := loadConfig("", "", []string{"command_defaults.echoImage.level=3"}, func(string) string { return "" }, nil)
+	if err != nil {
+		t.Fatalf("a default for a plugin command failed to load: %v", err)
+	}
+	if err := c.CheckCommandDefaults(); err == nil {
+		t.Error("expected an unknown command before the plugin is loaded")
+	}
+	LoadPlugins([]string{dir}, io.Discard)
+	if err := c.CheckCommandDefaults(); err != nil {
+		t.Errorf("after loading the plugin: %v", err)
+	}
+	c.setCommandDefault("echoImage", "nope", "1", "flag -c")
+	if err := c.CheckCommandDefaults(); err == nil || !strings.Contains(err.Error(), `no argument "nope"`) {
+		t.Errorf("unknown plugin argument: %v", err)
+	}
+}
+
+func TestPluginErrorsAndTimeout(t *testing.T) {
+	withPluginState(t)
+	dir := t.TempDir()
+	writePlugin(t, dir, "fail", `case "$1" in
+--timp-describe) echo '{"protocol": 1, "commands": [{"name": "failing"}, {"name": "slow"}]}' ;;
+--timp-apply)
+  if [ "$2" = slow ]; then exec sleep 10; fi
+  echo "bad input: no faces found" >&2; exit 3 ;;
+esac
+`)
+	writePlugin(t, dir, "broken", `echo 'not json'`)
+	writePlugin(t, dir, "future", `echo '{"protocol": 9, "commands": []}'`)
+
+	var warn bytes.Buffer
+	LoadPlugins([]string{dir}, &warn)
+	if !strings.Contains(warn.String(), "timp-plugin-broken: invalid description") ||
+		!strings.Contains(warn.String(), "unsupported protocol version 9") {
+		t.Errorf("warnings = %q", warn.String())
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	_, err := stdimg.ApplyCommandStdlib(img, "failing", nil)
+	if err == nil || !strings.Contains(err.Error(), "exit 3") || !strings.Contains(err.Error(), "no faces found") {
+		t.Errorf("failing plugin error = %v", err)
+	}
+
+	prev := pluginTimeout
+	pluginTimeout = 200 * time.Millisecond
+	defer func() { pluginTimeout = prev }()
+	start := time.Now()
+	_, err = stdimg.ApplyCommandStdlib(img, "slow", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("slow plugin error = %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timeout took %v", time.Since(start))
+	}
+}
+
+func TestRawNRGBARoundTrip(t *testing.T) {
+	src := solidNRGBA(3, 2, color.NRGBA{1, 2, 3, 4})
+	var buf bytes.Buffer
+	if err := writeRawNRGBA(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readRawNRGBA(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Pix, src.Pix) || got.Bounds() != src.Bounds() {
+		t.Error("raw NRGBA round trip mismatch")
+	}
+	if _, err := readRawNRGBA(strings.NewReader("PNG\x00garbage....")); err == nil {
+		t.Error("expected an error for a bad header")
+	}
+}
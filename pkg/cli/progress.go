@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// progressDelay is how long a command runs before its progress is shown, so
+// that quick commands do not flicker.
+const progressDelay = 200 * time.Millisecond
+
+// interruptContext returns a context that is cancelled when the user presses
+// Ctrl-C. While it is active, Ctrl-C cancels the running command instead of
+// exiting; call stop to restore the default behaviour.
+func interruptContext() (ctx context.Context, stop func()) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// progressPrinter draws a one-line "label NN%" indicator, redrawn in place.
+// It stays silent when w is not a terminal and during the first
+// progressDelay.
+type progressPrinter struct {
+	w       io.Writer
+	label   string
+	enabled bool
+	start   time.Time
+	now     func() time.Time
+	percent int
+	shown   bool
+}
+
+// newProgressPrinter returns a printer for label that writes to stderr when
+// stderr is a terminal.
+func newProgressPrinter(label string) *progressPrinter {
+	return &progressPrinter{w: os.Stderr, label: label, enabled: isTerminal(int(os.Stderr.Fd())), start: time.Now(), now: time.Now, percent: -1}
+}
+
+// update is a stdimg.ProgressFunc.
+func (p *progressPrinter) update(done float64) {
+	if !p.enabled || p.now().Sub(p.start) < progressDelay {
+		return
+	}
+	percent := int(done * 100)
+	if percent == p.percent {
+		return
+	}
+	p.percent = percent
+	p.shown = true
+	fmt.Fprintf(p.w, "\r%s %3d%%", p.label, percent)
+}
+
+// finish erases the indicator if it was drawn.
+func (p *progressPrinter) finish() {
+	if p.shown {
+		fmt.Fprint(p.w, "\r\x1b[K")
+		p.shown = false
+	}
+}
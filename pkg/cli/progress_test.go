@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressPrinter(t *testing.T) {
+	var out strings.Builder
+	now := time.Unix(0, 0)
+	p := &progressPrinter{w: &out, label: "Applying blur", enabled: true, start: now, now: func() time.Time { return now }, percent: -1}
+
+	p.update(0.1)
+	if out.Len() != 0 {
+		t.Fatalf("nothing should be drawn before the delay, got %q", out.String())
+	}
+	now = now.Add(progressDelay)
+	p.update(0.42)
+	p.update(0.421)
+	p.update(0.5)
+	p.finish()
+	if want := "\rApplying blur  42%\rApplying blur  50%\r\x1b[K"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	p = &progressPrinter{w: &out, enabled: false, now: time.Now, percent: -1}
+	p.update(0.5)
+	p.finish()
+	if out.Len() != 0 {
+		t.Errorf("disabled printer wrote %q", out.String())
+	}
+}
@@ -0,0 +1,360 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Recipe is a replayable processing pipeline loaded from a recipe file.
+//
+// A recipe file holds one command per line followed by its arguments, in the
+// same form accepted by `timp run`:
+//
+//	# warm, sharpened web export
+//	set width = 1600
+//	autoLevel
+//	modulate 100 115 100
+//	adaptiveSharpen 1 0.8
+//	resize $width 1067
+//
+// Blank lines and lines starting with '#' are ignored. A trailing comment
+// starts with a '#' on its own or followed by a space, so hex colors such as
+// #ff8800 can be written unquoted. Arguments may use double or single quotes;
+// "" passes an empty argument so an optional parameter keeps its default,
+// and "--name" sets the bool parameter name to true. --roi, --mask and
+// --feather limit the line's command to a Region.
+// `set name = value` defines a variable that later lines reference as $name
+// or ${name}; $$ is a literal dollar sign.
+type Recipe struct {
+	// Vars holds the final variable values, including caller overrides.
+	Vars map[string]string
+	// Steps are the commands in file order; Step.Line is the 1-based source line.
+	Steps []Step
+}
+
+// LoadRecipe reads and validates the recipe file at path. Values in vars take
+// precedence over `set` lines in the file, which lets callers parameterize a
+// recipe without editing it.
+func LoadRecipe(path string, store *StdMetaStore, vars map[string]string) (*Recipe, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rec, err := ParseRecipe(f, store, vars)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rec, nil
+}
+
+// ParseRecipe parses a recipe from r and checks every step against the
+// command registry in store before returning, so a recipe either loads
+// completely or not at all. Errors are prefixed with the offending line number.
+func ParseRecipe(r io.Reader, store *StdMetaStore, vars map[string]string) (*Recipe, error) {
+	if store == nil {
+		return nil, fmt.Errorf("metadata store is nil")
+	}
+	rec := &Recipe{Vars: map[string]string{}}
+	for k, v := range vars {
+		rec.Vars[k] = v
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := rec.Vars[name]
+		return v, ok
+	}
+
+	sc := bufio.NewScanner(r)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if name, value, ok, err := parseRecipeSet(line, lookup); ok || err != nil {
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			// Caller-supplied values win over defaults in the file.
+			if _, overridden := vars[name]; !overridden {
+				rec.Vars[name] = value
+			}
+			continue
+		}
+
+		fields, err := splitRecipeLine(line, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		spec, ok := store.byName[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown command: %s", lineNo, fields[0])
+		}
+		var args []string
+		var region Region
+		for i := 1; i < len(fields); i++ {
+			tok := fields[i]
+			if isRegionFlag(tok) {
+				if i+1 >= len(fields) {
+					return nil, fmt.Errorf("line %d: %s requires a value", lineNo, tok)
+				}
+				if err := parseRegionFlag(&region, tok, fields[i+1]); err != nil {
+					return nil, fmt.Errorf("line %d: %s: %w", lineNo, spec.Name, err)
+				}
+				i++
+				continue
+			}
+			if next, ok, err := applyBoolFlag(spec, args, tok); ok || err != nil {
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNo, err)
+				}
+				args = next
+				continue
+			}
+			args = append(args, tok)
+		}
+		if len(args) > len(spec.Args) {
+			return nil, fmt.Errorf("line %d: %s takes at most %d arguments, got %d", lineNo, spec.Name, len(spec.Args), len(args))
+		}
+		if _, err := NormalizeArgsFromStd(store, spec.Name, args); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", lineNo, spec.Name, err)
+		}
+		rec.Steps = append(rec.Steps, Step{Name: spec.Name, Args: args, Line: lineNo, Region: region})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// parseRecipeSet recognizes `set name = value` (the '=' is optional). ok
+// reports whether line is a set statement; the value is tokenized like any
+// other line, so it may be quoted or refer to earlier variables.
+func parseRecipeSet(line string, lookup func(string) (string, bool)) (name, value string, ok bool, err error) {
+	if !strings.HasPrefix(line, "set") || len(line) == 3 || !unicode.IsSpace(rune(line[3])) {
+		return "", "", false, nil
+	}
+	rest := strings.TrimSpace(line[3:])
+	end := 0
+	for end < len(rest) && isRecipeVarChar(rest[end]) {
+		end++
+	}
+	name = rest[:end]
+	if name == "" {
+		return "", "", true, fmt.Errorf("set: missing variable name")
+	}
+	rest = strings.TrimSpace(rest[end:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "="))
+	fields, err := splitRecipeLine(rest, lookup)
+	if err != nil {
+		return "", "", true, err
+	}
+	if len(fields) > 1 {
+		return "", "", true, fmt.Errorf("set %s: value must be a single token (quote it if it contains spaces)", name)
+	}
+	if len(fields) == 1 {
+		value = fields[0]
+	}
+	return name, value, true, nil
+}
+
+// ParseVarFlag parses a `name=value` command-line variable override.
+func ParseVarFlag(s string, vars map[string]string) error {
+	name, value, ok := strings.Cut(s, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid variable %q (want name=value)", s)
+	}
+	for i := 0; i < len(name); i++ {
+		if !isRecipeVarChar(name[i]) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	vars[name] = value
+	return nil
+}
+
+func isRecipeVarChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// splitRecipeLine splits a recipe line into fields, honoring quotes and
+// expanding variables outside single quotes. A lone '#' or "# " between
+// fields ends the line.
+func splitRecipeLine(line string, lookup func(string) (string, bool)) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	inField := false
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case quote == '"' && c == '"':
+			quote = 0
+		case quote == '"' && c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\' || line[i+1] == '$'):
+			i++
+			cur.WriteByte(line[i])
+		case c == '$':
+			val, n, err := expandRecipeVar(line[i:], lookup)
+			if err != nil {
+				return nil, err
+			}
+			cur.WriteString(val)
+			inField = true
+			i += n - 1
+		case quote == '"':
+			cur.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote = c
+			inField = true
+		case c == '#' && !inField && (i+1 == len(line) || line[i+1] == ' ' || line[i+1] == '\t'):
+			return fields, nil
+		case c == ' ' || c == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteByte(c)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// expandRecipeVar expands the variable reference at the start of s (which
+// begins with '$') and returns its value and the number of bytes consumed.
+func expandRecipeVar(s string, lookup func(string) (string, bool)) (string, int, error) {
+	if len(s) > 1 && s[1] == '$' {
+		return "$", 2, nil
+	}
+	var name string
+	n := 0
+	if len(s) > 1 && s[1] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0, fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name = s[2:end]
+		n = end + 1
+	} else {
+		end := 1
+		for end < len(s) && isRecipeVarChar(s[end]) {
+			end++
+		}
+		name = s[1:end]
+		n = end
+	}
+	if name == "" {
+		return "", 0, fmt.Errorf("empty variable reference")
+	}
+	v, ok := lookup(name)
+	if !ok {
+		return "", 0, fmt.Errorf("undefined variable: %s", name)
+	}
+	return v, n, nil
+}
+
+// FormatRecipe renders steps as a recipe that ParseRecipe reads back to the
+// same steps. Trailing empty (default) arguments are dropped.
+func FormatRecipe(steps []Step) string {
+	var b strings.Builder
+	b.WriteString("# recorded timp session\n")
+	for _, s := range steps {
+		b.WriteString(s.Name)
+		for _, a := range slices.Concat(trimDefaultArgs(s.Args), s.Region.flags()) {
+			b.WriteByte(' ')
+			b.WriteString(quoteRecipeArg(a))
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// FormatShellCommand renders steps as a `timp run` command line reading
+// input and writing output, quoted for POSIX shells.
+func FormatShellCommand(input, output string, steps []Step) string {
+	parts := []string{"timp", "run", shellQuote(input)}
+	for _, s := range steps {
+		parts = append(parts, s.Name)
+		for _, a := range slices.Concat(trimDefaultArgs(s.Args), s.Region.flags()) {
+			parts = append(parts, shellQuote(a))
+		}
+	}
+	parts = append(parts, "-o", shellQuote(output))
+	return strings.Join(parts, " ")
+}
+
+// editedOutputPath suggests an output path next to input, e.g.
+// photo.jpg -> photo-edited.jpg.
+func editedOutputPath(input string) string {
+	if input == "" {
+		return "out.png"
+	}
+	ext := filepath.Ext(input)
+	return strings.TrimSuffix(input, ext) + "-edited" + ext
+}
+
+// trimDefaultArgs drops trailing empty arguments, which NormalizeArgsFromStd
+// uses as placeholders for omitted optional parameters.
+func trimDefaultArgs(args []string) []string {
+	n := len(args)
+	for n > 0 && args[n-1] == "" {
+		n--
+	}
+	return args[:n]
+}
+
+// quoteRecipeArg quotes a recipe argument when splitRecipeLine would
+// otherwise change it.
+func quoteRecipeArg(a string) string {
+	if a != "" && !strings.ContainsAny(a, " \t\"'$\\") && a != "#" {
+		return a
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "$", "\\$")
+	return "\"" + r.Replace(a) + "\""
+}
+
+// shellQuote quotes s for a POSIX shell unless it only holds characters that
+// need no quoting.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, c := range s {
+		if !(c == '_' || c == '-' || c == '.' || c == '/' || c == ',' || c == ':' || c == '+' || c == '%' || c == '=' ||
+			c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", "'\\''") + "'"
+}
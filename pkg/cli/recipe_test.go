@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestParseRecipe(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	src := `# web export
+set width = 800
+set sigma 1.5
+
+autoLevel
+resize $width ${width}  # square
+blur "$sigma"
+sepia "" 40
+annotate 'cost $5' "" 12 1 1 #ff0000
+`
+	rec, err := ParseRecipe(strings.NewReader(src), store, map[string]string{"sigma": "2"})
+	if err != nil {
+		t.Fatalf("ParseRecipe: %v", err)
+	}
+	want := []Step{
+		{Name: "autoLevel", Line: 5},
+		{Name: "resize", Args: []string{"800", "800"}, Line: 6},
+		{Name: "blur", Args: []string{"2"}, Line: 7},
+		{Name: "sepia", Args: []string{"", "40"}, Line: 8},
+		{Name: "annotate", Args: []string{"cost $5", "", "12", "1", "1", "#ff0000"}, Line: 9},
+	}
+	if len(rec.Steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(rec.Steps), len(want), rec.Steps)
+	}
+	for i, w := range want {
+		g := rec.Steps[i]
+		if g.Name != w.Name || g.Line != w.Line || strings.Join(g.Args, "|") != strings.Join(w.Args, "|") {
+			t.Fatalf("step %d = %+v, want %+v", i, g, w)
+		}
+	}
+	if rec.Vars["width"] != "800" || rec.Vars["sigma"] != "2" {
+		t.Fatalf("unexpected vars: %v", rec.Vars)
+	}
+}
+
+func TestParseRecipeErrorsReportLine(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	cases := map[string]string{
+		"autoLevel\nblurr 1\n":         "line 2: unknown command: blurr",
+		"# c\n\nresize 10\n":           "line 3: resize: missing required parameter: height",
+		"gamma 1 2\n":                  "line 1: gamma takes at most 1 arguments",
+		"resize $w 10\n":               "line 1: undefined variable: w",
+		"autoLevel\nannotate \"oops\n": "line 2: unterminated \" quote",
+		"autoLevel\n\nresize ten 10\n": "line 3: resize: parameter width: expected integer",
+	}
+	for src, want := range cases {
+		_, err := ParseRecipe(strings.NewReader(src), store, nil)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseRecipe(%q) error = %v, want containing %q", src, err, want)
+		}
+	}
+}
+
+func TestFormatRecipeRoundTrip(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	steps := []Step{
+		{Name: "resize", Args: []string{"800", "600"}},
+		{Name: "sepia", Args: []string{"", "40", "", "", "", ""}},
+		{Name: "annotate", Args: []string{"it's $5 \"off\"", "", "12", "1", "1", "#ff0000"}},
+		{Name: "strip", Args: []string{}},
+		{Name: "blur", Args: []string{"2"}, Region: Region{Rect: image.Rect(1, 2, 11, 22), Mask: "sky mask.png", Feather: 1.5}},
+	}
+	rec, err := ParseRecipe(strings.NewReader(FormatRecipe(steps)), store, nil)
+	if err != nil {
+		t.Fatalf("ParseRecipe(FormatRecipe): %v", err)
+	}
+	if len(rec.Steps) != len(steps) {
+		t.Fatalf("got %d steps, want %d", len(rec.Steps), len(steps))
+	}
+	for i, s := range steps {
+		got := rec.Steps[i]
+		if got.Name != s.Name || strings.Join(got.Args, "|") != strings.Join(trimDefaultArgs(s.Args), "|") || got.Region != s.Region {
+			t.Fatalf("step %d = %+v, want %+v", i, got, s)
+		}
+	}
+}
+
+func TestFormatShellCommand(t *testing.T) {
+	steps := []Step{
+		{Name: "resize", Args: []string{"800", "600"}},
+		{Name: "sepia", Args: []string{"", "40", ""}},
+		{Name: "annotate", Args: []string{"it's here", "", "12", "1", "1", "#ff0000"}},
+	}
+	got := FormatShellCommand("my photo.jpg", "out.jpg", steps)
+	want := `timp run 'my photo.jpg' resize 800 600 sepia '' 40 annotate 'it'\''s here' '' 12 1 1 '#ff0000' -o out.jpg`
+	if got != want {
+		t.Fatalf("FormatShellCommand =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// Region limits a step to part of the image. It is written after a step's
+// arguments, on the command line or in a recipe:
+//
+//	blur 6 --mask background.png --feather 4
+//	modulate 100 130 100 --roi 0,0,1920,500
+//
+// --roi takes x,y,width,height; --mask takes a grayscale image file or an
+// open buffer (@name) whose white areas receive the command; --feather
+// blurs the edge of the selection by the given sigma in pixels. When both
+// --roi and --mask are given the step is limited to their intersection.
+type Region struct {
+	Rect    image.Rectangle
+	Mask    string
+	Feather float64
+}
+
+// IsZero reports whether r leaves the step unrestricted.
+func (r Region) IsZero() bool {
+	return r.Rect.Empty() && r.Mask == ""
+}
+
+// flags renders r as the tokens that parseRegionFlag reads back.
+func (r Region) flags() []string {
+	var out []string
+	if !r.Rect.Empty() {
+		out = append(out, "--roi", fmt.Sprintf("%d,%d,%d,%d", r.Rect.Min.X, r.Rect.Min.Y, r.Rect.Dx(), r.Rect.Dy()))
+	}
+	if r.Mask != "" {
+		out = append(out, "--mask", r.Mask)
+	}
+	if r.Feather > 0 && !r.IsZero() {
+		out = append(out, "--feather", strconv.FormatFloat(r.Feather, 'g', -1, 64))
+	}
+	return out
+}
+
+// String describes r for listings.
+func (r Region) String() string {
+	if r.IsZero() {
+		return "whole image"
+	}
+	return strings.Join(r.flags(), " ")
+}
+
+// mask builds the selection mask for an image with bounds b.
+func (r Region) mask(b image.Rectangle) (*image.Gray, error) {
+	if r.IsZero() {
+		return nil, nil
+	}
+	var m *image.Gray
+	if !r.Rect.Empty() {
+		m = stdimg.RectMask(b, r.Rect.Add(b.Min))
+	}
+	if r.Mask != "" {
+		fromFile, err := stdimg.LoadMask(r.Mask, b)
+		if err != nil {
+			return nil, err
+		}
+		m = stdimg.IntersectMasks(m, fromFile)
+	}
+	return stdimg.FeatherMask(m, r.Feather), nil
+}
+
+// isRegionFlag reports whether tok is one of the flags parseRegionFlag
+// handles.
+func isRegionFlag(tok string) bool {
+	return tok == "--roi" || tok == "--mask" || tok == "--feather"
+}
+
+// parseRegionFlag sets the part of r named by flag from value.
+func parseRegionFlag(r *Region, flag, value string) error {
+	switch flag {
+	case "--roi":
+		rect, err := parseROI(value)
+		if err != nil {
+			return err
+		}
+		r.Rect = rect
+	case "--mask":
+		if value == "" {
+			return fmt.Errorf("--mask requires an image path")
+		}
+		r.Mask = value
+	case "--feather":
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("invalid --feather %q (want a sigma in pixels)", value)
+		}
+		r.Feather = f
+	default:
+		return fmt.Errorf("unknown region flag %s", flag)
+	}
+	return nil
+}
+
+// parseROI parses "x,y,width,height".
+func parseROI(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid --roi %q (want x,y,width,height)", s)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid --roi %q (want x,y,width,height)", s)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid --roi %q: width and height must be positive", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// promptRegion asks for the parts of a Region. Leaving the rectangle and
+// the mask empty selects the whole image again.
+func promptRegion() (Region, error) {
+	var r Region
+	roi, _ := PromptLineKind("Rectangle as x,y,width,height (empty for none): ", "roi", nil)
+	if roi = strings.TrimSpace(roi); roi != "" {
+		if err := parseRegionFlag(&r, "--roi", roi); err != nil {
+			return Region{}, err
+		}
+	}
+	mask, _ := PromptLineWithFzf("Mask image or @buffer [enter '/' to use fzf] (empty for none): ")
+	if mask = strings.TrimSpace(mask); mask != "" {
+		r.Mask = mask
+	}
+	if r.IsZero() {
+		return r, nil
+	}
+	feather, _ := PromptLineKind("Feather sigma in pixels (empty for a hard edge): ", "feather", nil)
+	if feather = strings.TrimSpace(feather); feather != "" {
+		if err := parseRegionFlag(&r, "--feather", feather); err != nil {
+			return Region{}, err
+		}
+	}
+	return r, nil
+}
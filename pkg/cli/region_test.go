@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestParseStepsRegionFlags(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	steps, err := ParseSteps(store, []string{"blur", "2", "--roi", "1,2,30,40", "--feather", "3", "negate", "--mask", "@sky"})
+	if err != nil {
+		t.Fatalf("ParseSteps: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("got %d steps: %+v", len(steps), steps)
+	}
+	if want := (Region{Rect: image.Rect(1, 2, 31, 42), Feather: 3}); steps[0].Region != want {
+		t.Errorf("blur region = %+v, want %+v", steps[0].Region, want)
+	}
+	if steps[1].Region.Mask != "@sky" || len(steps[1].Args) != 0 {
+		t.Errorf("negate step = %+v", steps[1])
+	}
+	for _, bad := range [][]string{
+		{"--roi", "0,0,1,1", "blur", "1"},
+		{"blur", "1", "--roi", "0,0,1"},
+		{"blur", "1", "--feather", "-2"},
+		{"blur", "1", "--mask"},
+	} {
+		if _, err := ParseSteps(store, bad); err == nil {
+			t.Errorf("ParseSteps(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRunOneShotRegion(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	maskPath := filepath.Join(dir, "mask.png")
+	out := filepath.Join(dir, "out.png")
+	writePNG := func(path string, img image.Image) {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePNG(in, solidNRGBA(8, 4, color.NRGBA{R: 40, G: 40, B: 40, A: 255}))
+	// The mask selects the left half.
+	mask := image.NewGray(image.Rect(0, 0, 8, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			mask.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	writePNG(maskPath, mask)
+
+	if err := RunOneShot([]string{in, "negate", "--mask", maskPath, "gamma", "1", "--roi", "0,0,2,2", "-o", out}); err != nil {
+		t.Fatalf("RunOneShot: %v", err)
+	}
+	img, _, _, _, err := LoadImage(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := stdimg.ToNRGBA(img)
+	if got := res.NRGBAAt(1, 1).R; got != 215 {
+		t.Errorf("inside the mask: %d, want 215", got)
+	}
+	if got := res.NRGBAAt(6, 1).R; got != 40 {
+		t.Errorf("outside the mask: %d, want 40", got)
+	}
+
+	if err := RunOneShot([]string{in, "resize", "4", "4", "--roi", "0,0,2,2", "-o", out}); err == nil {
+		t.Error("a size-changing command limited to a region should fail")
+	}
+}
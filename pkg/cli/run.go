@@ -0,0 +1,385 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// Step is a single engine command invocation together with its raw
+// (not yet normalized) arguments.
+type Step struct {
+	Name string
+	Args []string
+	// Line is the 1-based recipe line the step came from, or 0 when the step
+	// was given on the command line.
+	Line int
+	// Region, unless zero, limits the step to part of the image.
+	Region Region
+}
+
+// label identifies s in error messages: by recipe line when known, otherwise
+// by its 1-based position i in the chain.
+func (s Step) label(i int) string {
+	if s.Line > 0 {
+		return fmt.Sprintf("line %d (%s)", s.Line, s.Name)
+	}
+	return fmt.Sprintf("step %d (%s)", i+1, s.Name)
+}
+
+// runUsage prints the usage for the non-interactive `run` subcommand.
+func runUsage() {
+	fmt.Fprintln(os.Stderr, "usage: timp run <input> [--recipe file] [--var name=value] [<command> [args...] [--roi x,y,w,h] [--mask image] [--feather sigma]...] -o <output> [--format png|jpeg|gif]")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Commands are applied in order. Optional arguments may be omitted at the end")
+	fmt.Fprintln(os.Stderr, "of a command or passed as an empty string (\"\") to keep the default.")
+	fmt.Fprintln(os.Stderr, "Recipe steps run before any commands given on the command line.")
+	fmt.Fprintln(os.Stderr, "--roi and --mask after a command's arguments limit it to a rectangle or to")
+	fmt.Fprintln(os.Stderr, "the white areas of a grayscale mask; --feather softens the selection edge.")
+	fmt.Fprintln(os.Stderr, "Use - as input or output to read from stdin or write to stdout; the output")
+	fmt.Fprintln(os.Stderr, "format then comes from --format (default: the input format).")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "example: timp run in.jpg resize 800 600 blur 1.2 -o out.jpg")
+	fmt.Fprintln(os.Stderr, "         timp run in.jpg --recipe web.timp --var width=1200 -o out.jpg")
+	fmt.Fprintln(os.Stderr, "         timp run in.jpg blur 6 --mask background.png --feather 4 -o out.jpg")
+	fmt.Fprintln(os.Stderr, "         curl -s https://example.com/a.jpg | timp run - resize 256 256 -o - --format png > a.png")
+}
+
+// stepLabel renders s as "name arg ..." for listings, leaving out trailing
+// default arguments.
+func stepLabel(s Step) string {
+	return strings.TrimSpace(s.Name + " " + strings.Join(slices.Concat(trimDefaultArgs(s.Args), s.Region.flags()), " "))
+}
+
+// ParseSteps splits a flat token list such as "resize 800 600 blur 1.2" into
+// Steps using the command registry in store. A token that names a known
+// command starts a new step once the current step has all of its required
+// arguments; every other token is an argument of the current step. A bool
+// argument may also be set with a "--name" token, e.g. "identify --json",
+// and --roi, --mask and --feather limit the current step to a Region.
+func ParseSteps(store *StdMetaStore, tokens []string) ([]Step, error) {
+	if store == nil {
+		return nil, fmt.Errorf("metadata store is nil")
+	}
+	var steps []Step
+	var spec stdimg.CommandSpec
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if isRegionFlag(tok) {
+			if len(steps) == 0 {
+				return nil, fmt.Errorf("%s must follow a command", tok)
+			}
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("%s requires a value", tok)
+			}
+			if err := parseRegionFlag(&steps[len(steps)-1].Region, tok, tokens[i+1]); err != nil {
+				return nil, fmt.Errorf("%s: %w", steps[len(steps)-1].Name, err)
+			}
+			i++
+			continue
+		}
+		c, known := store.byName[tok]
+		if known && (len(steps) == 0 || len(steps[len(steps)-1].Args) >= requiredArgCount(spec)) {
+			steps = append(steps, Step{Name: c.Name})
+			spec = c
+			continue
+		}
+		if len(steps) == 0 {
+			return nil, fmt.Errorf("unknown command: %s", tok)
+		}
+		cur := &steps[len(steps)-1]
+		if args, ok, err := applyBoolFlag(spec, cur.Args, tok); ok || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			cur.Args = args
+			continue
+		}
+		if len(cur.Args) >= len(spec.Args) {
+			if !known {
+				return nil, fmt.Errorf("unknown command: %s", tok)
+			}
+			return nil, fmt.Errorf("%s: unexpected argument %q (takes at most %d)", cur.Name, tok, len(spec.Args))
+		}
+		cur.Args = append(cur.Args, tok)
+	}
+	return steps, nil
+}
+
+// applyBoolFlag handles a "--name" token naming a bool argument of c: it
+// sets that argument to true in args, padding skipped optional arguments
+// with "". ok reports whether tok was such a flag.
+func applyBoolFlag(c stdimg.CommandSpec, args []string, tok string) (out []string, ok bool, err error) {
+	name, isFlag := strings.CutPrefix(tok, "--")
+	if !isFlag {
+		return args, false, nil
+	}
+	for j, a := range c.Args {
+		if a.Type != "bool" || a.Name != name {
+			continue
+		}
+		if j < len(args) {
+			return nil, true, fmt.Errorf("%s: %s given twice", c.Name, tok)
+		}
+		for len(args) < j {
+			args = append(args, "")
+		}
+		return append(args, "true"), true, nil
+	}
+	return args, false, nil
+}
+
+// requiredArgCount returns the number of required arguments of a command.
+func requiredArgCount(c stdimg.CommandSpec) int {
+	n := 0
+	for _, a := range c.Args {
+		if a.Required {
+			n++
+		}
+	}
+	return n
+}
+
+// applyStep validates s against the metadata store and applies it to img.
+// It returns the resulting image (img itself when the command produces no
+// image, e.g. identify) and the normalized arguments that were used.
+// Cancelling ctx stops a long-running command with ctx.Err().
+func applyStep(ctx context.Context, store *StdMetaStore, img image.Image, s Step) (image.Image, []string, error) {
+	normArgs, err := NormalizeArgsFromStd(store, s.Name, s.Args)
+	if err != nil {
+		return nil, nil, err
+	}
+	mask, err := s.Region.mask(img.Bounds())
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := stdimg.ApplyCommandMasked(ctx, img, s.Name, normArgs, mask, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if out == nil {
+		out = img
+	}
+	return out, normArgs, nil
+}
+
+// imageState is an image together with the metadata that travels with it
+// between load and save.
+type imageState struct {
+	img          image.Image
+	path         string
+	format       string
+	appSegments  []AppSegment
+	autoOriented bool
+}
+
+// applySteps applies steps to st in order, handling the metadata side effects
+// of strip and identify and the report of compare. onApplied, when non-nil, is called after each step
+// with the step's normalized arguments.
+// Runs of point operations without a region (level, gamma, ...) are fused
+// and only evaluated when the next other step, or the caller, needs the
+// image, so the st passed to onApplied for those steps still holds the
+// image from before the run.
+// It stops at the first failure and returns the state reached so far with the
+// error; callers that need all-or-nothing semantics keep their own copy.
+func applySteps(ctx context.Context, store *StdMetaStore, st imageState, steps []Step, onApplied func(i int, s Step, st imageState)) (imageState, error) {
+	pending := stdimg.NewGraph(st.img)
+	flush := func() error {
+		img, err := pending.Image(ctx, nil)
+		if err != nil {
+			return err
+		}
+		st.img = img
+		pending = stdimg.NewGraph(img)
+		return nil
+	}
+	fail := func(i int, s Step, err error) (imageState, error) {
+		_ = flush()
+		return st, fmt.Errorf("%s: %w", s.label(i), err)
+	}
+	for i, s := range steps {
+		if s.Region.IsZero() && stdimg.IsPointOperation(s.Name) {
+			normArgs, err := NormalizeArgsFromStd(store, s.Name, s.Args)
+			if err == nil {
+				err = pending.Apply(s.Name, normArgs)
+			}
+			if err != nil {
+				return fail(i, s, err)
+			}
+			s.Args = normArgs
+			if onApplied != nil {
+				onApplied(i, s, st)
+			}
+			continue
+		}
+		if err := flush(); err != nil {
+			return fail(i, s, err)
+		}
+		prev := st.img
+		out, normArgs, err := applyStep(ctx, store, st.img, s)
+		if err != nil {
+			return fail(i, s, err)
+		}
+		s.Args = normArgs
+		st.img = out
+		pending = stdimg.NewGraph(out)
+		switch s.Name {
+		case "strip":
+			st.appSegments = nil
+			st.autoOriented = false
+		case "identify":
+			if len(s.Args) > 0 && s.Args[0] == "true" {
+				if err := writeIdentifyJSON(previewOut(), st, true); err != nil {
+					return st, fmt.Errorf("%s: %w", s.label(i), err)
+				}
+			} else {
+				printIdentify(previewOut(), st.path)
+			}
+		case "compare":
+			reportComparison(previewOut(), prev, s.Args[0])
+		}
+		if onApplied != nil {
+			onApplied(i, s, st)
+		}
+	}
+	if err := flush(); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+// buildSteps loads the recipes in order and appends the command chain given
+// as tokens, validating every step before returning.
+func buildSteps(store *StdMetaStore, recipes []string, vars map[string]string, tokens []string) ([]Step, error) {
+	var steps []Step
+	for _, path := range recipes {
+		rec, err := LoadRecipe(path, store, vars)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, rec.Steps...)
+	}
+	cmdSteps, err := ParseSteps(store, tokens)
+	if err != nil {
+		return nil, err
+	}
+	// Validate the whole chain before doing any work so typos fail fast.
+	for i, s := range cmdSteps {
+		if _, err := NormalizeArgsFromStd(store, s.Name, s.Args); err != nil {
+			return nil, fmt.Errorf("%s: %w", s.label(len(steps)+i), err)
+		}
+	}
+	return append(steps, cmdSteps...), nil
+}
+
+// RunOneShot implements `timp run`: it loads an input image, applies a chain
+// of commands in order and saves the result, keeping JPEG APPn metadata unless
+// the chain contains `strip`. It returns on the first failure.
+func RunOneShot(args []string) error {
+	var output, format string
+	var recipes []string
+	vars := map[string]string{}
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-o", "--output", "--format", "--recipe", "--var":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s requires a value", args[i])
+			}
+			switch args[i] {
+			case "--format":
+				format = args[i+1]
+			case "--recipe":
+				recipes = append(recipes, args[i+1])
+			case "--var":
+				if err := ParseVarFlag(args[i+1], vars); err != nil {
+					return err
+				}
+			default:
+				output = args[i+1]
+			}
+			i++
+		case "-h", "--help":
+			runUsage()
+			return nil
+		default:
+			rest = append(rest, args[i])
+		}
+	}
+	if len(rest) == 0 {
+		runUsage()
+		return fmt.Errorf("missing input image")
+	}
+	if output == "" {
+		return fmt.Errorf("missing output path (-o)")
+	}
+	if format != "" {
+		if _, err := normalizeImageFormat(format); err != nil {
+			return err
+		}
+	}
+	input := rest[0]
+	if output == "-" {
+		// Keep stdout clean for the image stream.
+		stdoutIsData = true
+		defer func() { stdoutIsData = false }()
+	}
+
+	store := newCommandStore()
+	steps, err := buildSteps(store, recipes, vars, rest[1:])
+	if err != nil {
+		return err
+	}
+
+	img, inFormat, appSegments, autoOriented, err := LoadImage(input)
+	if err != nil {
+		return fmt.Errorf("failed to read image %s: %w", input, err)
+	}
+	st := imageState{img: img, path: input, format: inFormat, appSegments: appSegments, autoOriented: autoOriented}
+	st, err = applySteps(context.Background(), store, st, steps, func(_ int, s Step, st imageState) {
+		if s.Name == "identify" {
+			if info, ierr := GetImageInfoImage(st.img); ierr == nil {
+				fmt.Fprintln(previewOut(), info)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if format == "" && output == "-" {
+		format = st.format
+	}
+	if err := SaveImageFormat(output, format, st.img, st.appSegments, st.autoOriented); err != nil {
+		return fmt.Errorf("failed to write image %s: %w", output, err)
+	}
+	if output != "-" {
+		fmt.Printf("Saved to %s\n", output)
+	}
+	return nil
+}
+
+// runSubcommand dispatches non-interactive subcommands. It reports whether
+// args named a subcommand; the error is the subcommand's result.
+func runSubcommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "run":
+		return true, RunOneShot(args[1:])
+	case "batch":
+		return true, RunBatch(args[1:])
+	case "identify":
+		return true, RunIdentify(args[1:])
+	case "config":
+		return true, RunConfig(args[1:])
+	case "compare":
+		return true, RunCompare(args[1:])
+	}
+	return false, nil
+}
@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestParseSteps(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	steps, err := ParseSteps(store, []string{"resize", "8", "6", "blur", "1.2", "strip", "sepia", "50%"})
+	if err != nil {
+		t.Fatalf("ParseSteps: %v", err)
+	}
+	want := []Step{
+		{Name: "resize", Args: []string{"8", "6"}},
+		{Name: "blur", Args: []string{"1.2"}},
+		{Name: "strip"},
+		{Name: "sepia", Args: []string{"50%"}},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(steps), len(want), steps)
+	}
+	for i := range want {
+		if steps[i].Name != want[i].Name || len(steps[i].Args) != len(want[i].Args) {
+			t.Fatalf("step %d = %+v, want %+v", i, steps[i], want[i])
+		}
+		for j := range want[i].Args {
+			if steps[i].Args[j] != want[i].Args[j] {
+				t.Fatalf("step %d arg %d = %q, want %q", i, j, steps[i].Args[j], want[i].Args[j])
+			}
+		}
+	}
+
+	if _, err := ParseSteps(store, []string{"blurr", "1"}); err == nil {
+		t.Fatalf("expected error for unknown command")
+	}
+	if _, err := ParseSteps(store, []string{"gamma", "1", "2"}); err == nil {
+		t.Fatalf("expected error for extra argument")
+	}
+}
+
+func TestRunOneShot(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out.png")
+
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 12))
+	for y := 0; y < 12; y++ {
+		for x := 0; x < 16; x++ {
+			src.Set(x, y, color.NRGBA{uint8(x * 16), uint8(y * 20), 100, 255})
+		}
+	}
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if err := RunOneShot([]string{in, "resize", "8", "6", "blur", "1", "-o", out}); err != nil {
+		t.Fatalf("RunOneShot: %v", err)
+	}
+	img, _, _, _, err := LoadImage(out)
+	if err != nil {
+		t.Fatalf("LoadImage output: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 8 || b.Dy() != 6 {
+		t.Fatalf("output size = %dx%d, want 8x6", b.Dx(), b.Dy())
+	}
+
+	// An invalid argument must fail before anything is written.
+	bad := filepath.Join(dir, "bad.png")
+	if err := RunOneShot([]string{in, "resize", "x", "6", "-o", bad}); err == nil {
+		t.Fatalf("expected validation error")
+	}
+	if _, err := os.Stat(bad); !os.IsNotExist(err) {
+		t.Fatalf("output written despite validation error")
+	}
+}
+
+func TestRunOneShotKeeps16BitPNG(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out.png")
+
+	src := image.NewNRGBA64(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			src.SetNRGBA64(x, y, color.NRGBA64{uint16(x*8000 + 3), uint16(y * 8000), 1234, 65535})
+		}
+	}
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// sepia has no 16-bit implementation; the output must stay deep anyway.
+	if err := RunOneShot([]string{in, "gamma", "1.1", "sepia", "-o", out}); err != nil {
+		t.Fatalf("RunOneShot: %v", err)
+	}
+	f, err = os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ColorModel != color.RGBA64Model && cfg.ColorModel != color.NRGBA64Model {
+		t.Errorf("output color model is not 16-bit")
+	}
+}
+
+func TestRunOneShotStdinStdout(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.png")
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	f, err := os.Create(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	stdin, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	outPath := filepath.Join(dir, "stdout.bin")
+	stdout, err := os.Create(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldIn, oldOut := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = stdin, stdout
+	// identify prints a summary, which must not end up in the image stream.
+	runErr := RunOneShot([]string{"-", "negate", "identify", "-o", "-", "--format", "jpg"})
+	os.Stdin, os.Stdout = oldIn, oldOut
+	stdout.Close()
+	if runErr != nil {
+		t.Fatalf("RunOneShot: %v", runErr)
+	}
+
+	data, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 3 || data[0] != 0xFF || data[1] != 0xD8 {
+		t.Fatalf("stdout does not start with a JPEG SOI marker: % x", data[:min(len(data), 8)])
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("stdout is not a valid JPEG: %v", err)
+	}
+	if stdoutIsData {
+		t.Fatalf("stdoutIsData left set after run")
+	}
+}
+
+func TestApplyStepsFusesPointOperations(t *testing.T) {
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 9))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 37)
+	}
+	steps, err := ParseSteps(store, []string{
+		"level", "10", "1.3", "240", "gamma", "1.6", "negate",
+		"modulate", "90", "120", "15", "--roi", "0,0,8,9",
+		"gamma", "0.8", "threshold", "100", "true", "flip",
+		"negate", "true", "modulate", "110", "90", "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want image.Image = src
+	for _, s := range steps {
+		if want, _, err = applyStep(context.Background(), store, want, s); err != nil {
+			t.Fatalf("%s: %v", s.Name, err)
+		}
+	}
+	var seen int
+	st, err := applySteps(context.Background(), store, imageState{img: src}, steps, func(int, Step, imageState) { seen++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen != len(steps) {
+		t.Errorf("onApplied called %d times, want %d", seen, len(steps))
+	}
+	samePixels(t, stdimg.ToNRGBA(st.img), stdimg.ToNRGBA(want))
+
+	bad := append(steps[:2:2], Step{Name: "gamma", Args: []string{"nope"}})
+	if _, err := applySteps(context.Background(), store, imageState{img: src}, bad, nil); err == nil || !strings.Contains(err.Error(), "step 3") {
+		t.Errorf("bad step error = %v", err)
+	}
+}
+
+// GIFs get a palette built from the image, so a handful of colors outside
+// the web-safe palette survive exactly, and transparency is kept.
+func TestSaveGIFQuantizes(t *testing.T) {
+	colors := []color.NRGBA{{13, 77, 201, 255}, {250, 131, 7, 255}, {99, 99, 98, 255}}
+	src := image.NewNRGBA(image.Rect(0, 0, 12, 9))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 12; x++ {
+			src.SetNRGBA(x, y, colors[(x/4+y/3)%len(colors)])
+		}
+	}
+	src.SetNRGBA(0, 0, color.NRGBA{})
+	path := filepath.Join(t.TempDir(), "out.gif")
+	if err := SaveImage(path, src, nil, false); err != nil {
+		t.Fatalf("SaveImage: %v", err)
+	}
+	img, format, _, _, err := LoadImage(path)
+	if err != nil || format != "gif" {
+		t.Fatalf("LoadImage: %v (%s)", err, format)
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Error("transparent pixel became opaque")
+	}
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 12; x++ {
+			if x == 0 && y == 0 {
+				continue
+			}
+			if got := color.NRGBAModel.Convert(img.At(x, y)); got != src.NRGBAAt(x, y) {
+				t.Fatalf("(%d,%d) = %v, want %v", x, y, got, src.NRGBAAt(x, y))
+			}
+		}
+	}
+}
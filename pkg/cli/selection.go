@@ -0,0 +1,164 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+// selectionRef is the mask reference that stands for the active buffer's
+// selection. Steps applied while a selection is active record it as their
+// --mask, so it has to be saved to a file before a recipe can replay them.
+const selectionRef = "@selection"
+
+// setSelection makes m the buffer's selection and limits later commands to
+// it. A nil m clears the selection.
+func (b *Buffer) setSelection(m *image.Gray) {
+	b.selection = m
+	switch {
+	case m != nil:
+		b.region.Mask = selectionRef
+	case b.region.Mask == selectionRef:
+		b.region.Mask = ""
+	}
+}
+
+// combineSelection merges next into cur: "replace" (or "") drops cur,
+// "add", "subtract" and "intersect" combine the two. Subtracting from no
+// selection leaves nothing selected. cur must cover the same area as next,
+// which it no longer does once the image is resized or cropped.
+func combineSelection(mode string, cur, next *image.Gray) (*image.Gray, error) {
+	switch mode {
+	case "", "replace":
+		return next, nil
+	case "add", "subtract", "intersect":
+	default:
+		return nil, fmt.Errorf("unknown selection mode %q (want replace, add, subtract or intersect)", mode)
+	}
+	if cur != nil && !cur.Rect.Eq(next.Rect) {
+		return nil, fmt.Errorf("the selection is %dx%d but the image is %dx%d; replace it instead", cur.Rect.Dx(), cur.Rect.Dy(), next.Rect.Dx(), next.Rect.Dy())
+	}
+	switch mode {
+	case "add":
+		if cur == nil {
+			return next, nil
+		}
+		return stdimg.AddMasks(cur, next), nil
+	case "subtract":
+		if cur == nil {
+			return image.NewGray(next.Rect), nil
+		}
+		return stdimg.SubtractMasks(cur, next), nil
+	}
+	return stdimg.IntersectMasks(cur, next), nil
+}
+
+// describeSelection summarises m for the interactive prompt.
+func describeSelection(m *image.Gray) string {
+	if m == nil {
+		return "none"
+	}
+	var sum int64
+	for y := 0; y < m.Rect.Dy(); y++ {
+		for _, v := range m.Pix[y*m.Stride : y*m.Stride+m.Rect.Dx()] {
+			sum += int64(v)
+		}
+	}
+	n := int64(m.Rect.Dx()) * int64(m.Rect.Dy())
+	if n == 0 {
+		return "empty"
+	}
+	return fmt.Sprintf("%.1f%% of the image", float64(sum)*100/float64(n*255))
+}
+
+// selectWith runs one of the select commands on img and returns its result
+// as a mask over img's bounds.
+func selectWith(img image.Image, name string, args []string) (*image.Gray, error) {
+	out, err := stdimg.ApplyCommandContext(context.Background(), img, name, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	return stdimg.MaskFromImage(out, img.Bounds()), nil
+}
+
+// editSelection runs the interactive selection menu for buf.
+func editSelection(buf *Buffer) error {
+	fmt.Printf("Current selection: %s\n", describeSelection(buf.selection))
+	choice, _ := PromptLine("(c) select by color at x,y, (s)imilar colors, (i)nvert, (g)row, s(h)rink, (f)eather, (w)rite as PNG, (x) clear (leave empty to cancel): ")
+	choice = strings.ToLower(strings.TrimSpace(choice))
+	if choice == "" {
+		fmt.Println("selection unchanged")
+		return nil
+	}
+	if choice != "c" && choice != "s" && buf.selection == nil {
+		return fmt.Errorf("nothing selected; use (c) or (s) first")
+	}
+	sel := buf.selection
+	switch choice {
+	case "c", "s":
+		var next *image.Gray
+		var err error
+		if choice == "c" {
+			at, _ := PromptLineKind("Seed point as x,y: ", "point", nil)
+			xs, ys, ok := strings.Cut(at, ",")
+			if !ok {
+				return fmt.Errorf("invalid point %q (want x,y)", at)
+			}
+			fuzz, _ := PromptLineKind("Fuzz as Lab delta-E or percent (e.g. 10): ", "arg:fuzz", nil)
+			next, err = selectWith(buf.st.img, "selectColor", []string{strings.TrimSpace(xs), strings.TrimSpace(ys), strings.TrimSpace(fuzz)})
+		} else {
+			col, _ := PromptLineKind("Color (CSS name or hex): ", "arg:color", nil)
+			fuzz, _ := PromptLineKind("Fuzz as Lab delta-E or percent (e.g. 10): ", "arg:fuzz", nil)
+			next, err = selectWith(buf.st.img, "selectSimilar", []string{strings.TrimSpace(col), strings.TrimSpace(fuzz)})
+		}
+		if err != nil {
+			return err
+		}
+		mode := ""
+		if buf.selection != nil {
+			mode, _ = PromptLineKind("Combine with the current selection: replace, add, subtract or intersect [replace]: ", "selection-mode", nil)
+		}
+		if sel, err = combineSelection(strings.ToLower(strings.TrimSpace(mode)), buf.selection, next); err != nil {
+			return err
+		}
+	case "i":
+		sel = stdimg.InvertMask(sel)
+	case "g", "h", "f":
+		amount, _ := PromptLineKind("Radius in pixels: ", "radius", nil)
+		v, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid radius %q", amount)
+		}
+		switch choice {
+		case "g":
+			sel = stdimg.GrowMask(sel, int(v+0.5))
+		case "h":
+			sel = stdimg.ShrinkMask(sel, int(v+0.5))
+		default:
+			sel = stdimg.FeatherMask(sel, v)
+		}
+	case "w":
+		out, _ := PromptLineKind("Enter mask filename (.png): ", "path", completePath)
+		if out == "" {
+			return fmt.Errorf("no filename provided")
+		}
+		if err := SaveImageFormat(out, "png", sel, nil, false); err != nil {
+			return err
+		}
+		fmt.Printf("Saved selection to %s\n", out)
+		return nil
+	case "x":
+		buf.setSelection(nil)
+		fmt.Println("selection cleared; commands apply to:", buf.region)
+		return nil
+	default:
+		return fmt.Errorf("unknown choice: %s", choice)
+	}
+	buf.setSelection(sel)
+	fmt.Printf("Selection: %s; commands apply to: %s\n", describeSelection(sel), buf.region)
+	return nil
+}
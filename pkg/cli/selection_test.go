@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
+)
+
+func TestCombineSelection(t *testing.T) {
+	b := image.Rect(0, 0, 4, 1)
+	cur := image.NewGray(b)
+	copy(cur.Pix, []uint8{255, 255, 0, 0})
+	next := image.NewGray(b)
+	copy(next.Pix, []uint8{0, 255, 255, 0})
+	for _, tc := range []struct {
+		mode string
+		want []uint8
+	}{
+		{"", []uint8{0, 255, 255, 0}},
+		{"replace", []uint8{0, 255, 255, 0}},
+		{"add", []uint8{255, 255, 255, 0}},
+		{"subtract", []uint8{255, 0, 0, 0}},
+		{"intersect", []uint8{0, 255, 0, 0}},
+	} {
+		got, err := combineSelection(tc.mode, cur, next)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.mode, err)
+		}
+		if string(got.Pix) != string(tc.want) {
+			t.Errorf("%q = %v, want %v", tc.mode, got.Pix, tc.want)
+		}
+	}
+	if _, err := combineSelection("xor", cur, next); err == nil {
+		t.Error("unknown mode should fail")
+	}
+
+	// Subtracting from nothing selects nothing.
+	got, err := combineSelection("subtract", nil, next)
+	if err != nil || string(got.Pix) != string([]uint8{0, 0, 0, 0}) {
+		t.Errorf("subtract from no selection = %v, %v", got, err)
+	}
+	// A selection left over from before a resize or crop no longer fits.
+	resized := image.NewGray(image.Rect(0, 0, 2, 1))
+	for _, mode := range []string{"add", "subtract", "intersect"} {
+		if _, err := combineSelection(mode, cur, resized); err == nil {
+			t.Errorf("%q with a selection of another size should fail", mode)
+		}
+	}
+	if got, err := combineSelection("replace", cur, resized); err != nil || got != resized {
+		t.Errorf("replace with a selection of another size = %v, %v", got, err)
+	}
+}
+
+func TestSelectionLimitsSteps(t *testing.T) {
+	sess := NewSession(defaultHistoryMemory)
+	defer sess.CloseAll()
+	stdimg.SourceResolver = sess.Resolve
+	defer func() { stdimg.SourceResolver = nil }()
+
+	// Red on the left half, blue on the right.
+	img := solidNRGBA(6, 2, color.NRGBA{0, 0, 255, 255})
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 255})
+		}
+	}
+	buf := sess.Open(imageState{img: img, path: "in.png"})
+	sel, err := selectWith(img, "selectColor", []string{"0", "0", "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.setSelection(sel)
+	if buf.region.Mask != selectionRef {
+		t.Fatalf("region = %v, want the selection as mask", buf.region)
+	}
+	if got, ok := sess.Resolve(selectionRef); !ok || got != sel {
+		t.Fatalf("Resolve(%s) = %v, %v", selectionRef, got, ok)
+	}
+
+	store := NewMetaStoreFromStdimg(stdimg.Commands)
+	out, _, err := applyStep(context.Background(), store, img, Step{Name: "negate", Region: buf.region})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := stdimg.ToNRGBA(out)
+	if got := res.NRGBAAt(1, 1); got != (color.NRGBA{0, 255, 255, 255}) {
+		t.Errorf("selected pixel = %v, want negated red", got)
+	}
+	if got := res.NRGBAAt(4, 1); got != (color.NRGBA{0, 0, 255, 255}) {
+		t.Errorf("unselected pixel = %v, want unchanged blue", got)
+	}
+
+	buf.setSelection(nil)
+	if !buf.region.IsZero() {
+		t.Errorf("clearing the selection left region %v", buf.region)
+	}
+}
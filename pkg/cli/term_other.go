@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package cli
+
+import "errors"
+
+// isTerminal always reports false here, so prompts read plain lines.
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
@@ -11,6 +11,8 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+
+	"github.com/Fepozopo/timp/pkg/stdimg"
 )
 
 // Terminal preview helper for Kitty and iTerm2 inline-image protocols.
@@ -30,21 +32,35 @@ import (
 //
 // Notes:
 //   - Sending binary escape sequences to stdout is expected in this terminal-only preview mode.
+//   - When stdout carries image data (`timp run ... -o -`), previews go to stderr instead.
 //
 // Debugging helper controlled by PREVIEW_DEBUG=1
 var previewDebug bool
 
-func init() {
-	if err := LoadDotEnv(".env"); err != nil {
-		// Ignore error if .env not present; it's optional
-	}
+// stdoutIsData is set while stdout carries an encoded image; previews and
+// status messages are then written to stderr so they cannot corrupt it.
+var stdoutIsData bool
 
-	debug := os.Getenv("PREVIEW_DEBUG")
-	if debug == "1" || debug == "true" {
-		previewDebug = true
+// previewOut returns the stream previews and status messages are written to.
+func previewOut() *os.File {
+	if stdoutIsData {
+		return os.Stderr
 	}
+	return os.Stdout
 }
 
+// previewBackend is the preferred backend (config key preview_backend,
+// PREVIEW_BACKEND in the environment); empty means detect.
+var previewBackend string
+
+// previewLimits bounds the preview size in terminal cells.
+type previewLimits struct {
+	MinCols, MinRows, MaxCols, MaxRows int
+}
+
+// previewClamp is set from the preview_* config keys.
+var previewClamp = previewLimits{MinCols: 6, MinRows: 3, MaxCols: 80, MaxRows: 40}
+
 func debugf(format string, args ...interface{}) {
 	if previewDebug {
 		fmt.Fprintf(os.Stderr, "timp-preview: "+format+"\n", args...)
@@ -166,11 +182,16 @@ func PreviewImage(img image.Image, format string) error {
 	if img == nil {
 		return fmt.Errorf("nil image")
 	}
+	if stdimg.Is16Bit(img) {
+		// The terminal shows 8 bits per channel; a 16-bit PNG only doubles
+		// the payload.
+		img = stdimg.ToNRGBA(img)
+	}
 	var buf bytes.Buffer
 	f := strings.ToLower(format)
 	// Determine backend override and only force PNG for kitty when appropriate as some terminals have issues with JPEG
 	// if they only partially support the kitty graphics protocol.
-	backend := strings.ToLower(os.Getenv("PREVIEW_BACKEND"))
+	backend := previewBackend
 	if backend == "" && hasChafa() != true || backend == "kitty" {
 		// Ghostty implements the kitty protocol only partially and reliably
 		// renders PNG — force PNG for ghostty terminals while leaving real
@@ -183,7 +204,7 @@ func PreviewImage(img image.Image, format string) error {
 		debugf("PREVIEW_BACKEND=%s -> not forcing png", backend)
 	}
 	if f == "jpeg" || f == "jpg" {
-		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
 			return fmt.Errorf("jpeg encode failed: %w", err)
 		}
 	} else {
@@ -216,10 +237,8 @@ func computePreviewSize(img image.Image) PreviewSize {
 	const charW = 8
 	const charH = 16
 	// Clamp ranges for columns/rows to keep previews reasonably small.
-	const minCols = 6
-	const minRows = 3
-	const maxCols = 80
-	const maxRows = 40
+	minCols, minRows := previewClamp.MinCols, previewClamp.MinRows
+	maxCols, maxRows := previewClamp.MaxCols, previewClamp.MaxRows
 
 	// Maximum pixel dimensions based on max cols/rows.
 	maxPixelW := maxCols * charW
@@ -266,7 +285,7 @@ func previewBytes(blob []byte, format string, size PreviewSize) error {
 
 	// Allow overriding preferred backend via PREVIEW_BACKEND (e.g. "kitty", "inline", "sixel", "chafa").
 	// If set, attempt that backend first but still fall back to the usual sequence on error.
-	if v := strings.ToLower(os.Getenv("PREVIEW_BACKEND")); v != "" {
+	if v := previewBackend; v != "" {
 		debugf("PREVIEW_BACKEND override: %s", v)
 		switch v {
 		case "kitty":
@@ -394,7 +413,7 @@ func sendKittyImage(data []byte, format string, size PreviewSize) error {
 	rows := size.Rows
 	debugf("kitty placement: cols=%d rows=%d (computed)", cols, rows)
 
-	stdout := os.Stdout
+	stdout := previewOut()
 
 	// Helper to write a raw sequence to stdout.
 	writeSeq := func(s string) error {
@@ -448,7 +467,7 @@ func sendKittyImage(data []byte, format string, size PreviewSize) error {
 	// so subsequent text appears directly under the image. Clamp to a
 	// small maximum to avoid a large gap.
 	for i := 0; i < postImageNewlines(size.Rows); i++ {
-		fmt.Println()
+		fmt.Fprintln(previewOut())
 	}
 
 	// Done
@@ -473,13 +492,13 @@ func sendInlineImage(data []byte, format string, size PreviewSize) error {
 		meta += fmt.Sprintf("width=%dpx;height=%dpx;", size.PixelWidth, size.PixelHeight)
 	}
 	seq := "\x1b]1337;File=name=" + name + ";inline=1;" + meta + ":" + enc + "\a"
-	n, err := os.Stdout.Write([]byte(seq))
+	n, err := previewOut().Write([]byte(seq))
 	debugf("wrote %d bytes to stdout for inline image (err=%v)", n, err)
 
 	// After the image is transmitted, advance the cursor a small number of lines
 	// so the prompt/info prints directly under the image instead of far below.
 	for i := 0; i < postImageNewlines(0); i++ {
-		fmt.Println()
+		fmt.Fprintln(previewOut())
 	}
 
 	return err
@@ -501,7 +520,7 @@ func sendSixelImage(data []byte, format string, size PreviewSize) error {
 	// We call it with '-' to accept stdin.
 	cmd := exec.Command("img2sixel", "-")
 	cmd.Stdin = bytes.NewReader(data)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = previewOut()
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err == nil {
@@ -509,7 +528,7 @@ func sendSixelImage(data []byte, format string, size PreviewSize) error {
 		// Advance a small number of lines after the image so subsequent text
 		// appears just below it.
 		for i := 0; i < postImageNewlines(0); i++ {
-			fmt.Println()
+			fmt.Fprintln(previewOut())
 		}
 		return nil
 	} else {
@@ -534,12 +553,12 @@ func sendSixelImage(data []byte, format string, size PreviewSize) error {
 		name = "preview.jpg"
 	}
 	seq := "\x1b]1337;File=name=" + name + ";inline=1;size=" + fmt.Sprintf("%d", len(data)) + ":" + enc + "\a"
-	n, err := os.Stdout.Write([]byte(seq))
+	n, err := previewOut().Write([]byte(seq))
 	debugf("wrote %d bytes for inline PNG fallback (err=%v)", n, err)
 
 	// Ensure the cursor moves to the next line after the image.
 	for i := 0; i < postImageNewlines(0); i++ {
-		fmt.Println()
+		fmt.Fprintln(previewOut())
 	}
 
 	return err
@@ -590,7 +609,7 @@ func sendChafaImage(data []byte, format string, size PreviewSize) error {
 
 	cmd := exec.Command("chafa", args...)
 	cmd.Stdin = bytes.NewReader(data)
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = previewOut()
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
@@ -600,7 +619,7 @@ func sendChafaImage(data []byte, format string, size PreviewSize) error {
 	// Ensure adequate spacing after the image so subsequent text isn't overwritten.
 	// Use the computed row count from PreviewSize.
 	for i := 0; i < postImageNewlines(size.Rows); i++ {
-		fmt.Println()
+		fmt.Fprintln(previewOut())
 	}
 
 	return nil
@@ -9,6 +9,7 @@ import (
 	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -17,16 +18,14 @@ import (
 	"github.com/Fepozopo/timp/pkg/stdimg"
 )
 
+// jpegQuality is the quality used for JPEG output (config key jpeg_quality).
+var jpegQuality = 92
+
 // PromptLine displays a prompt and reads a full line of input from the user.
 // The returned string is trimmed of surrounding whitespace (including the newline).
+// On a terminal the line can be edited and recalled with the arrow keys.
 func PromptLine(prompt string) (string, error) {
-	fmt.Print(prompt)
-	reader := bufio.NewReader(os.Stdin)
-	line, err := reader.ReadString('\n')
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(line), nil
+	return PromptLineKind(prompt, "line", nil)
 }
 
 // PromptLineOrFzf reads a full line from stdin and treats a single-line "/"
@@ -36,20 +35,16 @@ func PromptLine(prompt string) (string, error) {
 //   - If the trimmed line equals "/", launch fzf via SelectFileWithFzf(".").
 //   - If fzf returns a non-empty selection, return it.
 //   - If fzf is unavailable or selection is cancelled, fall back to a typed prompt
-//     (re-using PromptLine to read a full line).
+//     (reading another full line with path completion).
 //   - Otherwise return the trimmed line as the input value.
 //
 // This approach preserves support for paths containing spaces because we read
 // the entire input line instead of a single token.
 func PromptLineOrFzf(prompt string) (string, error) {
-	fmt.Print(prompt)
-	reader := bufio.NewReader(os.Stdin)
-
-	line, err := reader.ReadString('\n')
+	input, err := PromptLineKind(prompt, "path", completePath)
 	if err != nil {
 		return "", err
 	}
-	input := strings.TrimSpace(line)
 
 	if input == "/" {
 		// User requested fzf selection.
@@ -60,7 +55,7 @@ func PromptLineOrFzf(prompt string) (string, error) {
 			return sel, nil
 		}
 		// fzf not available or selection cancelled — fall back to typed prompt.
-		return PromptLine(prompt)
+		return PromptLineKind(prompt, "path", completePath)
 	}
 
 	return input, nil
@@ -106,13 +101,32 @@ type AppSegment struct {
 // LoadImage loads a file from disk into an image.Image and returns the image,
 // detected format, extracted JPEG APPn segments (in original order), a flag
 // indicating whether AutoOrient was applied, and an error.
-// Supports PNG/JPEG/GIF based on file signature.
+// Supports PNG/JPEG/GIF based on file signature. A path of "-" reads the image
+// from stdin.
 func LoadImage(path string) (image.Image, string, []AppSegment, bool, error) {
 	// Read full file to allow EXIF inspection for JPEG orientation.
-	b, err := os.ReadFile(path)
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+		if err == nil && len(b) == 0 {
+			err = fmt.Errorf("no image data on stdin")
+		}
+	} else {
+		b, err = os.ReadFile(path)
+	}
 	if err != nil {
 		return nil, "", nil, false, err
 	}
+	// A layered project file is flattened; open it interactively to edit
+	// the layers.
+	if isDocumentPath(path) {
+		doc, err := stdimg.ReadDocument(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return nil, "", nil, false, err
+		}
+		return doc.Flatten(), "png", nil, false, nil
+	}
 	// quick format detection via magic
 	format := ""
 	if len(b) >= 3 && bytes.Equal(b[:3], []byte{0xFF, 0xD8, 0xFF}) {
@@ -645,16 +659,65 @@ func insertAppSegmentsIntoJPEG(jpegBytes []byte, segments []AppSegment) ([]byte,
 // the APPn segments will be re-inserted after SOI in original order. If autoOriented is true,
 // the EXIF Orientation tag (if present in APP1) will be set to 1 before insertion.
 func SaveImage(path string, img image.Image, appSegments []AppSegment, autoOriented bool) error {
+	return SaveImageFormat(path, "", img, appSegments, autoOriented)
+}
+
+// SaveImageFormat is like SaveImage but encodes as format ("png", "jpeg" or
+// "gif") regardless of the extension. An empty format is inferred from the
+// extension as in SaveImage. A path of "-" writes to stdout, and then the
+// format defaults to PNG.
+func SaveImageFormat(path, format string, img image.Image, appSegments []AppSegment, autoOriented bool) error {
+	if format == "" {
+		format = formatFromExt(path)
+	}
+	format, err := normalizeImageFormat(format)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		return encodeImage(os.Stdout, format, img, appSegments, autoOriented)
+	}
 	f, err := os.Create(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	ext := strings.ToLower(filepath.Ext(path))
-	switch ext {
-	case ".png":
-		return png.Encode(f, img)
+	if err := encodeImage(f, format, img, appSegments, autoOriented); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// formatFromExt maps a file extension to an output format, defaulting to PNG.
+func formatFromExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
 	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".gif":
+		return "gif"
+	default:
+		return "png"
+	}
+}
+
+// normalizeImageFormat validates an output format name and returns its
+// canonical form.
+func normalizeImageFormat(format string) (string, error) {
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case "png", "gif", "jpeg":
+		return f, nil
+	case "jpg":
+		return "jpeg", nil
+	default:
+		return "", fmt.Errorf("unsupported output format %q (use png, jpeg or gif)", format)
+	}
+}
+
+// encodeImage writes img to w in the given canonical format, re-inserting
+// JPEG APPn segments as described for SaveImage.
+func encodeImage(w io.Writer, format string, img image.Image, appSegments []AppSegment, autoOriented bool) error {
+	switch format {
+	case "jpeg":
 		// If we have APPn segments, reinsert them after SOI in original order.
 		if len(appSegments) > 0 {
 			// If auto-oriented, adjust the EXIF APP1 payload(s) to set Orientation=1.
@@ -669,7 +732,7 @@ func SaveImage(path string, img image.Image, appSegments []AppSegment, autoOrien
 			}
 			// encode image to JPEG bytes
 			buf := &bytes.Buffer{}
-			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: 92}); err != nil {
+			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
 				return err
 			}
 			jpegBytes := buf.Bytes()
@@ -683,18 +746,27 @@ func SaveImage(path string, img image.Image, appSegments []AppSegment, autoOrien
 				return ierr
 			}
 			// write final bytes
-			if _, err := f.Write(final); err != nil {
+			if _, err := w.Write(final); err != nil {
 				return err
 			}
 			return nil
 		}
 		// no app segments: fallback to normal encode
-		return jpeg.Encode(f, img, &jpeg.Options{Quality: 92})
-	case ".gif":
-		return gif.Encode(f, img, nil)
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: jpegQuality})
+	case "gif":
+		// keep the color table of an image that already has one; reduce
+		// anything else with the quantize command's palette builder rather
+		// than to the fixed palette gif.Encode would use
+		if p, ok := img.(*image.Paletted); ok && len(p.Palette) <= stdimg.MaxPaletteColors {
+			return gif.Encode(w, p, nil)
+		}
+		p, err := stdimg.PalettedImage(stdimg.ToNRGBA(img), stdimg.MaxPaletteColors, stdimg.QuantizeMedianCut, stdimg.DitherFloydSteinberg)
+		if err != nil {
+			return err
+		}
+		return gif.Encode(w, p, nil)
 	default:
-		// default to PNG
-		return png.Encode(f, img)
+		return png.Encode(w, img)
 	}
 }
 
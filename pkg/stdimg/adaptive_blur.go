@@ -1,9 +1,9 @@
 package stdimg
 
 import (
+	"context"
 	"image"
 	"math"
-	"runtime"
 )
 
 // AdaptiveBlur approximates an adaptive blur by blending a blurred image with the original
@@ -19,12 +19,23 @@ func AdaptiveBlur(src *image.NRGBA, radius, sigma float64) *image.NRGBA {
 // sigmaMin/sigmaMax define the range of Gaussian sigmas to apply (sigmaMin for high-variance regions, sigmaMax for low-variance areas).
 // levels is the number of discrete sigma levels to precompute and is used to approximate per-pixel variable sigma efficiently.
 func AdaptiveBlurPerPixel(src *image.NRGBA, radius, sigmaMin, sigmaMax float64, levels int) *image.NRGBA {
+	out, _ := AdaptiveBlurPerPixelContext(context.Background(), src, radius, sigmaMin, sigmaMax, levels, nil)
+	return out
+}
+
+// AdaptiveBlurPerPixelContext is AdaptiveBlurPerPixel with cancellation and
+// progress reporting. Most of the time goes into the blurred levels, which
+// are cancelled individually.
+func AdaptiveBlurPerPixelContext(ctx context.Context, src *image.NRGBA, radius, sigmaMin, sigmaMax float64, levels int, progress ProgressFunc) (*image.NRGBA, error) {
 	if src == nil {
-		return nil
+		return nil, nil
+	}
+	if progress == nil {
+		progress = noProgress
 	}
 	if levels <= 1 || sigmaMin == sigmaMax {
 		// fallback to uniform blur with sigmaMax
-		return SeparableGaussianBlur(src, sigmaMax)
+		return SeparableGaussianBlurContext(ctx, src, sigmaMax, progress)
 	}
 	b := src.Bounds()
 	w, h := b.Dx(), b.Dy()
@@ -130,89 +141,68 @@ func AdaptiveBlurPerPixel(src *image.NRGBA, radius, sigmaMin, sigmaMax float64,
 	// precompute blurred images for each sigma level
 	blurredLevels := make([]*image.NRGBA, levels)
 	for i := 0; i < levels; i++ {
-		blurredLevels[i] = SeparableGaussianBlur(src, levelSig[i])
+		level, err := SeparableGaussianBlurContext(ctx, src, levelSig[i], subProgress(progress, 0.9*float64(i)/float64(levels), 0.9/float64(levels)))
+		if err != nil {
+			return nil, err
+		}
+		blurredLevels[i] = level
 	}
 	// build output by interpolating between adjacent levels per pixel based on normalized variance (parallelized)
 	out := image.NewNRGBA(b)
-	// choose worker count based on available CPUs
-	workers := runtime.NumCPU()
-	if workers < 1 {
-		workers = 1
-	}
-	if workers > h {
-		workers = h
-	}
-	rowsPerWorker := (h + workers - 1) / workers
-	done := make(chan struct{}, workers)
-	for worker := 0; worker < workers; worker++ {
-		y0 := worker * rowsPerWorker
-		y1 := y0 + rowsPerWorker
-		if y1 > h {
-			y1 = h
-		}
-		if y0 >= y1 {
-			// nothing for this worker
-			done <- struct{}{}
-			continue
-		}
-		go func(y0, y1 int) {
-			for y := y0; y < y1; y++ {
-				for x := 0; x < w; x++ {
-					v := vars[y*w+x]
-					varNorm := 0.0
-					if maxVar > minVar {
-						varNorm = (v - minVar) / (maxVar - minVar)
-						if varNorm < 0 {
-							varNorm = 0
-						}
-						if varNorm > 1 {
-							varNorm = 1
-						}
-					}
-					// fractional index: higher variance -> closer to sigmaMin (less blur), so invert varNorm
-					idxF := (1.0 - varNorm) * float64(levels-1)
-					idx0 := int(math.Floor(idxF))
-					if idx0 < 0 {
-						idx0 = 0
+	err := parallelRowsContext(ctx, h, subProgress(progress, 0.9, 0.1), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				v := vars[y*w+x]
+				varNorm := 0.0
+				if maxVar > minVar {
+					varNorm = (v - minVar) / (maxVar - minVar)
+					if varNorm < 0 {
+						varNorm = 0
 					}
-					if idx0 >= levels-1 {
-						// use last level directly
-						pixOff := out.PixOffset(x, y)
-						bPixOff := blurredLevels[levels-1].PixOffset(x, y)
-						out.Pix[pixOff+0] = blurredLevels[levels-1].Pix[bPixOff+0]
-						out.Pix[pixOff+1] = blurredLevels[levels-1].Pix[bPixOff+1]
-						out.Pix[pixOff+2] = blurredLevels[levels-1].Pix[bPixOff+2]
-						out.Pix[pixOff+3] = src.Pix[src.PixOffset(x, y)+3]
-						continue
+					if varNorm > 1 {
+						varNorm = 1
 					}
-					idx1 := idx0 + 1
-					t := idxF - float64(idx0)
-					// interpolate between blurredLevels[idx0] and blurredLevels[idx1]
+				}
+				// fractional index: higher variance -> closer to sigmaMin (less blur), so invert varNorm
+				idxF := (1.0 - varNorm) * float64(levels-1)
+				idx0 := int(math.Floor(idxF))
+				if idx0 < 0 {
+					idx0 = 0
+				}
+				if idx0 >= levels-1 {
+					// use last level directly
 					pixOff := out.PixOffset(x, y)
-					b0 := blurredLevels[idx0].PixOffset(x, y)
-					b1 := blurredLevels[idx1].PixOffset(x, y)
-					r0 := float64(blurredLevels[idx0].Pix[b0+0])
-					g0 := float64(blurredLevels[idx0].Pix[b0+1])
-					b_0 := float64(blurredLevels[idx0].Pix[b0+2])
-					r1 := float64(blurredLevels[idx1].Pix[b1+0])
-					g1 := float64(blurredLevels[idx1].Pix[b1+1])
-					b_1 := float64(blurredLevels[idx1].Pix[b1+2])
-					rVal := r0*(1.0-t) + r1*t
-					gVal := g0*(1.0-t) + g1*t
-					bVal := b_0*(1.0-t) + b_1*t
-					out.Pix[pixOff+0] = uint8(clampFloatToUint8(rVal))
-					out.Pix[pixOff+1] = uint8(clampFloatToUint8(gVal))
-					out.Pix[pixOff+2] = uint8(clampFloatToUint8(bVal))
+					bPixOff := blurredLevels[levels-1].PixOffset(x, y)
+					out.Pix[pixOff+0] = blurredLevels[levels-1].Pix[bPixOff+0]
+					out.Pix[pixOff+1] = blurredLevels[levels-1].Pix[bPixOff+1]
+					out.Pix[pixOff+2] = blurredLevels[levels-1].Pix[bPixOff+2]
 					out.Pix[pixOff+3] = src.Pix[src.PixOffset(x, y)+3]
+					continue
 				}
+				idx1 := idx0 + 1
+				t := idxF - float64(idx0)
+				// interpolate between blurredLevels[idx0] and blurredLevels[idx1]
+				pixOff := out.PixOffset(x, y)
+				b0 := blurredLevels[idx0].PixOffset(x, y)
+				b1 := blurredLevels[idx1].PixOffset(x, y)
+				r0 := float64(blurredLevels[idx0].Pix[b0+0])
+				g0 := float64(blurredLevels[idx0].Pix[b0+1])
+				b_0 := float64(blurredLevels[idx0].Pix[b0+2])
+				r1 := float64(blurredLevels[idx1].Pix[b1+0])
+				g1 := float64(blurredLevels[idx1].Pix[b1+1])
+				b_1 := float64(blurredLevels[idx1].Pix[b1+2])
+				rVal := r0*(1.0-t) + r1*t
+				gVal := g0*(1.0-t) + g1*t
+				bVal := b_0*(1.0-t) + b_1*t
+				out.Pix[pixOff+0] = uint8(clampFloatToUint8(rVal))
+				out.Pix[pixOff+1] = uint8(clampFloatToUint8(gVal))
+				out.Pix[pixOff+2] = uint8(clampFloatToUint8(bVal))
+				out.Pix[pixOff+3] = src.Pix[src.PixOffset(x, y)+3]
 			}
-			done <- struct{}{}
-		}(y0, y1)
-	}
-	// wait for workers
-	for i := 0; i < workers; i++ {
-		<-done
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	close(done)
-	return out
+	return out, nil
 }
@@ -1,6 +1,7 @@
 package stdimg
 
 import (
+	"context"
 	"image"
 )
 
@@ -12,23 +13,46 @@ func AdaptiveResize(src *image.NRGBA, width, height int, a float64) *image.NRGBA
 	if src == nil {
 		return nil
 	}
-	b := src.Bounds()
+	w, h, ok := adaptiveSize(src.Bounds(), width, height)
+	if !ok {
+		return CloneNRGBA(src)
+	}
+	return ResampleLanczos(src, w, h, a)
+}
+
+// AdaptiveResize64 is AdaptiveResize for 16-bit images.
+func AdaptiveResize64(src *image.NRGBA64, width, height int, a float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	w, h, ok := adaptiveSize(src.Bounds(), width, height)
+	if !ok {
+		return CloneNRGBA64(src)
+	}
+	out, _ := ResampleLanczos64Context(context.Background(), src, w, h, a, nil)
+	return out
+}
+
+// adaptiveSize resolves the target size of AdaptiveResize, filling in a
+// zero width or height from the aspect ratio of b. ok is false when there
+// is nothing to resize.
+func adaptiveSize(b image.Rectangle, width, height int) (w, h int, ok bool) {
 	sw := b.Dx()
-	h := b.Dy()
+	sh := b.Dy()
 	if width == 0 && height == 0 {
-		return CloneNRGBA(src)
+		return 0, 0, false
 	}
-	w := width
-	hh := height
+	w = width
+	h = height
 	if w == 0 {
 		// preserve aspect
-		w = int((float64(sw) * float64(hh)) / float64(h))
+		w = int((float64(sw) * float64(h)) / float64(sh))
 	}
-	if hh == 0 {
-		hh = int((float64(h) * float64(w)) / float64(sw))
+	if h == 0 {
+		h = int((float64(sh) * float64(w)) / float64(sw))
 	}
-	if w <= 0 || hh <= 0 {
-		return CloneNRGBA(src)
+	if w <= 0 || h <= 0 {
+		return 0, 0, false
 	}
-	return ResampleLanczos(src, w, hh, a)
+	return w, h, true
 }
@@ -2,11 +2,55 @@ package stdimg
 
 import (
 	"image"
+	"strings"
 )
 
+// Local thresholding methods of AdaptiveThresholdEx. Each compares a
+// pixel's luminance with a threshold from the mean m and the standard
+// deviation s of the luminance in the window around it.
+const (
+	// ThresholdMean uses m.
+	ThresholdMean = "mean"
+	// ThresholdSauvola uses m * (1 + k*(s/R - 1)), which adapts to faint
+	// and uneven backgrounds in scanned text.
+	ThresholdSauvola = "sauvola"
+	// ThresholdNiblack uses m + k*s.
+	ThresholdNiblack = "niblack"
+)
+
+// ThresholdMethods lists the methods accepted by AdaptiveThresholdEx.
+var ThresholdMethods = []string{ThresholdMean, ThresholdSauvola, ThresholdNiblack}
+
+// DefaultThresholdK returns the customary k for a thresholding method.
+func DefaultThresholdK(method string) float64 {
+	switch strings.ToLower(method) {
+	case ThresholdSauvola:
+		return 0.5
+	case ThresholdNiblack:
+		return -0.2
+	}
+	return 0
+}
+
+// maxStatsWindow bounds the window sides of the methods that use the
+// standard deviation, so that sums of squares stay exact (see
+// IntegralImage).
+const maxStatsWindow = 1023
+
 // AdaptiveThreshold applies a local mean threshold over a window of windowW x windowH.
 // Pixels above mean - offset become white, otherwise black. Returns a bilevel NRGBA image.
 func AdaptiveThreshold(src *image.NRGBA, windowW, windowH int, offset float64) *image.NRGBA {
+	return AdaptiveThresholdEx(src, windowW, windowH, offset, ThresholdMean, 0, 0)
+}
+
+// AdaptiveThresholdEx thresholds src against a local threshold computed
+// by method over a window of windowW x windowH, clipped to the image.
+// Pixels above the threshold minus offset become white, the others black;
+// alpha is kept. k and r are the method's parameters, with r the dynamic
+// range of the standard deviation for Sauvola (128 if r <= 0). Luminance
+// is on the 0..255 scale. Local statistics come from an IntegralImage, so
+// the cost does not depend on the window size.
+func AdaptiveThresholdEx(src *image.NRGBA, windowW, windowH int, offset float64, method string, k, r float64) *image.NRGBA {
 	if src == nil {
 		return nil
 	}
@@ -19,59 +63,64 @@ func AdaptiveThreshold(src *image.NRGBA, windowW, windowH int, offset float64) *
 	if windowH <= 0 {
 		windowH = 15
 	}
-	// compute luminance image as float64
-	lum := make([]float64, w*h)
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := src.PixOffset(x+b.Min.X, y+b.Min.Y)
-			r := float64(src.Pix[i+0])
-			g := float64(src.Pix[i+1])
-			b_ := float64(src.Pix[i+2])
-			lum[y*w+x] = 0.2126*r + 0.7152*g + 0.0722*b_
-		}
+	method = strings.ToLower(method)
+	if method != ThresholdMean {
+		windowW = min(windowW, maxStatsWindow)
+		windowH = min(windowH, maxStatsWindow)
 	}
-	// integral image for fast local means
-	integ := make([]float64, (w+1)*(h+1))
-	for y := 1; y <= h; y++ {
-		sum := 0.0
-		for x := 1; x <= w; x++ {
-			sum += lum[(y-1)*w+(x-1)]
-			integ[y*(w+1)+x] = integ[(y-1)*(w+1)+x] + sum
-		}
+	if r <= 0 {
+		r = 128
 	}
+	// Luminance is kept in fixed point, scaled by lumScale, so that the
+	// window sums are exact and do not depend on where the image starts;
+	// tiled processing relies on that.
+	lum := luminanceValues(src)
+	integ := NewIntegralImage(lum, w, h)
 
+	grid := image.Rect(0, 0, w, h)
 	out := image.NewNRGBA(src.Rect)
 	halfW := windowW / 2
 	halfH := windowH / 2
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			x0 := clampInt(x-halfW, 0, w-1)
-			x1 := clampInt(x+halfW, 0, w-1)
-			y0 := clampInt(y-halfH, 0, h-1)
-			y1 := clampInt(y+halfH, 0, h-1)
-			// integral coordinates are +1
-			sx := x0 + 1
-			ex := x1 + 1
-			sy := y0 + 1
-			ey := y1 + 1
-			area := float64((x1 - x0 + 1) * (y1 - y0 + 1))
-			s := integ[ey*(w+1)+ex] - integ[(sy-1)*(w+1)+ex] - integ[ey*(w+1)+(sx-1)] + integ[(sy-1)*(w+1)+(sx-1)]
-			mean := s / area
-			th := mean - offset
-			idx := src.PixOffset(x+b.Min.X, y+b.Min.Y)
-			val := 0.2126*float64(src.Pix[idx+0]) + 0.7152*float64(src.Pix[idx+1]) + 0.0722*float64(src.Pix[idx+2])
-			if val > th {
-				out.Pix[idx+0] = 255
-				out.Pix[idx+1] = 255
-				out.Pix[idx+2] = 255
-				out.Pix[idx+3] = src.Pix[idx+3]
-			} else {
-				out.Pix[idx+0] = 0
-				out.Pix[idx+1] = 0
-				out.Pix[idx+2] = 0
+	parallelRows(h, func(rowStart, rowEnd int) {
+		for y := rowStart; y < rowEnd; y++ {
+			for x := 0; x < w; x++ {
+				win := image.Rect(x-halfW, y-halfH, x+halfW+1, y+halfH+1).Intersect(grid)
+				var white bool
+				switch method {
+				case ThresholdSauvola, ThresholdNiblack:
+					m, s := integ.Stats(win)
+					m, s = m/lumScale, s/lumScale
+					th := m + k*s
+					if method == ThresholdSauvola {
+						th = m * (1 + k*(s/r-1))
+					}
+					white = float64(lum[y*w+x])/lumScale > th-offset
+				default:
+					// val > mean - offset, multiplied through by area*lumScale
+					s, _ := integ.Sum(win)
+					area := int64(win.Dx() * win.Dy())
+					white = float64(int64(lum[y*w+x])*area-int64(s)) > -offset*lumScale*float64(area)
+				}
+				idx := src.PixOffset(x+b.Min.X, y+b.Min.Y)
+				v := uint8(0)
+				if white {
+					v = 255
+				}
+				out.Pix[idx+0] = v
+				out.Pix[idx+1] = v
+				out.Pix[idx+2] = v
 				out.Pix[idx+3] = src.Pix[idx+3]
 			}
 		}
-	}
+	})
 	return out
 }
+
+// lumScale is the fixed-point scale of fixedLuminance.
+const lumScale = 10000
+
+// fixedLuminance returns the Rec. 709 luminance of an RGB pixel times
+// lumScale, which is exact in integers.
+func fixedLuminance(p []uint8) uint32 {
+	return 2126*uint32(p[0]) + 7152*uint32(p[1]) + 722*uint32(p[2])
+}
@@ -47,13 +47,15 @@ func FlipNRGBA(src *image.NRGBA) *image.NRGBA {
 	out := image.NewNRGBA(b)
 	w := b.Dx()
 	h := b.Dy()
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			srcIdx := src.PixOffset(x, y)
-			dstIdx := out.PixOffset(x, h-1-y)
-			copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				srcIdx := src.PixOffset(x, y)
+				dstIdx := out.PixOffset(x, h-1-y)
+				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+			}
 		}
-	}
+	})
 	return out
 }
 
@@ -65,13 +67,15 @@ func FlopNRGBA(src *image.NRGBA) *image.NRGBA {
 	out := image.NewNRGBA(b)
 	w := b.Dx()
 	h := b.Dy()
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			srcIdx := src.PixOffset(x, y)
-			dstIdx := out.PixOffset(w-1-x, y)
-			copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				srcIdx := src.PixOffset(x, y)
+				dstIdx := out.PixOffset(w-1-x, y)
+				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+			}
 		}
-	}
+	})
 	return out
 }
 
@@ -83,13 +87,15 @@ func Rotate180NRGBA(src *image.NRGBA) *image.NRGBA {
 	out := image.NewNRGBA(b)
 	w := b.Dx()
 	h := b.Dy()
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			srcIdx := src.PixOffset(x, y)
-			dstIdx := out.PixOffset(w-1-x, h-1-y)
-			copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				srcIdx := src.PixOffset(x, y)
+				dstIdx := out.PixOffset(w-1-x, h-1-y)
+				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+			}
 		}
-	}
+	})
 	return out
 }
 
@@ -101,13 +107,15 @@ func Rotate90CWNRGBA(src *image.NRGBA) *image.NRGBA {
 	w := b.Dx()
 	h := b.Dy()
 	out := image.NewNRGBA(image.Rect(0, 0, h, w))
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			srcIdx := src.PixOffset(x, y)
-			dstIdx := out.PixOffset(h-1-y, x)
-			copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				srcIdx := src.PixOffset(x, y)
+				dstIdx := out.PixOffset(h-1-y, x)
+				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+			}
 		}
-	}
+	})
 	return out
 }
 
@@ -119,12 +127,14 @@ func Rotate90CCWNRGBA(src *image.NRGBA) *image.NRGBA {
 	w := b.Dx()
 	h := b.Dy()
 	out := image.NewNRGBA(image.Rect(0, 0, h, w))
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			srcIdx := src.PixOffset(x, y)
-			dstIdx := out.PixOffset(y, w-1-x)
-			copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				srcIdx := src.PixOffset(x, y)
+				dstIdx := out.PixOffset(y, w-1-x)
+				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
+			}
 		}
-	}
+	})
 	return out
 }
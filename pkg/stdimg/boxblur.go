@@ -0,0 +1,228 @@
+package stdimg
+
+import (
+	"context"
+	"image"
+	"math"
+)
+
+// Large-sigma Gaussian blur. The direct convolution costs O(sigma) per pixel,
+// so from boxBlurMinSigma on SeparableGaussianBlur instead runs three box
+// blurs in a row, whose widths are chosen so that their combined variance
+// matches sigma². Each box blur keeps a running sum along the line, which
+// costs the same for any radius. Repeated box blurs converge on a Gaussian;
+// after three the difference is a few levels at most (see the tests).
+
+// boxBlurMinSigma is the sigma from which blurs use box passes.
+const boxBlurMinSigma = 8.0
+
+// boxPasses is the number of box blurs that approximate one Gaussian.
+const boxPasses = 3
+
+// boxRadii returns the radii of the box blurs approximating a Gaussian of
+// sigma: boxes of two neighbouring odd widths, as many of the narrower as
+// brings the total variance closest to sigma².
+func boxRadii(sigma float64) [boxPasses]int {
+	n := float64(boxPasses)
+	ideal := math.Sqrt(12*sigma*sigma/n + 1)
+	wl := int(math.Floor(ideal))
+	if wl%2 == 0 {
+		wl--
+	}
+	fl := float64(wl)
+	m := int(math.Round((12*sigma*sigma - n*fl*fl - 4*n*fl - 3*n) / (-4*fl - 4)))
+	var radii [boxPasses]int
+	for i := range radii {
+		w := wl + 2
+		if i < m {
+			w = wl
+		}
+		radii[i] = (w - 1) / 2
+	}
+	return radii
+}
+
+// boxReach returns how far the box passes for sigma reach in total.
+func boxReach(radii [boxPasses]int) int {
+	reach := 0
+	for _, r := range radii {
+		reach += r
+	}
+	return reach
+}
+
+// boxPass box-blurs the interleaved RGBA pixels lo..hi-1 of src with a
+// window of 2r+1 pixels, writing pixels lo+r..hi-r-1 of dst: those whose
+// window lies within src.
+func boxPass(src, dst []uint32, lo, hi, r int) {
+	size := uint32(2*r + 1)
+	half := size / 2
+	// divide by multiplying with 2^32/size, which is exact to well under
+	// one unit of the line values
+	inv := uint64(1<<32) / uint64(size)
+	var s0, s1, s2, s3 uint32
+	for i := lo * 4; i < (lo+2*r+1)*4; i += 4 {
+		s0 += src[i]
+		s1 += src[i+1]
+		s2 += src[i+2]
+		s3 += src[i+3]
+	}
+	src = src[:hi*4]
+	dst = dst[:hi*4]
+	for x := lo + r; x < hi-r; x++ {
+		d := dst[x*4 : x*4+4 : x*4+4]
+		d[0] = uint32(uint64(s0+half) * inv >> 32)
+		d[1] = uint32(uint64(s1+half) * inv >> 32)
+		d[2] = uint32(uint64(s2+half) * inv >> 32)
+		d[3] = uint32(uint64(s3+half) * inv >> 32)
+		if x+r+1 < hi {
+			in := src[(x+r+1)*4 : (x+r+1)*4+4 : (x+r+1)*4+4]
+			out := src[(x-r)*4 : (x-r)*4+4 : (x-r)*4+4]
+			s0 += in[0] - out[0]
+			s1 += in[1] - out[1]
+			s2 += in[2] - out[2]
+			s3 += in[3] - out[3]
+		}
+	}
+}
+
+// boxBlurLine runs the box passes of radii over a line of n pixels held in
+// line after reach padding pixels, which repeat the end pixels as the
+// direct convolution does beyond the image. It returns the n blurred
+// pixels, stored in line or tmp.
+func boxBlurLine(line, tmp []uint32, n int, radii [boxPasses]int) []uint32 {
+	reach := boxReach(radii)
+	for k := 0; k < reach; k++ {
+		copy(line[k*4:k*4+4], line[reach*4:reach*4+4])
+		copy(line[(reach+n+k)*4:(reach+n+k)*4+4], line[(reach+n-1)*4:(reach+n)*4])
+	}
+	lo, hi := 0, n+2*reach
+	for _, r := range radii {
+		boxPass(line, tmp, lo, hi, r)
+		lo, hi = lo+r, hi-r
+		line, tmp = tmp, line
+	}
+	return line[reach*4 : (reach+n)*4]
+}
+
+// boxBlurImage blurs a w x h image with the box passes for sigma, first
+// along rows and then along columns. loadRow and storeRow move row y
+// between the source and the intermediate image, loadCol and storeCol
+// column x between the intermediate and the result, as interleaved RGBA
+// values.
+func boxBlurImage(ctx context.Context, w, h int, sigma float64, progress ProgressFunc, loadRow, storeRow, loadCol, storeCol func(i int, line []uint32)) error {
+	if progress == nil {
+		progress = noProgress
+	}
+	radii := boxRadii(sigma)
+	split := 0.5
+	if w+h > 0 {
+		split = float64(h) / float64(w+h)
+	}
+	reach := boxReach(radii)
+	pass := func(length int, load, store func(int, []uint32)) func(i0, i1 int) {
+		return func(i0, i1 int) {
+			line := make([]uint32, (length+2*reach)*4)
+			tmp := make([]uint32, len(line))
+			for i := i0; i < i1; i++ {
+				load(i, line[reach*4:(reach+length)*4])
+				store(i, boxBlurLine(line, tmp, length, radii))
+			}
+		}
+	}
+	if err := parallelRowsContext(ctx, h, subProgress(progress, 0, split), pass(w, loadRow, storeRow)); err != nil {
+		return err
+	}
+	return parallelRowsContext(ctx, w, subProgress(progress, split, 1-split), pass(h, loadCol, storeCol))
+}
+
+// boxBlurContext is the large-sigma path of SeparableGaussianBlurContext.
+// Lines are held with 8 fractional bits so that only the two stores round
+// to 8 bits, as in the direct convolution.
+func boxBlurContext(ctx context.Context, src *image.NRGBA, sigma float64, progress ProgressFunc) (*image.NRGBA, error) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := image.NewNRGBA(image.Rect(0, 0, w, h))
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	err := boxBlurImage(ctx, w, h, sigma, progress,
+		func(y int, line []uint32) {
+			p := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for i := range line {
+				line[i] = uint32(p[i]) << 8
+			}
+		},
+		func(y int, line []uint32) {
+			p := tmp.Pix[y*tmp.Stride:]
+			for i, v := range line {
+				p[i] = uint8((v + 128) >> 8)
+			}
+		},
+		func(x int, line []uint32) {
+			for k := 0; k < h; k++ {
+				p := tmp.Pix[k*tmp.Stride+x*4:]
+				for c := 0; c < 4; c++ {
+					line[k*4+c] = uint32(p[c]) << 8
+				}
+			}
+		},
+		func(x int, line []uint32) {
+			for k := 0; k < h; k++ {
+				p := dst.Pix[k*dst.Stride+x*4:]
+				for c := 0; c < 4; c++ {
+					p[c] = uint8((line[k*4+c] + 128) >> 8)
+				}
+			}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// boxBlur64Context is the large-sigma path of SeparableGaussianBlur64Context.
+func boxBlur64Context(ctx context.Context, src *image.NRGBA64, sigma float64, progress ProgressFunc) (*image.NRGBA64, error) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	dst := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	load := func(p []uint8, line []uint32, k int) {
+		for c := 0; c < 4; c++ {
+			line[k*4+c] = uint32(p[c*2])<<8 | uint32(p[c*2+1])
+		}
+	}
+	store := func(p []uint8, line []uint32, k int) {
+		for c := 0; c < 4; c++ {
+			v := line[k*4+c]
+			p[c*2], p[c*2+1] = uint8(v>>8), uint8(v)
+		}
+	}
+	err := boxBlurImage(ctx, w, h, sigma, progress,
+		func(y int, line []uint32) {
+			p := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for k := 0; k < w; k++ {
+				load(p[k*8:], line, k)
+			}
+		},
+		func(y int, line []uint32) {
+			p := tmp.Pix[y*tmp.Stride:]
+			for k := 0; k < w; k++ {
+				store(p[k*8:], line, k)
+			}
+		},
+		func(x int, line []uint32) {
+			for k := 0; k < h; k++ {
+				load(tmp.Pix[k*tmp.Stride+x*8:], line, k)
+			}
+		},
+		func(x int, line []uint32) {
+			for k := 0; k < h; k++ {
+				store(dst.Pix[k*dst.Stride+x*8:], line, k)
+			}
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,84 @@
+package stdimg
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// checkerNRGBA has hard 255-level edges in every channel, the worst case
+// for a Gaussian approximation.
+func checkerNRGBA(w, h, cell int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(0)
+			if (x/cell+y/cell)%2 == 0 {
+				v = 255
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, uint8(x), uint8(x * y), 255 - v/2})
+		}
+	}
+	return img
+}
+
+func TestBoxBlurApproximatesGaussian(t *testing.T) {
+	src := checkerNRGBA(300, 200, 40)
+	for _, sigma := range []float64{boxBlurMinSigma, 12.5, 30, 50} {
+		exact, err := convolveGaussianContext(context.Background(), src, sigma, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := SeparableGaussianBlur(src, sigma)
+		worst, total := 0, 0
+		for i := range got.Pix {
+			d := int(got.Pix[i]) - int(exact.Pix[i])
+			d = max(d, -d)
+			worst = max(worst, d)
+			total += d
+		}
+		if mean := float64(total) / float64(len(got.Pix)); worst > 8 || mean > 2 {
+			t.Errorf("sigma %v: deviates from the exact Gaussian by up to %d levels, %.2f on average", sigma, worst, mean)
+		}
+	}
+}
+
+func TestBoxRadiiMatchVariance(t *testing.T) {
+	for _, sigma := range []float64{8, 9.3, 17, 30, 120} {
+		variance := 0.0
+		for _, r := range boxRadii(sigma) {
+			w := float64(2*r + 1)
+			variance += (w*w - 1) / 12
+		}
+		// neighbouring widths differ by 2, so the variance is within one
+		// step of sigma²
+		if d := variance - sigma*sigma; d > 2*sigma || d < -2*sigma {
+			t.Errorf("sigma %v: boxes %v have variance %.1f, want %.1f", sigma, boxRadii(sigma), variance, sigma*sigma)
+		}
+	}
+}
+
+func TestBoxBlur64MatchesEightBit(t *testing.T) {
+	src := checkerNRGBA(90, 70, 16)
+	want := SeparableGaussianBlur(src, 10)
+	got, err := SeparableGaussianBlur64Context(context.Background(), ToNRGBA64(src), 10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	low := ToNRGBA(got)
+	for i := range want.Pix {
+		if d := int(low.Pix[i]) - int(want.Pix[i]); d > 1 || d < -1 {
+			t.Fatalf("16-bit box blur differs at byte %d: %d vs %d", i, low.Pix[i], want.Pix[i])
+		}
+	}
+}
+
+// BenchmarkBlurLargeSigma blurs a 24 megapixel image with sigma 30.
+func BenchmarkBlurLargeSigma(b *testing.B) {
+	src := gradientNRGBA(6000, 4000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SeparableGaussianBlur(src, 30)
+	}
+}
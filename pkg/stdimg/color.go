@@ -89,35 +89,7 @@ func Modulate(src *image.NRGBA, brightnessPct, saturationPct, hueDegrees float64
 	if src == nil {
 		return nil
 	}
-	b := src.Bounds()
-	w := b.Dx()
-	h := b.Dy()
-	out := image.NewNRGBA(b)
-	bFactor := brightnessPct / 100.0
-	sFactor := saturationPct / 100.0
-	hueShift := hueDegrees / 360.0 // convert to 0..1
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := src.PixOffset(x, y)
-			r := float64(src.Pix[i+0]) / 255.0
-			g := float64(src.Pix[i+1]) / 255.0
-			b_ := float64(src.Pix[i+2]) / 255.0
-			a := src.Pix[i+3]
-
-			h, s, l := rgbToHsl(r, g, b_)
-			// apply hue shift
-			h = math.Mod(h+hueShift, 1.0)
-			// adjust saturation and lightness
-			s = clamp01(s * sFactor)
-			l = clamp01(l * bFactor)
-			r2, g2, b2 := hslToRgb(h, s, l)
-			out.Pix[i+0] = uint8(clampFloatToUint8(r2 * 255.0))
-			out.Pix[i+1] = uint8(clampFloatToUint8(g2 * 255.0))
-			out.Pix[i+2] = uint8(clampFloatToUint8(b2 * 255.0))
-			out.Pix[i+3] = a
-		}
-	}
-	return out
+	return modulateOp(brightnessPct, saturationPct, hueDegrees).apply(src)
 }
 
 // parseColorString accepts multiple forms: named colors (basic set), #rrggbb, #rrggbbaa, #rgb, #rgba
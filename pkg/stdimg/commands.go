@@ -1,179 +1,176 @@
-// Package stdimg: authoritative registry of stdlib engine commands.
+// Package stdimg: the built-in commands of the stdlib engine.
 //
-// This file mirrors the commands implemented in ApplyCommandStdlib in
-// pkg/stdimg/engine.go. Keep this list up-to-date when you add or
-// modify commands so callers (CLI, docs, help text) can read a single
-// source of truth.
+// Each command is registered here together with its handler (see
+// engine.go), so the spec that callers (CLI, docs, help text) read and the
+// code that runs can not drift apart.
 
 package stdimg
 
 // ArgSpec describes a single argument for a command. Fields are textual
 // and intended for help/validation UI rather than machine-enforced typing.
 type ArgSpec struct {
-	Name        string // human name
-	Type        string // "int", "float", "bool", "string", "path", etc.
-	Required    bool
-	Default     string // textual default (for help only)
-	Description string
+	Name        string `json:"name"` // human name
+	Type        string `json:"type"` // "int", "float", "bool", "string", "path", etc.
+	Required    bool   `json:"required,omitempty"`
+	Default     string `json:"default,omitempty"` // textual default (for help only)
+	Description string `json:"description,omitempty"`
 }
 
 // CommandSpec defines a single command and its expected arguments.
 type CommandSpec struct {
-	Name        string
-	Args        []ArgSpec
-	Usage       string // short usage string
-	Description string // brief description
+	Name        string    `json:"name"`
+	Args        []ArgSpec `json:"args,omitempty"`
+	Usage       string    `json:"usage,omitempty"`       // short usage string
+	Description string    `json:"description,omitempty"` // brief description
 }
 
-// Commands is the authoritative list of commands implemented by the stdlib engine.
-// Keep this synchronized with ApplyCommandStdlib in pkg/stdimg/engine.go.
-var Commands = []CommandSpec{
-	{
+// The built-in commands, in the order they are listed to users.
+func init() {
+	RegisterContext(CommandSpec{
 		Name:        "resize",
-		Args:        []ArgSpec{{"width", "int", true, "", "output width"}, {"height", "int", true, "", "output height"}},
-		Usage:       "resize <width> <height>",
+		Args:        []ArgSpec{{"width", "int", true, "", "output width"}, {"height", "int", true, "", "output height"}, {"linear", "bool", false, "", "work in linear light (default: the linear_light setting)"}},
+		Usage:       "resize <width> <height> [linear]",
 		Description: "Resize image using Lanczos resampling (a=3).",
-	},
-	{
+	}, applyResize)
+	Register(CommandSpec{
 		Name:        "rotate",
-		Args:        []ArgSpec{{"degrees", "float", true, "", "rotation degrees"}},
-		Usage:       "rotate <degrees>",
+		Args:        []ArgSpec{{"degrees", "float", true, "", "rotation degrees"}, {"linear", "bool", false, "", "work in linear light (default: the linear_light setting)"}},
+		Usage:       "rotate <degrees> [linear]",
 		Description: "Rotate image using inverse mapping with bilinear sampling.",
-	},
-	{
+	}, applyRotate)
+	RegisterContext(CommandSpec{
 		Name:        "blur",
-		Args:        []ArgSpec{{"sigma", "float", true, "", "gaussian sigma"}},
-		Usage:       "blur <sigma>",
+		Args:        []ArgSpec{{"sigma", "float", true, "", "gaussian sigma"}, {"linear", "bool", false, "", "work in linear light (default: the linear_light setting)"}},
+		Usage:       "blur <sigma> [linear]",
 		Description: "Separable Gaussian blur.",
-	},
-	{
+	}, applyBlur)
+	RegisterContext(CommandSpec{
 		Name:        "medianFilter",
 		Args:        []ArgSpec{{"radius", "int", true, "", "median radius"}},
 		Usage:       "medianFilter <radius>",
 		Description: "Median filter (sliding-window histogram).",
-	},
-	{
+	}, applyMedianFilter)
+	RegisterContext(CommandSpec{
 		Name:        "despeckle",
 		Args:        []ArgSpec{{"radius", "int", false, "1", "optional radius"}},
 		Usage:       "despeckle [radius]",
 		Description: "Despeckle (wrapper around median filter).",
-	},
-	{
+	}, applyDespeckle)
+	Register(CommandSpec{
 		Name:        "level",
 		Args:        []ArgSpec{{"blackPoint", "float", true, "", "black point"}, {"gamma", "float", true, "", "gamma"}, {"whitePoint", "float", true, "", "white point"}},
 		Usage:       "level <blackPoint> <gamma> <whitePoint>",
 		Description: "Adjust levels (black/gamma/white).",
-	},
-	{
+	}, applyLevel)
+	Register(CommandSpec{
 		Name:        "normalize",
 		Args:        []ArgSpec{},
 		Usage:       "normalize",
 		Description: "Stretch per-channel extremes to full [0,255].",
-	},
-	{
+	}, applyNormalize)
+	Register(CommandSpec{
 		Name:        "autoLevel",
 		Args:        []ArgSpec{},
 		Usage:       "autoLevel",
 		Description: "Automatic level normalization.",
-	},
-	{
+	}, applyAutoLevel)
+	Register(CommandSpec{
 		Name:        "autoGamma",
 		Args:        []ArgSpec{},
 		Usage:       "autoGamma",
 		Description: "Automatic gamma correction.",
-	},
-	{
+	}, applyAutoGamma)
+	Register(CommandSpec{
 		Name:        "gamma",
 		Args:        []ArgSpec{{"gamma", "float", true, "", "gamma value"}},
 		Usage:       "gamma <gamma>",
 		Description: "Apply gamma correction.",
-	},
-	{
+	}, applyGamma)
+	Register(CommandSpec{
 		Name:        "negate",
 		Args:        []ArgSpec{{"onlyGray", "bool", false, "false", "only invert grayscale pixels"}},
 		Usage:       "negate [onlyGray]",
 		Description: "Invert colors (optional only-gray).",
-	},
-	{
+	}, applyNegate)
+	Register(CommandSpec{
 		Name:        "threshold",
 		Args:        []ArgSpec{{"value", "float", true, "", "threshold value"}, {"perChannel", "bool", false, "false", "apply per-channel"}},
 		Usage:       "threshold <value> [perChannel]",
 		Description: "Threshold image by value (luminance or per-channel).",
-	},
-	{
+	}, applyThreshold)
+	Register(CommandSpec{
 		Name:        "modulate",
 		Args:        []ArgSpec{{"brightness", "float", true, "", "brightness percent (e.g., 100)"}, {"saturation", "float", true, "", "saturation percent"}, {"hue", "float", true, "", "hue degrees"}},
 		Usage:       "modulate <brightness> <saturation> <hue>",
 		Description: "Adjust brightness, saturation and hue.",
-	},
-	{
+	}, applyModulate)
+	Register(CommandSpec{
 		Name:        "vignette",
 		Args:        []ArgSpec{{"radius", "float", true, "", "radius"}, {"sigma", "float", true, "", "sigma"}, {"x", "int", true, "", "center x"}, {"y", "int", true, "", "center y"}, {"strength", "float", false, "1.0", "0..1 or percent like 50%"}},
 		Usage:       "vignette <radius> <sigma> <x> <y> [strength]",
 		Description: "Apply vignette effect centered at (x,y).",
-	},
-	{
+	}, applyVignette)
+	Register(CommandSpec{
 		Name:        "grayscale",
 		Args:        []ArgSpec{},
 		Usage:       "grayscale",
 		Description: "Convert to luminance (Rec.709).",
-	},
-	{
+	}, applyGrayscale)
+	RegisterContext(CommandSpec{
 		Name:        "edge",
 		Args:        []ArgSpec{{"sigma", "float", false, "0.0", "pre-blur sigma"}, {"scale", "float", false, "1.0", "edge scale multiplier"}, {"threshold", "float", false, "0.0", "threshold value"}, {"binary", "bool", false, "false", "binary output"}},
 		Usage:       "edge [sigma] [scale] [threshold] [binary]",
 		Description: "Sobel-based edge detector with options.",
-	},
-	{
+	}, applyEdge)
+	RegisterContext(CommandSpec{
 		Name:        "adaptiveBlur",
 		Args:        []ArgSpec{{"radius", "float", false, "1.0", "variance neighborhood radius"}, {"sigmaMin", "float", false, "0.5", "min sigma (for high variance)"}, {"sigmaMax", "float", false, "1.0", "max sigma (for low variance)"}, {"levels", "int", false, "6", "discrete levels to precompute"}},
 		Usage:       "adaptiveBlur [radius] [sigmaMin] [sigmaMax] [levels]",
 		Description: "Variance-driven per-pixel adaptive blur.",
-	},
-	// New adaptive/stdlib commands added during migration
-	{
+	}, applyAdaptiveBlur)
+	Register(CommandSpec{
 		Name:        "adaptiveResize",
-		Args:        []ArgSpec{{"width", "int", false, "0", "target width (0 = preserve aspect)"}, {"height", "int", false, "0", "target height (0 = preserve aspect)"}, {"a", "float", false, "3.0", "Lanczos a parameter (3 recommended)"}},
-		Usage:       "adaptiveResize [width] [height] [a]",
+		Args:        []ArgSpec{{"width", "int", false, "0", "target width (0 = preserve aspect)"}, {"height", "int", false, "0", "target height (0 = preserve aspect)"}, {"a", "float", false, "3.0", "Lanczos a parameter (3 recommended)"}, {"linear", "bool", false, "", "work in linear light (default: the linear_light setting)"}},
+		Usage:       "adaptiveResize [width] [height] [a] [linear]",
 		Description: "Resize using Lanczos resampling with aspect-preserve semantics.",
-	},
-	{
+	}, applyAdaptiveResize)
+	Register(CommandSpec{
 		Name:        "adaptiveSharpen",
 		Args:        []ArgSpec{{"radius", "float", false, "0.0", "blur radius (0 = auto)"}, {"sigma", "float", false, "1.0", "sigma for blur"}, {"amount", "float", false, "1.0", "sharpen amount"}},
 		Usage:       "adaptiveSharpen [radius] [sigma] [amount]",
 		Description: "Sharpen using unsharp-mask (approximation).",
-	},
-	{
+	}, applyAdaptiveSharpen)
+	RegisterContext(CommandSpec{
 		Name:        "adaptiveThreshold",
-		Args:        []ArgSpec{{"window_width", "int", false, "15", "local window width (odd)"}, {"window_height", "int", false, "15", "local window height (odd)"}, {"offset", "float", false, "0.0", "threshold offset (subtract from mean)"}},
-		Usage:       "adaptiveThreshold [window_width] [window_height] [offset]",
-		Description: "Local threshold using moving window mean (bilevel output).",
-	},
-	{
+		Args:        []ArgSpec{{"window_width", "int", false, "15", "local window width (odd)"}, {"window_height", "int", false, "15", "local window height (odd)"}, {"offset", "float", false, "0.0", "threshold offset (subtract from the local threshold)"}, {"method", "enum", false, "mean", "local threshold (mean|sauvola|niblack)"}, {"k", "float", false, "", "weight of the local standard deviation (default 0.5 for sauvola, -0.2 for niblack)"}, {"R", "float", false, "128", "dynamic range of the standard deviation (sauvola)"}},
+		Usage:       "adaptiveThreshold [window_width] [window_height] [offset] [method] [k] [R]",
+		Description: "Local threshold from the window mean, or Sauvola/Niblack from mean and standard deviation (bilevel output).",
+	}, applyAdaptiveThreshold)
+	Register(CommandSpec{
 		Name:        "addNoise",
 		Args:        []ArgSpec{{"type", "enum", false, "GAUSSIAN", "noise type (GAUSSIAN|UNIFORM|POISSON)"}, {"amount", "float", false, "10.0", "noise strength (stddev or range)"}, {"seed", "int", false, "0", "random seed (0 = time-based)"}},
 		Usage:       "addNoise [type] [amount] [seed]",
 		Description: "Add noise to image; supports GAUSSIAN, UNIFORM (POISSON optional).",
-	},
-	{
+	}, applyAddNoise)
+	Register(CommandSpec{
 		Name:        "crop",
 		Args:        []ArgSpec{{"width", "int", true, "", "crop width"}, {"height", "int", true, "", "crop height"}, {"x", "int", true, "", "x offset"}, {"y", "int", true, "", "y offset"}},
 		Usage:       "crop <width> <height> <x> <y>",
 		Description: "Crop image (intersected with bounds).",
-	},
-	{
+	}, applyCrop)
+	Register(CommandSpec{
 		Name:        "flip",
 		Args:        []ArgSpec{},
 		Usage:       "flip",
 		Description: "Vertical flip.",
-	},
-	{
+	}, applyFlip)
+	Register(CommandSpec{
 		Name:        "flop",
 		Args:        []ArgSpec{},
 		Usage:       "flop",
 		Description: "Horizontal flip.",
-	},
-	{
+	}, applyFlop)
+	Register(CommandSpec{
 		Name: "histogram",
 		Args: []ArgSpec{
 			{"bins", "int", false, "256", "number of bins"},
@@ -181,53 +178,126 @@ var Commands = []CommandSpec{
 		},
 		Usage:       "histogram [bins] [pixelWindow]",
 		Description: "Render a histogram image (returns image)",
-	},
-	{
+	}, applyHistogram)
+	Register(CommandSpec{
+		Name:        "compare",
+		Args:        []ArgSpec{{"imagePath", "path", true, "", "path to the image to compare with, or @name of an open buffer"}, {"scale", "float", false, "10", "Lab delta-E shown as white"}},
+		Usage:       "compare <imagePath> [scale]",
+		Description: "Render a heat map of the differences from another image of the same size (returns image); the CLI also reports MAE, MSE, PSNR, SSIM and mean delta-E.",
+	}, applyCompare)
+	Register(CommandSpec{
 		Name:        "equalize",
 		Args:        []ArgSpec{},
 		Usage:       "equalize",
 		Description: "Equalize histogram per-channel.",
-	},
-	{
+	}, applyEqualize)
+	Register(CommandSpec{
 		Name:        "trim",
 		Args:        []ArgSpec{{"fuzz", "float_or_percent", true, "", "fuzz numeric or percent (e.g. 5 or 5%)"}},
 		Usage:       "trim <fuzz>",
 		Description: "Trim borders within fuzz tolerance.",
-	},
-	{
+	}, applyTrim)
+	Register(CommandSpec{
 		Name:        "floodfillPaint",
 		Args:        []ArgSpec{{"fillColor", "string", true, "", "CSS color or hex (e.g. #ff0000)"}, {"fuzz", "float_or_percent", true, "", "fuzz as Lab delta-E or percent (e.g. 5 or 50%)"}, {"borderColor", "string", false, "", "CSS color or hex for border or empty string"}, {"x", "int", true, "", "start x"}, {"y", "int", true, "", "start y"}, {"invert", "bool", false, "false", "invert fill region"}},
 		Usage:       "floodfillPaint <fillColor> <fuzz> <borderColor> <x> <y> [invert]",
 		Description: "Flood-fill region starting at (x,y) using perceptual fuzz (Lab delta-E).",
-	},
-	{
+	}, applyFloodfillPaint)
+	Register(CommandSpec{
+		Name:        "selectColor",
+		Args:        []ArgSpec{{"x", "int", true, "", "seed x"}, {"y", "int", true, "", "seed y"}, {"fuzz", "float_or_percent", true, "", "fuzz as Lab delta-E or percent (e.g. 5 or 50%)"}},
+		Usage:       "selectColor <x> <y> <fuzz>",
+		Description: "Select the region connected to (x,y) with a similar color (returns a mask image).",
+	}, applySelectColor)
+	Register(CommandSpec{
+		Name:        "selectSimilar",
+		Args:        []ArgSpec{{"color", "string", true, "", "CSS color or hex (e.g. #ff0000)"}, {"fuzz", "float_or_percent", true, "", "fuzz as Lab delta-E or percent (e.g. 5 or 50%)"}},
+		Usage:       "selectSimilar <color> <fuzz>",
+		Description: "Select every pixel close to color (returns a mask image).",
+	}, applySelectSimilar)
+	Register(CommandSpec{
 		Name:        "annotate",
 		Args:        []ArgSpec{{"text", "string", true, "", "text to draw"}, {"fontPath", "path_or_empty", false, "", "font path (optional)"}, {"size", "float", true, "", "font size"}, {"x", "int", true, "", "x position"}, {"y", "int", true, "", "y position"}, {"color", "string", true, "", "CSS hex or name (e.g. #ff0000)"}},
 		Usage:       "annotate <text> [fontPath] <size> <x> <y> <color>",
 		Description: "Draw text; supports 5 or 6 args (font optional).",
-	},
-	{
+	}, applyAnnotate)
+	Register(CommandSpec{
 		Name:        "composite",
-		Args:        []ArgSpec{{"srcImagePath", "path", true, "", "path to source image"}, {"operator", "string", true, "", "compose operator (e.g. OVER)"}, {"x", "int", true, "", "x offset"}, {"y", "int", true, "", "y offset"}},
-		Usage:       "composite <srcImagePath> <operator> <x> <y>",
-		Description: "Composite an image loaded from disk at offset using operator.",
-	},
-	{
+		Args:        []ArgSpec{{"srcImagePath", "path", true, "", "path to source image, or @name of an open buffer"}, {"operator", "string", true, "", "compose operator (e.g. OVER)"}, {"x", "int", true, "", "x offset"}, {"y", "int", true, "", "y offset"}, {"linear", "bool", false, "", "work in linear light (default: the linear_light setting)"}},
+		Usage:       "composite <srcImagePath> <operator> <x> <y> [linear]",
+		Description: "Composite an image loaded from disk (or an open buffer) at offset using operator.",
+	}, applyComposite)
+	Register(CommandSpec{
 		Name:        "identify",
-		Args:        []ArgSpec{},
-		Usage:       "identify",
+		Args:        []ArgSpec{{"json", "bool", false, "false", "print a JSON document instead of a summary"}},
+		Usage:       "identify [json]",
 		Description: "Print image metadata; returns nil image.",
-	},
-	{
+	}, applyIdentify)
+	Register(CommandSpec{
 		Name:        "strip",
 		Args:        []ArgSpec{},
 		Usage:       "strip",
 		Description: "Strip metadata; returns image unchanged.",
-	},
-	{
+	}, applyStrip)
+	Register(CommandSpec{
 		Name:        "sepia",
 		Args:        []ArgSpec{{"percentage", "float_or_percent", false, "70%", "sepia intensity (0..100% or 0..1)"}, {"midtoneCenter", "float", false, "50", "midtone center L (0..100)"}, {"midtoneSigma", "float", false, "20", "midtone width (sigma)"}, {"highlightThreshold", "float", false, "80", "L at which protection starts"}, {"highlightSoftness", "float", false, "10", "softness for highlight protection"}, {"curve", "float", false, "0.12", "filmic S-curve strength (0..1)"}},
 		Usage:       "sepia [percentage] [midtoneCenter] [midtoneSigma] [highlightThreshold] [highlightSoftness] [curve]",
 		Description: "Apply Sepia tone with optional intensity and tonal controls.",
-	},
+	}, applySepia)
+	RegisterContext(CommandSpec{
+		Name:        "posterize",
+		Args:        []ArgSpec{{"levels", "int", true, "", "levels per channel (2 or more)"}, {"dither", "enum", false, "none", "dithering (none|floyd-steinberg|atkinson|sierra|bayer)"}},
+		Usage:       "posterize <levels> [dither]",
+		Description: "Reduce each channel to the given number of levels, optionally dithered.",
+	}, applyPosterize)
+	RegisterContext(CommandSpec{
+		Name:        "quantize",
+		Args:        []ArgSpec{{"colors", "int", true, "", "palette size (2..256)"}, {"dither", "enum", false, "none", "dithering (none|floyd-steinberg|atkinson|sierra|bayer)"}, {"method", "enum", false, "median-cut", "palette builder (median-cut|octree)"}},
+		Usage:       "quantize <colors> [dither] [method]",
+		Description: "Reduce the image to a palette of at most the given number of colors, optionally dithered.",
+	}, applyQuantize)
+	RegisterContext(CommandSpec{
+		Name:        "sharpen",
+		Args:        []ArgSpec{{"radius", "float", false, "0", "radius (kept for compatibility; sigma controls the blur)"}, {"sigma", "float", false, "1.0", "gaussian sigma"}},
+		Usage:       "sharpen [radius] [sigma]",
+		Description: "Sharpen with an unsharp mask of amount 1.",
+	}, applySharpen)
+	RegisterContext(CommandSpec{
+		Name:        "unsharpMask",
+		Args:        []ArgSpec{{"radius", "float", false, "0", "radius (kept for compatibility; sigma controls the blur)"}, {"sigma", "float", false, "1.0", "gaussian sigma"}, {"amount", "float", false, "1.0", "strength of the sharpening"}, {"threshold", "float", false, "0", "minimum difference to sharpen (0 = all)"}},
+		Usage:       "unsharpMask [radius] [sigma] [amount] [threshold]",
+		Description: "Sharpen by adding back the difference from a Gaussian blur.",
+	}, applyUnsharpMask)
+
+	// Commands that also run at 16 bits per channel.
+	Register64("resize", applyResize64)
+	Register64("rotate", applyRotate64)
+	Register64("blur", applyBlur64)
+	Register64("adaptiveResize", applyAdaptiveResize64)
+	Register64("composite", applyComposite64)
+	Register64("crop", applyCrop64)
+	Register64("level", applyLevel64)
+	Register64("gamma", applyGamma64)
+	Register64("negate", applyNegate64)
+	Register64("threshold", applyThreshold64)
+	Register64("normalize", noArgs64("normalize", Normalize64))
+	Register64("autoLevel", noArgs64("autoLevel", Normalize64))
+	Register64("autoGamma", noArgs64("autoGamma", AutoGamma64))
+	Register64("equalize", filter64(Equalize64))
+	Register64("grayscale", filter64(Grayscale64))
+	Register64("flip", filter64(Flip64))
+	Register64("flop", filter64(Flop64))
+	Register64("modulate", applyModulate64)
+	Register64("vignette", applyVignette64)
+	Register64("sepia", applySepia64)
+	Register64("posterize", applyPosterize64)
+	Register64("sharpen", applySharpen64)
+	Register64("unsharpMask", applyUnsharpMask64)
+
+	registerPoint("level", levelPoint)
+	registerPoint("gamma", gammaPoint)
+	registerPoint("negate", negatePoint)
+	registerPoint("threshold", thresholdPoint)
+	registerPoint("modulate", modulatePoint)
 }
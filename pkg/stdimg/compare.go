@@ -0,0 +1,239 @@
+package stdimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Comparison holds measures of the difference between two images of the
+// same size. Channel values are on the 0..255 scale. Colors are compared
+// premultiplied by alpha, so the color under a transparent pixel does not
+// count, but a change of transparency does.
+type Comparison struct {
+	// MAE and MSE are the mean absolute and mean squared differences of
+	// the premultiplied R, G and B channels and of alpha.
+	MAE, MSE float64
+	// PSNR is the peak signal-to-noise ratio in dB, +Inf for identical
+	// images.
+	PSNR float64
+	// SSIM is the mean structural similarity of the premultiplied
+	// luminance over 11x11
+	// Gaussian windows (sigma 1.5): 1 for identical images, lower the more
+	// their local structure differs.
+	SSIM float64
+	// DeltaE is the mean CIE76 Lab difference of the colors over black,
+	// about 2.3 at the smallest difference most people notice.
+	DeltaE float64
+}
+
+// Identical reports whether the compared images had the same alpha
+// everywhere and the same colors wherever they are not fully transparent.
+func (c Comparison) Identical() bool { return c.MSE == 0 }
+
+// errSizeMismatch reports images that cannot be compared pixel by pixel.
+func errSizeMismatch(a, b image.Rectangle) error {
+	return fmt.Errorf("images differ in size: %dx%d and %dx%d", a.Dx(), a.Dy(), b.Dx(), b.Dy())
+}
+
+// CompareImages measures how much b differs from a, including alpha.
+func CompareImages(a, b *image.NRGBA) (Comparison, error) {
+	if a.Rect.Size() != b.Rect.Size() {
+		return Comparison{}, errSizeMismatch(a.Rect, b.Rect)
+	}
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	if w == 0 || h == 0 {
+		return Comparison{PSNR: math.Inf(1), SSIM: 1}, nil
+	}
+	a, b = premultiplied(a), premultiplied(b)
+	// per-row totals, added up in order so the result does not depend on
+	// the number of workers
+	absRows := make([]uint64, h)
+	sqRows := make([]uint64, h)
+	deRows := make([]float64, h)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := a.PixOffset(x, y)
+				for c := 0; c < 4; c++ {
+					d := int64(a.Pix[i+c]) - int64(b.Pix[i+c])
+					absRows[y] += uint64(max(d, -d))
+					sqRows[y] += uint64(d * d)
+				}
+				deRows[y] += math.Sqrt(labDistanceSq(nrgbaAt(a.Pix, i), nrgbaAt(b.Pix, i)))
+			}
+		}
+	})
+	var abs, sq uint64
+	var de float64
+	for y := 0; y < h; y++ {
+		abs += absRows[y]
+		sq += sqRows[y]
+		de += deRows[y]
+	}
+	n := float64(w * h)
+	c := Comparison{
+		MAE:    float64(abs) / (4 * n),
+		MSE:    float64(sq) / (4 * n),
+		DeltaE: de / n,
+		SSIM:   ssim(a, b),
+	}
+	c.PSNR = math.Inf(1)
+	if c.MSE > 0 {
+		c.PSNR = 10 * math.Log10(255*255/c.MSE)
+	}
+	return c, nil
+}
+
+// CompareToSource compares img with the image named by ref, an open
+// buffer through SourceResolver or a file, as the compare command does.
+func CompareToSource(img image.Image, ref string) (Comparison, error) {
+	other, err := loadSourceImage(ref)
+	if err != nil {
+		return Comparison{}, err
+	}
+	return CompareImages(ToNRGBA(img), ToNRGBA(other))
+}
+
+// premultiplied returns a copy of src, moved to (0, 0), whose colors are
+// scaled by alpha and so are black where src is fully transparent. Alpha
+// is kept.
+func premultiplied(src *image.NRGBA) *image.NRGBA {
+	b := src.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				i := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+				o := out.PixOffset(x, y)
+				a := uint32(src.Pix[i+3])
+				for c := 0; c < 3; c++ {
+					out.Pix[o+c] = uint8((uint32(src.Pix[i+c])*a + 127) / 255)
+				}
+				out.Pix[o+3] = uint8(a)
+			}
+		}
+	})
+	return out
+}
+
+func nrgbaAt(pix []uint8, i int) color.NRGBA {
+	return color.NRGBA{pix[i], pix[i+1], pix[i+2], pix[i+3]}
+}
+
+// ssim returns the mean structural similarity of the luminance of a and b,
+// which have the same size, with the constants of Wang et al. (2004).
+func ssim(a, b *image.NRGBA) float64 {
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	la := luminanceValues(a)
+	lb := luminanceValues(b)
+	n := w * h
+	planes := [5][]float64{}
+	for k := range planes {
+		planes[k] = make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		va, vb := float64(la[i])/lumScale, float64(lb[i])/lumScale
+		planes[0][i], planes[1][i] = va, vb
+		planes[2][i], planes[3][i], planes[4][i] = va*va, vb*vb, va*vb
+	}
+	for k := range planes {
+		planes[k] = blurPlane(planes[k], w, h, 1.5)
+	}
+	const c1, c2 = (0.01 * 255) * (0.01 * 255), (0.03 * 255) * (0.03 * 255)
+	rows := make([]float64, h)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for i := y * w; i < y*w+w; i++ {
+				ma, mb := planes[0][i], planes[1][i]
+				va, vb, cov := planes[2][i]-ma*ma, planes[3][i]-mb*mb, planes[4][i]-ma*mb
+				rows[y] += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			}
+		}
+	})
+	sum := 0.0
+	for _, r := range rows {
+		sum += r
+	}
+	return sum / float64(n)
+}
+
+// blurPlane returns a w x h plane of values blurred with a Gaussian of
+// sigma, repeating the edge values as SeparableGaussianBlur does.
+func blurPlane(p []float64, w, h int, sigma float64) []float64 {
+	kern, radius := gaussianKernel1D(sigma)
+	tmp := make([]float64, len(p))
+	out := make([]float64, len(p))
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				s := 0.0
+				for k := -radius; k <= radius; k++ {
+					s += p[y*w+clampInt(x+k, 0, w-1)] * kern[k+radius]
+				}
+				tmp[y*w+x] = s
+			}
+		}
+	})
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				s := 0.0
+				for k := -radius; k <= radius; k++ {
+					s += tmp[clampInt(y+k, 0, h-1)*w+x] * kern[k+radius]
+				}
+				out[y*w+x] = s
+			}
+		}
+	})
+	return out
+}
+
+// heatStops are the colors of DifferenceMap from no difference to full
+// scale.
+var heatStops = []color.NRGBA{
+	{0, 0, 0, 255},
+	{80, 0, 160, 255},
+	{220, 40, 40, 255},
+	{255, 200, 0, 255},
+	{255, 255, 255, 255},
+}
+
+// heatColor maps t in 0..1 onto heatStops.
+func heatColor(t float64) color.NRGBA {
+	t = clamp01(t) * float64(len(heatStops)-1)
+	i := min(int(t), len(heatStops)-2)
+	f := t - float64(i)
+	lo, hi := heatStops[i], heatStops[i+1]
+	mix := func(a, b uint8) uint8 { return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f)) }
+	return color.NRGBA{mix(lo.R, hi.R), mix(lo.G, hi.G), mix(lo.B, hi.B), 255}
+}
+
+// DifferenceMap renders the Lab delta-E between each pair of pixels of a
+// and b, over black as in CompareImages, as a heat map: black where they
+// match, through purple, red and yellow to white at a difference of scale
+// or more.
+func DifferenceMap(a, b *image.NRGBA, scale float64) (*image.NRGBA, error) {
+	if a.Rect.Size() != b.Rect.Size() {
+		return nil, errSizeMismatch(a.Rect, b.Rect)
+	}
+	if scale <= 0 {
+		scale = 10
+	}
+	w, h := a.Rect.Dx(), a.Rect.Dy()
+	a, b = premultiplied(a), premultiplied(b)
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := a.PixOffset(x, y)
+				de := math.Sqrt(labDistanceSq(nrgbaAt(a.Pix, i), nrgbaAt(b.Pix, i)))
+				c := heatColor(de / scale)
+				o := out.PixOffset(x, y)
+				out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = c.R, c.G, c.B, c.A
+			}
+		}
+	})
+	return out, nil
+}
@@ -0,0 +1,104 @@
+package stdimg
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCompareIdentical(t *testing.T) {
+	a := gradientNRGBA(40, 30)
+	c, err := CompareImages(a, ToNRGBA(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Identical() || c.MAE != 0 || c.DeltaE != 0 || !math.IsInf(c.PSNR, 1) || math.Abs(c.SSIM-1) > 1e-9 {
+		t.Fatalf("identical images: %+v", c)
+	}
+	heat, err := DifferenceMap(a, a, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(heat.Pix); i += 4 {
+		if heat.Pix[i] != 0 || heat.Pix[i+1] != 0 || heat.Pix[i+2] != 0 || heat.Pix[i+3] != 255 {
+			t.Fatalf("difference map of identical images is not black at %d", i/4)
+		}
+	}
+}
+
+func TestCompareKnownDifference(t *testing.T) {
+	a := gradientNRGBA(32, 32)
+	b := ToNRGBA(a)
+	// raise the red channel of every pixel by 6 (gradientNRGBA stays below 250)
+	for i := 0; i < len(b.Pix); i += 4 {
+		b.Pix[i] += 6
+	}
+	c, err := CompareImages(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// averaged over R, G, B and A
+	if c.MAE != 1.5 || c.MSE != 9 {
+		t.Fatalf("MAE %v MSE %v, want 1.5 and 9", c.MAE, c.MSE)
+	}
+	if want := 10 * math.Log10(255*255/9.0); math.Abs(c.PSNR-want) > 1e-9 {
+		t.Fatalf("PSNR %v, want %v", c.PSNR, want)
+	}
+	if c.SSIM >= 1 || c.SSIM < 0.9 || c.DeltaE <= 0 {
+		t.Fatalf("a slight shift: %+v", c)
+	}
+
+	// noise changes the structure, a uniform shift hardly does
+	n := ToNRGBA(a)
+	for i := range n.Pix {
+		if i%4 != 3 {
+			n.Pix[i] ^= uint8(i*37) & 0x3f
+		}
+	}
+	noisy, _ := CompareImages(a, n)
+	if noisy.SSIM >= c.SSIM {
+		t.Fatalf("SSIM of noise %v not below that of a shift %v", noisy.SSIM, c.SSIM)
+	}
+}
+
+func TestCompareAlpha(t *testing.T) {
+	opaque := gradientNRGBA(8, 8)
+	transparent := ToNRGBA(opaque)
+	for i := 3; i < len(transparent.Pix); i += 4 {
+		transparent.Pix[i] = 0
+	}
+	c, err := CompareImages(opaque, transparent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Identical() || c.MAE == 0 || c.DeltaE == 0 {
+		t.Fatalf("opaque and transparent images compare as %+v", c)
+	}
+
+	// the color under fully transparent pixels does not count
+	other := ToNRGBA(transparent)
+	for i := 0; i < len(other.Pix); i += 4 {
+		other.Pix[i] ^= 0xff
+	}
+	c, err = CompareImages(transparent, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Identical() || c.DeltaE != 0 {
+		t.Fatalf("transparent images with different colors compare as %+v", c)
+	}
+}
+
+func TestCompareSizeMismatch(t *testing.T) {
+	a := gradientNRGBA(10, 10)
+	b := image.NewNRGBA(image.Rect(0, 0, 10, 11))
+	if _, err := CompareImages(a, b); err == nil {
+		t.Error("CompareImages accepted images of different sizes")
+	}
+	if _, err := DifferenceMap(a, b, 10); err == nil {
+		t.Error("DifferenceMap accepted images of different sizes")
+	}
+	if _, err := ApplyCommandStdlib(a, "compare", []string{""}); err == nil {
+		t.Error("compare without an image path succeeded")
+	}
+}
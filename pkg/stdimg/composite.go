@@ -1,10 +1,38 @@
 package stdimg
 
 import (
+	"fmt"
 	"image"
 	"math"
+	"os"
 )
 
+// SourceResolver, when set, resolves the source reference of commands that
+// read a second image (composite, compare) before it is treated as a file path. The
+// interactive CLI uses it to expose open buffers as "@name". Returning false
+// falls back to opening ref from disk.
+var SourceResolver func(ref string) (image.Image, bool)
+
+// loadSourceImage returns the image named by ref, consulting SourceResolver
+// first and then decoding ref as a file.
+func loadSourceImage(ref string) (image.Image, error) {
+	if SourceResolver != nil {
+		if img, ok := SourceResolver(ref); ok {
+			return img, nil
+		}
+	}
+	f, err := os.Open(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open: %w", err)
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode: %w", err)
+	}
+	return img, nil
+}
+
 // Supported blend operators (case-insensitive): OVER, MULTIPLY, SCREEN, OVERLAY, ADD, DIFFERENCE, DISSOLVE
 
 func clamp01(v float64) float64 {
@@ -48,11 +76,49 @@ func Composite(dst *image.NRGBA, src image.Image, op string, xoff, yoff int) *im
 		return dst // nothing to do
 	}
 
-	// choose blend function
+	blendFunc := blendFor(op)
+
+	parallelRows(endY-startY, func(y0, y1 int) {
+		for y := startY + y0; y < startY+y1; y++ {
+			for x := startX; x < endX; x++ {
+				si := srcNR.PixOffset(x-xoff, y-yoff)
+				di := dst.PixOffset(x, y)
+				sr := float64(srcNR.Pix[si+0]) / 255.0
+				sg := float64(srcNR.Pix[si+1]) / 255.0
+				sb := float64(srcNR.Pix[si+2]) / 255.0
+				sa := float64(srcNR.Pix[si+3]) / 255.0
+
+				dr_ := float64(dst.Pix[di+0]) / 255.0
+				dg := float64(dst.Pix[di+1]) / 255.0
+				db := float64(dst.Pix[di+2]) / 255.0
+				da := float64(dst.Pix[di+3]) / 255.0
+
+				// compute blended RGB according to blend function
+				br := blendFunc(sr, dr_)
+				bg := blendFunc(sg, dg)
+				bb := blendFunc(sb, db)
+
+				// composite over dst using src alpha
+				outA := sa + da*(1-sa)
+				outR := (1-sa)*dr_ + sa*br
+				outG := (1-sa)*dg + sa*bg
+				outB := (1-sa)*db + sa*bb
+
+				// write back
+				dst.Pix[di+0] = uint8(clampFloatToUint8(outR * 255.0))
+				dst.Pix[di+1] = uint8(clampFloatToUint8(outG * 255.0))
+				dst.Pix[di+2] = uint8(clampFloatToUint8(outB * 255.0))
+				dst.Pix[di+3] = uint8(clampFloatToUint8(outA * 255.0))
+			}
+		}
+	})
+	return dst
+}
+
+// blendFor returns the blend function of compose operator op.
+func blendFor(op string) func(sr, dr float64) float64 {
 	var blendFunc func(sr, dr float64) float64
-	s := op
-	s = stringUpper(s)
-	switch s {
+	switch stringUpper(op) {
 	case "MULTIPLY":
 		blendFunc = blendMultiply
 	case "SCREEN":
@@ -70,39 +136,39 @@ func Composite(dst *image.NRGBA, src image.Image, op string, xoff, yoff int) *im
 		// default to normal over
 		blendFunc = func(sr, dr float64) float64 { return sr }
 	}
+	return blendFunc
+}
 
-	for y := startY; y < endY; y++ {
-		for x := startX; x < endX; x++ {
-			si := srcNR.PixOffset(x-xoff, y-yoff)
-			di := dst.PixOffset(x, y)
-			sr := float64(srcNR.Pix[si+0]) / 255.0
-			sg := float64(srcNR.Pix[si+1]) / 255.0
-			sb := float64(srcNR.Pix[si+2]) / 255.0
-			sa := float64(srcNR.Pix[si+3]) / 255.0
-
-			dr_ := float64(dst.Pix[di+0]) / 255.0
-			dg := float64(dst.Pix[di+1]) / 255.0
-			db := float64(dst.Pix[di+2]) / 255.0
-			da := float64(dst.Pix[di+3]) / 255.0
-
-			// compute blended RGB according to blend function
-			br := blendFunc(sr, dr_)
-			bg := blendFunc(sg, dg)
-			bb := blendFunc(sb, db)
-
-			// composite over dst using src alpha
-			outA := sa + da*(1-sa)
-			outR := (1-sa)*dr_ + sa*br
-			outG := (1-sa)*dg + sa*bg
-			outB := (1-sa)*db + sa*bb
-
-			// write back
-			dst.Pix[di+0] = uint8(clampFloatToUint8(outR * 255.0))
-			dst.Pix[di+1] = uint8(clampFloatToUint8(outG * 255.0))
-			dst.Pix[di+2] = uint8(clampFloatToUint8(outB * 255.0))
-			dst.Pix[di+3] = uint8(clampFloatToUint8(outA * 255.0))
-		}
+// Composite64 is Composite for 16-bit images.
+func Composite64(dst, src *image.NRGBA64, op string, xoff, yoff int) *image.NRGBA64 {
+	if dst == nil || src == nil {
+		return dst
+	}
+	dstB := dst.Bounds()
+	srcB := src.Bounds()
+	startX := maxInt(dstB.Min.X, xoff)
+	startY := maxInt(dstB.Min.Y, yoff)
+	endX := minInt(dstB.Max.X, xoff+srcB.Dx())
+	endY := minInt(dstB.Max.Y, yoff+srcB.Dy())
+	if startX >= endX || startY >= endY {
+		return dst
 	}
+	blendFunc := blendFor(op)
+	parallelRows(endY-startY, func(y0, y1 int) {
+		for y := startY + y0; y < startY+y1; y++ {
+			for x := startX; x < endX; x++ {
+				sr, sg, sb, sa := getF64(src, srcB.Min.X+x-xoff, srcB.Min.Y+y-yoff)
+				dr, dg, db, da := getF64(dst, x, y)
+				sr, sg, sb, sa = sr/65535, sg/65535, sb/65535, sa/65535
+				dr, dg, db, da = dr/65535, dg/65535, db/65535, da/65535
+				outA := sa + da*(1-sa)
+				outR := (1-sa)*dr + sa*blendFunc(sr, dr)
+				outG := (1-sa)*dg + sa*blendFunc(sg, dg)
+				outB := (1-sa)*db + sa*blendFunc(sb, db)
+				set64(dst, dst.PixOffset(x, y), to16(outR*65535), to16(outG*65535), to16(outB*65535), to16(outA*65535))
+			}
+		}
+	})
 	return dst
 }
 
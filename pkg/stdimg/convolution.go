@@ -1,9 +1,9 @@
 package stdimg
 
 import (
+	"context"
 	"image"
 	"math"
-	"sync"
 )
 
 // gaussianKernel1D generates a 1D Gaussian kernel with given sigma. Returns kernel and half-width radius.
@@ -30,8 +30,28 @@ func gaussianKernel1D(sigma float64) ([]float64, int) {
 
 // SeparableGaussianBlur applies a separable gaussian blur to src and returns a new *image.NRGBA
 func SeparableGaussianBlur(src *image.NRGBA, sigma float64) *image.NRGBA {
+	out, _ := SeparableGaussianBlurContext(context.Background(), src, sigma, nil)
+	return out
+}
+
+// SeparableGaussianBlurContext is SeparableGaussianBlur with cancellation
+// and progress reporting. It returns ctx.Err() if ctx is cancelled before
+// the blur completes.
+func SeparableGaussianBlurContext(ctx context.Context, src *image.NRGBA, sigma float64, progress ProgressFunc) (*image.NRGBA, error) {
 	if src == nil {
-		return nil
+		return nil, nil
+	}
+	if sigma >= boxBlurMinSigma {
+		return boxBlurContext(ctx, src, sigma, progress)
+	}
+	return convolveGaussianContext(ctx, src, sigma, progress)
+}
+
+// convolveGaussianContext blurs src by direct convolution with the
+// Gaussian kernel, one row and then one column at a time.
+func convolveGaussianContext(ctx context.Context, src *image.NRGBA, sigma float64, progress ProgressFunc) (*image.NRGBA, error) {
+	if progress == nil {
+		progress = noProgress
 	}
 	kern, radius := gaussianKernel1D(sigma)
 	b := src.Bounds()
@@ -39,13 +59,15 @@ func SeparableGaussianBlur(src *image.NRGBA, sigma float64) *image.NRGBA {
 	// temporary buffer for horiz pass
 	tmp := image.NewNRGBA(image.Rect(0, 0, w, h))
 	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	// the passes process h rows and w columns of equal length
+	split := 0.5
+	if w+h > 0 {
+		split = float64(h) / float64(w+h)
+	}
 
 	// horizontal pass
-	var wg sync.WaitGroup
-	for y := 0; y < h; y++ {
-		wg.Add(1)
-		go func(y int) {
-			defer wg.Done()
+	err := parallelRowsContext(ctx, h, subProgress(progress, 0, split), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
 			for x := 0; x < w; x++ {
 				sr, sg, sb, sa := 0.0, 0.0, 0.0, 0.0
 				wsum := 0.0
@@ -71,15 +93,15 @@ func SeparableGaussianBlur(src *image.NRGBA, sigma float64) *image.NRGBA {
 				tmp.Pix[i+2] = uint8(clampFloatToUint8(sb / wsum))
 				tmp.Pix[i+3] = uint8(clampFloatToUint8(sa / wsum))
 			}
-		}(y)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	wg.Wait()
 
-	// vertical pass
-	for x := 0; x < w; x++ {
-		wg.Add(1)
-		go func(x int) {
-			defer wg.Done()
+	// vertical pass, split by columns
+	err = parallelRowsContext(ctx, w, subProgress(progress, split, 1-split), func(x0, x1 int) {
+		for x := x0; x < x1; x++ {
 			for y := 0; y < h; y++ {
 				sr, sg, sb, sa := 0.0, 0.0, 0.0, 0.0
 				wsum := 0.0
@@ -104,8 +126,10 @@ func SeparableGaussianBlur(src *image.NRGBA, sigma float64) *image.NRGBA {
 				dst.Pix[i+2] = uint8(clampFloatToUint8(sb / wsum))
 				dst.Pix[i+3] = uint8(clampFloatToUint8(sa / wsum))
 			}
-		}(x)
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	wg.Wait()
-	return dst
+	return dst, nil
 }
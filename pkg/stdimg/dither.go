@@ -0,0 +1,194 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Dithering of Quantize, Remap and PosterizeDither, which hides the bands
+// of a reduced palette by spreading each pixel's rounding error.
+const (
+	// DitherNone maps each pixel to its nearest color.
+	DitherNone = "none"
+	// DitherFloydSteinberg diffuses the whole error to four neighbours.
+	DitherFloydSteinberg = "floyd-steinberg"
+	// DitherAtkinson diffuses three quarters of the error to six
+	// neighbours, which keeps more contrast in flat areas.
+	DitherAtkinson = "atkinson"
+	// DitherSierra diffuses the whole error to ten neighbours over three
+	// rows, for the smoothest gradients.
+	DitherSierra = "sierra"
+	// DitherBayer adds the threshold of an 8x8 Bayer matrix before
+	// rounding, for a regular cross-hatch that does not crawl between
+	// frames.
+	DitherBayer = "bayer"
+)
+
+// DitherMethods lists the dithering accepted by Quantize and
+// PosterizeDither.
+var DitherMethods = []string{DitherNone, DitherFloydSteinberg, DitherAtkinson, DitherSierra, DitherBayer}
+
+// parseDither checks a dithering name; empty means DitherNone.
+func parseDither(s string) (string, error) {
+	if s == "" {
+		return DitherNone, nil
+	}
+	d := strings.ToLower(s)
+	if !slices.Contains(DitherMethods, d) {
+		return "", fmt.Errorf("unknown dither %q (want %s)", s, strings.Join(DitherMethods, ", "))
+	}
+	return d, nil
+}
+
+// diffusionTap sends weight/divisor of the error to the pixel at dx, dy.
+type diffusionTap struct{ dx, dy, weight int }
+
+type diffusionKernel struct {
+	taps    []diffusionTap
+	divisor float32
+}
+
+var diffusionKernels = map[string]diffusionKernel{
+	DitherFloydSteinberg: {[]diffusionTap{{1, 0, 7}, {-1, 1, 3}, {0, 1, 5}, {1, 1, 1}}, 16},
+	DitherAtkinson:       {[]diffusionTap{{1, 0, 1}, {2, 0, 1}, {-1, 1, 1}, {0, 1, 1}, {1, 1, 1}, {0, 2, 1}}, 8},
+	DitherSierra: {[]diffusionTap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}, 32},
+}
+
+// bayer8 is the 8x8 Bayer threshold matrix, with values 0..63.
+var bayer8 = [8][8]uint8{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// nearestFunc returns the output color nearest to r, g, b.
+type nearestFunc func(r, g, b uint8) (uint8, uint8, uint8)
+
+func roundToUint8(v float64) uint8 {
+	return uint8(clampFloatToUint8(math.Round(v)))
+}
+
+// paletteSpread estimates the distance between neighbouring colors of a
+// palette of n colors spread over the RGB cube, the amplitude of its Bayer
+// dither.
+func paletteSpread(n int) float64 {
+	return 255 / math.Cbrt(float64(n))
+}
+
+// ditherContext maps the pixels of src through nearest with the given
+// dithering. spread is the step between output colors, which scales the
+// Bayer thresholds. newNearest is called once per worker, so that the
+// functions it returns need not be safe for concurrent use. Alpha is kept.
+func ditherContext(ctx context.Context, src *image.NRGBA, dither string, spread float64, newNearest func() nearestFunc, progress ProgressFunc) (*image.NRGBA, error) {
+	dither, err := parseDither(dither)
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewNRGBA(b)
+	kern, diffuse := diffusionKernels[dither]
+	if !diffuse {
+		err := parallelRowsContext(ctx, h, progress, func(y0, y1 int) {
+			nearest := newNearest()
+			for y := y0; y < y1; y++ {
+				for x := 0; x < w; x++ {
+					i := src.PixOffset(x+b.Min.X, y+b.Min.Y)
+					r, g, bl := src.Pix[i], src.Pix[i+1], src.Pix[i+2]
+					if dither == DitherBayer {
+						d := spread * ((float64(bayer8[y&7][x&7])+0.5)/64 - 0.5)
+						r = roundToUint8(float64(r) + d)
+						g = roundToUint8(float64(g) + d)
+						bl = roundToUint8(float64(bl) + d)
+					}
+					out.Pix[i], out.Pix[i+1], out.Pix[i+2] = nearest(r, g, bl)
+					out.Pix[i+3] = src.Pix[i+3]
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+
+	// Error diffusion runs in scan order. errs holds the error carried into
+	// the current row and the two below it, with two pixels of padding on
+	// each side.
+	if progress == nil {
+		progress = noProgress
+	}
+	nearest := newNearest()
+	const pad = 2
+	stride := (w + 2*pad) * 3
+	var errs [3][]float32
+	for k := range errs {
+		errs[k] = make([]float32, stride)
+	}
+	for y := 0; y < h; y++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		cur := errs[y%3]
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(x+b.Min.X, y+b.Min.Y)
+			e := cur[(x+pad)*3 : (x+pad)*3+3]
+			var v [3]uint8
+			for k := range v {
+				v[k] = roundToUint8(float64(float32(src.Pix[i+k]) + e[k]))
+			}
+			r, g, bl := nearest(v[0], v[1], v[2])
+			out.Pix[i], out.Pix[i+1], out.Pix[i+2], out.Pix[i+3] = r, g, bl, src.Pix[i+3]
+			if src.Pix[i+3] == 0 {
+				// invisible pixels neither take nor pass on error
+				continue
+			}
+			q := [3]float32{float32(v[0]) - float32(r), float32(v[1]) - float32(g), float32(v[2]) - float32(bl)}
+			for _, t := range kern.taps {
+				row := errs[(y+t.dy)%3]
+				j := (x + pad + t.dx) * 3
+				f := float32(t.weight) / kern.divisor
+				row[j] += q[0] * f
+				row[j+1] += q[1] * f
+				row[j+2] += q[2] * f
+			}
+		}
+		clear(cur)
+		progress(float64(y+1) / float64(h))
+	}
+	return out, nil
+}
+
+// PosterizeDither reduces each channel to levels evenly spaced values, as
+// Posterize does, with the given dithering (see DitherMethods).
+func PosterizeDither(src *image.NRGBA, levels int, dither string) (*image.NRGBA, error) {
+	return posterizeContext(context.Background(), src, levels, dither, nil)
+}
+
+func posterizeContext(ctx context.Context, src *image.NRGBA, levels int, dither string, progress ProgressFunc) (*image.NRGBA, error) {
+	if levels < 2 {
+		return CloneNRGBA(src), nil
+	}
+	step := 255.0 / float64(levels-1)
+	round := func(v uint8) uint8 {
+		// rounded like Posterize, which this matches without dithering
+		return uint8(clampFloatToUint8(math.Round(float64(v)/step) * step))
+	}
+	newNearest := func() nearestFunc {
+		return func(r, g, b uint8) (uint8, uint8, uint8) { return round(r), round(g), round(b) }
+	}
+	return ditherContext(ctx, src, dither, step, newNearest, progress)
+}
@@ -0,0 +1,129 @@
+package stdimg
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+)
+
+// A layered project file (.timpdoc) is a zip archive holding document.json,
+// which describes the canvas and the layer stack, and one PNG per layer
+// image and mask. Layer images keep their bit depth.
+
+const docManifest = "document.json"
+
+type docFile struct {
+	Version int            `json:"version"`
+	Width   int            `json:"width"`
+	Height  int            `json:"height"`
+	Active  int            `json:"active"`
+	Layers  []docFileLayer `json:"layers"`
+}
+
+type docFileLayer struct {
+	Name    string  `json:"name"`
+	Image   string  `json:"image"`
+	X       int     `json:"x"`
+	Y       int     `json:"y"`
+	Opacity float64 `json:"opacity"`
+	Hidden  bool    `json:"hidden,omitempty"`
+	Blend   string  `json:"blend,omitempty"`
+	Mask    string  `json:"mask,omitempty"`
+}
+
+// WriteDocument writes d to w as a layered project file.
+func WriteDocument(w io.Writer, d *Document) error {
+	zw := zip.NewWriter(w)
+	manifest := docFile{Version: 1, Width: d.Width, Height: d.Height, Active: d.Active}
+	writePNG := func(name string, img image.Image) error {
+		// PNG is already compressed, so store it as is.
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		return png.Encode(f, img)
+	}
+	for i, l := range d.Layers {
+		fl := docFileLayer{
+			Name:    l.Name,
+			Image:   fmt.Sprintf("layers/%d.png", i),
+			X:       l.Offset.X,
+			Y:       l.Offset.Y,
+			Opacity: l.Opacity,
+			Hidden:  l.Hidden,
+			Blend:   l.Blend,
+		}
+		if err := writePNG(fl.Image, l.Image); err != nil {
+			return fmt.Errorf("layer %s: %w", l.Name, err)
+		}
+		if l.Mask != nil {
+			fl.Mask = fmt.Sprintf("masks/%d.png", i)
+			if err := writePNG(fl.Mask, l.Mask); err != nil {
+				return fmt.Errorf("mask of layer %s: %w", l.Name, err)
+			}
+		}
+		manifest.Layers = append(manifest.Layers, fl)
+	}
+	f, err := zw.Create(docManifest)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadDocument reads a layered project file of the given size from r.
+func ReadDocument(r io.ReaderAt, size int64) (*Document, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a timp document: %w", err)
+	}
+	readPNG := func(name string) (image.Image, error) {
+		f, err := zr.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return png.Decode(f)
+	}
+	mf, err := zr.Open(docManifest)
+	if err != nil {
+		return nil, fmt.Errorf("not a timp document: %w", err)
+	}
+	var manifest docFile
+	err = json.NewDecoder(mf).Decode(&manifest)
+	mf.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", docManifest, err)
+	}
+	if manifest.Version != 1 {
+		return nil, fmt.Errorf("unsupported document version %d", manifest.Version)
+	}
+	if manifest.Width <= 0 || manifest.Height <= 0 || len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("document has no canvas or no layers")
+	}
+	d := &Document{Width: manifest.Width, Height: manifest.Height, Active: clampInt(manifest.Active, 0, len(manifest.Layers)-1)}
+	for _, fl := range manifest.Layers {
+		img, err := readPNG(fl.Image)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", fl.Name, err)
+		}
+		l := &Layer{Name: fl.Name, Image: img, Offset: image.Pt(fl.X, fl.Y), Opacity: fl.Opacity, Hidden: fl.Hidden, Blend: fl.Blend}
+		if fl.Mask != "" {
+			m, err := readPNG(fl.Mask)
+			if err != nil {
+				return nil, fmt.Errorf("mask of layer %s: %w", fl.Name, err)
+			}
+			l.Mask = MaskFromImage(m, img.Bounds())
+		}
+		d.Layers = append(d.Layers, l)
+	}
+	return d, nil
+}
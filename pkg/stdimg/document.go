@@ -0,0 +1,168 @@
+package stdimg
+
+import (
+	"fmt"
+	"image"
+)
+
+// Document is a stack of layers over a canvas of fixed size. Layers are
+// kept bottom first; Flatten blends them, in order, into a single image.
+type Document struct {
+	Width, Height int
+	Layers        []*Layer
+	// Active is the index of the layer that commands apply to.
+	Active int
+}
+
+// Layer is one image in a Document.
+type Layer struct {
+	Name  string
+	Image image.Image
+	// Offset places the layer's top-left corner on the canvas.
+	Offset image.Point
+	// Opacity scales the layer's alpha, from 0 to 1.
+	Opacity float64
+	Hidden  bool
+	// Blend is a composite operator (OVER, MULTIPLY, SCREEN, OVERLAY, ADD,
+	// DIFFERENCE); empty means OVER.
+	Blend string
+	// Mask, when set, weights the layer's alpha per pixel. It is fitted to
+	// the layer's size as MaskFromImage does.
+	Mask *image.Gray
+}
+
+// NewLayer returns a fully opaque, visible layer showing img.
+func NewLayer(name string, img image.Image) *Layer {
+	return &Layer{Name: name, Image: img, Opacity: 1, Blend: "OVER"}
+}
+
+// NewDocument returns a document the size of base with base as its only
+// layer.
+func NewDocument(name string, base image.Image) *Document {
+	b := base.Bounds()
+	return &Document{Width: b.Dx(), Height: b.Dy(), Layers: []*Layer{NewLayer(name, base)}}
+}
+
+// Bounds returns the canvas rectangle, anchored at the origin.
+func (d *Document) Bounds() image.Rectangle {
+	return image.Rect(0, 0, d.Width, d.Height)
+}
+
+// ActiveLayer returns the layer commands apply to.
+func (d *Document) ActiveLayer() *Layer {
+	return d.Layers[d.Active]
+}
+
+// Add inserts l above the active layer and makes it active.
+func (d *Document) Add(l *Layer) {
+	i := d.Active + 1
+	if len(d.Layers) == 0 {
+		i = 0
+	}
+	d.Layers = append(d.Layers[:i], append([]*Layer{l}, d.Layers[i:]...)...)
+	d.Active = i
+}
+
+// Remove deletes layer i. The last layer of a document can not be removed.
+func (d *Document) Remove(i int) error {
+	if i < 0 || i >= len(d.Layers) {
+		return fmt.Errorf("no layer %d", i+1)
+	}
+	if len(d.Layers) == 1 {
+		return fmt.Errorf("a document needs at least one layer")
+	}
+	d.Layers = append(d.Layers[:i], d.Layers[i+1:]...)
+	if d.Active > i || d.Active == len(d.Layers) {
+		d.Active--
+	}
+	return nil
+}
+
+// Move moves layer i up (delta > 0) or down the stack by delta places,
+// stopping at the top or bottom. The active layer stays active.
+func (d *Document) Move(i, delta int) {
+	j := clampInt(i+delta, 0, len(d.Layers)-1)
+	active := d.Layers[d.Active]
+	l := d.Layers[i]
+	d.Layers = append(d.Layers[:i], d.Layers[i+1:]...)
+	d.Layers = append(d.Layers[:j], append([]*Layer{l}, d.Layers[j:]...)...)
+	for k, x := range d.Layers {
+		if x == active {
+			d.Active = k
+		}
+	}
+}
+
+// Deep reports whether any layer holds 16-bit samples, in which case
+// Flatten returns an *image.NRGBA64.
+func (d *Document) Deep() bool {
+	for _, l := range d.Layers {
+		if Is16Bit(l.Image) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flatten blends the visible layers onto a transparent canvas and returns
+// the result, as *image.NRGBA64 if the document is Deep and *image.NRGBA
+// otherwise. The blend follows the W3C compositing model, so a layer over
+// transparent canvas keeps its colors.
+func (d *Document) Flatten() image.Image {
+	canvas := image.NewNRGBA64(d.Bounds())
+	for _, l := range d.Layers {
+		if l.Hidden || l.Opacity <= 0 || l.Image == nil {
+			continue
+		}
+		blendLayer(canvas, l)
+	}
+	if d.Deep() {
+		return canvas
+	}
+	return ToNRGBA(canvas)
+}
+
+// blendLayer composites l onto canvas in place.
+func blendLayer(canvas *image.NRGBA64, l *Layer) {
+	src := ToNRGBA64(l.Image)
+	sb := src.Bounds()
+	var mask *image.Gray
+	if l.Mask != nil {
+		mask = l.Mask
+		if mask.Rect.Dx() != sb.Dx() || mask.Rect.Dy() != sb.Dy() {
+			mask = MaskFromImage(mask, sb)
+		}
+	}
+	area := image.Rectangle{Min: l.Offset, Max: l.Offset.Add(sb.Size())}.Intersect(canvas.Bounds())
+	if area.Empty() {
+		return
+	}
+	blendFunc := blendFor(l.Blend)
+	opacity := clamp01(l.Opacity)
+	parallelRows(area.Dy(), func(y0, y1 int) {
+		for y := area.Min.Y + y0; y < area.Min.Y+y1; y++ {
+			for x := area.Min.X; x < area.Max.X; x++ {
+				lx, ly := x-l.Offset.X, y-l.Offset.Y
+				sr, sg, sbl, sa := getF64(src, sb.Min.X+lx, sb.Min.Y+ly)
+				sa = sa / 65535 * opacity
+				if mask != nil {
+					sa *= float64(mask.Pix[ly*mask.Stride+lx]) / 255
+				}
+				if sa <= 0 {
+					continue
+				}
+				sr, sg, sbl = sr/65535, sg/65535, sbl/65535
+				i := canvas.PixOffset(x, y)
+				dr, dg, db, da := getF64(canvas, x, y)
+				dr, dg, db, da = dr/65535, dg/65535, db/65535, da/65535
+				outA := sa + da*(1-sa)
+				mix := func(s, d float64) float64 {
+					// the blend only applies where there is something below
+					s = (1-da)*s + da*blendFunc(s, d)
+					return (sa*s + da*(1-sa)*d) / outA
+				}
+				set64(canvas, i, to16(mix(sr, dr)*65535), to16(mix(sg, dg)*65535), to16(mix(sbl, db)*65535), to16(outA*65535))
+			}
+		}
+	})
+}
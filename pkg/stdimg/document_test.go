@@ -0,0 +1,113 @@
+package stdimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFlattenSingleLayerIsIdentity(t *testing.T) {
+	src := gradientNRGBA(23, 17)
+	flat := NewDocument("background", src).Flatten()
+	if !bytes.Equal(ToNRGBA(flat).Pix, src.Pix) {
+		t.Error("flattening one opaque layer changed the pixels")
+	}
+	deep := ramp64(23, 17)
+	flat = NewDocument("background", deep).Flatten()
+	got, ok := flat.(*image.NRGBA64)
+	if !ok || !bytes.Equal(got.Pix, deep.Pix) {
+		t.Errorf("flattening a 16-bit layer: %T, identical %v", flat, ok && bytes.Equal(got.Pix, deep.Pix))
+	}
+}
+
+func TestFlattenBlendOpacityMaskOffset(t *testing.T) {
+	doc := NewDocument("background", makeSolidNRGBA(4, 2, color.NRGBA{200, 100, 50, 255}))
+	top := NewLayer("top", makeSolidNRGBA(2, 2, color.NRGBA{128, 128, 128, 255}))
+	top.Blend = "MULTIPLY"
+	top.Opacity = 0.5
+	top.Offset = image.Pt(2, 0)
+	top.Mask = image.NewGray(image.Rect(0, 0, 2, 2))
+	copy(top.Mask.Pix, []uint8{255, 255, 0, 0})
+	doc.Add(top)
+	if doc.Active != 1 {
+		t.Fatalf("the added layer is not active")
+	}
+	flat := ToNRGBA(doc.Flatten())
+	// multiply by ~0.5 at half opacity gives ~0.75 of the background
+	if got := flat.NRGBAAt(3, 0); got.R < 149 || got.R > 151 || got.A != 255 {
+		t.Errorf("blended pixel = %v, want R about 150", got)
+	}
+	for _, p := range []image.Point{{0, 0}, {1, 1}, {3, 1}} {
+		if got := flat.NRGBAAt(p.X, p.Y); got != (color.NRGBA{200, 100, 50, 255}) {
+			t.Errorf("%v = %v, want the background", p, got)
+		}
+	}
+	top.Hidden = true
+	if got := ToNRGBA(doc.Flatten()).NRGBAAt(3, 0); got.R != 200 {
+		t.Errorf("hidden layer still shows: %v", got)
+	}
+}
+
+func TestDocumentLayerOrder(t *testing.T) {
+	doc := NewDocument("a", makeSolidNRGBA(1, 1, color.NRGBA{255, 0, 0, 255}))
+	doc.Add(NewLayer("b", makeSolidNRGBA(1, 1, color.NRGBA{0, 255, 0, 255})))
+	doc.Add(NewLayer("c", makeSolidNRGBA(1, 1, color.NRGBA{0, 0, 255, 255})))
+	if got := ToNRGBA(doc.Flatten()).NRGBAAt(0, 0); got.B != 255 {
+		t.Fatalf("top layer c should win, got %v", got)
+	}
+	doc.Move(2, -2)
+	names := doc.Layers[0].Name + doc.Layers[1].Name + doc.Layers[2].Name
+	if names != "cab" || doc.ActiveLayer().Name != "c" {
+		t.Fatalf("after move: %s, active %s", names, doc.ActiveLayer().Name)
+	}
+	if got := ToNRGBA(doc.Flatten()).NRGBAAt(0, 0); got.G != 255 {
+		t.Errorf("top layer b should win, got %v", got)
+	}
+	if err := doc.Remove(0); err != nil || doc.ActiveLayer().Name != "a" {
+		t.Fatalf("Remove(0) = %v, active %s", err, doc.ActiveLayer().Name)
+	}
+	_ = doc.Remove(0)
+	if err := doc.Remove(0); err == nil {
+		t.Error("removing the last layer should fail")
+	}
+}
+
+func TestDocumentRoundTrip(t *testing.T) {
+	doc := NewDocument("background", gradientNRGBA(9, 7))
+	top := NewLayer("deep", ramp64(4, 3))
+	top.Offset = image.Pt(3, -1)
+	top.Opacity = 0.25
+	top.Blend = "SCREEN"
+	top.Mask = image.NewGray(image.Rect(0, 0, 4, 3))
+	top.Mask.Pix[5] = 200
+	doc.Add(top)
+	doc.Add(NewLayer("hidden", makeSolidNRGBA(2, 2, color.NRGBA{1, 2, 3, 4})))
+	doc.Layers[2].Hidden = true
+
+	var buf bytes.Buffer
+	if err := WriteDocument(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadDocument(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Width != 9 || got.Height != 7 || got.Active != 2 || len(got.Layers) != 3 {
+		t.Fatalf("document = %dx%d active %d with %d layers", got.Width, got.Height, got.Active, len(got.Layers))
+	}
+	l := got.Layers[1]
+	if l.Name != "deep" || l.Offset != top.Offset || l.Opacity != 0.25 || l.Blend != "SCREEN" || !Is16Bit(l.Image) || l.Mask.Pix[5] != 200 {
+		t.Errorf("layer = %+v", l)
+	}
+	if !got.Layers[2].Hidden {
+		t.Error("visibility was not kept")
+	}
+	want, have := doc.Flatten().(*image.NRGBA64), got.Flatten().(*image.NRGBA64)
+	if !bytes.Equal(want.Pix, have.Pix) {
+		t.Error("the reloaded document flattens differently")
+	}
+	if _, err := ReadDocument(bytes.NewReader([]byte("nope")), 4); err == nil {
+		t.Error("reading garbage should fail")
+	}
+}
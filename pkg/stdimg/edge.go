@@ -13,11 +13,26 @@ func EdgeEx(src *image.NRGBA, sigma, scale, threshold float64, binary bool) *ima
 	}
 	b := src.Bounds()
 	w, h := b.Dx(), b.Dy()
-	var proc *image.NRGBA
+	mag := edgeMagnitudes(src, sigma, scale)
+	maxMag := 0.0
+	for _, m := range mag {
+		if m > maxMag {
+			maxMag = m
+		}
+	}
+	out := image.NewNRGBA(b)
+	drawEdges(out, image.Point{}, mag, w, image.Rect(0, 0, w, h), maxMag, threshold, binary)
+	return out
+}
+
+// edgeMagnitudes returns the scaled Sobel gradient magnitude of every pixel
+// of src after the optional pre-blur, row by row.
+func edgeMagnitudes(src *image.NRGBA, sigma, scale float64) []float64 {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	proc := src
 	if sigma > 0 {
 		proc = SeparableGaussianBlur(src, sigma)
-	} else {
-		proc = CloneNRGBA(src)
 	}
 
 	// Sobel kernels
@@ -25,79 +40,83 @@ func EdgeEx(src *image.NRGBA, sigma, scale, threshold float64, binary bool) *ima
 	gy := [3][3]float64{{-1, -2, -1}, {0, 0, 0}, {1, 2, 1}}
 
 	mag := make([]float64, w*h)
-	maxMag := 0.0
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			sumX := 0.0
-			sumY := 0.0
-			for ky := -1; ky <= 1; ky++ {
-				for kx := -1; kx <= 1; kx++ {
-					ix := x + kx
-					iy := y + ky
-					if ix < 0 {
-						ix = 0
-					} else if ix >= w {
-						ix = w - 1
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				sumX := 0.0
+				sumY := 0.0
+				for ky := -1; ky <= 1; ky++ {
+					for kx := -1; kx <= 1; kx++ {
+						ix := x + kx
+						iy := y + ky
+						if ix < 0 {
+							ix = 0
+						} else if ix >= w {
+							ix = w - 1
+						}
+						if iy < 0 {
+							iy = 0
+						} else if iy >= h {
+							iy = h - 1
+						}
+						c := samplePixelClamped(proc, ix, iy)
+						r := float64(c.R) / 255.0
+						g := float64(c.G) / 255.0
+						b_ := float64(c.B) / 255.0
+						lum := 0.2126*r + 0.7152*g + 0.0722*b_
+						kxv := gx[ky+1][kx+1]
+						kyv := gy[ky+1][kx+1]
+						sumX += lum * kxv
+						sumY += lum * kyv
 					}
-					if iy < 0 {
-						iy = 0
-					} else if iy >= h {
-						iy = h - 1
-					}
-					c := samplePixelClamped(proc, ix, iy)
-					r := float64(c.R) / 255.0
-					g := float64(c.G) / 255.0
-					b_ := float64(c.B) / 255.0
-					lum := 0.2126*r + 0.7152*g + 0.0722*b_
-					kxv := gx[ky+1][kx+1]
-					kyv := gy[ky+1][kx+1]
-					sumX += lum * kxv
-					sumY += lum * kyv
 				}
+				m := math.Sqrt(sumX*sumX + sumY*sumY)
+				if scale > 0 {
+					m *= scale
+				}
+				mag[y*w+x] = m
 			}
-			m := math.Sqrt(sumX*sumX + sumY*sumY)
-			if scale > 0 {
-				m *= scale
-			}
-			if m > maxMag {
-				maxMag = m
-			}
-			mag[y*w+x] = m
 		}
-	}
+	})
+	return mag
+}
 
-	out := image.NewNRGBA(b)
+// drawEdges writes the magnitudes of rectangle r of a row-major slice
+// magW wide into out, with r.Min landing at at, normalised by maxMag and
+// thresholded as EdgeEx describes.
+func drawEdges(out *image.NRGBA, at image.Point, mag []float64, magW int, r image.Rectangle, maxMag, threshold float64, binary bool) {
 	// normalize to [0,255]
 	norm := 1.0
 	if maxMag > 0 {
 		norm = 1.0 / maxMag
 	}
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			m := mag[y*w+x] * norm * 255.0
-			val := clampFloatToUint8(m)
-			if threshold > 0 {
-				if binary {
-					if m >= threshold {
-						val = 255
+	parallelRows(r.Dy(), func(y0, y1 int) {
+		for y := r.Min.Y + y0; y < r.Min.Y+y1; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				m := mag[y*magW+x] * norm * 255.0
+				val := clampFloatToUint8(m)
+				if threshold > 0 {
+					if binary {
+						if m >= threshold {
+							val = 255
+						} else {
+							val = 0
+						}
 					} else {
-						val = 0
-					}
-				} else {
-					// zero-out below threshold
-					if m < threshold {
-						val = 0
+						// zero-out below threshold
+						if m < threshold {
+							val = 0
+						}
 					}
 				}
+				i := (at.Y+y-r.Min.Y)*out.Stride + (at.X+x-r.Min.X)*4
+				out.Pix[i+0] = uint8(val)
+				out.Pix[i+1] = uint8(val)
+				out.Pix[i+2] = uint8(val)
+				out.Pix[i+3] = 255
 			}
-			i := out.PixOffset(x, y)
-			out.Pix[i+0] = uint8(val)
-			out.Pix[i+1] = uint8(val)
-			out.Pix[i+2] = uint8(val)
-			out.Pix[i+3] = 255
 		}
-	}
-	return out
+	})
 }
 
 // Edge is a compatibility wrapper using EdgeEx with defaults (no pre-blur, scale applied directly).
@@ -1,95 +1,105 @@
 package stdimg
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"math"
-	"os"
+	"slices"
 	"strconv"
+	"strings"
 
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
 )
 
-// ApplyCommandStdlib applies basic commands to an image.NRGBA and returns a new image.
-// It implements a subset of the original ImageMagick-backed commands: resize, rotate, blur, sharpen, crop, flip, flop, grayscale, strip, identify (returns nil image and prints info).
+// ApplyCommandStdlib applies the registered command commandName to img and
+// returns the result. The handler receives a private NRGBA copy of img, so
+// the caller's image is never modified. identify returns a nil image.
+// A 16-bit img gives a 16-bit result: the command's Register64 handler runs
+// on an NRGBA64 copy if it has one, otherwise the 8-bit result is widened.
 func ApplyCommandStdlib(img image.Image, commandName string, args []string) (image.Image, error) {
-	if img == nil {
-		return nil, fmt.Errorf("source image is nil")
+	return ApplyCommandContext(context.Background(), img, commandName, args, nil)
+}
+
+// Handlers for the built-in commands, registered in commands.go. Each one
+// receives a private copy of the input and the normalized argument strings.
+// The slow ones take a context and a progress callback.
+
+func applyResize(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	w, h, linear, err := parseResizeArgs(args)
+	if err != nil {
+		return nil, err
 	}
-	src := ToNRGBA(img)
-	switch commandName {
-	case "resize":
-		if len(args) != 2 {
-			return nil, fmt.Errorf("resize requires 2 args: width height")
-		}
-		w, err := strconv.Atoi(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid width: %w", err)
-		}
-		h, err := strconv.Atoi(args[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid height: %w", err)
-		}
-		// use Lanczos a=3
-		out := ResampleLanczos(src, w, h, 3.0)
-		return out, nil
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return ResampleLanczos64Context(ctx, lin, w, h, 3.0, progress)
+		})
+	}
+	// use Lanczos a=3
+	return ResampleLanczosContext(ctx, src, w, h, 3.0, progress)
+}
 
-	case "rotate":
-		if len(args) != 1 {
-			return nil, fmt.Errorf("rotate requires 1 arg: degrees")
-		}
-		deg, err := strconv.ParseFloat(args[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid degrees: %w", err)
-		}
-		// do a simple rotate using inverse mapping with bilinear sampling
-		rad := deg * (math.Pi / 180.0)
-		cos := math.Cos(rad)
-		sin := math.Sin(rad)
-		// compute new bounds
-		w0 := src.Bounds().Dx()
-		h0 := src.Bounds().Dy()
-		// compute corners
-		cx := float64(w0) / 2.0
-		cy := float64(h0) / 2.0
-		// approximate new bounds by rotating corners
-		var xs [4]float64
-		var ys [4]float64
-		corners := [4][2]float64{{0 - cx, 0 - cy}, {float64(w0) - cx, 0 - cy}, {float64(w0) - cx, float64(h0) - cy}, {0 - cx, float64(h0) - cy}}
-		for i := 0; i < 4; i++ {
-			xs[i] = corners[i][0]*cos - corners[i][1]*sin
-			ys[i] = corners[i][0]*sin + corners[i][1]*cos
-		}
-		minX, maxX := xs[0], xs[0]
-		minY, maxY := ys[0], ys[0]
-		for i := 1; i < 4; i++ {
-			if xs[i] < minX {
-				minX = xs[i]
-			}
-			if xs[i] > maxX {
-				maxX = xs[i]
-			}
-			if ys[i] < minY {
-				minY = ys[i]
-			}
-			if ys[i] > maxY {
-				maxY = ys[i]
-			}
-		}
-		newW := int(math.Ceil(maxX - minX))
-		newH := int(math.Ceil(maxY - minY))
-		out := image.NewNRGBA(image.Rect(0, 0, newW, newH))
-		for y := 0; y < newH; y++ {
-			for x := 0; x < newW; x++ {
+func parseResizeArgs(args []string) (w, h int, linear bool, err error) {
+	if len(args) < 2 || len(args) > 3 {
+		return 0, 0, false, fmt.Errorf("resize requires 2 args: width height [linear]")
+	}
+	w, err = strconv.Atoi(args[0])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid width: %w", err)
+	}
+	h, err = strconv.Atoi(args[1])
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid height: %w", err)
+	}
+	linear, err = linearArg(args, 2)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid linear flag: %w", err)
+	}
+	return w, h, linear, nil
+}
+
+func applyRotate(src *image.NRGBA, args []string) (image.Image, error) {
+	deg, linear, err := parseRotateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return Rotate64(lin, deg), nil
+		})
+	}
+	return Rotate(src, deg), nil
+}
+
+func parseRotateArgs(args []string) (deg float64, linear bool, err error) {
+	if len(args) < 1 || len(args) > 2 {
+		return 0, false, fmt.Errorf("rotate requires 1 arg: degrees [linear]")
+	}
+	deg, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid degrees: %w", err)
+	}
+	linear, err = linearArg(args, 1)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid linear flag: %w", err)
+	}
+	return deg, linear, nil
+}
+
+// Rotate rotates src by deg degrees using inverse mapping with bilinear
+// sampling. The canvas grows to fit the rotated image.
+func Rotate(src *image.NRGBA, deg float64) *image.NRGBA {
+	g := newRotation(src.Bounds().Dx(), src.Bounds().Dy(), deg)
+	out := image.NewNRGBA(image.Rect(0, 0, g.w, g.h))
+	parallelRows(g.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < g.w; x++ {
 				// map dest pixel to source coordinate
-				xRel := float64(x) + minX
-				yRel := float64(y) + minY
-				sx := xRel*cos + yRel*sin + cx
-				sy := -xRel*sin + yRel*cos + cy
+				sx, sy := g.source(x, y)
 				rf, gf, bf, af := sampleBilinear(src, sx, sy)
 				i := out.PixOffset(x, y)
 				out.Pix[i+0] = uint8(clampFloatToUint8(rf))
@@ -98,292 +108,440 @@ func ApplyCommandStdlib(img image.Image, commandName string, args []string) (ima
 				out.Pix[i+3] = uint8(clampFloatToUint8(af))
 			}
 		}
-		return out, nil
+	})
+	return out
+}
 
-	case "blur":
-		// accept one arg: sigma
-		if len(args) < 1 {
-			return nil, fmt.Errorf("blur requires 1 arg: sigma")
-		}
-		sigma, err := strconv.ParseFloat(args[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid sigma: %w", err)
-		}
-		out := SeparableGaussianBlur(src, sigma)
-		return out, nil
+// rotation maps pixels of a rotated canvas back to the source image.
+type rotation struct {
+	cos, sin, cx, cy, minX, minY float64
+	w, h                         int
+}
 
-	case "medianFilter":
-		// medianFilter requires 1 arg: radius
-		if len(args) != 1 {
-			return nil, fmt.Errorf("medianFilter requires 1 arg: radius")
-		}
-		radius, err := strconv.Atoi(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid radius: %w", err)
-		}
-		out := MedianFilter(src, radius)
-		return out, nil
+func newRotation(w0, h0 int, deg float64) rotation {
+	rad := deg * (math.Pi / 180.0)
+	cos := math.Cos(rad)
+	sin := math.Sin(rad)
+	// compute corners
+	cx := float64(w0) / 2.0
+	cy := float64(h0) / 2.0
+	// approximate new bounds by rotating corners
+	var xs [4]float64
+	var ys [4]float64
+	corners := [4][2]float64{{0 - cx, 0 - cy}, {float64(w0) - cx, 0 - cy}, {float64(w0) - cx, float64(h0) - cy}, {0 - cx, float64(h0) - cy}}
+	for i := 0; i < 4; i++ {
+		xs[i] = corners[i][0]*cos - corners[i][1]*sin
+		ys[i] = corners[i][0]*sin + corners[i][1]*cos
+	}
+	minX, maxX := xs[0], xs[0]
+	minY, maxY := ys[0], ys[0]
+	for i := 1; i < 4; i++ {
+		minX = math.Min(minX, xs[i])
+		maxX = math.Max(maxX, xs[i])
+		minY = math.Min(minY, ys[i])
+		maxY = math.Max(maxY, ys[i])
+	}
+	return rotation{cos: cos, sin: sin, cx: cx, cy: cy, minX: minX, minY: minY,
+		w: int(math.Ceil(maxX - minX)), h: int(math.Ceil(maxY - minY))}
+}
 
-	case "despeckle":
-		// despeckle [radius]
-		radius := 1
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
-				radius = v
-			}
-		}
-		out := Despeckle(src, radius)
-		return out, nil
+// source returns the source coordinate that lands on canvas pixel (x, y).
+func (r rotation) source(x, y int) (sx, sy float64) {
+	xRel := float64(x) + r.minX
+	yRel := float64(y) + r.minY
+	return xRel*r.cos + yRel*r.sin + r.cx, -xRel*r.sin + yRel*r.cos + r.cy
+}
 
-	case "level":
-		// level requires 3 args: blackPoint gamma whitePoint
-		if len(args) != 3 {
-			return nil, fmt.Errorf("level requires 3 args: blackPoint gamma whitePoint")
-		}
-		blackPoint, err := strconv.ParseFloat(args[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid blackPoint: %w", err)
-		}
-		gamma, err := strconv.ParseFloat(args[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid gamma: %w", err)
-		}
-		whitePoint, err := strconv.ParseFloat(args[2], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid whitePoint: %w", err)
-		}
-		out := Level(src, blackPoint, gamma, whitePoint)
-		return out, nil
+func applyBlur(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	sigma, linear, err := parseBlurArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return SeparableGaussianBlur64Context(ctx, lin, sigma, progress)
+		})
+	}
+	return fromOrigin(src, func(src *image.NRGBA) (*image.NRGBA, error) {
+		// source, horizontal pass and result
+		if side := tileSide(src, gaussianRadius(sigma), 12); side > 0 {
+			return filterTiles(ctx, src, side, gaussianRadius(sigma), progress, func(ctx context.Context, tile *image.NRGBA) (*image.NRGBA, error) {
+				return SeparableGaussianBlurContext(ctx, tile, sigma, nil)
+			})
+		}
+		return SeparableGaussianBlurContext(ctx, src, sigma, progress)
+	})
+}
 
-	case "normalize":
-		// normalize takes no args
-		if len(args) != 0 {
-			return nil, fmt.Errorf("normalize takes no args")
-		}
-		out := Normalize(src)
-		return out, nil
+func parseBlurArgs(args []string) (sigma float64, linear bool, err error) {
+	// accept sigma and an optional linear flag
+	if len(args) < 1 {
+		return 0, false, fmt.Errorf("blur requires 1 arg: sigma")
+	}
+	sigma, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid sigma: %w", err)
+	}
+	linear, err = linearArg(args, 1)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid linear flag: %w", err)
+	}
+	return sigma, linear, nil
+}
 
-	case "autoLevel":
-		// autoLevel takes no args
-		if len(args) != 0 {
-			return nil, fmt.Errorf("autoLevel takes no args")
-		}
-		out := AutoLevel(src)
-		return out, nil
+func applyMedianFilter(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// medianFilter requires 1 arg: radius
+	if len(args) != 1 {
+		return nil, fmt.Errorf("medianFilter requires 1 arg: radius")
+	}
+	radius, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid radius: %w", err)
+	}
+	return medianFilter(ctx, src, radius, progress)
+}
 
-	case "autoGamma":
-		// autoGamma takes no args
-		if len(args) != 0 {
-			return nil, fmt.Errorf("autoGamma takes no args")
-		}
-		out := AutoGamma(src)
-		return out, nil
+// medianFilter is MedianFilterContext, in tiles when the image is over the
+// tile memory budget. Cropped images keep their position.
+func medianFilter(ctx context.Context, src *image.NRGBA, radius int, progress ProgressFunc) (*image.NRGBA, error) {
+	return fromOrigin(src, func(src *image.NRGBA) (*image.NRGBA, error) {
+		// source and result; a negative radius reaches no neighbours
+		halo := max(radius, 0)
+		if side := tileSide(src, halo, 8); side > 0 {
+			return filterTiles(ctx, src, side, halo, progress, func(ctx context.Context, tile *image.NRGBA) (*image.NRGBA, error) {
+				return MedianFilterContext(ctx, tile, radius, nil)
+			})
+		}
+		return MedianFilterContext(ctx, src, radius, progress)
+	})
+}
 
-	case "gamma":
-		// gamma requires 1 arg: gamma value
-		if len(args) != 1 {
-			return nil, fmt.Errorf("gamma requires 1 arg: gamma")
+func applyDespeckle(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// despeckle [radius]
+	radius := 1
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			radius = v
 		}
-		gammaVal, err := strconv.ParseFloat(args[0], 64)
+	}
+	// Despeckle is a median filter with a small radius
+	return medianFilter(ctx, src, radius, progress)
+}
+
+func applyLevel(src *image.NRGBA, args []string) (image.Image, error) {
+	blackPoint, gamma, whitePoint, err := parseLevelArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	out := Level(src, blackPoint, gamma, whitePoint)
+	return out, nil
+}
+
+func parseLevelArgs(args []string) (blackPoint, gamma, whitePoint float64, err error) {
+	// level requires 3 args: blackPoint gamma whitePoint
+	if len(args) != 3 {
+		return 0, 0, 0, fmt.Errorf("level requires 3 args: blackPoint gamma whitePoint")
+	}
+	blackPoint, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid blackPoint: %w", err)
+	}
+	gamma, err = strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid gamma: %w", err)
+	}
+	whitePoint, err = strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid whitePoint: %w", err)
+	}
+	return blackPoint, gamma, whitePoint, nil
+}
+
+func applyNormalize(src *image.NRGBA, args []string) (image.Image, error) {
+	// normalize takes no args
+	if len(args) != 0 {
+		return nil, fmt.Errorf("normalize takes no args")
+	}
+	out := Normalize(src)
+	return out, nil
+}
+
+func applyAutoLevel(src *image.NRGBA, args []string) (image.Image, error) {
+	// autoLevel takes no args
+	if len(args) != 0 {
+		return nil, fmt.Errorf("autoLevel takes no args")
+	}
+	out := AutoLevel(src)
+	return out, nil
+}
+
+func applyAutoGamma(src *image.NRGBA, args []string) (image.Image, error) {
+	// autoGamma takes no args
+	if len(args) != 0 {
+		return nil, fmt.Errorf("autoGamma takes no args")
+	}
+	out := AutoGamma(src)
+	return out, nil
+}
+
+func applyGamma(src *image.NRGBA, args []string) (image.Image, error) {
+	gammaVal, err := parseGammaArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	out := Gamma(src, gammaVal)
+	return out, nil
+}
+
+func parseGammaArgs(args []string) (float64, error) {
+	// gamma requires 1 arg: gamma value
+	if len(args) != 1 {
+		return 0, fmt.Errorf("gamma requires 1 arg: gamma")
+	}
+	gammaVal, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gamma: %w", err)
+	}
+	return gammaVal, nil
+}
+
+func applyNegate(src *image.NRGBA, args []string) (image.Image, error) {
+	onlyGray, err := parseNegateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	out := Negate(src, onlyGray)
+	return out, nil
+}
+
+func parseNegateArgs(args []string) (onlyGray bool, err error) {
+	// negate [onlyGray]
+	if len(args) >= 1 && args[0] != "" {
+		onlyGray, err = strconv.ParseBool(args[0])
 		if err != nil {
-			return nil, fmt.Errorf("invalid gamma: %w", err)
+			return false, fmt.Errorf("invalid onlyGray flag: %w", err)
 		}
-		out := Gamma(src, gammaVal)
-		return out, nil
+	}
+	return onlyGray, nil
+}
 
-	case "negate":
-		// negate [onlyGray]
-		onlyGray := false
-		if len(args) >= 1 && args[0] != "" {
-			b, err := strconv.ParseBool(args[0])
-			if err != nil {
-				return nil, fmt.Errorf("invalid onlyGray flag: %w", err)
-			}
-			onlyGray = b
-		}
-		out := Negate(src, onlyGray)
-		return out, nil
+func applyThreshold(src *image.NRGBA, args []string) (image.Image, error) {
+	threshVal, perChannel, err := parseThresholdArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	out := Threshold(src, threshVal, perChannel)
+	return out, nil
+}
 
-	case "threshold":
-		// threshold <value> [perChannel]
-		if len(args) < 1 {
-			return nil, fmt.Errorf("threshold requires at least 1 arg: value")
-		}
-		threshVal, err := strconv.ParseFloat(args[0], 64)
+func parseThresholdArgs(args []string) (threshVal float64, perChannel bool, err error) {
+	// threshold <value> [perChannel]
+	if len(args) < 1 {
+		return 0, false, fmt.Errorf("threshold requires at least 1 arg: value")
+	}
+	threshVal, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid threshold value: %w", err)
+	}
+	if len(args) >= 2 && args[1] != "" {
+		perChannel, err = strconv.ParseBool(args[1])
 		if err != nil {
-			return nil, fmt.Errorf("invalid threshold value: %w", err)
+			return 0, false, fmt.Errorf("invalid perChannel flag: %w", err)
 		}
-		perChannel := false
-		if len(args) >= 2 && args[1] != "" {
-			b, err := strconv.ParseBool(args[1])
+	}
+	return threshVal, perChannel, nil
+}
+
+func applyModulate(src *image.NRGBA, args []string) (image.Image, error) {
+	brightness, saturation, hue, err := parseModulateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Modulate(src, brightness, saturation, hue), nil
+}
+
+func parseModulateArgs(args []string) (brightness, saturation, hue float64, err error) {
+	// modulate requires 3 args: brightness percent, saturation percent, hue degrees
+	if len(args) != 3 {
+		return 0, 0, 0, fmt.Errorf("modulate requires 3 args: brightness saturation hue")
+	}
+	brightness, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid brightness: %w", err)
+	}
+	saturation, err = strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid saturation: %w", err)
+	}
+	hue, err = strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hue: %w", err)
+	}
+	return brightness, saturation, hue, nil
+}
+
+func applyVignette(src *image.NRGBA, args []string) (image.Image, error) {
+	radius, sigma, x, y, strength, err := parseVignetteArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Vignette(src, radius, sigma, x, y, strength), nil
+}
+
+func parseVignetteArgs(args []string) (radius, sigma float64, x, y int, strength float64, err error) {
+	// vignette requires 4 or 5 args: radius sigma x y [strength]
+	if len(args) < 4 {
+		return 0, 0, 0, 0, 0, fmt.Errorf("vignette requires 4 args: radius sigma x y [strength]")
+	}
+	radius, err = strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return 0, 0, 0, 0, 0, fmt.Errorf("invalid radius: %w", err)
+	}
+	sigma, err = strconv.ParseFloat(args[1], 64)
+	if err != nil {
+		return 0, 0, 0, 0, 0, fmt.Errorf("invalid sigma: %w", err)
+	}
+	x, err = strconv.Atoi(args[2])
+	if err != nil {
+		return 0, 0, 0, 0, 0, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err = strconv.Atoi(args[3])
+	if err != nil {
+		return 0, 0, 0, 0, 0, fmt.Errorf("invalid y: %w", err)
+	}
+	strength = 1.0
+	if len(args) >= 5 && args[4] != "" {
+		// support percent like "50%" or fraction like "0.5"
+		if args[4][len(args[4])-1] == '%' {
+			v, err := strconv.ParseFloat(args[4][:len(args[4])-1], 64)
 			if err != nil {
-				return nil, fmt.Errorf("invalid perChannel flag: %w", err)
+				return 0, 0, 0, 0, 0, fmt.Errorf("invalid strength percent: %w", err)
 			}
-			perChannel = b
-		}
-		out := Threshold(src, threshVal, perChannel)
-		return out, nil
-
-	case "modulate":
-		// modulate requires 3 args: brightness percent, saturation percent, hue degrees
-		if len(args) != 3 {
-			return nil, fmt.Errorf("modulate requires 3 args: brightness saturation hue")
-		}
-		brightness, err := strconv.ParseFloat(args[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid brightness: %w", err)
+			strength = v / 100.0
+		} else {
+			v, err := strconv.ParseFloat(args[4], 64)
+			if err != nil {
+				return 0, 0, 0, 0, 0, fmt.Errorf("invalid strength: %w", err)
+			}
+			strength = v
 		}
-		saturation, err := strconv.ParseFloat(args[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid saturation: %w", err)
+		if strength < 0 {
+			strength = 0
 		}
-		hue, err := strconv.ParseFloat(args[2], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid hue: %w", err)
+		if strength > 1 {
+			strength = 1
 		}
-		return Modulate(src, brightness, saturation, hue), nil
+	}
+	return radius, sigma, x, y, strength, nil
+}
 
-	case "vignette":
-		// vignette requires 4 or 5 args: radius sigma x y [strength]
-		if len(args) < 4 {
-			return nil, fmt.Errorf("vignette requires 4 args: radius sigma x y [strength]")
-		}
-		radius, err := strconv.ParseFloat(args[0], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid radius: %w", err)
-		}
-		sigma, err := strconv.ParseFloat(args[1], 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid sigma: %w", err)
-		}
-		x, err := strconv.Atoi(args[2])
-		if err != nil {
-			return nil, fmt.Errorf("invalid x: %w", err)
-		}
-		y, err := strconv.Atoi(args[3])
-		if err != nil {
-			return nil, fmt.Errorf("invalid y: %w", err)
-		}
-		strength := 1.0
-		if len(args) >= 5 && args[4] != "" {
-			// support percent like "50%" or fraction like "0.5"
-			if args[4][len(args[4])-1] == '%' {
-				v, err := strconv.ParseFloat(args[4][:len(args[4])-1], 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid strength percent: %w", err)
-				}
-				strength = v / 100.0
-			} else {
-				v, err := strconv.ParseFloat(args[4], 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid strength: %w", err)
-				}
-				strength = v
-			}
-			if strength < 0 {
-				strength = 0
+func applySepia(src *image.NRGBA, args []string) (image.Image, error) {
+	p, err := parseSepiaArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return SepiaTone(src, p.percentage, p.midtoneCenter, p.midtoneSigma, p.highlightThreshold, p.highlightSoftness, p.curve), nil
+}
+
+// sepiaArgs are the parsed arguments of sepia.
+type sepiaArgs struct {
+	percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve float64
+}
+
+func parseSepiaArgs(args []string) (sepiaArgs, error) {
+	// sepia [percentage midtoneCenter midtoneSigma highlightThreshold highlightSoftness curve]
+	// percentage accepts "50%" or "0.5" or "50"
+	percentage := 1.0
+	midtoneCenter := 50.0
+	midtoneSigma := 20.0
+	highlightThreshold := 80.0
+	highlightSoftness := 10.0
+	curve := 0.12
+	if len(args) >= 1 && args[0] != "" {
+		pstr := args[0]
+		if pstr[len(pstr)-1] == '%' {
+			v, err := strconv.ParseFloat(pstr[:len(pstr)-1], 64)
+			if err != nil {
+				return sepiaArgs{}, fmt.Errorf("invalid sepia percentage: %w", err)
 			}
-			if strength > 1 {
-				strength = 1
+			percentage = v / 100.0
+		} else {
+			v, err := strconv.ParseFloat(pstr, 64)
+			if err != nil {
+				return sepiaArgs{}, fmt.Errorf("invalid sepia percentage: %w", err)
 			}
-		}
-		out := Vignette(src, radius, sigma, x, y, strength)
-		return out, nil
-
-	case "sepia":
-		// sepia [percentage midtoneCenter midtoneSigma highlightThreshold highlightSoftness curve]
-		// percentage accepts "50%" or "0.5" or "50"
-		percentage := 1.0
-		midtoneCenter := 50.0
-		midtoneSigma := 20.0
-		highlightThreshold := 80.0
-		highlightSoftness := 10.0
-		curve := 0.12
-		if len(args) >= 1 && args[0] != "" {
-			pstr := args[0]
-			if pstr[len(pstr)-1] == '%' {
-				v, err := strconv.ParseFloat(pstr[:len(pstr)-1], 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid sepia percentage: %w", err)
-				}
+			if v > 1 {
 				percentage = v / 100.0
 			} else {
-				v, err := strconv.ParseFloat(pstr, 64)
-				if err != nil {
-					return nil, fmt.Errorf("invalid sepia percentage: %w", err)
-				}
-				if v > 1 {
-					percentage = v / 100.0
-				} else {
-					percentage = v
-				}
-			}
-			if percentage < 0 {
-				percentage = 0
-			}
-			if percentage > 1 {
-				percentage = 1
+				percentage = v
 			}
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.ParseFloat(args[1], 64); err == nil {
-				midtoneCenter = v
-			}
+		if percentage < 0 {
+			percentage = 0
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				midtoneSigma = v
-			}
+		if percentage > 1 {
+			percentage = 1
 		}
-		if len(args) >= 4 && args[3] != "" {
-			if v, err := strconv.ParseFloat(args[3], 64); err == nil {
-				highlightThreshold = v
-			}
-		}
-		if len(args) >= 5 && args[4] != "" {
-			if v, err := strconv.ParseFloat(args[4], 64); err == nil {
-				highlightSoftness = v
-			}
-		}
-		if len(args) >= 6 && args[5] != "" {
-			if v, err := strconv.ParseFloat(args[5], 64); err == nil {
-				curve = v
-			}
-		}
-		// clamp sensible ranges
-		if midtoneSigma <= 0 {
-			midtoneSigma = 1.0
-		}
-		if midtoneCenter < 0 {
-			midtoneCenter = 0
-		}
-		if midtoneCenter > 100 {
-			midtoneCenter = 100
-		}
-		if highlightThreshold < 0 {
-			highlightThreshold = 0
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.ParseFloat(args[1], 64); err == nil {
+			midtoneCenter = v
 		}
-		if highlightThreshold > 100 {
-			highlightThreshold = 100
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			midtoneSigma = v
 		}
-		if highlightSoftness < 0 {
-			highlightSoftness = 0
+	}
+	if len(args) >= 4 && args[3] != "" {
+		if v, err := strconv.ParseFloat(args[3], 64); err == nil {
+			highlightThreshold = v
 		}
-		if curve < 0 {
-			curve = 0
+	}
+	if len(args) >= 5 && args[4] != "" {
+		if v, err := strconv.ParseFloat(args[4], 64); err == nil {
+			highlightSoftness = v
 		}
-		if curve > 1 {
-			curve = 1
+	}
+	if len(args) >= 6 && args[5] != "" {
+		if v, err := strconv.ParseFloat(args[5], 64); err == nil {
+			curve = v
 		}
-		out := SepiaTone(src, percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve)
-		return out, nil
-
-	case "grayscale":
+	}
+	// clamp sensible ranges
+	if midtoneSigma <= 0 {
+		midtoneSigma = 1.0
+	}
+	if midtoneCenter < 0 {
+		midtoneCenter = 0
+	}
+	if midtoneCenter > 100 {
+		midtoneCenter = 100
+	}
+	if highlightThreshold < 0 {
+		highlightThreshold = 0
+	}
+	if highlightThreshold > 100 {
+		highlightThreshold = 100
+	}
+	if highlightSoftness < 0 {
+		highlightSoftness = 0
+	}
+	if curve < 0 {
+		curve = 0
+	}
+	if curve > 1 {
+		curve = 1
+	}
+	return sepiaArgs{percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve}, nil
+}
 
-		// simple luminance conversion
-		b := src.Bounds()
-		out := image.NewNRGBA(b)
-		for y := b.Min.Y; y < b.Max.Y; y++ {
+func applyGrayscale(src *image.NRGBA, args []string) (image.Image, error) {
+	// simple luminance conversion
+	b := src.Bounds()
+	out := image.NewNRGBA(b)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
 			for x := b.Min.X; x < b.Max.X; x++ {
 				i := src.PixOffset(x, y)
 				r := src.Pix[i+0]
@@ -398,424 +556,655 @@ func ApplyCommandStdlib(img image.Image, commandName string, args []string) (ima
 				out.Pix[i+3] = a
 			}
 		}
-		return out, nil
-
-	case "edge":
-		// edge [sigma] [scale] [threshold] [binary]
-		// examples: "edge 0.0 1.0 0.0 false"
-		sigma := 0.0
-		scale := 1.0
-		threshold := 0.0
-		binary := false
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.ParseFloat(args[0], 64); err == nil {
-				sigma = v
-			}
-		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.ParseFloat(args[1], 64); err == nil {
-				scale = v
-			}
-		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				threshold = v
-			}
-		}
-		if len(args) >= 4 && args[3] != "" {
-			if b, err := strconv.ParseBool(args[3]); err == nil {
-				binary = b
-			}
-		}
-		out := EdgeEx(src, sigma, scale, threshold, binary)
-		return out, nil
-
-	case "adaptiveBlur":
-		// adaptiveBlur [radius] [sigmaMin] [sigmaMax] [levels]
-		// defaults: radius=1.0, sigmaMin=0.5, sigmaMax=1.0, levels=6
-		radius := 1.0
-		sigmaMin := 0.5
-		sigmaMax := 1.0
-		levels := 6
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.ParseFloat(args[0], 64); err == nil {
-				radius = v
-			}
+	})
+	return out, nil
+}
+
+func applyEdge(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// edge [sigma] [scale] [threshold] [binary]
+	// examples: "edge 0.0 1.0 0.0 false"
+	sigma := 0.0
+	scale := 1.0
+	threshold := 0.0
+	binary := false
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.ParseFloat(args[0], 64); err == nil {
+			sigma = v
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.ParseFloat(args[1], 64); err == nil {
-				sigmaMin = v
-			}
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.ParseFloat(args[1], 64); err == nil {
+			scale = v
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				sigmaMax = v
-			}
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			threshold = v
 		}
-		if len(args) >= 4 && args[3] != "" {
-			if v, err := strconv.Atoi(args[3]); err == nil && v > 0 {
-				levels = v
-			}
+	}
+	if len(args) >= 4 && args[3] != "" {
+		if b, err := strconv.ParseBool(args[3]); err == nil {
+			binary = b
 		}
-		out := AdaptiveBlurPerPixel(src, radius, sigmaMin, sigmaMax, levels)
-		return out, nil
-
-	case "adaptiveResize":
-		// adaptiveResize [width] [height] [a]
-		width := 0
-		height := 0
-		a := 3.0
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.Atoi(args[0]); err == nil {
-				width = v
-			}
+	}
+	return fromOrigin(src, func(src *image.NRGBA) (*image.NRGBA, error) {
+		// source, blur passes and float64 magnitudes
+		if side := tileSide(src, gaussianRadius(sigma)+1, 20); side > 0 {
+			return edgeTiles(ctx, src, side, sigma, scale, threshold, binary, progress)
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.Atoi(args[1]); err == nil {
-				height = v
-			}
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				a = v
-			}
+		return EdgeEx(src, sigma, scale, threshold, binary), nil
+	})
+}
+
+func applyAdaptiveBlur(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// adaptiveBlur [radius] [sigmaMin] [sigmaMax] [levels]
+	// defaults: radius=1.0, sigmaMin=0.5, sigmaMax=1.0, levels=6
+	radius := 1.0
+	sigmaMin := 0.5
+	sigmaMax := 1.0
+	levels := 6
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.ParseFloat(args[0], 64); err == nil {
+			radius = v
 		}
-		out := AdaptiveResize(src, width, height, a)
-		return out, nil
-
-	case "adaptiveSharpen":
-		// adaptiveSharpen [radius] [sigma] [amount]
-		radius := 0.0
-		sigma := 1.0
-		amount := 1.0
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.ParseFloat(args[0], 64); err == nil {
-				radius = v
-			}
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.ParseFloat(args[1], 64); err == nil {
+			sigmaMin = v
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.ParseFloat(args[1], 64); err == nil {
-				sigma = v
-			}
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			sigmaMax = v
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				amount = v
-			}
+	}
+	if len(args) >= 4 && args[3] != "" {
+		if v, err := strconv.Atoi(args[3]); err == nil && v > 0 {
+			levels = v
 		}
-		out := AdaptiveSharpen(src, radius, sigma, amount)
-		return out, nil
-
-	case "adaptiveThreshold":
-		// adaptiveThreshold [window_width] [window_height] [offset]
-		ww := 15
-		wh := 15
-		off := 0.0
-		if len(args) >= 1 && args[0] != "" {
-			if v, err := strconv.Atoi(args[0]); err == nil {
-				ww = v
-			}
+	}
+	return AdaptiveBlurPerPixelContext(ctx, src, radius, sigmaMin, sigmaMax, levels, progress)
+}
+
+func applyAdaptiveResize(src *image.NRGBA, args []string) (image.Image, error) {
+	width, height, a, linear, err := parseAdaptiveResizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return AdaptiveResize64(lin, width, height, a), nil
+		})
+	}
+	out := AdaptiveResize(src, width, height, a)
+	return out, nil
+}
+
+func parseAdaptiveResizeArgs(args []string) (width, height int, a float64, linear bool, err error) {
+	// adaptiveResize [width] [height] [a] [linear]
+	a = 3.0
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.Atoi(args[0]); err == nil {
+			width = v
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.Atoi(args[1]); err == nil {
-				wh = v
-			}
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.Atoi(args[1]); err == nil {
+			height = v
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseFloat(args[2], 64); err == nil {
-				off = v
-			}
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			a = v
 		}
-		out := AdaptiveThreshold(src, ww, wh, off)
-		return out, nil
+	}
+	linear, err = linearArg(args, 3)
+	if err != nil {
+		return 0, 0, 0, false, fmt.Errorf("invalid linear flag: %w", err)
+	}
+	return width, height, a, linear, nil
+}
 
-	case "addNoise":
-		// addNoise [type] [amount] [seed]
-		typ := "GAUSSIAN"
-		amt := 10.0
-		seed := int64(0)
-		if len(args) >= 1 && args[0] != "" {
-			typ = args[0]
+func applyAdaptiveSharpen(src *image.NRGBA, args []string) (image.Image, error) {
+	// adaptiveSharpen [radius] [sigma] [amount]
+	radius := 0.0
+	sigma := 1.0
+	amount := 1.0
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.ParseFloat(args[0], 64); err == nil {
+			radius = v
 		}
-		if len(args) >= 2 && args[1] != "" {
-			if v, err := strconv.ParseFloat(args[1], 64); err == nil {
-				amt = v
-			}
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.ParseFloat(args[1], 64); err == nil {
+			sigma = v
 		}
-		if len(args) >= 3 && args[2] != "" {
-			if v, err := strconv.ParseInt(args[2], 10, 64); err == nil {
-				seed = v
-			}
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			amount = v
 		}
-		out := AddNoise(src, typ, amt, seed)
-		return out, nil
+	}
+	out := AdaptiveSharpen(src, radius, sigma, amount)
+	return out, nil
+}
 
-	case "crop":
-		if len(args) != 4 {
-			return nil, fmt.Errorf("crop requires 4 args: width height x y")
+func applyAdaptiveThreshold(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// adaptiveThreshold [window_width] [window_height] [offset] [method] [k] [R]
+	ww := 15
+	wh := 15
+	off := 0.0
+	if len(args) >= 1 && args[0] != "" {
+		if v, err := strconv.Atoi(args[0]); err == nil {
+			ww = v
 		}
-		w, err := strconv.Atoi(args[0])
-		if err != nil {
-			return nil, fmt.Errorf("invalid width: %w", err)
-		}
-		h, err := strconv.Atoi(args[1])
-		if err != nil {
-			return nil, fmt.Errorf("invalid height: %w", err)
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.Atoi(args[1]); err == nil {
+			wh = v
 		}
-		x0, err := strconv.Atoi(args[2])
-		if err != nil {
-			return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseFloat(args[2], 64); err == nil {
+			off = v
 		}
-		y0, err := strconv.Atoi(args[3])
-		if err != nil {
-			return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	method := ThresholdMean
+	if len(args) >= 4 && args[3] != "" {
+		method = strings.ToLower(args[3])
+		if !slices.Contains(ThresholdMethods, method) {
+			return nil, fmt.Errorf("unknown threshold method %q (want %s)", args[3], strings.Join(ThresholdMethods, ", "))
 		}
-		rect := image.Rect(x0, y0, x0+w, y0+h).Intersect(src.Bounds())
-		out := image.NewNRGBA(rect)
-		draw.Draw(out, rect.Sub(rect.Min), src, rect.Min, draw.Src)
-		return out, nil
-
-	case "flip":
-		b := src.Bounds()
-		out := image.NewNRGBA(b)
-		w := b.Dx()
-		h := b.Dy()
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				srcIdx := src.PixOffset(x, y)
-				dstIdx := out.PixOffset(x, h-1-y)
-				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
-			}
+	}
+	vals := []float64{DefaultThresholdK(method), 128}
+	if len(args) > 4 {
+		if err := parseOptionalFloats(args[4:], []string{"k", "R"}, vals); err != nil {
+			return nil, err
 		}
-		return out, nil
-
-	case "flop":
-		b := src.Bounds()
-		out := image.NewNRGBA(b)
-		w := b.Dx()
-		h := b.Dy()
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				srcIdx := src.PixOffset(x, y)
-				dstIdx := out.PixOffset(w-1-x, y)
-				copy(out.Pix[dstIdx:dstIdx+4], src.Pix[srcIdx:srcIdx+4])
-			}
+	}
+	if ww <= 0 {
+		ww = 15
+	}
+	if wh <= 0 {
+		wh = 15
+	}
+	threshold := func(img *image.NRGBA) *image.NRGBA {
+		return AdaptiveThresholdEx(img, ww, wh, off, method, vals[0], vals[1])
+	}
+	return fromOrigin(src, func(src *image.NRGBA) (*image.NRGBA, error) {
+		// source, luminance, integral images and result
+		if side := tileSide(src, max(ww, wh)/2, 28); side > 0 {
+			return filterTiles(ctx, src, side, max(ww, wh)/2, progress, func(_ context.Context, tile *image.NRGBA) (*image.NRGBA, error) {
+				return threshold(tile), nil
+			})
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return threshold(src), nil
+	})
+}
+
+func applyAddNoise(src *image.NRGBA, args []string) (image.Image, error) {
+	// addNoise [type] [amount] [seed]
+	typ := "GAUSSIAN"
+	amt := 10.0
+	seed := int64(0)
+	if len(args) >= 1 && args[0] != "" {
+		typ = args[0]
+	}
+	if len(args) >= 2 && args[1] != "" {
+		if v, err := strconv.ParseFloat(args[1], 64); err == nil {
+			amt = v
 		}
-		return out, nil
-
-	case "histogram":
-		// optional args: bins [pixelWindow]
-		// bins: number of histogram bins (e.g. 256)
-		// pixelWindow: smoothing window (pixels) used when computing the smoothed max / vertical scaling.
-		//   - decreasing the window will "zoom out" (more fine detail / narrow spikes visible)
-		//   - increasing the window will "zoom in" (more smoothing / narrow spikes suppressed)
-		bins := 256
-		if len(args) > 0 && args[0] != "" {
-			if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
-				bins = v
-			}
+	}
+	if len(args) >= 3 && args[2] != "" {
+		if v, err := strconv.ParseInt(args[2], 10, 64); err == nil {
+			seed = v
 		}
-		// If a pixelWindow was provided, update the package-level HistogramSmoothWindow.
-		if len(args) > 1 && args[1] != "" {
-			if v, err := strconv.Atoi(args[1]); err == nil && v > 0 {
-				HistogramSmoothWindow = v
-			}
+	}
+	out := AddNoise(src, typ, amt, seed)
+	return out, nil
+}
+
+func applyCrop(src *image.NRGBA, args []string) (image.Image, error) {
+	rect, err := parseCropArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	rect = rect.Intersect(src.Bounds())
+	out := image.NewNRGBA(rect)
+	draw.Draw(out, rect.Sub(rect.Min), src, rect.Min, draw.Src)
+	return out, nil
+}
+
+func parseCropArgs(args []string) (image.Rectangle, error) {
+	if len(args) != 4 {
+		return image.Rectangle{}, fmt.Errorf("crop requires 4 args: width height x y")
+	}
+	w, err := strconv.Atoi(args[0])
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid width: %w", err)
+	}
+	h, err := strconv.Atoi(args[1])
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid height: %w", err)
+	}
+	x0, err := strconv.Atoi(args[2])
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid x: %w", err)
+	}
+	y0, err := strconv.Atoi(args[3])
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("invalid y: %w", err)
+	}
+	return image.Rect(x0, y0, x0+w, y0+h), nil
+}
+
+func applyFlip(src *image.NRGBA, args []string) (image.Image, error) {
+	return FlipNRGBA(src), nil
+}
+
+func applyFlop(src *image.NRGBA, args []string) (image.Image, error) {
+	return FlopNRGBA(src), nil
+}
+
+func applyHistogram(src *image.NRGBA, args []string) (image.Image, error) {
+	// optional args: bins [pixelWindow]
+	// bins: number of histogram bins (e.g. 256)
+	// pixelWindow: smoothing window (pixels) used when computing the smoothed max / vertical scaling.
+	//   - decreasing the window will "zoom out" (more fine detail / narrow spikes visible)
+	//   - increasing the window will "zoom in" (more smoothing / narrow spikes suppressed)
+	bins := 256
+	if len(args) > 0 && args[0] != "" {
+		if v, err := strconv.Atoi(args[0]); err == nil && v > 0 {
+			bins = v
 		}
-		rHist, gHist, bHist := ComputeHistogram(src, bins)
-		// Render a small histogram PNG and return as image
-		histImg := RenderHistogramImage(rHist, gHist, bHist, 1024, 240)
-		return histImg, nil
-
-	case "equalize":
-		out := Equalize(src)
-		return out, nil
-
-	case "trim":
-		// trim requires 1 arg: fuzz
-		if len(args) < 1 {
-			return nil, fmt.Errorf("trim requires 1 arg: fuzz")
-		}
-		// support percent or numeric
-		fuzzStr := args[0]
-		fuzz := 0.0
-		if len(fuzzStr) > 0 && fuzzStr[len(fuzzStr)-1] == '%' {
-			v, err := strconv.ParseFloat(fuzzStr[:len(fuzzStr)-1], 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid fuzz percent: %w", err)
-			}
-			fuzz = v * 255.0 / 100.0
-		} else {
-			v, err := strconv.ParseFloat(fuzzStr, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid fuzz: %w", err)
-			}
-			fuzz = v
-		}
-		out := Trim(src, fuzz)
-		return out, nil
-
-	case "annotate":
-		// annotate text [fontPath] size x y color
-		if !(len(args) == 5 || len(args) == 6) {
-			return nil, fmt.Errorf("annotate requires 5 args: text size x y color or 6 args: text fontPath size x y color")
-		}
-		var text, fontPath, sizeStr, colorStr string
-		var x, y int
-		var size float64
-		if len(args) == 5 {
-			text = args[0]
-			sizeStr = args[1]
-			// parse x y
-			tmpX, err := strconv.Atoi(args[2])
-			if err != nil {
-				return nil, fmt.Errorf("invalid x: %w", err)
-			}
-			tmpY, err := strconv.Atoi(args[3])
-			if err != nil {
-				return nil, fmt.Errorf("invalid y: %w", err)
-			}
-			x = tmpX
-			y = tmpY
-			colorStr = args[4]
-		} else {
-			// 6 args
-			text = args[0]
-			fontPath = args[1]
-			sizeStr = args[2]
-			tmpX, err := strconv.Atoi(args[3])
-			if err != nil {
-				return nil, fmt.Errorf("invalid x: %w", err)
-			}
-			tmpY, err := strconv.Atoi(args[4])
-			if err != nil {
-				return nil, fmt.Errorf("invalid y: %w", err)
-			}
-			x = tmpX
-			y = tmpY
-			colorStr = args[5]
+	}
+	// If a pixelWindow was provided, update the package-level HistogramSmoothWindow.
+	if len(args) > 1 && args[1] != "" {
+		if v, err := strconv.Atoi(args[1]); err == nil && v > 0 {
+			HistogramSmoothWindow = v
 		}
-		size, err := strconv.ParseFloat(sizeStr, 64)
+	}
+	rHist, gHist, bHist := ComputeHistogram(src, bins)
+	// Render a small histogram PNG and return as image
+	histImg := RenderHistogramImage(rHist, gHist, bHist, 1024, 240)
+	return histImg, nil
+}
+
+func applyCompare(src *image.NRGBA, args []string) (image.Image, error) {
+	// compare imagePath [scale]
+	if len(args) < 1 || args[0] == "" {
+		return nil, fmt.Errorf("compare requires 1 arg: imagePath")
+	}
+	vals := []float64{10}
+	if err := parseOptionalFloats(args[1:], []string{"scale"}, vals); err != nil {
+		return nil, err
+	}
+	other, err := loadSourceImage(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return DifferenceMap(src, ToNRGBA(other), vals[0])
+}
+
+func applyEqualize(src *image.NRGBA, args []string) (image.Image, error) {
+	out := Equalize(src)
+	return out, nil
+}
+
+func applyTrim(src *image.NRGBA, args []string) (image.Image, error) {
+	// trim requires 1 arg: fuzz
+	if len(args) < 1 {
+		return nil, fmt.Errorf("trim requires 1 arg: fuzz")
+	}
+	// support percent or numeric
+	fuzzStr := args[0]
+	fuzz := 0.0
+	if len(fuzzStr) > 0 && fuzzStr[len(fuzzStr)-1] == '%' {
+		v, err := strconv.ParseFloat(fuzzStr[:len(fuzzStr)-1], 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid size: %w", err)
+			return nil, fmt.Errorf("invalid fuzz percent: %w", err)
 		}
-		col, err := parseHexColor(colorStr)
+		fuzz = v * 255.0 / 100.0
+	} else {
+		v, err := strconv.ParseFloat(fuzzStr, 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid color: %w", err)
+			return nil, fmt.Errorf("invalid fuzz: %w", err)
 		}
-		out, err := Annotate(src, text, fontPath, size, x, y, col)
-		return out, err
+		fuzz = v
+	}
+	out := Trim(src, fuzz)
+	return out, nil
+}
 
-	case "composite":
-		// composite srcImagePath composeOperator x y
-		if len(args) != 4 {
-			return nil, fmt.Errorf("composite requires 4 args: srcImagePath operator x y")
-		}
-		srcPath := args[0]
-		op := args[1]
-		xOff, err := strconv.Atoi(args[2])
+func applyAnnotate(src *image.NRGBA, args []string) (image.Image, error) {
+	// annotate text [fontPath] size x y color
+	if !(len(args) == 5 || len(args) == 6) {
+		return nil, fmt.Errorf("annotate requires 5 args: text size x y color or 6 args: text fontPath size x y color")
+	}
+	var text, fontPath, sizeStr, colorStr string
+	var x, y int
+	var size float64
+	if len(args) == 5 {
+		text = args[0]
+		sizeStr = args[1]
+		// parse x y
+		tmpX, err := strconv.Atoi(args[2])
 		if err != nil {
 			return nil, fmt.Errorf("invalid x: %w", err)
 		}
-		yOff, err := strconv.Atoi(args[3])
+		tmpY, err := strconv.Atoi(args[3])
 		if err != nil {
 			return nil, fmt.Errorf("invalid y: %w", err)
 		}
-		f, err := os.Open(srcPath)
+		x = tmpX
+		y = tmpY
+		colorStr = args[4]
+	} else {
+		// 6 args
+		text = args[0]
+		fontPath = args[1]
+		sizeStr = args[2]
+		tmpX, err := strconv.Atoi(args[3])
 		if err != nil {
-			return nil, fmt.Errorf("failed to open composite source: %w", err)
+			return nil, fmt.Errorf("invalid x: %w", err)
 		}
-		defer f.Close()
-		img2, _, err := image.Decode(f)
+		tmpY, err := strconv.Atoi(args[4])
 		if err != nil {
-			return nil, fmt.Errorf("failed to decode composite source: %w", err)
-		}
-		out := Composite(src, img2, op, xOff, yOff)
-		return out, nil
-
-	case "floodfillPaint":
-		// floodfillPaint fillColor fuzz borderColor x y [invert]
-		if len(args) < 5 {
-			return nil, fmt.Errorf("floodfillPaint requires at least 5 args: fillColor fuzz borderColor x y [invert]")
-		}
-		fillStr := args[0]
-		fuzzStr := args[1]
-		borderStr := args[2]
-		xStr := args[3]
-		yStr := args[4]
-		inv := false
-		if len(args) >= 6 && args[5] != "" {
-			b, err := strconv.ParseBool(args[5])
-			if err != nil {
-				return nil, fmt.Errorf("invalid invert flag: %w", err)
-			}
-			inv = b
+			return nil, fmt.Errorf("invalid y: %w", err)
 		}
-		fillCol, err := parseHexColor(fillStr)
+		x = tmpX
+		y = tmpY
+		colorStr = args[5]
+	}
+	size, err := strconv.ParseFloat(sizeStr, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid size: %w", err)
+	}
+	col, err := parseHexColor(colorStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid color: %w", err)
+	}
+	out, err := Annotate(src, text, fontPath, size, x, y, col)
+	return out, err
+}
+
+func applyComposite(src *image.NRGBA, args []string) (image.Image, error) {
+	c, err := parseCompositeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return Composite64(lin, toLinear64(c.img), c.op, c.x, c.y), nil
+		})
+	}
+	out := Composite(src, c.img, c.op, c.x, c.y)
+	return out, nil
+}
+
+// compositeArgs are the parsed arguments of composite, with the source
+// image already loaded.
+type compositeArgs struct {
+	img    image.Image
+	op     string
+	x, y   int
+	linear bool
+}
+
+func parseCompositeArgs(args []string) (compositeArgs, error) {
+	// composite srcImagePath composeOperator x y [linear]
+	if len(args) < 4 || len(args) > 5 {
+		return compositeArgs{}, fmt.Errorf("composite requires 4 args: srcImagePath operator x y [linear]")
+	}
+	c := compositeArgs{op: args[1]}
+	var err error
+	c.x, err = strconv.Atoi(args[2])
+	if err != nil {
+		return compositeArgs{}, fmt.Errorf("invalid x: %w", err)
+	}
+	c.y, err = strconv.Atoi(args[3])
+	if err != nil {
+		return compositeArgs{}, fmt.Errorf("invalid y: %w", err)
+	}
+	c.linear, err = linearArg(args, 4)
+	if err != nil {
+		return compositeArgs{}, fmt.Errorf("invalid linear flag: %w", err)
+	}
+	c.img, err = loadSourceImage(args[0])
+	if err != nil {
+		return compositeArgs{}, fmt.Errorf("composite source: %w", err)
+	}
+	return c, nil
+}
+
+func applyFloodfillPaint(src *image.NRGBA, args []string) (image.Image, error) {
+	// floodfillPaint fillColor fuzz borderColor x y [invert]
+	if len(args) < 5 {
+		return nil, fmt.Errorf("floodfillPaint requires at least 5 args: fillColor fuzz borderColor x y [invert]")
+	}
+	fillStr := args[0]
+	fuzzStr := args[1]
+	borderStr := args[2]
+	xStr := args[3]
+	yStr := args[4]
+	inv := false
+	if len(args) >= 6 && args[5] != "" {
+		b, err := strconv.ParseBool(args[5])
 		if err != nil {
-			return nil, fmt.Errorf("invalid fill color: %w", err)
+			return nil, fmt.Errorf("invalid invert flag: %w", err)
 		}
-		borderCol := color.NRGBA{0, 0, 0, 0}
-		if borderStr != "" {
-			bc, err := parseHexColor(borderStr)
-			if err != nil {
-				return nil, fmt.Errorf("invalid border color: %w", err)
-			}
-			if c, ok := bc.(color.NRGBA); ok {
-				borderCol = c
-			} else {
-				r, g, b, a := bc.RGBA()
-				borderCol = color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
-			}
-		}
-		// convert fillCol to NRGBA
-		var fillColNRGBA color.NRGBA
-		if c, ok := fillCol.(color.NRGBA); ok {
-			fillColNRGBA = c
-		} else {
-			r, g, b, a := fillCol.RGBA()
-			fillColNRGBA = color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
-		}
-		// parse fuzz (interpreted as Lab Delta-E). Support percent like "50%" mapping to 0..100, or numeric deltaE.
-		fuzz := 0.0
-		if len(fuzzStr) > 0 && fuzzStr[len(fuzzStr)-1] == '%' {
-			v, err := strconv.ParseFloat(fuzzStr[:len(fuzzStr)-1], 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid fuzz percent: %w", err)
-			}
-			// percent maps to 0..100 deltaE
-			fuzz = v
-		} else {
-			v, err := strconv.ParseFloat(fuzzStr, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid fuzz: %w", err)
-			}
-			fuzz = v
-		}
-		if fuzz < 0 {
-			fuzz = 0
-		}
-		if fuzz > 200 {
-			fuzz = 200
+		inv = b
+	}
+	fillCol, err := parseHexColor(fillStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fill color: %w", err)
+	}
+	borderCol := color.NRGBA{0, 0, 0, 0}
+	if borderStr != "" {
+		bc, err := parseHexColor(borderStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid border color: %w", err)
 		}
-		x0, err := strconv.Atoi(xStr)
+		borderCol = toNRGBAColor(bc)
+	}
+	fillColNRGBA := toNRGBAColor(fillCol)
+	fuzz, err := parseDeltaE(fuzzStr)
+	if err != nil {
+		return nil, err
+	}
+	x0, err := strconv.Atoi(xStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y0, err := strconv.Atoi(yStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	out := FloodfillPaint(src, fillColNRGBA, fuzz, borderCol, x0, y0, inv)
+	return out, nil
+}
+
+func applySelectColor(src *image.NRGBA, args []string) (image.Image, error) {
+	// selectColor x y fuzz
+	if len(args) != 3 {
+		return nil, fmt.Errorf("selectColor requires 3 args: x y fuzz")
+	}
+	x, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid x: %w", err)
+	}
+	y, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid y: %w", err)
+	}
+	fuzz, err := parseDeltaE(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return SelectByColorAt(src, x, y, fuzz), nil
+}
+
+func applySelectSimilar(src *image.NRGBA, args []string) (image.Image, error) {
+	// selectSimilar color fuzz
+	if len(args) != 2 {
+		return nil, fmt.Errorf("selectSimilar requires 2 args: color fuzz")
+	}
+	c, err := parseHexColor(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid color: %w", err)
+	}
+	fuzz, err := parseDeltaE(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return SelectSimilar(src, toNRGBAColor(c), fuzz), nil
+}
+
+// toNRGBAColor converts c to color.NRGBA by taking the high byte of each
+// channel.
+func toNRGBAColor(c color.Color) color.NRGBA {
+	if n, ok := c.(color.NRGBA); ok {
+		return n
+	}
+	r, g, b, a := c.RGBA()
+	return color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+}
+
+// parseDeltaE parses a fuzz tolerance in Lab delta-E units, clamped to
+// 0..200. A trailing percent sign is accepted, with 100% meaning 100.
+func parseDeltaE(s string) (float64, error) {
+	fuzz := 0.0
+	if len(s) > 0 && s[len(s)-1] == '%' {
+		v, err := strconv.ParseFloat(s[:len(s)-1], 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid x: %w", err)
+			return 0, fmt.Errorf("invalid fuzz percent: %w", err)
 		}
-		y0, err := strconv.Atoi(yStr)
+		// percent maps to 0..100 deltaE
+		fuzz = v
+	} else {
+		v, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid y: %w", err)
+			return 0, fmt.Errorf("invalid fuzz: %w", err)
 		}
-		out := FloodfillPaint(src, fillColNRGBA, fuzz, borderCol, x0, y0, inv)
-		return out, nil
+		fuzz = v
+	}
+	if fuzz < 0 {
+		fuzz = 0
+	}
+	if fuzz > 200 {
+		fuzz = 200
+	}
+	return fuzz, nil
+}
+
+func applyIdentify(src *image.NRGBA, args []string) (image.Image, error) {
+	return nil, nil
+}
 
-	case "identify":
-		return nil, nil
+func applyStrip(src *image.NRGBA, args []string) (image.Image, error) {
+	// No-op for stdlib: re-encoding will drop metadata at save time if this isn't run
+	return src, nil
+}
 
-	case "strip":
-		// No-op for stdlib: re-encoding will drop metadata at save time if this isn't run
-		return src, nil
+func applyPosterize(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	levels, dither, err := parsePosterizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return posterizeContext(ctx, src, levels, dither, progress)
+}
 
-	default:
-		return nil, fmt.Errorf("unsupported command in stdlib engine: %s", commandName)
+func parsePosterizeArgs(args []string) (levels int, dither string, err error) {
+	// posterize levels [dither]
+	if len(args) < 1 {
+		return 0, "", fmt.Errorf("posterize requires 1 arg: levels")
+	}
+	levels, err = strconv.Atoi(args[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid levels: %w", err)
+	}
+	if len(args) >= 2 {
+		dither = args[1]
+	}
+	dither, err = parseDither(dither)
+	if err != nil {
+		return 0, "", err
+	}
+	return levels, dither, nil
+}
+
+func applyQuantize(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// quantize colors [dither] [method]
+	if len(args) < 1 {
+		return nil, fmt.Errorf("quantize requires 1 arg: colors")
+	}
+	colors, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid colors: %w", err)
+	}
+	dither, method := "", ""
+	if len(args) >= 2 {
+		dither = args[1]
+	}
+	if len(args) >= 3 {
+		method = args[2]
+	}
+	return quantizeContext(ctx, src, colors, method, dither, progress)
+}
+
+func applySharpen(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// sharpen [radius] [sigma]
+	vals := []float64{0, 1.0}
+	if err := parseOptionalFloats(args, []string{"radius", "sigma"}, vals); err != nil {
+		return nil, err
+	}
+	return unsharpMask(ctx, src, vals[0], vals[1], 1.0, 0.0, progress)
+}
+
+func applyUnsharpMask(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+	// unsharpMask [radius] [sigma] [amount] [threshold]
+	vals := []float64{0, 1.0, 1.0, 0}
+	if err := parseOptionalFloats(args, []string{"radius", "sigma", "amount", "threshold"}, vals); err != nil {
+		return nil, err
+	}
+	return unsharpMask(ctx, src, vals[0], vals[1], vals[2], vals[3], progress)
+}
+
+// unsharpMask is UnsharpMask, in tiles when the image is over the tile
+// memory budget. Cropped images keep their position.
+func unsharpMask(ctx context.Context, src *image.NRGBA, radius, sigma, amount, threshold float64, progress ProgressFunc) (*image.NRGBA, error) {
+	return fromOrigin(src, func(src *image.NRGBA) (*image.NRGBA, error) {
+		// source, blur passes and result
+		if side := tileSide(src, gaussianRadius(sigma), 16); side > 0 {
+			return filterTiles(ctx, src, side, gaussianRadius(sigma), progress, func(_ context.Context, tile *image.NRGBA) (*image.NRGBA, error) {
+				return UnsharpMask(tile, radius, sigma, amount, threshold), nil
+			})
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return UnsharpMask(src, radius, sigma, amount, threshold), nil
+	})
+}
+
+// parseOptionalFloats overwrites vals[i] with args[i] for every non-empty
+// argument, naming the argument in the error if it does not parse.
+func parseOptionalFloats(args, names []string, vals []float64) error {
+	for i := range vals {
+		if i >= len(args) || args[i] == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", names[i], err)
+		}
+		vals[i] = v
 	}
+	return nil
 }
@@ -0,0 +1,190 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// 16-bit handlers for the built-in commands, registered with Register64 in
+// commands.go. They share argument parsing with their 8-bit counterparts.
+
+func applyResize64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	w, h, linear, err := parseResizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return ResampleLanczos64Context(ctx, lin, w, h, 3.0, progress)
+		})
+	}
+	return ResampleLanczos64Context(ctx, src, w, h, 3.0, progress)
+}
+
+func applyRotate64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	deg, linear, err := parseRotateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return Rotate64(lin, deg), nil
+		})
+	}
+	return Rotate64(src, deg), nil
+}
+
+func applyBlur64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	sigma, linear, err := parseBlurArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return SeparableGaussianBlur64Context(ctx, lin, sigma, progress)
+		})
+	}
+	return SeparableGaussianBlur64Context(ctx, src, sigma, progress)
+}
+
+func applyAdaptiveResize64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	width, height, a, linear, err := parseAdaptiveResizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return AdaptiveResize64(lin, width, height, a), nil
+		})
+	}
+	return AdaptiveResize64(src, width, height, a), nil
+}
+
+func applyComposite64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	c, err := parseCompositeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if c.linear {
+		return inLinearLight(src, func(lin *image.NRGBA64) (*image.NRGBA64, error) {
+			return Composite64(lin, toLinear64(c.img), c.op, c.x, c.y), nil
+		})
+	}
+	return Composite64(src, ToNRGBA64(c.img), c.op, c.x, c.y), nil
+}
+
+func applyCrop64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	rect, err := parseCropArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Crop64(src, rect), nil
+}
+
+func applyLevel64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	blackPoint, gamma, whitePoint, err := parseLevelArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Level64(src, blackPoint, gamma, whitePoint), nil
+}
+
+func applyGamma64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	gammaVal, err := parseGammaArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Gamma64(src, gammaVal), nil
+}
+
+func applyNegate64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	onlyGray, err := parseNegateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Negate64(src, onlyGray), nil
+}
+
+func applyThreshold64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	threshVal, perChannel, err := parseThresholdArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Threshold64(src, threshVal, perChannel), nil
+}
+
+func applyModulate64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	brightness, saturation, hue, err := parseModulateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Modulate64(src, brightness, saturation, hue), nil
+}
+
+func applyVignette64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	radius, sigma, x, y, strength, err := parseVignetteArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Vignette64(src, radius, sigma, x, y, strength), nil
+}
+
+func applySepia64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	p, err := parseSepiaArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return Sepia64(src, p.percentage, p.midtoneCenter, p.midtoneSigma, p.highlightThreshold, p.highlightSoftness, p.curve), nil
+}
+
+func applyPosterize64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	levels, dither, err := parsePosterizeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if dither != DitherNone {
+		// dithering works on 8-bit pixels; its output has no more
+		// precision to keep than the levels it rounds to
+		out, err := posterizeContext(ctx, ToNRGBA(src), levels, dither, progress)
+		if err != nil {
+			return nil, err
+		}
+		return ToNRGBA64(out), nil
+	}
+	return Posterize64(src, levels), nil
+}
+
+func applySharpen64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	vals := []float64{0, 1.0}
+	if err := parseOptionalFloats(args, []string{"radius", "sigma"}, vals); err != nil {
+		return nil, err
+	}
+	return UnsharpMask64(ctx, src, vals[0], vals[1], 1.0, 0.0, progress)
+}
+
+func applyUnsharpMask64(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+	vals := []float64{0, 1.0, 1.0, 0}
+	if err := parseOptionalFloats(args, []string{"radius", "sigma", "amount", "threshold"}, vals); err != nil {
+		return nil, err
+	}
+	return UnsharpMask64(ctx, src, vals[0], vals[1], vals[2], vals[3], progress)
+}
+
+// noArgs64 adapts a 16-bit filter without arguments to a Handler64.
+func noArgs64(name string, f func(*image.NRGBA64) *image.NRGBA64) Handler64 {
+	return func(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s takes no args", name)
+		}
+		return f(src), nil
+	}
+}
+
+// filter64 adapts a 16-bit filter that, like its 8-bit handler, ignores its
+// arguments.
+func filter64(f func(*image.NRGBA64) *image.NRGBA64) Handler64 {
+	return func(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+		return f(src), nil
+	}
+}
@@ -16,48 +16,50 @@ func UnsharpMask(src *image.NRGBA, radius float64, sigma float64, amount float64
 	w := b.Dx()
 	h := b.Dy()
 	out := image.NewNRGBA(image.Rect(0, 0, w, h))
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := src.PixOffset(x, y)
-			sr := float64(src.Pix[i+0])
-			sg := float64(src.Pix[i+1])
-			sb := float64(src.Pix[i+2])
-			sa := float64(src.Pix[i+3])
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := src.PixOffset(x, y)
+				sr := float64(src.Pix[i+0])
+				sg := float64(src.Pix[i+1])
+				sb := float64(src.Pix[i+2])
+				sa := float64(src.Pix[i+3])
 
-			bi := blurred.PixOffset(x, y)
-			br := float64(blurred.Pix[bi+0])
-			bg := float64(blurred.Pix[bi+1])
-			bb := float64(blurred.Pix[bi+2])
-			ba := float64(blurred.Pix[bi+3])
+				bi := blurred.PixOffset(x, y)
+				br := float64(blurred.Pix[bi+0])
+				bg := float64(blurred.Pix[bi+1])
+				bb := float64(blurred.Pix[bi+2])
+				ba := float64(blurred.Pix[bi+3])
 
-			// mask = src - blurred
-			mr := sr - br
-			mg := sg - bg
-			mb := sb - bb
+				// mask = src - blurred
+				mr := sr - br
+				mg := sg - bg
+				mb := sb - bb
 
-			if threshold > 0 {
-				// threshold is in same units as ImageMagick (likely 0..QuantumRange) but here assume 0..255
-				if math.Abs(mr) < threshold && math.Abs(mg) < threshold && math.Abs(mb) < threshold {
-					// below threshold: copy original
-					out.Pix[i+0] = uint8(clampFloatToUint8(sr))
-					out.Pix[i+1] = uint8(clampFloatToUint8(sg))
-					out.Pix[i+2] = uint8(clampFloatToUint8(sb))
-					out.Pix[i+3] = uint8(clampFloatToUint8(sa))
-					continue
+				if threshold > 0 {
+					// threshold is in same units as ImageMagick (likely 0..QuantumRange) but here assume 0..255
+					if math.Abs(mr) < threshold && math.Abs(mg) < threshold && math.Abs(mb) < threshold {
+						// below threshold: copy original
+						out.Pix[i+0] = uint8(clampFloatToUint8(sr))
+						out.Pix[i+1] = uint8(clampFloatToUint8(sg))
+						out.Pix[i+2] = uint8(clampFloatToUint8(sb))
+						out.Pix[i+3] = uint8(clampFloatToUint8(sa))
+						continue
+					}
 				}
-			}
 
-			r := sr + amount*mr
-			g := sg + amount*mg
-			b_ := sb + amount*mb
-			a_ := sa + amount*(sa-ba) // adjust alpha similarly
+				r := sr + amount*mr
+				g := sg + amount*mg
+				b_ := sb + amount*mb
+				a_ := sa + amount*(sa-ba) // adjust alpha similarly
 
-			out.Pix[i+0] = uint8(clampFloatToUint8(r))
-			out.Pix[i+1] = uint8(clampFloatToUint8(g))
-			out.Pix[i+2] = uint8(clampFloatToUint8(b_))
-			out.Pix[i+3] = uint8(clampFloatToUint8(a_))
+				out.Pix[i+0] = uint8(clampFloatToUint8(r))
+				out.Pix[i+1] = uint8(clampFloatToUint8(g))
+				out.Pix[i+2] = uint8(clampFloatToUint8(b_))
+				out.Pix[i+3] = uint8(clampFloatToUint8(a_))
+			}
 		}
-	}
+	})
 	return out
 }
 
@@ -4,8 +4,6 @@ import (
 	"image"
 	"image/color"
 	"math"
-	"runtime"
-	"sync"
 )
 
 // color conversion helpers: sRGB -> linear -> XYZ -> Lab
@@ -62,14 +60,21 @@ func labDistanceSq(c1, c2 color.NRGBA) float64 {
 	return dl*dl + da*da + db*db
 }
 
-// FloodfillPaint fills a region starting at (x,y) with fillColor using a fuzz tolerance.
-// Optimized for large images by using a bitset for the mask (1 bit per pixel).
-// If borderColor has non-zero alpha or non-zero RGB, it is treated as a boundary that cannot be crossed (within fuzz).
-// If invert is true, fill the inverse of the matched region.
-func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borderColor color.NRGBA, x, y int, invert bool) *image.NRGBA {
-	if src == nil {
-		return nil
-	}
+// regionBits is a 1-bit-per-pixel mask over an image's bounds, indexed
+// row by row from the top-left corner.
+type regionBits []byte
+
+func newRegionBits(n int) regionBits { return make(regionBits, (n+7)/8) }
+
+func (m regionBits) get(i int) byte { return (m[i>>3] >> (uint(i) & 7)) & 1 }
+func (m regionBits) set(i int)      { m[i>>3] |= 1 << (uint(i) & 7) }
+func (m regionBits) clear(i int)    { m[i>>3] &^= 1 << (uint(i) & 7) }
+
+// floodRegion returns the pixels of src matched by a flood fill from (x,y)
+// with a Lab delta-E tolerance of fuzz. A borderColor other than the zero
+// color turns the fill into "everything up to the border"; global matches
+// every pixel close to the start color, connected or not.
+func floodRegion(src *image.NRGBA, fuzz float64, borderColor color.NRGBA, x, y int, global bool) regionBits {
 	b := src.Bounds()
 	w := b.Dx()
 	h := b.Dy()
@@ -88,20 +93,8 @@ func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borde
 	}
 
 	// prepare mask as bitset to reduce memory (1 bit per pixel)
-	size := w * h
-	maskBytes := (size + 7) / 8
-	mask := make([]byte, maskBytes)
-
-	// bit helpers
-	getMask := func(i int) byte {
-		return (mask[i>>3] >> (uint(i) & 7)) & 1
-	}
-	setMask := func(i int) {
-		mask[i>>3] |= 1 << (uint(i) & 7)
-	}
-	clearMask := func(i int) {
-		mask[i>>3] &^= 1 << (uint(i) & 7)
-	}
+	mask := newRegionBits(w * h)
+	getMask, setMask := mask.get, mask.set
 
 	// helpers
 	idxOf := func(px, py int) int { return (py-b.Min.Y)*w + (px - b.Min.X) }
@@ -136,8 +129,8 @@ func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borde
 		return labDistanceSq(c, start) <= fuzzSq
 	}
 
-	// If invert without border, treat matching as global (non-connected): mark all matching pixels
-	if !useBorder && invert {
+	// global: mark all matching pixels, connected or not
+	if global {
 		for py := b.Min.Y; py < b.Max.Y; py++ {
 			for px := b.Min.X; px < b.Max.X; px++ {
 				i := idxOf(px, py)
@@ -248,6 +241,27 @@ func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borde
 		}
 	}
 
+	return mask
+}
+
+// FloodfillPaint fills a region starting at (x,y) with fillColor using a fuzz tolerance.
+// Optimized for large images by using a bitset for the mask (1 bit per pixel).
+// If borderColor has non-zero alpha or non-zero RGB, it is treated as a boundary that cannot be crossed (within fuzz).
+// If invert is true, fill the inverse of the matched region.
+func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borderColor color.NRGBA, x, y int, invert bool) *image.NRGBA {
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	w := b.Dx()
+	h := b.Dy()
+	size := w * h
+	idxOf := func(px, py int) int { return (py-b.Min.Y)*w + (px - b.Min.X) }
+	// If invert without border, treat matching as global (non-connected)
+	useBorder := !(borderColor.R == 0 && borderColor.G == 0 && borderColor.B == 0 && borderColor.A == 0)
+	mask := floodRegion(src, fuzz, borderColor, x, y, !useBorder && invert)
+	getMask, setMask, clearMask := mask.get, mask.set, mask.clear
+
 	// invert mask if requested (flip bits)
 	if invert {
 		for i := 0; i < size; i++ {
@@ -266,52 +280,36 @@ func FloodfillPaint(src *image.NRGBA, fillColor color.NRGBA, fuzz float64, borde
 	fb := float64(fillColor.B)
 	fa := float64(fillColor.A) / 255.0
 
-	// Parallelize compositing by splitting rows across workers
-	workers := runtime.NumCPU()
-	if workers < 1 {
-		workers = 1
-	}
-	var wg sync.WaitGroup
-	wg.Add(workers)
-	rowsPer := (h + workers - 1) / workers
-	for wi := 0; wi < workers; wi++ {
-		startRow := b.Min.Y + wi*rowsPer
-		endRow := startRow + rowsPer
-		if endRow > b.Min.Y+h {
-			endRow = b.Min.Y + h
-		}
-		go func(startY, endY int) {
-			defer wg.Done()
-			for py := startY; py < endY; py++ {
-				for px := b.Min.X; px < b.Max.X; px++ {
-					i := idxOf(px, py)
-					if getMask(i) == 0 {
-						continue
-					}
-					off := out.PixOffset(px, py)
-					sr := float64(src.Pix[off+0])
-					sg := float64(src.Pix[off+1])
-					sb := float64(src.Pix[off+2])
-					sa := float64(src.Pix[off+3]) / 255.0
+	// composite rows in parallel
+	parallelRows(h, func(y0, y1 int) {
+		for py := b.Min.Y + y0; py < b.Min.Y+y1; py++ {
+			for px := b.Min.X; px < b.Max.X; px++ {
+				i := idxOf(px, py)
+				if getMask(i) == 0 {
+					continue
+				}
+				off := out.PixOffset(px, py)
+				sr := float64(src.Pix[off+0])
+				sg := float64(src.Pix[off+1])
+				sb := float64(src.Pix[off+2])
+				sa := float64(src.Pix[off+3]) / 255.0
 
-					outA := fa + sa*(1.0-fa)
-					var nr, ng, nb float64
-					if outA > 0 {
-						nr = (fr*fa + sr*sa*(1.0-fa)) / outA
-						ng = (fg*fa + sg*sa*(1.0-fa)) / outA
-						nb = (fb*fa + sb*sa*(1.0-fa)) / outA
-					} else {
-						nr, ng, nb = 0, 0, 0
-					}
-					out.Pix[off+0] = uint8(clampFloatToUint8(nr))
-					out.Pix[off+1] = uint8(clampFloatToUint8(ng))
-					out.Pix[off+2] = uint8(clampFloatToUint8(nb))
-					out.Pix[off+3] = uint8(clampFloatToUint8(outA * 255.0))
+				outA := fa + sa*(1.0-fa)
+				var nr, ng, nb float64
+				if outA > 0 {
+					nr = (fr*fa + sr*sa*(1.0-fa)) / outA
+					ng = (fg*fa + sg*sa*(1.0-fa)) / outA
+					nb = (fb*fa + sb*sa*(1.0-fa)) / outA
+				} else {
+					nr, ng, nb = 0, 0, 0
 				}
+				out.Pix[off+0] = uint8(clampFloatToUint8(nr))
+				out.Pix[off+1] = uint8(clampFloatToUint8(ng))
+				out.Pix[off+2] = uint8(clampFloatToUint8(nb))
+				out.Pix[off+3] = uint8(clampFloatToUint8(outA * 255.0))
 			}
-		}(startRow, endRow)
-	}
-	wg.Wait()
+		}
+	})
 
 	return out
 }
@@ -0,0 +1,106 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// Graph is a chain of commands on an image that is evaluated lazily, when
+// Image is called. Runs of consecutive point operations (level, gamma,
+// negate, threshold, modulate) are fused into one lookup table or one
+// per-pixel kernel and evaluated in a single pass, instead of allocating
+// and walking a full image per step. The result is bit-identical to running
+// the commands one by one with ApplyCommandContext.
+type Graph struct {
+	img   image.Image
+	nodes []graphNode
+}
+
+type graphNode struct {
+	name string
+	args []string
+	// point is set for point operations.
+	point *pointOp
+}
+
+// NewGraph returns a graph with no pending commands over img.
+func NewGraph(img image.Image) *Graph {
+	return &Graph{img: img}
+}
+
+// Apply appends a command to the graph. The arguments of point operations
+// are checked straight away; those of other commands when they run.
+func (g *Graph) Apply(name string, args []string) error {
+	if _, _, ok := lookupContext(name); !ok {
+		return fmt.Errorf("unsupported command in stdlib engine: %s", name)
+	}
+	n := graphNode{name: name, args: args}
+	if build, ok := lookupPoint(name); ok {
+		op, err := build(args)
+		if err != nil {
+			return err
+		}
+		n.point = &op
+	}
+	g.nodes = append(g.nodes, n)
+	return nil
+}
+
+// Pending returns the number of commands that have not been evaluated yet.
+func (g *Graph) Pending() int {
+	return len(g.nodes)
+}
+
+// Image evaluates the pending commands and returns the resulting image.
+// Later calls return the same image until more commands are applied. On
+// error the graph is left as it was. progress, if non-nil, receives the
+// fraction of the pending commands completed.
+func (g *Graph) Image(ctx context.Context, progress ProgressFunc) (image.Image, error) {
+	if progress == nil {
+		progress = noProgress
+	}
+	img := g.img
+	total := float64(len(g.nodes))
+	for i := 0; i < len(g.nodes); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Collect the run of point operations starting at i.
+		j := i
+		var ops []pointOp
+		for j < len(g.nodes) && g.nodes[j].point != nil {
+			ops = append(ops, *g.nodes[j].point)
+			j++
+		}
+		// 16-bit images take the commands' 16-bit paths one at a time.
+		if len(ops) > 0 && !Is16Bit(img) {
+			img = fusePointOps(ops).apply(ToNRGBA(img))
+			i = j
+			progress(float64(i) / total)
+			continue
+		}
+		n := g.nodes[i]
+		base := float64(i)
+		out, err := ApplyCommandContext(ctx, img, n.name, n.args, func(done float64) {
+			progress((base + done) / total)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out != nil {
+			img = out
+		}
+		i++
+	}
+	g.img, g.nodes = img, nil
+	progress(1)
+	return img, nil
+}
+
+// IsPointOperation reports whether the command name maps each pixel on its
+// own, so that a Graph fuses it with neighbouring point operations.
+func IsPointOperation(name string) bool {
+	_, ok := lookupPoint(name)
+	return ok
+}
@@ -0,0 +1,142 @@
+package stdimg
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+)
+
+type graphStep struct {
+	name string
+	args []string
+}
+
+// tonalChain mixes lookup-table ops, per-pixel kernels and a command that
+// is not a point operation.
+var tonalChain = []graphStep{
+	{"level", []string{"10", "1.4", "240"}},
+	{"gamma", []string{"1.8"}},
+	{"negate", []string{"false"}},
+	{"modulate", []string{"110", "80", "30"}},
+	{"threshold", []string{"120", "true"}},
+	{"negate", []string{"true"}},
+	{"blur", []string{"1.5", ""}},
+	{"gamma", []string{"0.7"}},
+	{"threshold", []string{"90", "false"}},
+	{"level", []string{"0", "1", "255"}},
+}
+
+func runSequential(t testing.TB, img image.Image, steps []graphStep) image.Image {
+	for _, s := range steps {
+		out, err := ApplyCommandContext(context.Background(), img, s.name, s.args, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+		img = out
+	}
+	return img
+}
+
+func runGraph(t testing.TB, img image.Image, steps []graphStep) image.Image {
+	g := NewGraph(img)
+	for _, s := range steps {
+		if err := g.Apply(s.name, s.args); err != nil {
+			t.Fatalf("%s: %v", s.name, err)
+		}
+	}
+	out, err := g.Image(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestGraphMatchesSequential(t *testing.T) {
+	src := gradientNRGBA(97, 61)
+	for i := range src.Pix {
+		src.Pix[i] ^= uint8(i * 13)
+	}
+	for n := 1; n <= len(tonalChain); n++ {
+		want := ToNRGBA(runSequential(t, src, tonalChain[:n]))
+		got := ToNRGBA(runGraph(t, src, tonalChain[:n]))
+		if !bytes.Equal(want.Pix, got.Pix) || want.Rect != got.Rect {
+			t.Fatalf("first %d steps: fused result differs", n)
+		}
+	}
+
+	deep := ramp64(31, 17)
+	want := runSequential(t, deep, tonalChain).(*image.NRGBA64)
+	got, ok := runGraph(t, deep, tonalChain).(*image.NRGBA64)
+	if !ok || !bytes.Equal(want.Pix, got.Pix) {
+		t.Error("16-bit result differs")
+	}
+}
+
+func TestFusePointOpsComposesTables(t *testing.T) {
+	var ops []pointOp
+	for _, s := range tonalChain[:3] {
+		build, ok := lookupPoint(s.name)
+		if !ok {
+			t.Fatalf("%s is not a point operation", s.name)
+		}
+		op, err := build(s.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, op)
+	}
+	if fused := fusePointOps(ops); fused.lut == nil {
+		t.Error("level, gamma and negate should fuse into a single lookup table")
+	}
+}
+
+func TestGraphIsLazy(t *testing.T) {
+	g := NewGraph(gradientNRGBA(8, 8))
+	if err := g.Apply("gamma", []string{"x"}); err == nil {
+		t.Error("bad arguments of a point operation should fail on Apply")
+	}
+	if err := g.Apply("nope", nil); err == nil {
+		t.Error("unknown commands should fail on Apply")
+	}
+	for _, s := range tonalChain[:4] {
+		if err := g.Apply(s.name, s.args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g.Pending() != 4 {
+		t.Fatalf("Pending() = %d, want 4", g.Pending())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Image(ctx, nil); err == nil || g.Pending() != 4 {
+		t.Fatalf("cancelled evaluation: err %v, %d pending", err, g.Pending())
+	}
+	first, err := g.Image(context.Background(), nil)
+	if err != nil || g.Pending() != 0 {
+		t.Fatalf("Image: %v, %d pending", err, g.Pending())
+	}
+	if again, _ := g.Image(context.Background(), nil); again != first {
+		t.Error("a second evaluation recomputed the image")
+	}
+}
+
+func benchmarkTonal(b *testing.B, run func(testing.TB, image.Image, []graphStep) image.Image) {
+	src := gradientNRGBA(1024, 768)
+	steps := []graphStep{
+		{"level", []string{"10", "1.2", "245"}},
+		{"gamma", []string{"1.1"}},
+		{"modulate", []string{"105", "110", "0"}},
+		{"negate", []string{"false"}},
+		{"level", []string{"5", "0.9", "250"}},
+		{"negate", []string{"false"}},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = run(b, src, steps)
+	}
+}
+
+func BenchmarkTonalSequential(b *testing.B) { benchmarkTonal(b, runSequential) }
+func BenchmarkTonalGraph(b *testing.B)      { benchmarkTonal(b, runGraph) }
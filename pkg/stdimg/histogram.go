@@ -95,19 +95,21 @@ func Equalize(src *image.NRGBA) *image.NRGBA {
 	out := image.NewNRGBA(b)
 	w := b.Dx()
 	h := b.Dy()
-	for y := 0; y < h; y++ {
-		for x := 0; x < w; x++ {
-			i := src.PixOffset(x, y)
-			r := src.Pix[i+0]
-			g := src.Pix[i+1]
-			b_ := src.Pix[i+2]
-			a := src.Pix[i+3]
-			out.Pix[i+0] = mapR[r]
-			out.Pix[i+1] = mapG[g]
-			out.Pix[i+2] = mapB[b_]
-			out.Pix[i+3] = a
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := src.PixOffset(x, y)
+				r := src.Pix[i+0]
+				g := src.Pix[i+1]
+				b_ := src.Pix[i+2]
+				a := src.Pix[i+3]
+				out.Pix[i+0] = mapR[r]
+				out.Pix[i+1] = mapG[g]
+				out.Pix[i+2] = mapB[b_]
+				out.Pix[i+3] = a
+			}
 		}
-	}
+	})
 	return out
 }
 
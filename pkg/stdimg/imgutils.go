@@ -16,6 +16,17 @@ func ToNRGBA(src image.Image) *image.NRGBA {
 		copy(out.Pix, n.Pix)
 		return out
 	}
+	if n, ok := src.(*image.NRGBA64); ok {
+		// keep the high byte; going through RGBA() would premultiply
+		out := image.NewNRGBA(n.Rect)
+		for y := 0; y < n.Rect.Dy(); y++ {
+			row := out.Pix[y*out.Stride : y*out.Stride+n.Rect.Dx()*4]
+			for i := range row {
+				row[i] = n.Pix[y*n.Stride+2*i]
+			}
+		}
+		return out
+	}
 	b := src.Bounds()
 	out := image.NewNRGBA(b)
 	idx := 0
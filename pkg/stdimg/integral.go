@@ -0,0 +1,100 @@
+package stdimg
+
+import (
+	"image"
+	"math"
+)
+
+// IntegralImage is a summed-area table over a grid of values and their
+// squares. It gives the sum, mean and standard deviation of the values in
+// any rectangle in constant time, whatever the rectangle's size.
+//
+// Totals are kept modulo 2^64. Differences of them are exact, so the sums
+// over a rectangle are exact as long as they fit in 64 bits: for the
+// fixed-point luminance of LuminanceIntegral that is any rectangle for the
+// sums and up to 2^20 pixels for the sums of squares.
+type IntegralImage struct {
+	w, h int
+	// sum and sq hold (w+1) x (h+1) totals of the values above and to the
+	// left of each grid point.
+	sum, sq []uint64
+}
+
+// NewIntegralImage builds the tables for the w x h values in vals, given
+// row by row.
+func NewIntegralImage(vals []uint32, w, h int) *IntegralImage {
+	t := &IntegralImage{w: w, h: h, sum: make([]uint64, (w+1)*(h+1)), sq: make([]uint64, (w+1)*(h+1))}
+	stride := w + 1
+	// running totals along each row, then down each column
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			var s, q uint64
+			row := (y + 1) * stride
+			for x, v := range vals[y*w : y*w+w] {
+				s += uint64(v)
+				q += uint64(v) * uint64(v)
+				t.sum[row+x+1] = s
+				t.sq[row+x+1] = q
+			}
+		}
+	})
+	parallelRows(w, func(x0, x1 int) {
+		for y := 2; y <= h; y++ {
+			row, prev := y*stride, (y-1)*stride
+			for x := x0 + 1; x <= x1; x++ {
+				t.sum[row+x] += t.sum[prev+x]
+				t.sq[row+x] += t.sq[prev+x]
+			}
+		}
+	})
+	return t
+}
+
+// LuminanceIntegral builds the tables for the luminance of src in the
+// fixed point of fixedLuminance (lumScale units per 8-bit level).
+func LuminanceIntegral(src *image.NRGBA) *IntegralImage {
+	return NewIntegralImage(luminanceValues(src), src.Rect.Dx(), src.Rect.Dy())
+}
+
+// luminanceValues returns the fixed-point luminance of each pixel of src,
+// row by row from the top left.
+func luminanceValues(src *image.NRGBA) []uint32 {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	lum := make([]uint32, w*h)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := src.PixOffset(x+b.Min.X, y+b.Min.Y)
+				lum[y*w+x] = fixedLuminance(src.Pix[i : i+3 : i+3])
+			}
+		}
+	})
+	return lum
+}
+
+// Sum returns the sum and the sum of squares of the values in r, clipped
+// to the grid.
+func (t *IntegralImage) Sum(r image.Rectangle) (sum, sq uint64) {
+	r = r.Intersect(image.Rect(0, 0, t.w, t.h))
+	if r.Empty() {
+		return 0, 0
+	}
+	stride := t.w + 1
+	a, b := r.Min.Y*stride+r.Min.X, r.Min.Y*stride+r.Max.X
+	c, d := r.Max.Y*stride+r.Min.X, r.Max.Y*stride+r.Max.X
+	return t.sum[d] - t.sum[b] - t.sum[c] + t.sum[a], t.sq[d] - t.sq[b] - t.sq[c] + t.sq[a]
+}
+
+// Stats returns the mean and the standard deviation of the values in r,
+// clipped to the grid, or zeros if that leaves nothing.
+func (t *IntegralImage) Stats(r image.Rectangle) (mean, stddev float64) {
+	r = r.Intersect(image.Rect(0, 0, t.w, t.h))
+	if r.Empty() {
+		return 0, 0
+	}
+	sum, sq := t.Sum(r)
+	n := float64(r.Dx() * r.Dy())
+	mean = float64(sum) / n
+	return mean, math.Sqrt(math.Max(float64(sq)/n-mean*mean, 0))
+}
@@ -0,0 +1,98 @@
+package stdimg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestIntegralImageMatchesDirectSums(t *testing.T) {
+	const w, h = 23, 17
+	vals := make([]uint32, w*h)
+	for i := range vals {
+		vals[i] = uint32(i*7919) % 2550001
+	}
+	integ := NewIntegralImage(vals, w, h)
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, w, h),
+		image.Rect(3, 4, 9, 5),
+		image.Rect(-5, -5, 4, 30), // clipped
+		image.Rect(22, 16, 23, 17),
+		image.Rect(5, 5, 5, 9), // empty
+	} {
+		var sum, sq uint64
+		c := r.Intersect(image.Rect(0, 0, w, h))
+		for y := c.Min.Y; y < c.Max.Y; y++ {
+			for x := c.Min.X; x < c.Max.X; x++ {
+				v := uint64(vals[y*w+x])
+				sum += v
+				sq += v * v
+			}
+		}
+		if gotSum, gotSq := integ.Sum(r); gotSum != sum || gotSq != sq {
+			t.Errorf("Sum(%v) = %d, %d, want %d, %d", r, gotSum, gotSq, sum, sq)
+		}
+	}
+	mean, sd := NewIntegralImage([]uint32{2, 4, 4, 4, 5, 5, 7, 9}, 4, 2).Stats(image.Rect(0, 0, 4, 2))
+	if mean != 5 || math.Abs(sd-2) > 1e-12 {
+		t.Errorf("Stats = %v, %v, want 5, 2", mean, sd)
+	}
+}
+
+// pageNRGBA is dark text-like strokes on a background that fades from
+// light to mid gray, which a global threshold cannot separate.
+func pageNRGBA(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(230 - 110*x/w)
+			if x%12 < 2 && y%20 > 4 {
+				v /= 4
+			}
+			img.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestSauvolaAndNiblackSeparateStrokes(t *testing.T) {
+	src := pageNRGBA(120, 60)
+	for _, method := range []string{ThresholdSauvola, ThresholdNiblack} {
+		out := AdaptiveThresholdEx(src, 25, 25, 0, method, DefaultThresholdK(method), 128)
+		for y := 10; y < 50; y++ {
+			if y%20 <= 4 {
+				continue // between lines
+			}
+			for _, x := range []int{12, 13, 60, 61, 108, 109} {
+				if out.Pix[out.PixOffset(x, y)] != 0 {
+					t.Fatalf("%s: stroke pixel %d,%d is white", method, x, y)
+				}
+			}
+			for _, x := range []int{6, 54, 102} {
+				if out.Pix[out.PixOffset(x, y)] != 255 {
+					t.Fatalf("%s: background pixel %d,%d is black", method, x, y)
+				}
+			}
+		}
+	}
+}
+
+func TestAdaptiveThresholdMethodArgs(t *testing.T) {
+	src := pageNRGBA(40, 30)
+	got, err := ApplyCommandStdlib(src, "adaptiveThreshold", []string{"9", "9", "0", "SAUVOLA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := AdaptiveThresholdEx(src, 9, 9, 0, ThresholdSauvola, 0.5, 128)
+	if !bytes.Equal(ToNRGBA(got).Pix, want.Pix) {
+		t.Error("the command and AdaptiveThresholdEx differ")
+	}
+	if _, err := ApplyCommandStdlib(src, "adaptiveThreshold", []string{"9", "9", "0", "otsu"}); err == nil {
+		t.Error("an unknown method should be rejected")
+	}
+	if _, err := ApplyCommandStdlib(src, "adaptiveThreshold", []string{"9", "9", "0", "niblack", "x"}); err == nil {
+		t.Error("a bad k should be rejected")
+	}
+}
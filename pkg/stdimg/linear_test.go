@@ -0,0 +1,91 @@
+package stdimg
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestLinearRoundTripIsLossless(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 256, 1))
+	for x := 0; x < 256; x++ {
+		src.SetNRGBA(x, 0, color.NRGBA{uint8(x), uint8(255 - x), uint8(x / 2), uint8(x)})
+	}
+	out := fromLinear(toLinear64(src), false).(*image.NRGBA)
+	for i := range src.Pix {
+		if out.Pix[i] != src.Pix[i] {
+			t.Fatalf("byte %d: %d after round trip, want %d", i, out.Pix[i], src.Pix[i])
+		}
+	}
+}
+
+func TestLinearDownscaleKeepsBrightness(t *testing.T) {
+	// Black and white columns average to linear 0.5, which is sRGB 188;
+	// averaging the encoded values gives a too dark 128.
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 16; x++ {
+			v := uint8(255 * (x % 2))
+			src.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	defer SetLinearLight(false)
+	for _, c := range []struct {
+		global bool
+		args   []string
+		lo, hi uint8
+	}{
+		{false, []string{"2", "2"}, 120, 136},
+		{false, []string{"2", "2", "true"}, 182, 194},
+		{true, []string{"2", "2"}, 182, 194},
+		{true, []string{"2", "2", "false"}, 120, 136},
+	} {
+		SetLinearLight(c.global)
+		out, err := ApplyCommandStdlib(src, "resize", c.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := ToNRGBA(out).NRGBAAt(0, 0).R; v < c.lo || v > c.hi {
+			t.Errorf("global=%v resize %v: gray %d, want %d..%d", c.global, c.args, v, c.lo, c.hi)
+		}
+	}
+}
+
+func TestLinearKeepsDepth(t *testing.T) {
+	for _, name := range []string{"blur", "rotate"} {
+		out, err := ApplyCommandStdlib(ramp64(8, 8), name, []string{"1", "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := out.(*image.NRGBA64); !ok {
+			t.Errorf("linear %s on a 16-bit image returned %T", name, out)
+		}
+		out, err = ApplyCommandStdlib(gradientNRGBA(8, 8), name, []string{"1", "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := out.(*image.NRGBA); !ok {
+			t.Errorf("linear %s on an 8-bit image returned %T", name, out)
+		}
+	}
+}
+
+func TestCompositeLinear(t *testing.T) {
+	dst := makeSolidNRGBA(2, 1, color.NRGBA{0, 0, 0, 255})
+	half := makeSolidNRGBA(2, 1, color.NRGBA{255, 255, 255, 128})
+	SourceResolver = func(ref string) (image.Image, bool) { return half, ref == "@half" }
+	defer func() { SourceResolver = nil }()
+
+	gamma, err := ApplyCommandStdlib(dst, "composite", []string{"@half", "OVER", "0", "0", "false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lin, err := ApplyCommandStdlib(dst, "composite", []string{"@half", "OVER", "0", "0", "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, l := ToNRGBA(gamma).NRGBAAt(0, 0).R, ToNRGBA(lin).NRGBAAt(0, 0).R
+	if g < 125 || g > 131 || l < 182 || l > 194 {
+		t.Errorf("50%% white over black: gamma %d (want ~128), linear %d (want ~188)", g, l)
+	}
+}
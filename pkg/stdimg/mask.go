@@ -0,0 +1,165 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+)
+
+// Masks limit a command to part of an image. A mask is an *image.Gray the
+// size of the image: 255 takes the command's result, 0 keeps the original
+// pixel and values in between blend the two.
+
+// RectMask returns a mask covering b that selects roi.
+func RectMask(b, roi image.Rectangle) *image.Gray {
+	m := image.NewGray(b)
+	roi = roi.Intersect(b)
+	for y := roi.Min.Y; y < roi.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(roi.Min.X, y):m.PixOffset(roi.Max.X, y)]
+		for i := range row {
+			row[i] = 255
+		}
+	}
+	return m
+}
+
+// MaskFromImage turns img into a mask covering b, using its Rec.709
+// luminance scaled by its alpha. An img of another size is resampled to fit.
+func MaskFromImage(img image.Image, b image.Rectangle) *image.Gray {
+	src := ToNRGBA(img)
+	if src.Bounds().Dx() != b.Dx() || src.Bounds().Dy() != b.Dy() {
+		src = ResampleLanczos(src, b.Dx(), b.Dy(), 3.0)
+	}
+	sb := src.Bounds()
+	m := image.NewGray(b)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				i := src.PixOffset(sb.Min.X+x, sb.Min.Y+y)
+				p := src.Pix[i : i+4 : i+4]
+				lum := 0.2126*float64(p[0]) + 0.7152*float64(p[1]) + 0.0722*float64(p[2])
+				m.Pix[y*m.Stride+x] = uint8(clampFloatToUint8(lum*float64(p[3])/255 + 0.5))
+			}
+		}
+	})
+	return m
+}
+
+// LoadMask reads the mask image named by ref, a file path or anything
+// SourceResolver accepts, and fits it to b as MaskFromImage does.
+func LoadMask(ref string, b image.Rectangle) (*image.Gray, error) {
+	img, err := loadSourceImage(ref)
+	if err != nil {
+		return nil, fmt.Errorf("mask %s: %w", ref, err)
+	}
+	return MaskFromImage(img, b), nil
+}
+
+// FeatherMask softens the edges of m with a Gaussian blur of the given
+// sigma in pixels. A sigma <= 0 returns m unchanged.
+func FeatherMask(m *image.Gray, sigma float64) *image.Gray {
+	if m == nil || sigma <= 0 {
+		return m
+	}
+	b := m.Bounds()
+	tmp := image.NewNRGBA(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			v := m.Pix[y*m.Stride+x]
+			i := y*tmp.Stride + x*4
+			tmp.Pix[i], tmp.Pix[i+1], tmp.Pix[i+2], tmp.Pix[i+3] = v, v, v, 255
+		}
+	}
+	blurred := SeparableGaussianBlur(tmp, sigma)
+	out := image.NewGray(b)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = blurred.Pix[y*blurred.Stride+x*4]
+		}
+	}
+	return out
+}
+
+// IntersectMasks returns the product of a and b, which must cover the same
+// bounds. Either may be nil, meaning "everything".
+func IntersectMasks(a, b *image.Gray) *image.Gray {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	out := image.NewGray(a.Rect)
+	for y := 0; y < a.Rect.Dy(); y++ {
+		for x := 0; x < a.Rect.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = uint8((int(a.Pix[y*a.Stride+x])*int(b.Pix[y*b.Stride+x]) + 127) / 255)
+		}
+	}
+	return out
+}
+
+// ApplyCommandMasked is ApplyCommandContext limited to mask: the command
+// runs on the whole image and its result is blended back into img using the
+// mask values as weights. A nil mask applies the command everywhere.
+// Commands that change the image size can not be masked.
+func ApplyCommandMasked(ctx context.Context, img image.Image, commandName string, args []string, mask *image.Gray, progress ProgressFunc) (image.Image, error) {
+	out, err := ApplyCommandContext(ctx, img, commandName, args, progress)
+	if err != nil || out == nil || mask == nil {
+		return out, err
+	}
+	b := img.Bounds()
+	if out.Bounds().Dx() != b.Dx() || out.Bounds().Dy() != b.Dy() {
+		return nil, fmt.Errorf("%s changes the image size and can not be limited to a region", commandName)
+	}
+	if mask.Bounds().Dx() != b.Dx() || mask.Bounds().Dy() != b.Dy() {
+		return nil, fmt.Errorf("mask is %dx%d, image is %dx%d", mask.Bounds().Dx(), mask.Bounds().Dy(), b.Dx(), b.Dy())
+	}
+	if Is16Bit(img) || Is16Bit(out) {
+		return blendMasked64(ToNRGBA64(img), ToNRGBA64(out), mask), nil
+	}
+	return blendMasked(ToNRGBA(img), ToNRGBA(out), mask), nil
+}
+
+// blendMasked writes orig + (res-orig)*m/255 into res and returns it.
+func blendMasked(orig, res *image.NRGBA, mask *image.Gray) *image.NRGBA {
+	ob, rb := orig.Bounds(), res.Bounds()
+	parallelRows(ob.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < ob.Dx(); x++ {
+				m := int(mask.Pix[y*mask.Stride+x])
+				if m == 255 {
+					continue
+				}
+				oi := orig.PixOffset(ob.Min.X+x, ob.Min.Y+y)
+				ri := res.PixOffset(rb.Min.X+x, rb.Min.Y+y)
+				for c := 0; c < 4; c++ {
+					res.Pix[ri+c] = uint8((int(orig.Pix[oi+c])*(255-m) + int(res.Pix[ri+c])*m + 127) / 255)
+				}
+			}
+		}
+	})
+	return res
+}
+
+// blendMasked64 is blendMasked for 16-bit images.
+func blendMasked64(orig, res *image.NRGBA64, mask *image.Gray) *image.NRGBA64 {
+	ob, rb := orig.Bounds(), res.Bounds()
+	parallelRows(ob.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < ob.Dx(); x++ {
+				m := uint32(mask.Pix[y*mask.Stride+x]) * 257
+				if m == 65535 {
+					continue
+				}
+				or, og, obl, oa := get64(orig, orig.PixOffset(ob.Min.X+x, ob.Min.Y+y))
+				ri := res.PixOffset(rb.Min.X+x, rb.Min.Y+y)
+				rr, rg, rbl, ra := get64(res, ri)
+				mix := func(o, r uint16) uint16 {
+					return uint16((uint32(o)*(65535-m) + uint32(r)*m + 32767) / 65535)
+				}
+				set64(res, ri, mix(or, rr), mix(og, rg), mix(obl, rbl), mix(oa, ra))
+			}
+		}
+	})
+	return res
+}
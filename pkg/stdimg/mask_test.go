@@ -0,0 +1,78 @@
+package stdimg
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestApplyCommandMaskedRect(t *testing.T) {
+	src := makeSolidNRGBA(8, 8, color.NRGBA{R: 10, G: 20, B: 30, A: 255})
+	mask := RectMask(src.Bounds(), image.Rect(2, 2, 6, 6))
+	out, err := ApplyCommandMasked(context.Background(), src, "negate", nil, mask, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := ToNRGBA(out)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			want := color.NRGBA{R: 10, G: 20, B: 30, A: 255}
+			if x >= 2 && x < 6 && y >= 2 && y < 6 {
+				want = color.NRGBA{R: 245, G: 235, B: 225, A: 255}
+			}
+			if got := res.NRGBAAt(x, y); got != want {
+				t.Fatalf("(%d,%d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if src.NRGBAAt(3, 3).R != 10 {
+		t.Error("the input image was modified")
+	}
+}
+
+func TestApplyCommandMaskedWeights(t *testing.T) {
+	src := makeSolidNRGBA(4, 1, color.NRGBA{R: 0, G: 0, B: 0, A: 255})
+	mask := image.NewGray(src.Bounds())
+	copy(mask.Pix, []uint8{0, 64, 128, 255})
+	out, err := ApplyCommandMasked(context.Background(), src, "negate", nil, mask, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range []uint8{0, 64, 128, 255} {
+		if got := ToNRGBA(out).NRGBAAt(x, 0).R; got != want {
+			t.Errorf("x=%d: %d, want %d", x, got, want)
+		}
+	}
+
+	deep, err := ApplyCommandMasked(context.Background(), ToNRGBA64(src), "negate", nil, mask, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := deep.(*image.NRGBA64); !ok {
+		t.Errorf("masked 16-bit input returned %T", deep)
+	}
+}
+
+func TestFeatherMask(t *testing.T) {
+	m := FeatherMask(RectMask(image.Rect(0, 0, 20, 1), image.Rect(10, 0, 20, 1)), 2)
+	if m.GrayAt(0, 0).Y != 0 || m.GrayAt(19, 0).Y != 255 {
+		t.Errorf("feathering changed the mask far from its edge: %v", m.Pix)
+	}
+	if v := m.GrayAt(10, 0).Y; v < 100 || v > 200 {
+		t.Errorf("edge value %d, want a blend", v)
+	}
+	for x := 1; x < 20; x++ {
+		if m.Pix[x] < m.Pix[x-1] {
+			t.Fatalf("feathered edge is not monotonic: %v", m.Pix)
+		}
+	}
+}
+
+func TestApplyCommandMaskedRejectsResize(t *testing.T) {
+	src := makeSolidNRGBA(8, 8, color.NRGBA{A: 255})
+	mask := RectMask(src.Bounds(), image.Rect(0, 0, 4, 4))
+	if _, err := ApplyCommandMasked(context.Background(), src, "resize", []string{"4", "4"}, mask, nil); err == nil {
+		t.Error("masked resize should fail")
+	}
+}
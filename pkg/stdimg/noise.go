@@ -4,9 +4,7 @@ import (
 	"image"
 	"math"
 	"math/rand"
-	"runtime"
 	"sort"
-	"sync"
 )
 
 // AddNoise adds noise to src. typ may be "GAUSSIAN", "UNIFORM", or "POISSON".
@@ -34,6 +32,8 @@ func AddNoise(src *image.NRGBA, typ string, amount float64, seed int64) *image.N
 	if typ == "POISSON" {
 		cdfs = buildPoissonCDFs(amount)
 	}
+	// the pixel loop stays serial: the noise sequence depends on the order
+	// in which pixels draw from rng
 	for y := 0; y < h; y++ {
 		for x := 0; x < w; x++ {
 			i := src.PixOffset(x+src.Rect.Min.X, y+src.Rect.Min.Y)
@@ -121,53 +121,37 @@ func gaussianSample(rng *rand.Rand, std float64) float64 {
 // of length 256 where each entry is the CDF slice for that channel.
 func buildPoissonCDFs(amount float64) [][]float64 {
 	cdfs := make([][]float64, 256)
-	// parallel worker pool
-	workers := runtime.GOMAXPROCS(0)
-	if workers < 1 {
-		workers = 1
-	}
-	jobs := make(chan int, 256)
-	var wg sync.WaitGroup
-	wg.Add(workers)
-	for w := 0; w < workers; w++ {
-		go func() {
-			defer wg.Done()
-			for ch := range jobs {
-				lambda := (float64(ch) / 255.0) * amount
-				if lambda <= 0 {
-					cdfs[ch] = []float64{1.0}
-					continue
+	parallelRows(256, func(ch0, ch1 int) {
+		for ch := ch0; ch < ch1; ch++ {
+			lambda := (float64(ch) / 255.0) * amount
+			if lambda <= 0 {
+				cdfs[ch] = []float64{1.0}
+				continue
+			}
+			// pump PMF until cumulative nearly 1
+			cdf := make([]float64, 0, 32)
+			p := math.Exp(-lambda) // p0
+			cum := p
+			cdf = append(cdf, cum)
+			k := 1
+			// upper bound heuristic
+			upper := int(math.Ceil(lambda + 10*math.Sqrt(lambda) + 10))
+			if upper < 32 {
+				upper = 32
+			}
+			for cum < 1-1e-12 && k <= upper {
+				p = p * lambda / float64(k)
+				cum += p
+				// guard
+				if cum > 1 {
+					cum = 1
 				}
-				// pump PMF until cumulative nearly 1
-				cdf := make([]float64, 0, 32)
-				p := math.Exp(-lambda) // p0
-				cum := p
 				cdf = append(cdf, cum)
-				k := 1
-				// upper bound heuristic
-				upper := int(math.Ceil(lambda + 10*math.Sqrt(lambda) + 10))
-				if upper < 32 {
-					upper = 32
-				}
-				for cum < 1-1e-12 && k <= upper {
-					p = p * lambda / float64(k)
-					cum += p
-					// guard
-					if cum > 1 {
-						cum = 1
-					}
-					cdf = append(cdf, cum)
-					k++
-				}
-				cdfs[ch] = cdf
+				k++
 			}
-		}()
-	}
-	for ch := 0; ch < 256; ch++ {
-		jobs <- ch
-	}
-	close(jobs)
-	wg.Wait()
+			cdfs[ch] = cdf
+		}
+	})
 	return cdfs
 }
 
@@ -0,0 +1,644 @@
+package stdimg
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+)
+
+// 16-bit working buffers. Commands that have a 16-bit implementation (see
+// Register64) run on *image.NRGBA64 when the input has more than 8 bits per
+// channel, so precision survives repeated point operations and resampling.
+// Channel values are 0..65535; user-facing arguments keep their 8-bit scale
+// (a level black point of 10 means 10/255 either way).
+
+// Is16Bit reports whether img stores 16 bits per channel.
+func Is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, *image.Alpha16:
+		return true
+	}
+	return false
+}
+
+// ToNRGBA64 converts any image.Image to a new *image.NRGBA64.
+func ToNRGBA64(src image.Image) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	out := image.NewNRGBA64(b)
+	switch s := src.(type) {
+	case *image.NRGBA64:
+		for y := 0; y < b.Dy(); y++ {
+			copy(out.Pix[y*out.Stride:y*out.Stride+b.Dx()*8], s.Pix[y*s.Stride:])
+		}
+		return out
+	case *image.NRGBA:
+		// widen exactly: v*257 maps 0..255 onto 0..65535
+		parallelRows(b.Dy(), func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				si := y * s.Stride
+				di := y * out.Stride
+				for x := 0; x < b.Dx()*4; x++ {
+					out.Pix[di+2*x] = s.Pix[si+x]
+					out.Pix[di+2*x+1] = s.Pix[si+x]
+				}
+			}
+		})
+		return out
+	}
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				out.SetNRGBA64(x, y, color.NRGBA64Model.Convert(src.At(x, y)).(color.NRGBA64))
+			}
+		}
+	})
+	return out
+}
+
+// CloneNRGBA64 returns a copy of src.
+func CloneNRGBA64(src *image.NRGBA64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	out := image.NewNRGBA64(src.Rect)
+	copy(out.Pix, src.Pix)
+	return out
+}
+
+// get64 returns the channels of the pixel at Pix offset i.
+func get64(img *image.NRGBA64, i int) (r, g, b, a uint16) {
+	p := img.Pix[i : i+8 : i+8]
+	return uint16(p[0])<<8 | uint16(p[1]), uint16(p[2])<<8 | uint16(p[3]), uint16(p[4])<<8 | uint16(p[5]), uint16(p[6])<<8 | uint16(p[7])
+}
+
+// set64 stores the channels of the pixel at Pix offset i.
+func set64(img *image.NRGBA64, i int, r, g, b, a uint16) {
+	p := img.Pix[i : i+8 : i+8]
+	p[0], p[1] = uint8(r>>8), uint8(r)
+	p[2], p[3] = uint8(g>>8), uint8(g)
+	p[4], p[5] = uint8(b>>8), uint8(b)
+	p[6], p[7] = uint8(a>>8), uint8(a)
+}
+
+// getF64 returns the pixel at (x, y), clamped to the image, as floats.
+func getF64(img *image.NRGBA64, x, y int) (r, g, b, a float64) {
+	bb := img.Bounds()
+	x = clampInt(x, bb.Min.X, bb.Max.X-1)
+	y = clampInt(y, bb.Min.Y, bb.Max.Y-1)
+	ri, gi, bi, ai := get64(img, img.PixOffset(x, y))
+	return float64(ri), float64(gi), float64(bi), float64(ai)
+}
+
+// to16 rounds v to the nearest channel value, clamping to 0..65535.
+func to16(v float64) uint16 {
+	if v <= 0 || math.IsNaN(v) {
+		return 0
+	}
+	if v >= 65535 {
+		return 65535
+	}
+	return uint16(v + 0.5)
+}
+
+// channelLUT64 tabulates f, a map from [0,1] to [0,1], for every 16-bit
+// channel value.
+func channelLUT64(f func(v float64) float64) *[65536]uint16 {
+	var lut [65536]uint16
+	for v := range lut {
+		lut[v] = to16(f(float64(v)/65535) * 65535)
+	}
+	return &lut
+}
+
+// mapRGB64 applies lut to the color channels of src, leaving alpha alone.
+func mapRGB64(src *image.NRGBA64, lut *[65536]uint16) *image.NRGBA64 {
+	out := CloneNRGBA64(src)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				set64(out, i, lut[r], lut[g], lut[bl], a)
+			}
+		}
+	})
+	return out
+}
+
+// mapColor64 replaces the color of every pixel of src by f of its position
+// and channels, all in 0..1, leaving alpha alone.
+func mapColor64(src *image.NRGBA64, f func(x, y int, r, g, b, a float64) (float64, float64, float64)) *image.NRGBA64 {
+	out := image.NewNRGBA64(src.Rect)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				rf, gf, bf := f(x, y, float64(r)/65535, float64(g)/65535, float64(bl)/65535, float64(a)/65535)
+				set64(out, i, to16(rf*65535), to16(gf*65535), to16(bf*65535), a)
+			}
+		}
+	})
+	return out
+}
+
+// Level64 is Level for 16-bit images. blackPoint and whitePoint stay in the
+// 0..255 range.
+func Level64(src *image.NRGBA64, blackPoint, gamma, whitePoint float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	if whitePoint <= blackPoint {
+		return CloneNRGBA64(src)
+	}
+	minV := blackPoint / 255
+	maxV := whitePoint / 255
+	return mapRGB64(src, channelLUT64(func(v float64) float64 {
+		n := math.Min(math.Max((v-minV)/(maxV-minV), 0), 1)
+		if gamma > 0 {
+			n = math.Pow(n, 1/gamma)
+		}
+		return n
+	}))
+}
+
+// Gamma64 is Gamma for 16-bit images.
+func Gamma64(src *image.NRGBA64, gamma float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	if gamma <= 0 || math.IsNaN(gamma) || math.IsInf(gamma, 0) {
+		return CloneNRGBA64(src)
+	}
+	inv := 1 / gamma
+	return mapRGB64(src, channelLUT64(func(v float64) float64 { return math.Pow(v, inv) }))
+}
+
+// Negate64 is Negate for 16-bit images.
+func Negate64(src *image.NRGBA64, onlyGray bool) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	if !onlyGray {
+		return mapRGB64(src, channelLUT64(func(v float64) float64 { return 1 - v }))
+	}
+	out := CloneNRGBA64(src)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				rf, gf, bf := float64(r)/65535, float64(g)/65535, float64(bl)/65535
+				lum := 0.2126*rf + 0.7152*gf + 0.0722*bf
+				invLum := 1 - lum
+				if lum <= 0 {
+					v := to16(invLum * 65535)
+					set64(out, i, v, v, v, a)
+					continue
+				}
+				set64(out, i, to16(invLum*rf/lum*65535), to16(invLum*gf/lum*65535), to16(invLum*bf/lum*65535), a)
+			}
+		}
+	})
+	return out
+}
+
+// Threshold64 is Threshold for 16-bit images; thresh stays in 0..255.
+func Threshold64(src *image.NRGBA64, thresh float64, perChannel bool) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	thresh = math.Min(math.Max(thresh, 0), 255)
+	level := func(v float64) uint16 {
+		if v*255 >= thresh {
+			return 65535
+		}
+		return 0
+	}
+	out := CloneNRGBA64(src)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				rf, gf, bf := float64(r)/65535, float64(g)/65535, float64(bl)/65535
+				if perChannel {
+					set64(out, i, level(rf), level(gf), level(bf), a)
+					continue
+				}
+				v := level(0.2126*rf + 0.7152*gf + 0.0722*bf)
+				set64(out, i, v, v, v, a)
+			}
+		}
+	})
+	return out
+}
+
+// Normalize64 is Normalize for 16-bit images.
+func Normalize64(src *image.NRGBA64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	lo := [3]uint16{65535, 65535, 65535}
+	hi := [3]uint16{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := get64(src, src.PixOffset(x, y))
+			for c, v := range [3]uint16{r, g, bl} {
+				lo[c] = min(lo[c], v)
+				hi[c] = max(hi[c], v)
+			}
+		}
+	}
+	var luts [3]*[65536]uint16
+	for c := range luts {
+		minV, maxV := float64(lo[c])/65535, float64(hi[c])/65535
+		luts[c] = channelLUT64(func(v float64) float64 {
+			if maxV <= minV {
+				return v
+			}
+			return (v - minV) / (maxV - minV)
+		})
+	}
+	out := CloneNRGBA64(src)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				set64(out, i, luts[0][r], luts[1][g], luts[2][bl], a)
+			}
+		}
+	})
+	return out
+}
+
+// AutoGamma64 is AutoGamma for 16-bit images.
+func AutoGamma64(src *image.NRGBA64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	total := float64(b.Dx() * b.Dy())
+	if total <= 0 {
+		return CloneNRGBA64(src)
+	}
+	mean := 0.0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := get64(src, src.PixOffset(x, y))
+			mean += (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl)) / 65535
+		}
+	}
+	mean /= total
+	if mean <= 0 || mean >= 1 {
+		return CloneNRGBA64(src)
+	}
+	gamma := math.Log(0.5) / math.Log(mean)
+	if math.IsNaN(gamma) || math.IsInf(gamma, 0) {
+		return CloneNRGBA64(src)
+	}
+	gamma = math.Min(math.Max(gamma, 0.1), 10)
+	return mapRGB64(src, channelLUT64(func(v float64) float64 { return math.Pow(v, gamma) }))
+}
+
+// Equalize64 is Equalize for 16-bit images, using one histogram bin per
+// channel value.
+func Equalize64(src *image.NRGBA64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	b := src.Bounds()
+	total := float64(b.Dx() * b.Dy())
+	if total == 0 {
+		return CloneNRGBA64(src)
+	}
+	hists := make([][65536]int, 3)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := get64(src, src.PixOffset(x, y))
+			hists[0][r]++
+			hists[1][g]++
+			hists[2][bl]++
+		}
+	}
+	var luts [3][65536]uint16
+	for c := range luts {
+		cdf := 0
+		for v := range luts[c] {
+			cdf += hists[c][v]
+			luts[c][v] = to16(float64(cdf) / total * 65535)
+		}
+	}
+	out := CloneNRGBA64(src)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				set64(out, i, luts[0][r], luts[1][g], luts[2][bl], a)
+			}
+		}
+	})
+	return out
+}
+
+// Grayscale64 converts src to Rec.709 luminance, keeping 16 bits.
+func Grayscale64(src *image.NRGBA64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	out := image.NewNRGBA64(src.Rect)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r, g, bl, a := get64(src, i)
+				lum := to16(0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl))
+				set64(out, i, lum, lum, lum, a)
+			}
+		}
+	})
+	return out
+}
+
+// Modulate64 is Modulate for 16-bit images.
+func Modulate64(src *image.NRGBA64, brightnessPct, saturationPct, hueDegrees float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	modulate := modulateFunc(brightnessPct, saturationPct, hueDegrees)
+	return mapColor64(src, func(_, _ int, r, g, b, _ float64) (float64, float64, float64) {
+		return modulate(r, g, b)
+	})
+}
+
+// Posterize64 is Posterize for 16-bit images: each channel keeps levels
+// evenly spaced values of the full 16-bit range.
+func Posterize64(src *image.NRGBA64, levels int) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	if levels < 2 {
+		return CloneNRGBA64(src)
+	}
+	n := float64(levels - 1)
+	return mapRGB64(src, channelLUT64(func(v float64) float64 { return math.Round(v*n) / n }))
+}
+
+// Vignette64 is Vignette for 16-bit images.
+func Vignette64(src *image.NRGBA64, radius, sigma float64, cx, cy int, strength float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	factor := vignetteFactor(src.Bounds(), radius, sigma, cx, cy)
+	return mapColor64(src, func(x, y int, r, g, b, _ float64) (float64, float64, float64) {
+		f := factor(x, y)
+		return r * f, g * f, b * f
+	})
+}
+
+// Sepia64 is SepiaTone for 16-bit images. It converts to and from linear
+// light exactly rather than through SepiaTone's 8-bit tables.
+func Sepia64(src *image.NRGBA64, percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve float64) *image.NRGBA64 {
+	if src == nil {
+		return nil
+	}
+	tone := sepiaToner(percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve)
+	if tone == nil {
+		return CloneNRGBA64(src)
+	}
+	decode := func(v float64) float64 {
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	encode := func(v float64) float64 {
+		if v <= 0.0031308 {
+			return 12.92 * v
+		}
+		return 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return mapColor64(src, func(_, _ int, r, g, b, a float64) (float64, float64, float64) {
+		if a == 0 {
+			// fully transparent pixels are kept as they are
+			return r, g, b
+		}
+		r2, g2, b2 := tone(decode(r), decode(g), decode(b))
+		return encode(clamp01(r2)), encode(clamp01(g2)), encode(clamp01(b2))
+	})
+}
+
+// UnsharpMask64 is UnsharpMask for 16-bit images; threshold stays in
+// 0..255.
+func UnsharpMask64(ctx context.Context, src *image.NRGBA64, radius, sigma, amount, threshold float64, progress ProgressFunc) (*image.NRGBA64, error) {
+	if src == nil {
+		return nil, nil
+	}
+	blurred, err := SeparableGaussianBlur64Context(ctx, src, sigma, progress)
+	if err != nil {
+		return nil, err
+	}
+	threshold *= 65535.0 / 255
+	out := image.NewNRGBA64(src.Rect)
+	b := src.Bounds()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				sr, sg, sb, sa := get64(src, i)
+				br, bg, bb, ba := get64(blurred, blurred.PixOffset(x-b.Min.X, y-b.Min.Y))
+				// mask = src - blurred
+				mr := float64(sr) - float64(br)
+				mg := float64(sg) - float64(bg)
+				mb := float64(sb) - float64(bb)
+				if threshold > 0 && math.Abs(mr) < threshold && math.Abs(mg) < threshold && math.Abs(mb) < threshold {
+					set64(out, i, sr, sg, sb, sa)
+					continue
+				}
+				set64(out, i, to16(float64(sr)+amount*mr), to16(float64(sg)+amount*mg), to16(float64(sb)+amount*mb), to16(float64(sa)+amount*(float64(sa)-float64(ba))))
+			}
+		}
+	})
+	return out, nil
+}
+
+// Crop64 copies the part of src inside rect into a new image whose origin
+// is (0, 0).
+func Crop64(src *image.NRGBA64, rect image.Rectangle) *image.NRGBA64 {
+	rect = rect.Intersect(src.Bounds())
+	out := image.NewNRGBA64(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	for y := 0; y < rect.Dy(); y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+rect.Dx()*8], src.Pix[src.PixOffset(rect.Min.X, rect.Min.Y+y):])
+	}
+	return out
+}
+
+// Flip64 mirrors src vertically.
+func Flip64(src *image.NRGBA64) *image.NRGBA64 {
+	out := image.NewNRGBA64(src.Rect)
+	b := src.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		copy(out.Pix[(b.Dy()-1-y)*out.Stride:(b.Dy()-y)*out.Stride], src.Pix[y*src.Stride:y*src.Stride+b.Dx()*8])
+	}
+	return out
+}
+
+// Flop64 mirrors src horizontally.
+func Flop64(src *image.NRGBA64) *image.NRGBA64 {
+	out := image.NewNRGBA64(src.Rect)
+	b := src.Bounds()
+	w := b.Dx()
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				si := y*src.Stride + x*8
+				di := y*out.Stride + (w-1-x)*8
+				copy(out.Pix[di:di+8], src.Pix[si:si+8])
+			}
+		}
+	})
+	return out
+}
+
+// Rotate64 rotates src by deg degrees with bilinear sampling, growing the
+// canvas to fit as rotate does.
+func Rotate64(src *image.NRGBA64, deg float64) *image.NRGBA64 {
+	sb := src.Bounds()
+	g := newRotation(sb.Dx(), sb.Dy(), deg)
+	out := image.NewNRGBA64(image.Rect(0, 0, g.w, g.h))
+	parallelRows(g.h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < g.w; x++ {
+				sx, sy := g.source(x, y)
+				x0, y0 := sb.Min.X+int(math.Floor(sx)), sb.Min.Y+int(math.Floor(sy))
+				fx, fy := sx-math.Floor(sx), sy-math.Floor(sy)
+				r00, g00, b00, a00 := getF64(src, x0, y0)
+				r10, g10, b10, a10 := getF64(src, x0+1, y0)
+				r01, g01, b01, a01 := getF64(src, x0, y0+1)
+				r11, g11, b11, a11 := getF64(src, x0+1, y0+1)
+				lerp := func(v00, v10, v01, v11 float64) uint16 {
+					top := v00*(1-fx) + v10*fx
+					bottom := v01*(1-fx) + v11*fx
+					return to16(top*(1-fy) + bottom*fy)
+				}
+				set64(out, out.PixOffset(x, y), lerp(r00, r10, r01, r11), lerp(g00, g10, g01, g11), lerp(b00, b10, b01, b11), lerp(a00, a10, a01, a11))
+			}
+		}
+	})
+	return out
+}
+
+// SeparableGaussianBlur64Context is SeparableGaussianBlurContext for 16-bit
+// images.
+func SeparableGaussianBlur64Context(ctx context.Context, src *image.NRGBA64, sigma float64, progress ProgressFunc) (*image.NRGBA64, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if sigma >= boxBlurMinSigma {
+		return boxBlur64Context(ctx, src, sigma, progress)
+	}
+	return convolveGaussian64Context(ctx, src, sigma, progress)
+}
+
+// convolveGaussian64Context is convolveGaussianContext for 16-bit images.
+func convolveGaussian64Context(ctx context.Context, src *image.NRGBA64, sigma float64, progress ProgressFunc) (*image.NRGBA64, error) {
+	if progress == nil {
+		progress = noProgress
+	}
+	kern, radius := gaussianKernel1D(sigma)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	dst := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	split := 0.5
+	if w+h > 0 {
+		split = float64(h) / float64(w+h)
+	}
+	pass := func(in, out *image.NRGBA64, length int, at func(i, k int) (x, y int)) func(i0, i1 int) {
+		ib := in.Bounds()
+		return func(i0, i1 int) {
+			for i := i0; i < i1; i++ {
+				for k := 0; k < length; k++ {
+					var sr, sg, sb, sa, wsum float64
+					for d := -radius; d <= radius; d++ {
+						x, y := at(i, clampInt(k+d, 0, length-1))
+						r, g, bl, a := getF64(in, ib.Min.X+x, ib.Min.Y+y)
+						wgt := kern[d+radius]
+						sr += r * wgt
+						sg += g * wgt
+						sb += bl * wgt
+						sa += a * wgt
+						wsum += wgt
+					}
+					x, y := at(i, k)
+					set64(out, out.PixOffset(x, y), to16(sr/wsum), to16(sg/wsum), to16(sb/wsum), to16(sa/wsum))
+				}
+			}
+		}
+	}
+	// horizontal pass over rows, then vertical pass over columns
+	row := func(i, k int) (int, int) { return k, i }
+	col := func(i, k int) (int, int) { return i, k }
+	if err := parallelRowsContext(ctx, h, subProgress(progress, 0, split), pass(src, tmp, w, row)); err != nil {
+		return nil, err
+	}
+	if err := parallelRowsContext(ctx, w, subProgress(progress, split, 1-split), pass(tmp, dst, h, col)); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// ResampleLanczos64Context is ResampleLanczosContext for 16-bit images.
+func ResampleLanczos64Context(ctx context.Context, src *image.NRGBA64, dstW, dstH int, a float64, progress ProgressFunc) (*image.NRGBA64, error) {
+	if src == nil {
+		return nil, nil
+	}
+	srcB := src.Bounds()
+	dst := image.NewNRGBA64(image.Rect(0, 0, dstW, dstH))
+	if dstW == 0 || dstH == 0 {
+		return dst, nil
+	}
+	xScale := float64(srcB.Dx()) / float64(dstW)
+	yScale := float64(srcB.Dy()) / float64(dstH)
+	err := parallelRowsContext(ctx, dstH, progress, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := (float64(y)+0.5)*yScale - 0.5
+			for x := 0; x < dstW; x++ {
+				sx := (float64(x)+0.5)*xScale - 0.5
+				var sumR, sumG, sumB, sumA, weightSum float64
+				for yi := int(math.Floor(sy - a + 1)); yi <= int(math.Ceil(sy+a-1)); yi++ {
+					wy := lanczosKernel(float64(yi)-sy, a)
+					for xi := int(math.Floor(sx - a + 1)); xi <= int(math.Ceil(sx+a-1)); xi++ {
+						w := lanczosKernel(float64(xi)-sx, a) * wy
+						r, g, b, al := getF64(src, srcB.Min.X+xi, srcB.Min.Y+yi)
+						sumR += r * w
+						sumG += g * w
+						sumB += b * w
+						sumA += al * w
+						weightSum += w
+					}
+				}
+				if weightSum == 0 {
+					weightSum = 1
+				}
+				set64(dst, dst.PixOffset(x, y), to16(sumR/weightSum), to16(sumG/weightSum), to16(sumB/weightSum), to16(sumA/weightSum))
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
@@ -0,0 +1,148 @@
+package stdimg
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// ramp64 returns a 16-bit horizontal ramp over the shadows, finer than one
+// 8-bit step per pixel.
+func ramp64(w, h int) *image.NRGBA64 {
+	img := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint16(x * 40)
+			set64(img, img.PixOffset(x, y), v, v, v, 65535)
+		}
+	}
+	return img
+}
+
+func TestGammaRoundTripKeepsPrecision(t *testing.T) {
+	src := ramp64(256, 2)
+	var img image.Image = src
+	for _, g := range []string{"2.2", "0.4545454545", "2.2", "0.4545454545"} {
+		var err error
+		img, err = ApplyCommandStdlib(img, "gamma", []string{g})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, ok := img.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("gamma on a 16-bit image returned %T", img)
+	}
+	distinct := map[uint16]bool{}
+	for x := 0; x < 256; x++ {
+		want, _, _, _ := get64(src, src.PixOffset(x, 0))
+		got, _, _, _ := get64(out, out.PixOffset(x, 0))
+		if d := int(got) - int(want); d < -64 || d > 64 {
+			t.Fatalf("x=%d: %d after round trips, want about %d", x, got, want)
+		}
+		distinct[got] = true
+	}
+	// The ramp covers 40 8-bit steps; at 8 bits it would collapse further.
+	if len(distinct) < 200 {
+		t.Errorf("only %d distinct levels survived", len(distinct))
+	}
+}
+
+func TestApplyCommandKeeps16BitDepth(t *testing.T) {
+	src := ramp64(16, 8)
+	// medianFilter has no 16-bit path: the 8-bit result is widened again.
+	for _, c := range []struct {
+		name string
+		args []string
+	}{{"level", []string{"10", "1.2", "240"}}, {"resize", []string{"8", "4"}}, {"blur", []string{"1"}}, {"sepia", nil}, {"medianFilter", []string{"1"}}} {
+		out, err := ApplyCommandStdlib(src, c.name, c.args)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if _, ok := out.(*image.NRGBA64); !ok {
+			t.Errorf("%s returned %T, want *image.NRGBA64", c.name, out)
+		}
+	}
+	out, err := ApplyCommandStdlib(gradientNRGBA(16, 8), "level", []string{"10", "1.2", "240"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := out.(*image.NRGBA); !ok {
+		t.Errorf("8-bit input returned %T, want *image.NRGBA", out)
+	}
+}
+
+func TestModulate64KeepsLowByte(t *testing.T) {
+	src := ramp64(256, 2)
+	img, err := ApplyCommandStdlib(src, "modulate", []string{"110", "100", "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, ok := img.(*image.NRGBA64)
+	if !ok {
+		t.Fatalf("modulate on a 16-bit image returned %T", img)
+	}
+	fine := 0
+	for x := 0; x < 256; x++ {
+		v, _, _, _ := get64(src, src.PixOffset(x, 0))
+		got, _, _, _ := get64(out, out.PixOffset(x, 0))
+		if want := to16(float64(v) * 1.1); int(got) < int(want)-1 || int(got) > int(want)+1 {
+			t.Fatalf("x=%d: %d, want about %d", x, got, want)
+		}
+		if got%257 != 0 {
+			fine++
+		}
+	}
+	// A round trip through 8 bits leaves only multiples of 257.
+	if fine < 200 {
+		t.Errorf("only %d of 256 values kept their low byte", fine)
+	}
+}
+
+func Test16BitMatches8BitGeometry(t *testing.T) {
+	src := gradientNRGBA(13, 9)
+	wide := ToNRGBA64(src)
+	if got := ToNRGBA(wide); !bytes.Equal(got.Pix, src.Pix) {
+		t.Fatal("ToNRGBA(ToNRGBA64(img)) changed the pixels")
+	}
+	// The 8-bit rotate truncates its interpolated values, so allow one step.
+	for _, c := range []struct {
+		name string
+		args []string
+		tol  int
+	}{{"flip", nil, 0}, {"flop", nil, 0}, {"negate", nil, 0}, {"rotate", []string{"90"}, 1}} {
+		want, err := ApplyCommandStdlib(src, c.name, c.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ApplyCommandStdlib(wide, c.name, c.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, w := ToNRGBA(got), ToNRGBA(want)
+		if g.Rect != w.Rect {
+			t.Fatalf("%s: bounds %v, want %v", c.name, g.Rect, w.Rect)
+		}
+		for i := range g.Pix {
+			if d := int(g.Pix[i]) - int(w.Pix[i]); d < -c.tol || d > c.tol {
+				t.Fatalf("%s: byte %d is %d, want %d", c.name, i, g.Pix[i], w.Pix[i])
+			}
+		}
+	}
+
+	out, err := ApplyCommandStdlib(wide, "crop", []string{"4", "3", "2", "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ToNRGBA(out)
+	if c.Rect != image.Rect(0, 0, 4, 3) {
+		t.Fatalf("crop bounds = %v", c.Rect)
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			if c.NRGBAAt(x, y) != src.NRGBAAt(x+2, y+5) {
+				t.Fatalf("crop pixel (%d,%d) = %v, want %v", x, y, c.NRGBAAt(x, y), src.NRGBAAt(x+2, y+5))
+			}
+		}
+	}
+}
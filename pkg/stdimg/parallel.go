@@ -0,0 +1,93 @@
+package stdimg
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// workerCount is the number of goroutines filters may use; 0 means
+// GOMAXPROCS.
+var workerCount atomic.Int32
+
+// maxChunkRows bounds the rows handed to a worker at a time, so work stays
+// balanced and cancellation and progress stay responsive.
+const maxChunkRows = 16
+
+// SetWorkers sets how many goroutines filters may use. n <= 0 selects
+// GOMAXPROCS (the default); 1 runs every filter serially on the calling
+// goroutine. Output does not depend on the setting: work is split by rows,
+// and every row is computed the same way whichever goroutine runs it.
+func SetWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	workerCount.Store(int32(n))
+}
+
+// Workers returns the number of goroutines filters currently use.
+func Workers() int {
+	if n := int(workerCount.Load()); n > 0 {
+		return n
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallelRows calls fn for consecutive bands [y0, y1) covering [0, n),
+// spread over Workers() goroutines. fn must only write to data owned by its
+// rows.
+func parallelRows(n int, fn func(y0, y1 int)) {
+	_ = parallelRowsContext(context.Background(), n, nil, fn)
+}
+
+// parallelRowsContext is parallelRows with cancellation and progress
+// reporting. No new band is started once ctx is cancelled; the result is
+// ctx.Err().
+func parallelRowsContext(ctx context.Context, n int, progress ProgressFunc, fn func(y0, y1 int)) error {
+	if n <= 0 {
+		return ctx.Err()
+	}
+	if progress == nil {
+		progress = noProgress
+	}
+	workers := Workers()
+	chunk := n / (workers * 4)
+	if chunk < 1 {
+		chunk = 1
+	} else if chunk > maxChunkRows {
+		chunk = maxChunkRows
+	}
+	chunks := (n + chunk - 1) / chunk
+	if workers > chunks {
+		workers = chunks
+	}
+	rows := newRowCounter(n, progress)
+	var next atomic.Int64
+	work := func() {
+		for ctx.Err() == nil {
+			c := int(next.Add(1)) - 1
+			if c >= chunks {
+				return
+			}
+			y0 := c * chunk
+			y1 := min(y0+chunk, n)
+			fn(y0, y1)
+			rows.add(y1 - y0)
+		}
+	}
+	if workers <= 1 {
+		work()
+		return ctx.Err()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,134 @@
+package stdimg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+)
+
+func withWorkers(t *testing.T, n int) {
+	t.Helper()
+	prev := workerCount.Load()
+	SetWorkers(n)
+	t.Cleanup(func() { workerCount.Store(prev) })
+}
+
+func TestParallelRowsCoversEveryRowOnce(t *testing.T) {
+	for _, workers := range []int{1, 3, 8} {
+		withWorkers(t, workers)
+		for _, n := range []int{0, 1, 7, 64, 1001} {
+			hits := make([]int32, n)
+			parallelRows(n, func(y0, y1 int) {
+				for y := y0; y < y1; y++ {
+					atomic.AddInt32(&hits[y], 1)
+				}
+			})
+			for y, c := range hits {
+				if c != 1 {
+					t.Fatalf("workers=%d n=%d: row %d visited %d times", workers, n, y, c)
+				}
+			}
+		}
+	}
+}
+
+func TestParallelRowsContextCancel(t *testing.T) {
+	withWorkers(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	var rows atomic.Int32
+	err := parallelRowsContext(ctx, 1000, nil, func(y0, y1 int) {
+		rows.Add(int32(y1 - y0))
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if rows.Load() >= 1000 {
+		t.Error("cancellation should stop further bands")
+	}
+}
+
+func TestSetWorkers(t *testing.T) {
+	withWorkers(t, 3)
+	if Workers() != 3 {
+		t.Errorf("Workers() = %d, want 3", Workers())
+	}
+	SetWorkers(0)
+	if Workers() < 1 {
+		t.Errorf("default Workers() = %d", Workers())
+	}
+}
+
+var workerCases = []struct {
+	name string
+	args []string
+}{
+	{"resize", []string{"50", "31"}},
+	{"rotate", []string{"33"}},
+	{"blur", []string{"2.5"}},
+	{"blur", []string{"12"}},
+	{"medianFilter", []string{"2"}},
+	{"despeckle", []string{""}},
+	{"level", []string{"10", "1.4", "240"}},
+	{"normalize", nil},
+	{"autoLevel", nil},
+	{"autoGamma", nil},
+	{"gamma", []string{"1.8"}},
+	{"negate", []string{"false"}},
+	{"negate", []string{"true"}},
+	{"threshold", []string{"120", "false"}},
+	{"threshold", []string{"120", "true"}},
+	{"modulate", []string{"110", "80", "30"}},
+	{"vignette", []string{"20", "10", "30", "20", "0.8"}},
+	{"grayscale", nil},
+	{"edge", []string{"1", "1.5", "0", "false"}},
+	{"edge", []string{"0", "1", "40", "true"}},
+	{"adaptiveBlur", []string{"", "", "", ""}},
+	{"adaptiveResize", []string{"37", "0", "3"}},
+	{"adaptiveSharpen", []string{"0", "1", "1"}},
+	{"adaptiveThreshold", []string{"15", "15", "0"}},
+	{"adaptiveThreshold", []string{"15", "15", "0", "sauvola"}},
+	{"addNoise", []string{"GAUSSIAN", "10", "7"}},
+	{"crop", []string{"20", "15", "3", "4"}},
+	{"flip", nil},
+	{"flop", nil},
+	{"equalize", nil},
+	{"trim", []string{"5"}},
+	{"sepia", []string{"70%", "50", "20", "80", "10", "1"}},
+	{"posterize", []string{"4"}},
+	{"posterize", []string{"3", "bayer"}},
+	{"quantize", []string{"16", "floyd-steinberg"}},
+	{"quantize", []string{"12", "bayer", "octree"}},
+	{"sharpen", []string{"0", "1"}},
+	{"floodfillPaint", []string{"#ff0000", "20%", "", "5", "5", "false"}},
+	{"selectSimilar", []string{"#804020", "30"}},
+	{"addNoise", []string{"POISSON", "20", "3"}},
+	{"unsharpMask", []string{"0", "1.2", "1.5", "3"}},
+}
+
+// Results must not depend on how many goroutines do the work.
+func TestCommandsIndependentOfWorkers(t *testing.T) {
+	src := gradientNRGBA(73, 61)
+	run := func(n int) [][]byte {
+		withWorkers(t, n)
+		var outs [][]byte
+		for _, c := range workerCases {
+			out, err := ApplyCommandStdlib(src, c.name, c.args)
+			if err != nil {
+				t.Fatalf("%s %q: %v", c.name, c.args, err)
+			}
+			outs = append(outs, ToNRGBA(out.(image.Image)).Pix)
+		}
+		return outs
+	}
+	serial := run(1)
+	parallel := run(5)
+	for i, c := range workerCases {
+		if !bytes.Equal(serial[i], parallel[i]) {
+			t.Errorf("%s %q: serial and parallel results differ", c.name, c.args)
+		}
+	}
+}
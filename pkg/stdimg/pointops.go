@@ -0,0 +1,243 @@
+package stdimg
+
+import (
+	"image"
+	"math"
+)
+
+// Point operations map every pixel independently of its neighbours, so a
+// run of them can be fused and evaluated in one pass over the image (see
+// Graph). Each is described by a pointOp; the exported functions (Level,
+// Gamma, ...) evaluate a single pointOp, which keeps fused and step-by-step
+// results bit-identical.
+
+// pointOp is one per-pixel operation. Operations that map R, G and B through
+// the same function of the channel value carry a lut and leave alpha alone;
+// the others carry a kernel that rewrites one NRGBA pixel in place.
+type pointOp struct {
+	lut    *[256]uint8
+	kernel func(p []uint8)
+}
+
+// lutOp builds a pointOp from a per-channel function.
+func lutOp(f func(v uint8) uint8) pointOp {
+	var lut [256]uint8
+	for i := range lut {
+		lut[i] = f(uint8(i))
+	}
+	return pointOp{lut: &lut}
+}
+
+// identityOp leaves pixels unchanged.
+func identityOp() pointOp {
+	return lutOp(func(v uint8) uint8 { return v })
+}
+
+// fusePointOps combines ops, applied in order, into a single pointOp.
+// Adjacent lookup tables are composed into one table.
+func fusePointOps(ops []pointOp) pointOp {
+	var stages []pointOp
+	for _, op := range ops {
+		if n := len(stages); n > 0 && op.lut != nil && stages[n-1].lut != nil {
+			var lut [256]uint8
+			for i, v := range stages[n-1].lut {
+				lut[i] = op.lut[v]
+			}
+			stages[n-1] = pointOp{lut: &lut}
+			continue
+		}
+		stages = append(stages, op)
+	}
+	switch len(stages) {
+	case 0:
+		return identityOp()
+	case 1:
+		return stages[0]
+	}
+	return pointOp{kernel: func(p []uint8) {
+		for _, st := range stages {
+			st.applyPixel(p)
+		}
+	}}
+}
+
+func (op pointOp) applyPixel(p []uint8) {
+	if op.lut != nil {
+		p[0], p[1], p[2] = op.lut[p[0]], op.lut[p[1]], op.lut[p[2]]
+		return
+	}
+	op.kernel(p)
+}
+
+// apply returns a copy of src with op applied to every pixel.
+func (op pointOp) apply(src *image.NRGBA) *image.NRGBA {
+	out := CloneNRGBA(src)
+	w := out.Rect.Dx()
+	parallelRows(out.Rect.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := out.Pix[y*out.Stride : y*out.Stride+w*4]
+			if op.lut != nil {
+				for i := 0; i < len(row); i += 4 {
+					row[i], row[i+1], row[i+2] = op.lut[row[i]], op.lut[row[i+1]], op.lut[row[i+2]]
+				}
+				continue
+			}
+			for i := 0; i < len(row); i += 4 {
+				op.kernel(row[i : i+4 : i+4])
+			}
+		}
+	})
+	return out
+}
+
+// levelOp maps (v-black)/(white-black), clamped to [0,1] and raised to
+// 1/gamma when gamma > 0, back to 0..255. It is the identity when
+// white <= black.
+func levelOp(blackPoint, gamma, whitePoint float64) pointOp {
+	minV, maxV := blackPoint, whitePoint
+	if maxV <= minV {
+		return identityOp()
+	}
+	invGamma := 1.0
+	if gamma > 0 {
+		invGamma = 1.0 / gamma
+	}
+	return lutOp(func(v uint8) uint8 {
+		n := math.Min(math.Max((float64(v)-minV)/(maxV-minV), 0.0), 1.0)
+		if gamma > 0 {
+			n = math.Pow(n, invGamma)
+		}
+		return uint8(clampFloatToUint8(n * 255.0))
+	})
+}
+
+// gammaOp raises each channel to 1/gamma; invalid gammas are the identity.
+func gammaOp(gamma float64) pointOp {
+	if gamma <= 0 || math.IsNaN(gamma) || math.IsInf(gamma, 0) {
+		return identityOp()
+	}
+	inv := 1.0 / gamma
+	return lutOp(func(v uint8) uint8 {
+		return uint8(clampFloatToUint8(math.Pow(float64(v)/255.0, inv) * 255.0))
+	})
+}
+
+// negateOp inverts each channel, or with onlyGray inverts the luminance
+// and scales the channels to match.
+func negateOp(onlyGray bool) pointOp {
+	if !onlyGray {
+		return lutOp(func(v uint8) uint8 { return 255 - v })
+	}
+	return pointOp{kernel: func(p []uint8) {
+		rf := float64(p[0]) / 255.0
+		gf := float64(p[1]) / 255.0
+		bf := float64(p[2]) / 255.0
+		lum := 0.2126*rf + 0.7152*gf + 0.0722*bf
+		invLum := 1.0 - lum
+		if lum <= 0 {
+			p[0] = uint8(clampFloatToUint8(invLum * 255.0))
+			p[1] = p[0]
+			p[2] = p[0]
+			return
+		}
+		p[0] = uint8(clampFloatToUint8(invLum * (rf / lum) * 255.0))
+		p[1] = uint8(clampFloatToUint8(invLum * (gf / lum) * 255.0))
+		p[2] = uint8(clampFloatToUint8(invLum * (bf / lum) * 255.0))
+	}}
+}
+
+// thresholdOp sets pixels to black or white by luminance, or each channel
+// separately with perChannel. thresh is clamped to 0..255.
+func thresholdOp(thresh float64, perChannel bool) pointOp {
+	thresh = math.Min(math.Max(thresh, 0), 255)
+	if perChannel {
+		return lutOp(func(v uint8) uint8 {
+			if float64(v) >= thresh {
+				return 255
+			}
+			return 0
+		})
+	}
+	return pointOp{kernel: func(p []uint8) {
+		rf := float64(p[0]) / 255.0
+		gf := float64(p[1]) / 255.0
+		bf := float64(p[2]) / 255.0
+		lum := 0.2126*rf + 0.7152*gf + 0.0722*bf
+		v := uint8(0)
+		if lum*255.0 >= thresh {
+			v = 255
+		}
+		p[0], p[1], p[2] = v, v, v
+	}}
+}
+
+// modulateOp scales lightness and saturation by the given percentages and
+// rotates the hue by hueDegrees, in HSL.
+func modulateOp(brightnessPct, saturationPct, hueDegrees float64) pointOp {
+	modulate := modulateFunc(brightnessPct, saturationPct, hueDegrees)
+	return pointOp{kernel: func(p []uint8) {
+		r2, g2, b2 := modulate(float64(p[0])/255.0, float64(p[1])/255.0, float64(p[2])/255.0)
+		p[0] = uint8(clampFloatToUint8(r2 * 255.0))
+		p[1] = uint8(clampFloatToUint8(g2 * 255.0))
+		p[2] = uint8(clampFloatToUint8(b2 * 255.0))
+	}}
+}
+
+// modulateFunc is modulateOp on RGB values in 0..1, shared with Modulate64.
+func modulateFunc(brightnessPct, saturationPct, hueDegrees float64) func(r, g, b float64) (float64, float64, float64) {
+	bFactor := brightnessPct / 100.0
+	sFactor := saturationPct / 100.0
+	hueShift := hueDegrees / 360.0 // convert to 0..1
+	return func(r, g, b float64) (float64, float64, float64) {
+		h, s, l := rgbToHsl(r, g, b)
+		// apply hue shift
+		h = math.Mod(h+hueShift, 1.0)
+		// adjust saturation and lightness
+		s = clamp01(s * sFactor)
+		l = clamp01(l * bFactor)
+		return hslToRgb(h, s, l)
+	}
+}
+
+// Builders for registerPoint: they parse a command's arguments exactly as
+// its handler does.
+
+func levelPoint(args []string) (pointOp, error) {
+	blackPoint, gamma, whitePoint, err := parseLevelArgs(args)
+	if err != nil {
+		return pointOp{}, err
+	}
+	return levelOp(blackPoint, gamma, whitePoint), nil
+}
+
+func gammaPoint(args []string) (pointOp, error) {
+	gamma, err := parseGammaArgs(args)
+	if err != nil {
+		return pointOp{}, err
+	}
+	return gammaOp(gamma), nil
+}
+
+func negatePoint(args []string) (pointOp, error) {
+	onlyGray, err := parseNegateArgs(args)
+	if err != nil {
+		return pointOp{}, err
+	}
+	return negateOp(onlyGray), nil
+}
+
+func thresholdPoint(args []string) (pointOp, error) {
+	thresh, perChannel, err := parseThresholdArgs(args)
+	if err != nil {
+		return pointOp{}, err
+	}
+	return thresholdOp(thresh, perChannel), nil
+}
+
+func modulatePoint(args []string) (pointOp, error) {
+	brightness, saturation, hue, err := parseModulateArgs(args)
+	if err != nil {
+		return pointOp{}, err
+	}
+	return modulateOp(brightness, saturation, hue), nil
+}
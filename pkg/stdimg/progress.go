@@ -0,0 +1,104 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+)
+
+// ProgressFunc receives the fraction of a command's work completed so far,
+// from 0 to 1. Calls are serialized and the fraction never decreases, but
+// they may come from any goroutine.
+type ProgressFunc func(done float64)
+
+func noProgress(float64) {}
+
+// ApplyCommandContext is ApplyCommandStdlib with cancellation and progress
+// reporting. Long-running commands check ctx between rows and return
+// ctx.Err() once it is cancelled; progress, if non-nil, is called as work
+// completes.
+func ApplyCommandContext(ctx context.Context, img image.Image, commandName string, args []string, progress ProgressFunc) (image.Image, error) {
+	if img == nil {
+		return nil, fmt.Errorf("source image is nil")
+	}
+	_, fn, ok := lookupContext(commandName)
+	if !ok {
+		return nil, fmt.Errorf("unsupported command in stdlib engine: %s", commandName)
+	}
+	if progress == nil {
+		progress = noProgress
+	}
+	report := newProgressTracker(progress).report
+	var out image.Image
+	var err error
+	deep := Is16Bit(img)
+	if fn64, ok := lookup64(commandName); ok && deep {
+		out, err = fn64(ctx, ToNRGBA64(img), args, report)
+	} else {
+		out, err = fn(ctx, ToNRGBA(img), args, report)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deep && out != nil && !Is16Bit(out) {
+		// Commands without a 16-bit path still hand back a 16-bit image so
+		// the rest of the pipeline and the saved file keep their depth.
+		out = ToNRGBA64(out)
+	}
+	progress(1)
+	return out, nil
+}
+
+// progressTracker serializes calls to a ProgressFunc, keeps the reported
+// fraction monotonic and drops updates of less than 0.5%.
+type progressTracker struct {
+	mu   sync.Mutex
+	fn   ProgressFunc
+	last float64
+}
+
+func newProgressTracker(fn ProgressFunc) *progressTracker {
+	return &progressTracker{fn: fn, last: -1}
+}
+
+func (t *progressTracker) report(done float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if done < t.last+0.005 && done < 1 {
+		return
+	}
+	if done > 1 {
+		done = 1
+	}
+	t.last = done
+	t.fn(done)
+}
+
+// subProgress maps a stage's own 0..1 progress into [start, start+span) of
+// the overall progress.
+func subProgress(progress ProgressFunc, start, span float64) ProgressFunc {
+	return func(done float64) { progress(start + span*done) }
+}
+
+// rowCounter reports progress as rows complete. It is safe for concurrent
+// use and serializes the calls to progress.
+type rowCounter struct {
+	mu       sync.Mutex
+	done     int
+	total    int
+	progress ProgressFunc
+}
+
+func newRowCounter(total int, progress ProgressFunc) *rowCounter {
+	return &rowCounter{total: total, progress: progress}
+}
+
+func (c *rowCounter) add(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done += n
+	if c.total > 0 {
+		c.progress(float64(c.done) / float64(c.total))
+	}
+}
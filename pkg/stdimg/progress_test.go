@@ -0,0 +1,90 @@
+package stdimg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradientNRGBA(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 5), B: uint8(x ^ y), A: 255})
+		}
+	}
+	return img
+}
+
+func TestApplyCommandContextProgress(t *testing.T) {
+	src := gradientNRGBA(40, 30)
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"blur", []string{"2"}},
+		{"medianFilter", []string{"1"}},
+		{"resize", []string{"20", "15"}},
+		{"adaptiveBlur", []string{"", "", "", ""}},
+		{"negate", nil},
+	} {
+		var got []float64
+		out, err := ApplyCommandContext(context.Background(), src, tc.name, tc.args, func(done float64) {
+			got = append(got, done)
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(got) == 0 || got[len(got)-1] != 1 {
+			t.Errorf("%s: progress should end at 1, got %v", tc.name, got)
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i] < got[i-1] {
+				t.Errorf("%s: progress went backwards: %v", tc.name, got)
+				break
+			}
+		}
+		want, err := ApplyCommandStdlib(src, tc.name, tc.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.(*image.NRGBA).Pix, want.(*image.NRGBA).Pix) {
+			t.Errorf("%s: context and plain results differ", tc.name)
+		}
+	}
+}
+
+func TestApplyCommandContextCancel(t *testing.T) {
+	src := gradientNRGBA(64, 64)
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"blur", []string{"3"}},
+		{"medianFilter", []string{"2"}},
+		{"resize", []string{"128", "128"}},
+		{"adaptiveBlur", []string{"", "", "", ""}},
+	} {
+		// cancel part-way through, from the progress callback
+		ctx, cancel := context.WithCancel(context.Background())
+		out, err := ApplyCommandContext(ctx, src, tc.name, tc.args, func(done float64) {
+			if done > 0.1 {
+				cancel()
+			}
+		})
+		cancel()
+		if !errors.Is(err, context.Canceled) || out != nil {
+			t.Errorf("%s: got %v, %v; want nil, context.Canceled", tc.name, out, err)
+		}
+	}
+
+	// commands without their own checks still refuse to start
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ApplyCommandContext(ctx, src, "negate", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("negate: err = %v, want context.Canceled", err)
+	}
+}
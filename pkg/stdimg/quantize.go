@@ -0,0 +1,458 @@
+package stdimg
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Palette builders of Quantize.
+const (
+	// QuantizeMedianCut splits the color space box with the most pixels
+	// times the widest spread at its median until there are enough boxes.
+	QuantizeMedianCut = "median-cut"
+	// QuantizeOctree merges the sparsest branches of an octree of the
+	// colors until few enough leaves remain.
+	QuantizeOctree = "octree"
+)
+
+// QuantizeMethods lists the palette builders accepted by Quantize.
+var QuantizeMethods = []string{QuantizeMedianCut, QuantizeOctree}
+
+// MaxPaletteColors is the largest palette Quantize builds, the size of a
+// GIF color table.
+const MaxPaletteColors = 256
+
+// histBits is the precision of each channel in the color histogram the
+// palettes are built from. Bins keep the sums of the colors they collect,
+// so palette entries are exact means rather than bin centers.
+const histBits = 5
+
+// colorBin gathers the pixels of one histogram bin.
+type colorBin struct {
+	sum [3]uint64
+	n   uint64
+}
+
+func (c colorBin) mean() [3]uint8 {
+	var m [3]uint8
+	for k := range m {
+		m[k] = uint8((c.sum[k] + c.n/2) / c.n)
+	}
+	return m
+}
+
+func (c colorBin) color() color.NRGBA {
+	m := c.mean()
+	return color.NRGBA{m[0], m[1], m[2], 255}
+}
+
+func (c *colorBin) add(o colorBin) {
+	for k := range c.sum {
+		c.sum[k] += o.sum[k]
+	}
+	c.n += o.n
+}
+
+// colorHistogram returns the non-empty bins of the histogram of the pixels
+// of src with an alpha of at least minAlpha, in bin order.
+func colorHistogram(ctx context.Context, src *image.NRGBA, minAlpha uint8, progress ProgressFunc) ([]colorBin, error) {
+	const shift = 8 - histBits
+	b := src.Bounds()
+	w := b.Dx()
+	bins := make([]colorBin, 1<<(3*histBits))
+	var mu sync.Mutex
+	err := parallelRowsContext(ctx, b.Dy(), progress, func(y0, y1 int) {
+		local := make([]colorBin, len(bins))
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := src.PixOffset(x+b.Min.X, y+b.Min.Y)
+				if src.Pix[i+3] < minAlpha {
+					continue
+				}
+				r, g, bl := src.Pix[i], src.Pix[i+1], src.Pix[i+2]
+				bin := &local[int(r>>shift)<<(2*histBits)|int(g>>shift)<<histBits|int(bl>>shift)]
+				bin.sum[0] += uint64(r)
+				bin.sum[1] += uint64(g)
+				bin.sum[2] += uint64(bl)
+				bin.n++
+			}
+		}
+		mu.Lock()
+		for i := range local {
+			bins[i].add(local[i])
+		}
+		mu.Unlock()
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := bins[:0]
+	for _, c := range bins {
+		if c.n > 0 {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// MedianCutPalette returns a palette of at most n colors for src by median
+// cut. Fully transparent pixels are ignored.
+func MedianCutPalette(src *image.NRGBA, n int) color.Palette {
+	bins, _ := colorHistogram(context.Background(), src, 1, nil)
+	return medianCut(bins, n)
+}
+
+// OctreePalette returns a palette of at most n colors for src from an
+// octree of its colors. Fully transparent pixels are ignored.
+func OctreePalette(src *image.NRGBA, n int) color.Palette {
+	bins, _ := colorHistogram(context.Background(), src, 1, nil)
+	return octree(bins, n)
+}
+
+// buildPalette returns the palette of at most n colors that method builds
+// from bins.
+func buildPalette(bins []colorBin, n int, method string) color.Palette {
+	if method == QuantizeOctree {
+		return octree(bins, n)
+	}
+	return medianCut(bins, n)
+}
+
+// medianCut reorders bins into boxes and returns the mean color of each.
+func medianCut(bins []colorBin, n int) color.Palette {
+	type box struct {
+		lo, hi int // bins[lo:hi]
+		n      uint64
+		axis   int
+		spread int
+	}
+	measure := func(lo, hi int) box {
+		bx := box{lo: lo, hi: hi}
+		mn, mx := [3]int{255, 255, 255}, [3]int{}
+		for _, c := range bins[lo:hi] {
+			bx.n += c.n
+			for k, v := range c.mean() {
+				mn[k] = min(mn[k], int(v))
+				mx[k] = max(mx[k], int(v))
+			}
+		}
+		for k := range mn {
+			if s := mx[k] - mn[k]; s > bx.spread {
+				bx.axis, bx.spread = k, s
+			}
+		}
+		return bx
+	}
+	if len(bins) == 0 || n <= 0 {
+		return nil
+	}
+	boxes := []box{measure(0, len(bins))}
+	for len(boxes) < n {
+		// split the box with the most pixels times spread
+		best, score := -1, uint64(0)
+		for i, bx := range boxes {
+			if s := bx.n * uint64(bx.spread); bx.hi-bx.lo > 1 && s > score {
+				best, score = i, s
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bx := boxes[best]
+		part := bins[bx.lo:bx.hi]
+		slices.SortStableFunc(part, func(a, b colorBin) int {
+			return cmp.Compare(a.mean()[bx.axis], b.mean()[bx.axis])
+		})
+		// the weighted median, keeping a bin on each side
+		mid, acc := 1, part[0].n
+		for mid < len(part)-1 && acc+part[mid].n <= bx.n/2 {
+			acc += part[mid].n
+			mid++
+		}
+		boxes[best] = measure(bx.lo, bx.lo+mid)
+		boxes = append(boxes, measure(bx.lo+mid, bx.hi))
+	}
+	pal := make(color.Palette, len(boxes))
+	for i, bx := range boxes {
+		var c colorBin
+		for _, b := range bins[bx.lo:bx.hi] {
+			c.add(b)
+		}
+		pal[i] = c.color()
+	}
+	return pal
+}
+
+// octree inserts the bins into an octree histBits levels deep, one leaf per
+// bin, then folds the leaves of the deepest, least populated nodes into
+// their parent until at most n leaves remain. It can end with fewer than n
+// colors, since a fold removes up to seven leaves at once.
+func octree(bins []colorBin, n int) color.Palette {
+	type node struct {
+		colorBin
+		children [8]int32 // 0 for none; the root is never a child
+		level    int
+		leaf     bool
+	}
+	if len(bins) == 0 || n <= 0 {
+		return nil
+	}
+	nodes := []node{{}}
+	// the inner nodes of each level
+	levels := make([][]int32, histBits)
+	levels[0] = []int32{0}
+	for _, c := range bins {
+		m := c.mean()
+		cur := int32(0)
+		for level := 0; level < histBits; level++ {
+			nodes[cur].add(c)
+			bit := 7 - level
+			child := (m[0]>>bit&1)<<2 | (m[1]>>bit&1)<<1 | m[2]>>bit&1
+			next := nodes[cur].children[child]
+			if next == 0 {
+				next = int32(len(nodes))
+				nodes[cur].children[child] = next
+				nodes = append(nodes, node{level: level + 1, leaf: level+1 == histBits})
+				if level+1 < histBits {
+					levels[level+1] = append(levels[level+1], next)
+				}
+			}
+			cur = next
+		}
+		nodes[cur].add(c)
+	}
+	leaves := 0
+	for _, nd := range nodes {
+		if nd.leaf {
+			leaves++
+		}
+	}
+	for level := histBits - 1; level >= 0 && leaves > n; level-- {
+		parents := levels[level]
+		slices.SortStableFunc(parents, func(a, b int32) int {
+			return cmp.Compare(nodes[a].n, nodes[b].n)
+		})
+		for _, p := range parents {
+			if leaves <= n {
+				break
+			}
+			kids := 0
+			for k, c := range nodes[p].children {
+				if c != 0 {
+					kids++
+					nodes[p].children[k] = 0
+				}
+			}
+			nodes[p].leaf = true
+			leaves -= kids - 1
+		}
+	}
+	var pal color.Palette
+	var walk func(i int32)
+	walk = func(i int32) {
+		if nodes[i].leaf {
+			pal = append(pal, nodes[i].color())
+			return
+		}
+		for _, c := range nodes[i].children {
+			if c != 0 {
+				walk(c)
+			}
+		}
+	}
+	walk(0)
+	return pal
+}
+
+// matcherCacheBits sets the size of the cache of paletteMatcher.
+const matcherCacheBits = 16
+
+// paletteMatcher finds the nearest palette entry to a color, remembering
+// recent answers in a direct-mapped cache so that memory stays bounded on
+// images with millions of colors. It is not safe for concurrent use.
+type paletteMatcher struct {
+	pal [][3]int32
+	// byRed lists the palette indexes in order of red, so that a search
+	// can stop once the red difference alone exceeds the best distance
+	byRed []int
+	// cache entries hold 1<<32 | color<<8 | index, zero when empty
+	cache []uint64
+}
+
+func newPaletteMatcher(pal color.Palette) *paletteMatcher {
+	m := &paletteMatcher{pal: make([][3]int32, len(pal)), cache: make([]uint64, 1<<matcherCacheBits)}
+	for i, c := range pal {
+		n := color.NRGBAModel.Convert(c).(color.NRGBA)
+		m.pal[i] = [3]int32{int32(n.R), int32(n.G), int32(n.B)}
+		m.byRed = append(m.byRed, i)
+	}
+	slices.SortStableFunc(m.byRed, func(a, b int) int { return cmp.Compare(m.pal[a][0], m.pal[b][0]) })
+	return m
+}
+
+// index returns the index of the palette entry nearest to r, g, b in RGB.
+func (m *paletteMatcher) index(r, g, b uint8) uint8 {
+	key := uint32(r)<<16 | uint32(g)<<8 | uint32(b)
+	slot := &m.cache[(key*2654435761)>>(32-matcherCacheBits)]
+	if e := *slot; e>>32 == 1 && uint32(e)>>8 == key {
+		return uint8(e)
+	}
+	// walk outwards from the entries of the nearest red; ties go to the
+	// lowest index, as in a plain scan
+	start, _ := slices.BinarySearchFunc(m.byRed, int32(r), func(i int, v int32) int { return cmp.Compare(m.pal[i][0], v) })
+	best, bestD := 0, int32(1<<30)
+	try := func(i int) bool {
+		p := m.pal[i]
+		dr, dg, db := p[0]-int32(r), p[1]-int32(g), p[2]-int32(b)
+		if dr*dr > bestD {
+			return false
+		}
+		if d := dr*dr + dg*dg + db*db; d < bestD || d == bestD && i < best {
+			best, bestD = i, d
+		}
+		return true
+	}
+	for lo, hi := start-1, start; lo >= 0 || hi < len(m.byRed); {
+		if hi < len(m.byRed) && !try(m.byRed[hi]) {
+			hi = len(m.byRed)
+		} else {
+			hi++
+		}
+		if lo >= 0 && !try(m.byRed[lo]) {
+			lo = -1
+		} else {
+			lo--
+		}
+	}
+	*slot = 1<<32 | uint64(key)<<8 | uint64(best)
+	return uint8(best)
+}
+
+// parseQuantizeArgs checks the method and the number of colors of
+// Quantize.
+func parseQuantizeArgs(n int, method string) (string, error) {
+	if n < 2 || n > MaxPaletteColors {
+		return "", fmt.Errorf("colors must be between 2 and %d", MaxPaletteColors)
+	}
+	if method == "" {
+		return QuantizeMedianCut, nil
+	}
+	method = strings.ToLower(method)
+	if !slices.Contains(QuantizeMethods, method) {
+		return "", fmt.Errorf("unknown quantizer %q (want %s)", method, strings.Join(QuantizeMethods, ", "))
+	}
+	return method, nil
+}
+
+// Quantize reduces src to a palette of at most n colors (2..256) built by
+// method (median-cut if empty), mapping pixels to it with the given
+// dithering (see DitherMethods). Alpha is kept.
+func Quantize(src *image.NRGBA, n int, method, dither string) (*image.NRGBA, error) {
+	return quantizeContext(context.Background(), src, n, method, dither, nil)
+}
+
+func quantizeContext(ctx context.Context, src *image.NRGBA, n int, method, dither string, progress ProgressFunc) (*image.NRGBA, error) {
+	method, err := parseQuantizeArgs(n, method)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = noProgress
+	}
+	bins, err := colorHistogram(ctx, src, 1, subProgress(progress, 0, 0.3))
+	if err != nil {
+		return nil, err
+	}
+	pal := buildPalette(bins, n, method)
+	if len(pal) == 0 {
+		// nothing visible to build a palette from
+		return CloneNRGBA(src), nil
+	}
+	return remapContext(ctx, src, pal, dither, subProgress(progress, 0.3, 0.7))
+}
+
+// Remap maps every pixel of src to a color of pal with the given
+// dithering. Alpha is kept.
+func Remap(src *image.NRGBA, pal color.Palette, dither string) (*image.NRGBA, error) {
+	return remapContext(context.Background(), src, pal, dither, nil)
+}
+
+func remapContext(ctx context.Context, src *image.NRGBA, pal color.Palette, dither string, progress ProgressFunc) (*image.NRGBA, error) {
+	if len(pal) == 0 {
+		return nil, fmt.Errorf("empty palette")
+	}
+	newNearest := func() nearestFunc {
+		m := newPaletteMatcher(pal)
+		return func(r, g, b uint8) (uint8, uint8, uint8) {
+			p := m.pal[m.index(r, g, b)]
+			return uint8(p[0]), uint8(p[1]), uint8(p[2])
+		}
+	}
+	return ditherContext(ctx, src, dither, paletteSpread(len(pal)), newNearest, progress)
+}
+
+// PalettedImage converts src to an image of at most n colors (2..256) for
+// formats with a color table, such as GIF. Pixels less than half opaque
+// share a transparent entry, which takes one of the n colors when there
+// are any.
+func PalettedImage(src *image.NRGBA, n int, method, dither string) (*image.Paletted, error) {
+	method, err := parseQuantizeArgs(n, method)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	b := src.Bounds()
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y && !transparent; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if src.Pix[src.PixOffset(x, y)+3] < 128 {
+				transparent = true
+				break
+			}
+		}
+	}
+	colors := n
+	if transparent {
+		colors--
+	}
+	bins, err := colorHistogram(ctx, src, 128, nil)
+	if err != nil {
+		return nil, err
+	}
+	pal := buildPalette(bins, colors, method)
+	if len(pal) == 0 {
+		pal = color.Palette{color.NRGBA{0, 0, 0, 255}}
+	}
+	mapped, err := remapContext(ctx, src, pal, dither, nil)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[color.NRGBA]uint8, len(pal))
+	for i, c := range pal {
+		index[c.(color.NRGBA)] = uint8(i)
+	}
+	if transparent {
+		pal = append(pal, color.NRGBA{})
+	}
+	out := image.NewPaletted(b, pal)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				i := mapped.PixOffset(x+b.Min.X, y+b.Min.Y)
+				o := out.PixOffset(x+b.Min.X, y+b.Min.Y)
+				if src.Pix[i+3] < 128 {
+					out.Pix[o] = uint8(len(pal) - 1)
+					continue
+				}
+				out.Pix[o] = index[color.NRGBA{mapped.Pix[i], mapped.Pix[i+1], mapped.Pix[i+2], 255}]
+			}
+		}
+	})
+	return out, nil
+}
@@ -0,0 +1,148 @@
+package stdimg
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func distinctColors(img *image.NRGBA) map[color.NRGBA]bool {
+	seen := map[color.NRGBA]bool{}
+	for i := 0; i < len(img.Pix); i += 4 {
+		seen[color.NRGBA{img.Pix[i], img.Pix[i+1], img.Pix[i+2], 255}] = true
+	}
+	return seen
+}
+
+// An image with fewer colors than the palette comes back unchanged, with
+// or without error diffusion. (Bayer dithering offsets every pixel by up
+// to half the estimated palette spacing, so it may pick other entries.)
+func TestQuantizeKeepsFewColors(t *testing.T) {
+	colors := []color.NRGBA{{200, 30, 30, 255}, {20, 180, 40, 255}, {30, 40, 220, 255}, {250, 250, 250, 255}, {0, 0, 0, 255}}
+	src := image.NewNRGBA(image.Rect(0, 0, 30, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 30; x++ {
+			src.SetNRGBA(x, y, colors[(x/6+y/5)%len(colors)])
+		}
+	}
+	for _, method := range QuantizeMethods {
+		for _, dither := range []string{DitherNone, DitherFloydSteinberg, DitherAtkinson, DitherSierra} {
+			out, err := Quantize(src, 8, method, dither)
+			if err != nil {
+				t.Fatalf("%s %s: %v", method, dither, err)
+			}
+			for i := range src.Pix {
+				if out.Pix[i] != src.Pix[i] {
+					t.Fatalf("%s %s: pixel %d changed", method, dither, i/4)
+				}
+			}
+		}
+	}
+}
+
+func TestQuantizePaletteSize(t *testing.T) {
+	src := gradientNRGBA(64, 48)
+	for _, method := range QuantizeMethods {
+		for _, n := range []int{2, 7, 16, 256} {
+			pal := buildPalette(mustHistogram(t, src), n, method)
+			if len(pal) == 0 || len(pal) > n {
+				t.Errorf("%s: %d colors for a palette of %d", method, len(pal), n)
+			}
+			out, err := Quantize(src, n, method, DitherFloydSteinberg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(distinctColors(out)); got > n {
+				t.Errorf("%s %d: output has %d colors", method, n, got)
+			}
+		}
+	}
+	if p := MedianCutPalette(src, 16); len(p) != 16 {
+		t.Errorf("median cut gave %d colors, want 16", len(p))
+	}
+	if p := OctreePalette(src, 16); len(p) == 0 || len(p) > 16 {
+		t.Errorf("octree gave %d colors", len(p))
+	}
+}
+
+func mustHistogram(t *testing.T, src *image.NRGBA) []colorBin {
+	t.Helper()
+	bins, err := colorHistogram(t.Context(), src, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bins
+}
+
+// Dithering a flat gray to black and white keeps its mean, where plain
+// rounding cannot.
+func TestDitherKeepsMean(t *testing.T) {
+	src := makeSolidNRGBA(64, 64, color.NRGBA{R: 90, G: 90, B: 90, A: 255})
+	mean := func(img *image.NRGBA) float64 {
+		sum := 0
+		for i := 0; i < len(img.Pix); i += 4 {
+			sum += int(img.Pix[i])
+		}
+		return float64(sum) / float64(len(img.Pix)/4)
+	}
+	plain, err := PosterizeDither(src, 2, DitherNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := mean(plain); m != 0 {
+		t.Fatalf("undithered mean %v, want 0", m)
+	}
+	for _, d := range []string{DitherFloydSteinberg, DitherSierra, DitherBayer} {
+		out, err := PosterizeDither(src, 2, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := mean(out); m < 85 || m > 95 {
+			t.Errorf("%s: mean %v, want about 90", d, m)
+		}
+		if len(distinctColors(out)) != 2 {
+			t.Errorf("%s: not reduced to black and white", d)
+		}
+	}
+	// Atkinson drops a quarter of the error, so it only has to move
+	// towards the gray.
+	out, _ := PosterizeDither(src, 2, DitherAtkinson)
+	if m := mean(out); m <= 30 || m > 95 {
+		t.Errorf("atkinson: mean %v", m)
+	}
+}
+
+func TestQuantizeArgErrors(t *testing.T) {
+	src := gradientNRGBA(8, 8)
+	for _, args := range [][]string{{"1"}, {"257"}, {"x"}, {"8", "stucki"}, {"8", "none", "kmeans"}} {
+		if _, err := ApplyCommandStdlib(src, "quantize", args); err == nil {
+			t.Errorf("quantize %q: no error", args)
+		}
+	}
+	if _, err := ApplyCommandStdlib(src, "posterize", []string{"4", "stucki"}); err == nil {
+		t.Error("posterize with an unknown dither: no error")
+	}
+}
+
+func TestPalettedImageTransparency(t *testing.T) {
+	src := gradientNRGBA(20, 10)
+	for x := 0; x < 20; x++ {
+		src.Pix[src.PixOffset(x, 0)+3] = 0
+	}
+	p, err := PalettedImage(src, 16, QuantizeOctree, DitherFloydSteinberg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Palette) > 16 {
+		t.Fatalf("%d palette entries", len(p.Palette))
+	}
+	transparent := uint8(len(p.Palette) - 1)
+	if _, _, _, a := p.Palette[transparent].RGBA(); a != 0 {
+		t.Fatal("no transparent entry last")
+	}
+	for x := 0; x < 20; x++ {
+		if p.ColorIndexAt(x, 0) != transparent || p.ColorIndexAt(x, 1) == transparent {
+			t.Fatalf("column %d: wrong transparency", x)
+		}
+	}
+}
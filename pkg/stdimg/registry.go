@@ -0,0 +1,160 @@
+package stdimg
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+)
+
+// Handler implements a command. src is a private copy of the input that the
+// handler may modify and return. args holds the normalized argument strings,
+// normally one per ArgSpec with "" for an omitted optional argument.
+type Handler func(src *image.NRGBA, args []string) (image.Image, error)
+
+// ContextHandler is a Handler for long-running commands. It should return
+// ctx.Err() promptly once ctx is cancelled and report its progress through
+// progress, which is never nil.
+type ContextHandler func(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error)
+
+// Handler64 is the 16-bit implementation of a command, used instead of the
+// 8-bit handler when the input has 16 bits per channel.
+type Handler64 func(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error)
+
+var (
+	registryMu sync.RWMutex
+	handlers   = map[string]ContextHandler{}
+	handlers64 = map[string]Handler64{}
+	// pointBuilders holds the built-in point operations that Graph fuses.
+	pointBuilders = map[string]func(args []string) (pointOp, error){}
+)
+
+// Commands lists the registered commands in registration order: the
+// built-ins first, then any added with Register. It is maintained by
+// Register and Unregister and should be treated as read-only.
+var Commands []CommandSpec
+
+// Register adds a command. Programs that embed the engine can call it (for
+// example from an init function) to make their own commands available to
+// ApplyCommandStdlib and to everything that lists Commands. It panics if
+// spec has no name, fn is nil, or the name is already registered.
+func Register(spec CommandSpec, fn Handler) {
+	if fn == nil {
+		panic("stdimg: Register " + spec.Name + " with nil handler")
+	}
+	RegisterContext(spec, func(ctx context.Context, src *image.NRGBA, args []string, progress ProgressFunc) (image.Image, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return fn(src, args)
+	})
+}
+
+// RegisterContext is Register for a command that supports cancellation and
+// progress reporting.
+func RegisterContext(spec CommandSpec, fn ContextHandler) {
+	if spec.Name == "" {
+		panic("stdimg: Register with empty command name")
+	}
+	if fn == nil {
+		panic("stdimg: Register " + spec.Name + " with nil handler")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := handlers[spec.Name]; dup {
+		panic(fmt.Sprintf("stdimg: command %q registered twice", spec.Name))
+	}
+	handlers[spec.Name] = fn
+	Commands = append(Commands, spec)
+}
+
+// Register64 adds a 16-bit implementation to the already registered command
+// name. It panics if name is not registered, fn is nil, or name already has
+// one.
+func Register64(name string, fn Handler64) {
+	if fn == nil {
+		panic("stdimg: Register64 " + name + " with nil handler")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := handlers[name]; !ok {
+		panic(fmt.Sprintf("stdimg: Register64 of unknown command %q", name))
+	}
+	if _, dup := handlers64[name]; dup {
+		panic(fmt.Sprintf("stdimg: 16-bit command %q registered twice", name))
+	}
+	handlers64[name] = fn
+}
+
+// registerPoint marks the already registered command name as a point
+// operation that build describes, so that Graph can fuse it with its
+// neighbours. The command's handler must produce the same pixels.
+func registerPoint(name string, build func(args []string) (pointOp, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := handlers[name]; !ok {
+		panic(fmt.Sprintf("stdimg: registerPoint of unknown command %q", name))
+	}
+	pointBuilders[name] = build
+}
+
+// Unregister removes a command, reporting whether it was registered.
+func Unregister(name string) bool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := handlers[name]; !ok {
+		return false
+	}
+	delete(handlers, name)
+	delete(handlers64, name)
+	delete(pointBuilders, name)
+	for i, c := range Commands {
+		if c.Name == name {
+			// Copy so earlier snapshots of Commands are not disturbed.
+			Commands = append(Commands[:i:i], Commands[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// Lookup returns the spec and handler registered under name. The handler
+// of a context-aware command runs without cancellation.
+func Lookup(name string) (CommandSpec, Handler, bool) {
+	spec, fn, ok := lookupContext(name)
+	if !ok {
+		return CommandSpec{}, nil, false
+	}
+	return spec, func(src *image.NRGBA, args []string) (image.Image, error) {
+		return fn(context.Background(), src, args, noProgress)
+	}, true
+}
+
+func lookupContext(name string) (CommandSpec, ContextHandler, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := handlers[name]
+	if !ok {
+		return CommandSpec{}, nil, false
+	}
+	for _, c := range Commands {
+		if c.Name == name {
+			return c, fn, true
+		}
+	}
+	return CommandSpec{}, fn, true
+}
+
+func lookup64(name string) (Handler64, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := handlers64[name]
+	return fn, ok
+}
+
+func lookupPoint(name string) (func(args []string) (pointOp, error), bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := pointBuilders[name]
+	return fn, ok
+}
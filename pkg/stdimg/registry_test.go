@@ -0,0 +1,137 @@
+package stdimg
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestEveryCommandHasHandler(t *testing.T) {
+	seen := map[string]bool{}
+	for _, c := range Commands {
+		if seen[c.Name] {
+			t.Errorf("command %q listed twice", c.Name)
+		}
+		seen[c.Name] = true
+		if _, fn, ok := Lookup(c.Name); !ok || fn == nil {
+			t.Errorf("command %q has no handler", c.Name)
+		}
+	}
+	for _, name := range []string{"posterize", "sharpen", "unsharpMask"} {
+		if !seen[name] {
+			t.Errorf("%s is not registered", name)
+		}
+	}
+}
+
+func TestRegisterCustomCommand(t *testing.T) {
+	spec := CommandSpec{Name: "testFill", Args: []ArgSpec{{"value", "int", true, "", "gray value"}}, Usage: "testFill <value>"}
+	var got []string
+	Register(spec, func(src *image.NRGBA, args []string) (image.Image, error) {
+		got = args
+		for i := range src.Pix {
+			src.Pix[i] = 42
+		}
+		return src, nil
+	})
+	defer Unregister("testFill")
+
+	if c, _, ok := Lookup("testFill"); !ok || c.Usage != "testFill <value>" {
+		t.Fatalf("Lookup = %+v, %v", c, ok)
+	}
+	if Commands[len(Commands)-1].Name != "testFill" {
+		t.Error("registered command should be appended to Commands")
+	}
+	in := makeSolidNRGBA(2, 2, color.NRGBA{R: 1, G: 2, B: 3, A: 255})
+	out, err := ApplyCommandStdlib(in, "testFill", []string{"42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "42" {
+		t.Errorf("handler args = %q", got)
+	}
+	if out.(*image.NRGBA).Pix[0] != 42 || in.Pix[0] != 1 {
+		t.Error("handler should work on a copy of the input")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("duplicate Register should panic")
+			}
+		}()
+		Register(spec, func(src *image.NRGBA, args []string) (image.Image, error) { return src, nil })
+	}()
+
+	if !Unregister("testFill") || Unregister("testFill") {
+		t.Error("Unregister should report whether the command existed")
+	}
+	if _, err := ApplyCommandStdlib(in, "testFill", nil); err == nil || !strings.Contains(err.Error(), "unsupported command") {
+		t.Errorf("unregistered command error = %v", err)
+	}
+}
+
+func TestRegister64(t *testing.T) {
+	Register(CommandSpec{Name: "testDepth"}, func(src *image.NRGBA, args []string) (image.Image, error) {
+		return src, nil
+	})
+	defer Unregister("testDepth")
+	var ran64 bool
+	Register64("testDepth", func(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+		ran64 = true
+		return src, nil
+	})
+
+	if _, err := ApplyCommandStdlib(makeSolidNRGBA(2, 2, color.NRGBA{A: 255}), "testDepth", nil); err != nil || ran64 {
+		t.Fatalf("8-bit input: err=%v, 16-bit handler ran=%v", err, ran64)
+	}
+	if _, err := ApplyCommandStdlib(image.NewNRGBA64(image.Rect(0, 0, 2, 2)), "testDepth", nil); err != nil || !ran64 {
+		t.Fatalf("16-bit input: err=%v, 16-bit handler ran=%v", err, ran64)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Register64 of an unknown command should panic")
+			}
+		}()
+		Register64("noSuchCommand", func(ctx context.Context, src *image.NRGBA64, args []string, progress ProgressFunc) (image.Image, error) {
+			return src, nil
+		})
+	}()
+
+	Unregister("testDepth")
+	if _, ok := lookup64("testDepth"); ok {
+		t.Error("Unregister should drop the 16-bit handler too")
+	}
+}
+
+func TestNewlyExposedCommands(t *testing.T) {
+	src := makeSolidNRGBA(6, 6, color.NRGBA{R: 100, G: 150, B: 200, A: 255})
+	src.Pix[src.PixOffset(3, 3)] = 250
+	cases := []struct {
+		name string
+		args []string
+		want *image.NRGBA
+	}{
+		{"posterize", []string{"4"}, Posterize(src, 4)},
+		{"sharpen", []string{"", "1.5"}, Sharpen(src, 0, 1.5)},
+		{"unsharpMask", []string{"0", "1", "2", "5"}, UnsharpMask(src, 0, 1, 2, 5)},
+		{"unsharpMask", nil, UnsharpMask(src, 0, 1, 1, 0)},
+	}
+	for _, tc := range cases {
+		out, err := ApplyCommandStdlib(src, tc.name, tc.args)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if !bytes.Equal(out.(*image.NRGBA).Pix, tc.want.Pix) {
+			t.Errorf("%s %q differs from calling the function directly", tc.name, tc.args)
+		}
+	}
+	if _, err := ApplyCommandStdlib(src, "sharpen", []string{"x"}); err == nil {
+		t.Error("expected an error for a bad radius")
+	}
+}
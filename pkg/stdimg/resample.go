@@ -1,6 +1,7 @@
 package stdimg
 
 import (
+	"context"
 	"image"
 	"math"
 )
@@ -72,15 +73,22 @@ func lanczosKernel(x, a float64) float64 {
 
 // ResampleLanczos resamples src to dstW x dstH using Lanczos with window a (commonly 3).
 func ResampleLanczos(src *image.NRGBA, dstW, dstH int, a float64) *image.NRGBA {
+	out, _ := ResampleLanczosContext(context.Background(), src, dstW, dstH, a, nil)
+	return out
+}
+
+// ResampleLanczosContext is ResampleLanczos with cancellation and progress
+// reporting. Destination rows are computed in parallel.
+func ResampleLanczosContext(ctx context.Context, src *image.NRGBA, dstW, dstH int, a float64, progress ProgressFunc) (*image.NRGBA, error) {
 	if src == nil {
-		return nil
+		return nil, nil
 	}
 	srcB := src.Bounds()
 	srcW := srcB.Dx()
 	srcH := srcB.Dy()
 	dst := image.NewNRGBA(image.Rect(0, 0, dstW, dstH))
 	if dstW == 0 || dstH == 0 {
-		return dst
+		return dst, nil
 	}
 
 	// scale factors
@@ -88,42 +96,47 @@ func ResampleLanczos(src *image.NRGBA, dstW, dstH int, a float64) *image.NRGBA {
 	yScale := float64(srcH) / float64(dstH)
 
 	// for each destination pixel, compute source coordinate and apply lanczos window.
-	for y := 0; y < dstH; y++ {
-		sy := (float64(y)+0.5)*yScale - 0.5
-		for x := 0; x < dstW; x++ {
-			sx := (float64(x)+0.5)*xScale - 0.5
-			// accumulate
-			sumR, sumG, sumB, sumA := 0.0, 0.0, 0.0, 0.0
-			weightSum := 0.0
-			// kernel extent
-			xMin := int(math.Floor(sx - a + 1))
-			xMax := int(math.Ceil(sx + a - 1))
-			yMin := int(math.Floor(sy - a + 1))
-			yMax := int(math.Ceil(sy + a - 1))
-			for yi := yMin; yi <= yMax; yi++ {
-				wy := lanczosKernel(float64(yi)-sy, a)
-				for xi := xMin; xi <= xMax; xi++ {
-					wx := lanczosKernel(float64(xi)-sx, a)
-					w := wx * wy
-					c := samplePixelClamped(src, xi, yi)
-					sumR += float64(c.R) * w
-					sumG += float64(c.G) * w
-					sumB += float64(c.B) * w
-					sumA += float64(c.A) * w
-					weightSum += w
+	err := parallelRowsContext(ctx, dstH, progress, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			sy := (float64(y)+0.5)*yScale - 0.5
+			for x := 0; x < dstW; x++ {
+				sx := (float64(x)+0.5)*xScale - 0.5
+				// accumulate
+				sumR, sumG, sumB, sumA := 0.0, 0.0, 0.0, 0.0
+				weightSum := 0.0
+				// kernel extent
+				xMin := int(math.Floor(sx - a + 1))
+				xMax := int(math.Ceil(sx + a - 1))
+				yMin := int(math.Floor(sy - a + 1))
+				yMax := int(math.Ceil(sy + a - 1))
+				for yi := yMin; yi <= yMax; yi++ {
+					wy := lanczosKernel(float64(yi)-sy, a)
+					for xi := xMin; xi <= xMax; xi++ {
+						wx := lanczosKernel(float64(xi)-sx, a)
+						w := wx * wy
+						c := samplePixelClamped(src, xi, yi)
+						sumR += float64(c.R) * w
+						sumG += float64(c.G) * w
+						sumB += float64(c.B) * w
+						sumA += float64(c.A) * w
+						weightSum += w
+					}
 				}
+				if weightSum == 0 {
+					weightSum = 1
+				}
+				i := dst.PixOffset(x, y)
+				dst.Pix[i+0] = uint8(clampFloatToUint8(sumR / weightSum))
+				dst.Pix[i+1] = uint8(clampFloatToUint8(sumG / weightSum))
+				dst.Pix[i+2] = uint8(clampFloatToUint8(sumB / weightSum))
+				dst.Pix[i+3] = uint8(clampFloatToUint8(sumA / weightSum))
 			}
-			if weightSum == 0 {
-				weightSum = 1
-			}
-			i := dst.PixOffset(x, y)
-			dst.Pix[i+0] = uint8(clampFloatToUint8(sumR / weightSum))
-			dst.Pix[i+1] = uint8(clampFloatToUint8(sumG / weightSum))
-			dst.Pix[i+2] = uint8(clampFloatToUint8(sumB / weightSum))
-			dst.Pix[i+3] = uint8(clampFloatToUint8(sumA / weightSum))
 		}
+	})
+	if err != nil {
+		return nil, err
 	}
-	return dst
+	return dst, nil
 }
 
 // clampFloatToUint8 ensures v in [0,255]
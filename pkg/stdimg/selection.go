@@ -0,0 +1,138 @@
+package stdimg
+
+import (
+	"image"
+	"image/color"
+)
+
+// Selections are masks (see mask.go) built from the colors of an image,
+// magic-wand style: the same Lab delta-E region grow that floodfillPaint
+// uses, returned as a mask instead of being painted.
+
+// SelectByColorAt returns a mask selecting the pixels connected to (x,y)
+// (8-way) whose color is within fuzz (Lab delta-E) of the pixel at (x,y).
+func SelectByColorAt(src *image.NRGBA, x, y int, fuzz float64) *image.Gray {
+	b := src.Bounds()
+	if b.Empty() {
+		return image.NewGray(b)
+	}
+	return bitsToMask(floodRegion(src, fuzz, color.NRGBA{}, x, y, false), b)
+}
+
+// SelectSimilar returns a mask selecting every pixel of src within fuzz
+// (Lab delta-E) of c, whether connected or not. Alpha is ignored.
+func SelectSimilar(src *image.NRGBA, c color.NRGBA, fuzz float64) *image.Gray {
+	b := src.Bounds()
+	m := image.NewGray(b)
+	l0, a0, b0 := rgbToLab(c)
+	fuzzSq := fuzz * fuzz
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				i := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+				l, a, bb := rgbToLab(color.NRGBA{src.Pix[i], src.Pix[i+1], src.Pix[i+2], 255})
+				dl, da, db := l-l0, a-a0, bb-b0
+				if dl*dl+da*da+db*db <= fuzzSq {
+					m.Pix[y*m.Stride+x] = 255
+				}
+			}
+		}
+	})
+	return m
+}
+
+// bitsToMask expands a regionBits over b into a mask.
+func bitsToMask(bits regionBits, b image.Rectangle) *image.Gray {
+	m := image.NewGray(b)
+	w := b.Dx()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < w; x++ {
+			if bits.get(y*w+x) == 1 {
+				m.Pix[y*m.Stride+x] = 255
+			}
+		}
+	}
+	return m
+}
+
+// AddMasks returns the union (per-pixel maximum) of a and b, which must
+// cover the same bounds.
+func AddMasks(a, b *image.Gray) *image.Gray {
+	return combineMasks(a, b, func(p, q uint8) uint8 { return max(p, q) })
+}
+
+// SubtractMasks returns a with b taken away: a*(255-b)/255.
+func SubtractMasks(a, b *image.Gray) *image.Gray {
+	return combineMasks(a, b, func(p, q uint8) uint8 {
+		return uint8((int(p)*(255-int(q)) + 127) / 255)
+	})
+}
+
+func combineMasks(a, b *image.Gray, op func(p, q uint8) uint8) *image.Gray {
+	out := image.NewGray(a.Rect)
+	for y := 0; y < a.Rect.Dy(); y++ {
+		for x := 0; x < a.Rect.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = op(a.Pix[y*a.Stride+x], b.Pix[y*b.Stride+x])
+		}
+	}
+	return out
+}
+
+// InvertMask returns 255-m.
+func InvertMask(m *image.Gray) *image.Gray {
+	out := image.NewGray(m.Rect)
+	for y := 0; y < m.Rect.Dy(); y++ {
+		for x := 0; x < m.Rect.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = 255 - m.Pix[y*m.Stride+x]
+		}
+	}
+	return out
+}
+
+// GrowMask dilates m by r pixels (a square neighbourhood, so corners grow
+// diagonally as far as edges do). A radius <= 0 returns m unchanged.
+func GrowMask(m *image.Gray, r int) *image.Gray {
+	return morphMask(m, r, func(p, q uint8) uint8 { return max(p, q) })
+}
+
+// ShrinkMask erodes m by r pixels. Selections touching the image edge are
+// not eroded from that side.
+func ShrinkMask(m *image.Gray, r int) *image.Gray {
+	return morphMask(m, r, func(p, q uint8) uint8 { return min(p, q) })
+}
+
+// morphMask applies the separable running min or max filter pick over a
+// (2r+1)x(2r+1) window. Pixels outside m do not take part.
+func morphMask(m *image.Gray, r int, pick func(p, q uint8) uint8) *image.Gray {
+	if r <= 0 {
+		return m
+	}
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tmp := image.NewGray(b)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w]
+			for x := 0; x < w; x++ {
+				v := row[x]
+				for k := max(0, x-r); k <= min(w-1, x+r); k++ {
+					v = pick(v, row[k])
+				}
+				tmp.Pix[y*tmp.Stride+x] = v
+			}
+		}
+	})
+	out := image.NewGray(b)
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				v := tmp.Pix[y*tmp.Stride+x]
+				for k := max(0, y-r); k <= min(h-1, y+r); k++ {
+					v = pick(v, tmp.Pix[k*tmp.Stride+x])
+				}
+				out.Pix[y*out.Stride+x] = v
+			}
+		}
+	})
+	return out
+}
@@ -0,0 +1,100 @@
+package stdimg
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// twoSquares is a 9x5 blue image with two red 2x2 squares that do not touch.
+func twoSquares() *image.NRGBA {
+	img := makeSolidNRGBA(9, 5, color.NRGBA{0, 0, 255, 255})
+	red := color.NRGBA{255, 0, 0, 255}
+	for _, p := range []image.Point{{1, 1}, {2, 1}, {1, 2}, {2, 2}, {6, 2}, {7, 2}, {6, 3}, {7, 3}} {
+		img.SetNRGBA(p.X, p.Y, red)
+	}
+	return img
+}
+
+func countSelected(m *image.Gray) int {
+	n := 0
+	for _, v := range m.Pix {
+		if v == 255 {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSelectByColorAtIsContiguous(t *testing.T) {
+	m := SelectByColorAt(twoSquares(), 1, 1, 5)
+	if got := countSelected(m); got != 4 {
+		t.Fatalf("selected %d pixels, want 4", got)
+	}
+	if m.GrayAt(2, 2).Y != 255 || m.GrayAt(6, 2).Y != 0 {
+		t.Errorf("wrong square selected")
+	}
+}
+
+func TestSelectSimilarIsGlobal(t *testing.T) {
+	m := SelectSimilar(twoSquares(), color.NRGBA{250, 5, 0, 255}, 10)
+	if got := countSelected(m); got != 8 {
+		t.Fatalf("selected %d pixels, want 8", got)
+	}
+	if m.GrayAt(7, 3).Y != 255 || m.GrayAt(0, 0).Y != 0 {
+		t.Errorf("wrong pixels selected")
+	}
+}
+
+func TestSelectionCombinators(t *testing.T) {
+	src := twoSquares()
+	left := SelectByColorAt(src, 1, 1, 5)
+	right := SelectByColorAt(src, 7, 3, 5)
+	both := AddMasks(left, right)
+	if got := countSelected(both); got != 8 {
+		t.Errorf("add: %d pixels, want 8", got)
+	}
+	if got := countSelected(SubtractMasks(both, left)); got != 4 {
+		t.Errorf("subtract: %d pixels, want 4", got)
+	}
+	if got := countSelected(IntersectMasks(both, left)); got != 4 {
+		t.Errorf("intersect: %d pixels, want 4", got)
+	}
+	if got := countSelected(InvertMask(both)); got != 9*5-8 {
+		t.Errorf("invert: %d pixels, want %d", got, 9*5-8)
+	}
+	// the left square is 2x2 at (1,1); grown by 1 it is 4x4 at (0,0)
+	grown := GrowMask(left, 1)
+	if got := countSelected(grown); got != 16 {
+		t.Errorf("grow: %d pixels, want 16", got)
+	}
+	// pixels past the image edge do not erode, so the grown square keeps
+	// its corner at (0,0) and shrinks to 3x3
+	if got := countSelected(ShrinkMask(grown, 1)); got != 9 {
+		t.Errorf("shrink after grow: %d pixels, want 9", got)
+	}
+	if got := countSelected(ShrinkMask(left, 1)); got != 0 {
+		t.Errorf("shrink: %d pixels, want 0", got)
+	}
+}
+
+func TestSelectCommandsReturnMasks(t *testing.T) {
+	out, err := ApplyCommandStdlib(twoSquares(), "selectColor", []string{"6", "2", "5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := out.(*image.Gray)
+	if !ok {
+		t.Fatalf("selectColor returned %T, want *image.Gray", out)
+	}
+	if countSelected(m) != 4 || m.GrayAt(7, 3).Y != 255 {
+		t.Errorf("selectColor selected the wrong pixels")
+	}
+	out, err = ApplyCommandStdlib(twoSquares(), "selectSimilar", []string{"red", "10%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := countSelected(out.(*image.Gray)); got != 8 {
+		t.Errorf("selectSimilar selected %d pixels, want 8", got)
+	}
+}
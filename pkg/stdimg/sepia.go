@@ -4,7 +4,6 @@ import (
 	"image"
 	"image/color"
 	"math"
-	"runtime"
 	"sync"
 )
 
@@ -16,9 +15,56 @@ func SepiaTone(src *image.NRGBA, percentage, midtoneCenter, midtoneSigma, highli
 	if src == nil {
 		return nil
 	}
-	if percentage <= 0 {
+	tone := sepiaToner(percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve)
+	if tone == nil {
 		return src
 	}
+
+	bounds := src.Bounds()
+	out := image.NewNRGBA(bounds)
+	w := bounds.Dx()
+	h := bounds.Dy()
+	parallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < w; x++ {
+				i := src.PixOffset(x, y)
+				alpha := src.Pix[i+3]
+				// If fully transparent, keep as-is
+				if alpha == 0 {
+					out.Pix[i+0] = src.Pix[i+0]
+					out.Pix[i+1] = src.Pix[i+1]
+					out.Pix[i+2] = src.Pix[i+2]
+					out.Pix[i+3] = alpha
+					continue
+				}
+				r := src.Pix[i+0]
+				g := src.Pix[i+1]
+				b := src.Pix[i+2]
+
+				r2Lin, g2Lin, b2Lin := tone(srgb8ToLinearLUT(r), srgb8ToLinearLUT(g), srgb8ToLinearLUT(b))
+				// Gamma-encode back to sRGB 0..1 using LUT-accelerated approx
+				rOut := linearToSrgbApprox(r2Lin)
+				gOut := linearToSrgbApprox(g2Lin)
+				bOut := linearToSrgbApprox(b2Lin)
+
+				// clamp and write (linearToSrgbApprox returns 0..1)
+				out.Pix[i+0] = uint8(clampFloatToUint8(rOut * 255.0))
+				out.Pix[i+1] = uint8(clampFloatToUint8(gOut * 255.0))
+				out.Pix[i+2] = uint8(clampFloatToUint8(bOut * 255.0))
+				out.Pix[i+3] = alpha
+			}
+		}
+	})
+	return out
+}
+
+// sepiaToner returns the color transform of SepiaTone on linear RGB, shared
+// by its 8- and 16-bit versions, or nil when percentage leaves the image
+// unchanged.
+func sepiaToner(percentage, midtoneCenter, midtoneSigma, highlightThreshold, highlightSoftness, curve float64) func(rLin, gLin, bLin float64) (float64, float64, float64) {
+	if percentage <= 0 {
+		return nil
+	}
 	if percentage > 1 {
 		percentage = 1
 	}
@@ -64,137 +110,31 @@ func SepiaTone(src *image.NRGBA, percentage, midtoneCenter, midtoneSigma, highli
 	tX, tY, tZ := linearToXyz(trLin, tgLin, tbLin)
 	Lsep, asep, bsep := xyzToLab(tX, tY, tZ)
 
-	bounds := src.Bounds()
-	out := image.NewNRGBA(bounds)
-	w := bounds.Dx()
-	h := bounds.Dy()
-	// choose worker count
-	workers := runtime.GOMAXPROCS(0)
-	// fallback to single-threaded for small images
-	if h < 64 || workers <= 1 {
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				i := src.PixOffset(x, y)
-				alpha := src.Pix[i+3]
-				// If fully transparent, keep as-is
-				if alpha == 0 {
-					out.Pix[i+0] = src.Pix[i+0]
-					out.Pix[i+1] = src.Pix[i+1]
-					out.Pix[i+2] = src.Pix[i+2]
-					out.Pix[i+3] = alpha
-					continue
-				}
-				r := src.Pix[i+0]
-				g := src.Pix[i+1]
-				b := src.Pix[i+2]
-
-				rLin := srgb8ToLinearLUT(r)
-				gLin := srgb8ToLinearLUT(g)
-				bLin := srgb8ToLinearLUT(b)
-				X, Y, Z := linearToXyz(rLin, gLin, bLin)
-				L, aCh, bCh := xyzToLab(X, Y, Z)
-
-				// compute local blend factor pLocal based on midtone weighting and highlight protection
-				pLocal := percentage * midtoneWeight(L, midtoneCenter, midtoneSigma) * highlightProtect(L, highlightThreshold, highlightSoftness)
-				if pLocal < 0 {
-					pLocal = 0
-				}
-				if pLocal > 1 {
-					pLocal = 1
-				}
-
-				// Blend in Lab space toward target sepia Lab
-				L2 := (1.0-pLocal)*L + pLocal*Lsep
-				a2 := (1.0-pLocal)*aCh + pLocal*asep
-				b2 := (1.0-pLocal)*bCh + pLocal*bsep
-
-				// apply small filmic S-curve to L
-				L2 = applySCurve(L2, curve)
+	return func(rLin, gLin, bLin float64) (float64, float64, float64) {
+		X, Y, Z := linearToXyz(rLin, gLin, bLin)
+		L, aCh, bCh := xyzToLab(X, Y, Z)
 
-				// Convert back to linear RGB
-				x2, y2, z2 := labToXYZ(L2, a2, b2)
-				r2Lin, g2Lin, b2Lin := xyzToLinearRGB(x2, y2, z2)
-				// Gamma-encode back to sRGB 0..1 using LUT-accelerated approx
-				rOut := linearToSrgbApprox(r2Lin)
-				gOut := linearToSrgbApprox(g2Lin)
-				bOut := linearToSrgbApprox(b2Lin)
-
-				// clamp and write (linearToSrgbApprox returns 0..1)
-				out.Pix[i+0] = uint8(clampFloatToUint8(rOut * 255.0))
-				out.Pix[i+1] = uint8(clampFloatToUint8(gOut * 255.0))
-				out.Pix[i+2] = uint8(clampFloatToUint8(bOut * 255.0))
-				out.Pix[i+3] = alpha
-			}
+		// compute local blend factor pLocal based on midtone weighting and highlight protection
+		pLocal := percentage * midtoneWeight(L, midtoneCenter, midtoneSigma) * highlightProtect(L, highlightThreshold, highlightSoftness)
+		if pLocal < 0 {
+			pLocal = 0
 		}
-		return out
-	}
-
-	// parallel path
-	chunk := (h + workers - 1) / workers
-	var wg sync.WaitGroup
-	for wi := 0; wi < workers; wi++ {
-		y0 := wi * chunk
-		y1 := y0 + chunk
-		if y1 > h {
-			y1 = h
-		}
-		if y0 >= y1 {
-			continue
+		if pLocal > 1 {
+			pLocal = 1
 		}
-		wg.Add(1)
-		go func(y0, y1 int) {
-			defer wg.Done()
-			for y := y0; y < y1; y++ {
-				for x := 0; x < w; x++ {
-					i := src.PixOffset(x, y)
-					alpha := src.Pix[i+3]
-					if alpha == 0 {
-						out.Pix[i+0] = src.Pix[i+0]
-						out.Pix[i+1] = src.Pix[i+1]
-						out.Pix[i+2] = src.Pix[i+2]
-						out.Pix[i+3] = alpha
-						continue
-					}
-					r := src.Pix[i+0]
-					g := src.Pix[i+1]
-					b := src.Pix[i+2]
-
-					rLin := srgb8ToLinearLUT(r)
-					gLin := srgb8ToLinearLUT(g)
-					bLin := srgb8ToLinearLUT(b)
-					X, Y, Z := linearToXyz(rLin, gLin, bLin)
-					L, aCh, bCh := xyzToLab(X, Y, Z)
-
-					pLocal := percentage * midtoneWeight(L, midtoneCenter, midtoneSigma) * highlightProtect(L, highlightThreshold, highlightSoftness)
-					if pLocal < 0 {
-						pLocal = 0
-					}
-					if pLocal > 1 {
-						pLocal = 1
-					}
 
-					L2 := (1.0-pLocal)*L + pLocal*Lsep
-					a2 := (1.0-pLocal)*aCh + pLocal*asep
-					b2 := (1.0-pLocal)*bCh + pLocal*bsep
+		// Blend in Lab space toward target sepia Lab
+		L2 := (1.0-pLocal)*L + pLocal*Lsep
+		a2 := (1.0-pLocal)*aCh + pLocal*asep
+		b2 := (1.0-pLocal)*bCh + pLocal*bsep
 
-					L2 = applySCurve(L2, curve)
+		// apply small filmic S-curve to L
+		L2 = applySCurve(L2, curve)
 
-					x2, y2, z2 := labToXYZ(L2, a2, b2)
-					r2Lin, g2Lin, b2Lin := xyzToLinearRGB(x2, y2, z2)
-					rOut := linearToSrgbApprox(r2Lin)
-					gOut := linearToSrgbApprox(g2Lin)
-					bOut := linearToSrgbApprox(b2Lin)
-
-					out.Pix[i+0] = uint8(clampFloatToUint8(rOut * 255.0))
-					out.Pix[i+1] = uint8(clampFloatToUint8(gOut * 255.0))
-					out.Pix[i+2] = uint8(clampFloatToUint8(bOut * 255.0))
-					out.Pix[i+3] = alpha
-				}
-			}
-		}(y0, y1)
+		// Convert back to linear RGB
+		x2, y2, z2 := labToXYZ(L2, a2, b2)
+		return xyzToLinearRGB(x2, y2, z2)
 	}
-	wg.Wait()
-	return out
 }
 
 var (
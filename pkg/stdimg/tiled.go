@@ -0,0 +1,168 @@
+package stdimg
+
+import (
+	"context"
+	"image"
+	"math"
+	"sync/atomic"
+)
+
+// Tiled processing. Neighbourhood filters allocate several working buffers
+// the size of their input, which does not fit in memory for very large
+// scans. With a tile memory budget set, such images are filtered in square
+// tiles: each tile is copied out together with a halo of the pixels its
+// neighbourhood reaches, filtered on its own, and only its centre is kept.
+// A tile's halo either covers the whole neighbourhood or ends at the same
+// image edge as the whole image, so the result is identical to filtering
+// the image in one piece.
+
+// tileMemory is the working-memory budget in bytes of the tiled filters; 0
+// filters whole images.
+var tileMemory atomic.Int64
+
+// minTileSide keeps tiles useful when the budget is tiny next to the halo;
+// the budget is then exceeded rather than the work split into slivers.
+const minTileSide = 64
+
+// SetTileMemory sets the memory budget in bytes for the working buffers of
+// blur, medianFilter, despeckle, edge, sharpen, unsharpMask and
+// adaptiveThreshold. 8-bit images whose buffers would exceed it are
+// processed in tiles. The source and result images are not counted. n <= 0
+// turns tiling off.
+func SetTileMemory(n int64) { tileMemory.Store(max(n, 0)) }
+
+// TileMemory reports the budget set by SetTileMemory.
+func TileMemory() int64 { return tileMemory.Load() }
+
+// tileSide returns the side of the square tiles src should be filtered in,
+// for a filter reaching halo pixels in every direction and needing
+// perPixel bytes of buffers per pixel, or 0 to filter src whole.
+func tileSide(src *image.NRGBA, halo, perPixel int) int {
+	budget := TileMemory()
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	if budget <= 0 || int64(w)*int64(h)*int64(perPixel) <= budget {
+		return 0
+	}
+	side := int(math.Sqrt(float64(budget)/float64(perPixel))) - 2*halo
+	return max(side, minTileSide)
+}
+
+// fromOrigin runs filter on a view of src moved to start at (0, 0), which
+// shares its pixels, and moves the result back to src's position. The
+// whole-image filters index pixels from (0, 0), so this lets them and their
+// tiles take cropped images. filter must return a 0-based image.
+func fromOrigin(src *image.NRGBA, filter func(*image.NRGBA) (*image.NRGBA, error)) (*image.NRGBA, error) {
+	at := src.Rect.Min
+	if at == (image.Point{}) {
+		return filter(src)
+	}
+	moved := *src
+	moved.Rect = src.Rect.Sub(at)
+	out, err := filter(&moved)
+	if err != nil || out == nil {
+		return out, err
+	}
+	back := *out
+	back.Rect = out.Rect.Add(at)
+	return &back, nil
+}
+
+// gaussianRadius is the reach of SeparableGaussianBlur for sigma.
+func gaussianRadius(sigma float64) int {
+	if sigma >= boxBlurMinSigma {
+		return boxReach(boxRadii(sigma))
+	}
+	_, radius := gaussianKernel1D(sigma)
+	return radius
+}
+
+// forEachTile calls fn for each tile of side pixels of src, in rows, with a
+// copy of the tile and its halo and the tile's rectangle within that copy.
+// The copy is 0-based; the tile sits at r.Min of it and at at in src.
+func forEachTile(ctx context.Context, src *image.NRGBA, side, halo int, progress ProgressFunc, fn func(tile *image.NRGBA, r image.Rectangle, at image.Point) error) error {
+	if progress == nil {
+		progress = noProgress
+	}
+	b := src.Rect
+	cols := (b.Dx() + side - 1) / side
+	rows := (b.Dy() + side - 1) / side
+	done := 0
+	for ty := b.Min.Y; ty < b.Max.Y; ty += side {
+		for tx := b.Min.X; tx < b.Max.X; tx += side {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			inner := image.Rect(tx, ty, tx+side, ty+side).Intersect(b)
+			outer := inner.Inset(-halo).Intersect(b)
+			tile := image.NewNRGBA(image.Rect(0, 0, outer.Dx(), outer.Dy()))
+			n := outer.Dx() * 4
+			for y := outer.Min.Y; y < outer.Max.Y; y++ {
+				i := src.PixOffset(outer.Min.X, y)
+				copy(tile.Pix[(y-outer.Min.Y)*tile.Stride:][:n], src.Pix[i:i+n])
+			}
+			if err := fn(tile, inner.Sub(outer.Min), inner.Min); err != nil {
+				return err
+			}
+			done++
+			progress(float64(done) / float64(cols*rows))
+		}
+	}
+	return nil
+}
+
+// filterTiles runs filter on each tile of src and assembles the results.
+// filter must return an image with the tile's 0-based layout.
+func filterTiles(ctx context.Context, src *image.NRGBA, side, halo int, progress ProgressFunc, filter func(context.Context, *image.NRGBA) (*image.NRGBA, error)) (*image.NRGBA, error) {
+	out := image.NewNRGBA(src.Rect)
+	err := forEachTile(ctx, src, side, halo, progress, func(tile *image.NRGBA, r image.Rectangle, at image.Point) error {
+		res, err := filter(ctx, tile)
+		if err != nil {
+			return err
+		}
+		n := r.Dx() * 4
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			i := res.PixOffset(r.Min.X, y)
+			copy(out.Pix[out.PixOffset(at.X, at.Y+y-r.Min.Y):][:n], res.Pix[i:i+n])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// edgeTiles is EdgeEx in tiles. Edge magnitudes are normalised by their
+// maximum over the whole image, so a first pass finds the maximum and a
+// second one recomputes the magnitudes and draws them.
+func edgeTiles(ctx context.Context, src *image.NRGBA, side int, sigma, scale, threshold float64, binary bool, progress ProgressFunc) (*image.NRGBA, error) {
+	if progress == nil {
+		progress = noProgress
+	}
+	halo := gaussianRadius(sigma) + 1
+	maxMag := 0.0
+	err := forEachTile(ctx, src, side, halo, subProgress(progress, 0, 0.5), func(tile *image.NRGBA, r image.Rectangle, _ image.Point) error {
+		mag := edgeMagnitudes(tile, sigma, scale)
+		w := tile.Rect.Dx()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for _, m := range mag[y*w+r.Min.X : y*w+r.Max.X] {
+				if m > maxMag {
+					maxMag = m
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := image.NewNRGBA(src.Rect)
+	err = forEachTile(ctx, src, side, halo, subProgress(progress, 0.5, 0.5), func(tile *image.NRGBA, r image.Rectangle, at image.Point) error {
+		drawEdges(out, at, edgeMagnitudes(tile, sigma, scale), tile.Rect.Dx(), r, maxMag, threshold, binary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,97 @@
+package stdimg
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+)
+
+func withTileMemory(t *testing.T, n int64) {
+	t.Helper()
+	prev := TileMemory()
+	SetTileMemory(n)
+	t.Cleanup(func() { SetTileMemory(prev) })
+}
+
+// Tiles must not show: every neighbourhood filter gives the same pixels
+// as on the whole image, at tile edges and image edges alike.
+func TestTiledMatchesWholeImage(t *testing.T) {
+	src := gradientNRGBA(211, 157)
+	for i := range src.Pix {
+		src.Pix[i] ^= uint8(i * 29)
+	}
+	cases := []graphStep{
+		{"blur", []string{"2.5", "false"}},
+		{"blur", []string{"0", "false"}},
+		{"blur", []string{"9", "false"}},
+		{"medianFilter", []string{"3"}},
+		{"medianFilter", []string{"-1"}},
+		{"despeckle", []string{""}},
+		{"edge", []string{"0", "1", "0", "false"}},
+		{"edge", []string{"1.5", "2", "40", "true"}},
+		{"adaptiveThreshold", []string{"25", "9", "4"}},
+		{"adaptiveThreshold", []string{"15", "15", "0"}},
+		{"adaptiveThreshold", []string{"31", "31", "2", "sauvola", "0.3", "100"}},
+		{"adaptiveThreshold", []string{"15", "21", "0", "niblack"}},
+		{"sharpen", []string{"0", "1.2"}},
+		{"unsharpMask", []string{"0", "2", "1.5", "3"}},
+	}
+	// a cropped image starts away from the origin, as crop returns it
+	cropped := src.SubImage(image.Rect(10, 7, 190, 150)).(*image.NRGBA)
+	for _, in := range []*image.NRGBA{src, cropped} {
+		whole := make([]*image.NRGBA, len(cases))
+		for i, c := range cases {
+			whole[i] = ToNRGBA(runSequential(t, in, []graphStep{c}))
+		}
+		// 64x64 tiles plus halo, well under the image size
+		withTileMemory(t, 64*1024)
+		if tileSide(in, 2, 12) == 0 {
+			t.Fatalf("the budget does not split the %v test image into tiles", in.Rect)
+		}
+		for i, c := range cases {
+			var last float64
+			out, err := ApplyCommandContext(context.Background(), in, c.name, c.args, func(done float64) { last = done })
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			got := ToNRGBA(out)
+			if got.Rect != whole[i].Rect || !bytes.Equal(got.Pix, whole[i].Pix) {
+				t.Errorf("%s %q on %v: tiled result differs from the whole image", c.name, c.args, in.Rect)
+			}
+			if last != 1 {
+				t.Errorf("%s: progress ended at %v", c.name, last)
+			}
+		}
+		withTileMemory(t, 0)
+	}
+}
+
+func TestTileSide(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 1000, 1000))
+	withTileMemory(t, 0)
+	if n := tileSide(src, 5, 12); n != 0 {
+		t.Errorf("tiling off: side %d", n)
+	}
+	withTileMemory(t, 12*1000*1000)
+	if n := tileSide(src, 5, 12); n != 0 {
+		t.Errorf("image within the budget: side %d", n)
+	}
+	withTileMemory(t, 12*300*300)
+	if n := tileSide(src, 5, 12); n != 290 {
+		t.Errorf("side %d, want 290 so that a tile and its halo fit", n)
+	}
+	withTileMemory(t, 1024)
+	if n := tileSide(src, 5, 12); n != minTileSide {
+		t.Errorf("tiny budget: side %d, want %d", n, minTileSide)
+	}
+}
+
+func TestTiledCancellation(t *testing.T) {
+	withTileMemory(t, 64*1024)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ApplyCommandContext(ctx, gradientNRGBA(300, 200), "medianFilter", []string{"2"}, nil); err == nil {
+		t.Error("a cancelled tiled filter returned no error")
+	}
+}
@@ -18,47 +18,55 @@ func Vignette(src *image.NRGBA, radius, sigma float64, cx, cy int, strength floa
 		return nil
 	}
 	b := src.Bounds()
-	w := b.Dx()
-	h := b.Dy()
+	factor := vignetteFactor(b, radius, sigma, cx, cy)
+	out := image.NewNRGBA(b)
+	parallelRows(b.Dy(), func(y0, y1 int) {
+		for y := b.Min.Y + y0; y < b.Min.Y+y1; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				i := src.PixOffset(x, y)
+				r := float64(src.Pix[i+0])
+				g := float64(src.Pix[i+1])
+				b_ := float64(src.Pix[i+2])
+				a := src.Pix[i+3]
+
+				f := factor(x, y)
+				out.Pix[i+0] = uint8(clampFloatToUint8(r * f))
+				out.Pix[i+1] = uint8(clampFloatToUint8(g * f))
+				out.Pix[i+2] = uint8(clampFloatToUint8(b_ * f))
+				out.Pix[i+3] = a
+			}
+		}
+	})
+	return out
+}
+
+// vignetteFactor returns the brightness Vignette keeps at each pixel of b.
+func vignetteFactor(b image.Rectangle, radius, sigma float64, cx, cy int) func(x, y int) float64 {
 	if radius <= 0 {
-		radius = math.Hypot(float64(w), float64(h)) / 2.0
+		radius = math.Hypot(float64(b.Dx()), float64(b.Dy())) / 2.0
 	}
 	if sigma <= 0 {
 		sigma = radius / 3.0
 	}
 	// precompute normalizer at radius
 	normAtRadius := 1 - math.Exp(-0.5*(radius*radius)/(sigma*sigma))
-	out := image.NewNRGBA(b)
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			i := src.PixOffset(x, y)
-			r := float64(src.Pix[i+0])
-			g := float64(src.Pix[i+1])
-			b_ := float64(src.Pix[i+2])
-			a := src.Pix[i+3]
+	return func(x, y int) float64 {
+		dx := float64(x - cx)
+		dy := float64(y - cy)
+		d := math.Hypot(dx, dy)
 
-			dx := float64(x - cx)
-			dy := float64(y - cy)
-			d := math.Hypot(dx, dy)
-
-			// continuous normalized gaussian-like mask
-			val := 1 - math.Exp(-0.5*(d*d)/(sigma*sigma))
-			mask := val
-			if normAtRadius > 0 {
-				mask = val / normAtRadius
-			}
-			if mask < 0 {
-				mask = 0
-			}
-			if mask > 1 {
-				mask = 1
-			}
-			factor := 1.0 - mask
-			out.Pix[i+0] = uint8(clampFloatToUint8(r * factor))
-			out.Pix[i+1] = uint8(clampFloatToUint8(g * factor))
-			out.Pix[i+2] = uint8(clampFloatToUint8(b_ * factor))
-			out.Pix[i+3] = a
+		// continuous normalized gaussian-like mask
+		val := 1 - math.Exp(-0.5*(d*d)/(sigma*sigma))
+		mask := val
+		if normAtRadius > 0 {
+			mask = val / normAtRadius
 		}
+		if mask < 0 {
+			mask = 0
+		}
+		if mask > 1 {
+			mask = 1
+		}
+		return 1.0 - mask
 	}
-	return out
 }